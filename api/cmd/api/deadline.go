@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"trace-lite/api/internal/clickhouse"
+	"trace-lite/api/internal/handlers"
+)
+
+const maxRequestTimeout = 20 * time.Second
+
+var safeRequestID = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,64}$`)
+
+type partialResponse struct {
+	http.ResponseWriter
+	ctx         context.Context
+	wroteHeader bool
+}
+
+func (p *partialResponse) WriteHeader(status int) {
+	if !p.wroteHeader {
+		p.wroteHeader = true
+		if clickhouse.Partial(p.ctx) {
+			p.Header().Set("X-Result-Partial", "true")
+		}
+	}
+	p.ResponseWriter.WriteHeader(status)
+}
+
+func (p *partialResponse) Write(b []byte) (int, error) {
+	if !p.wroteHeader {
+		p.WriteHeader(http.StatusOK)
+	}
+	return p.ResponseWriter.Write(b)
+}
+
+func withRequestContext(next http.Handler, routes *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !safeRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		allowPartial := isTrue(r.URL.Query().Get("partial")) || isTrue(r.Header.Get("X-Allow-Partial"))
+		_, route := routes.Handler(r)
+		ctx := clickhouse.WithRequest(r.Context(), id, route, allowPartial)
+
+		timeout, ok := requestTimeout(r)
+		if ok {
+			if timeout <= 0 {
+				handlers.WriteError(w, http.StatusGatewayTimeout, "deadline_exceeded", "request deadline already passed", nil)
+				return
+			}
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		next.ServeHTTP(&partialResponse{ResponseWriter: w, ctx: ctx}, r.WithContext(ctx))
+	})
+}
+
+func requestTimeout(r *http.Request) (time.Duration, bool) {
+	var timeout time.Duration
+	found := false
+	if raw := strings.TrimSpace(r.Header.Get("X-Request-Deadline")); raw != "" {
+		if dl, err := time.Parse(time.RFC3339Nano, raw); err == nil {
+			timeout, found = time.Until(dl), true
+		}
+	}
+	if raw := strings.TrimSpace(r.URL.Query().Get("timeout")); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			if ms, msErr := strconv.Atoi(raw); msErr == nil {
+				d, err = time.Duration(ms)*time.Millisecond, nil
+			}
+		}
+		if err == nil && d > 0 && (!found || d < timeout) {
+			timeout, found = d, true
+		}
+	}
+	if found && timeout > maxRequestTimeout {
+		timeout = maxRequestTimeout
+	}
+	return timeout, found
+}
+
+func isTrue(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
+func newRequestID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b[:])
+}
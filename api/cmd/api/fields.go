@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type fieldFilter struct {
+	include map[string]bool
+	exclude map[string]bool
+}
+
+func parseFields(raw string) (fieldFilter, bool) {
+	f := fieldFilter{include: map[string]bool{}, exclude: map[string]bool{}}
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "":
+		case strings.HasPrefix(name, "-"):
+			f.exclude[strings.TrimPrefix(name, "-")] = true
+		default:
+			f.include[name] = true
+		}
+	}
+	return f, len(f.include) > 0 || len(f.exclude) > 0
+}
+
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+func withFields(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter, ok := parseFields(r.URL.Query().Get("fields"))
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		buf := &bufferedResponse{header: w.Header()}
+		next.ServeHTTP(buf, r)
+		if buf.status == 0 {
+			buf.status = http.StatusOK
+		}
+
+		body := buf.body.Bytes()
+		if buf.status == http.StatusOK && strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+			dec := json.NewDecoder(bytes.NewReader(body))
+			dec.UseNumber()
+			var payload any
+			if err := dec.Decode(&payload); err == nil {
+				if out, err := json.Marshal(filter.apply(payload, false)); err == nil {
+					body = append(out, '\n')
+				}
+			}
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(buf.status)
+		_, _ = w.Write(body)
+	})
+}
+
+func (f fieldFilter) apply(v any, row bool) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, child := range t {
+			if row && (f.exclude[k] || len(f.include) > 0 && !f.include[k]) {
+				delete(t, k)
+				continue
+			}
+			t[k] = f.apply(child, false)
+		}
+		return t
+	case []any:
+		for i := range t {
+			t[i] = f.apply(t[i], true)
+		}
+		return t
+	default:
+		return v
+	}
+}
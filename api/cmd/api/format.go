@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"trace-lite/api/internal/handlers"
+)
+
+func responseFormat(r *http.Request) string {
+	switch strings.ToLower(r.URL.Query().Get("format")) {
+	case "csv":
+		return "text/csv"
+	case "ndjson":
+		return "application/x-ndjson"
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mt {
+		case "text/csv", "application/x-ndjson":
+			return mt
+		case "application/json":
+			return ""
+		}
+	}
+	return ""
+}
+
+func withFormat(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format := responseFormat(r)
+		if format == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		buf := &bufferedResponse{header: w.Header()}
+		next.ServeHTTP(buf, r)
+		if buf.status == 0 {
+			buf.status = http.StatusOK
+		}
+
+		body := buf.body.Bytes()
+		if buf.status == http.StatusOK && strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+			dec := json.NewDecoder(bytes.NewReader(body))
+			dec.UseNumber()
+			var payload any
+			if err := dec.Decode(&payload); err == nil {
+				rows, err := pickRows(payload, r.URL.Query().Get("rows"))
+				if err != nil {
+					handlers.WriteError(w, http.StatusNotAcceptable, "not_tabular", err.Error(), nil)
+					return
+				}
+				if format == "text/csv" {
+					body, err = encodeCSV(rows)
+				} else {
+					body, err = encodeNDJSON(rows)
+				}
+				if err != nil {
+					handlers.WriteError(w, http.StatusInternalServerError, "encode_failed", err.Error(), nil)
+					return
+				}
+				w.Header().Set("Content-Type", format+"; charset=utf-8")
+			}
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(buf.status)
+		_, _ = w.Write(body)
+	})
+}
+
+func pickRows(payload any, key string) ([]map[string]any, error) {
+	var arr []any
+	switch t := payload.(type) {
+	case []any:
+		arr = t
+	case map[string]any:
+		if key == "" {
+			if _, ok := t["data"].([]any); ok {
+				key = "data"
+			} else {
+				var keys []string
+				for k, v := range t {
+					if _, ok := v.([]any); ok {
+						keys = append(keys, k)
+					}
+				}
+				sort.Strings(keys)
+				if len(keys) == 0 {
+					return []map[string]any{t}, nil
+				}
+				key = keys[0]
+			}
+		}
+		var ok bool
+		if arr, ok = t[key].([]any); !ok {
+			return nil, fmt.Errorf("response has no row list %q", key)
+		}
+	default:
+		return nil, fmt.Errorf("response is not tabular")
+	}
+
+	rows := make([]map[string]any, 0, len(arr))
+	for _, item := range arr {
+		if row, ok := item.(map[string]any); ok {
+			rows = append(rows, row)
+		} else {
+			rows = append(rows, map[string]any{"value": item})
+		}
+	}
+	return rows, nil
+}
+
+func encodeNDJSON(rows []map[string]any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	for _, row := range rows {
+		if err := enc.Encode(row); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeCSV(rows []map[string]any) ([]byte, error) {
+	seen := map[string]bool{}
+	var columns []string
+	for _, row := range rows {
+		for k := range row {
+			if !seen[k] {
+				seen[k] = true
+				columns = append(columns, k)
+			}
+		}
+	}
+	sort.Strings(columns)
+
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	if err := cw.Write(columns); err != nil {
+		return nil, err
+	}
+	record := make([]string, len(columns))
+	for _, row := range rows {
+		for i, col := range columns {
+			record[i] = csvCell(row[col])
+		}
+		if err := cw.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	cw.Flush()
+	return buf.Bytes(), cw.Error()
+}
+
+func csvCell(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case json.Number:
+		return t.String()
+	case bool:
+		return strconv.FormatBool(t)
+	default:
+		b, _ := json.Marshal(t)
+		return string(b)
+	}
+}
@@ -1,40 +1,110 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 
+	"trace-lite/api/internal/alerting"
 	"trace-lite/api/internal/clickhouse"
 	"trace-lite/api/internal/config"
 	"trace-lite/api/internal/handlers"
+	"trace-lite/api/internal/webui"
 )
 
 func main() {
-	cfg := config.Load()
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "TOML config file; environment variables override it")
+	checkConfig := flag.Bool("check-config", false, "print the effective configuration, validate it and exit")
+	flag.Parse()
+	cfg := config.MustLoad(*configPath, *checkConfig)
 	ch := clickhouse.NewClient(cfg.ClickHouseDSN, cfg.ClickHouseDB)
-	h := handlers.New(ch)
+	ch.SetCredentials(cfg.ClickHouseUser, cfg.ClickHousePass)
+	ch.SetCompression(cfg.ClickHouseCompress)
+	queries := clickhouse.NewQueryLog(cfg.SlowQueryThreshold)
+	ch.SetQueryLog(queries)
+	if cfg.StartupWait > 0 {
+		if err := ch.WaitReady(context.Background(), cfg.StartupWait); err != nil {
+			log.Fatalf("startup: %v", err)
+		}
+	}
+	h := handlers.New(ch, cfg)
+	h.SetQueryLog(queries)
+	if config.HasSecrets() && cfg.SecretsRefresh > 0 {
+		go refreshSecrets(cfg.SecretsRefresh, ch, h)
+	}
+	go h.RunAutoCompare(cfg.AutoCompareEvery)
+	go h.RunDependencyChanges(cfg.ChangesEvery)
+	alertCfg, err := alerting.Load(context.Background(), cfg.AlertRulesFile)
+	if err != nil {
+		log.Fatalf("alert rules: %v", err)
+	}
+	go h.RunAlerts(alertCfg, cfg.AlertInterval)
+	go h.RunOTLPExport(cfg.OTLPEndpoint, cfg.OTLPHeaders, cfg.OTLPInterval)
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/v1/healthz", h.Healthz)
+	mux.HandleFunc("/livez", h.Livez)
+	mux.HandleFunc("/readyz", h.Readyz)
+	mux.HandleFunc("/v1/healthz", h.Readyz)
 	mux.HandleFunc("/v1/traces", h.Traces)
 	mux.HandleFunc("/v1/traces/", h.TraceByID)
+	mux.HandleFunc("/v1/traces/clusters", h.TraceClusters)
 	mux.HandleFunc("/v1/dependency", h.Dependency)
 	mux.HandleFunc("/v1/dependency/diff", h.DependencyDiff)
+	mux.HandleFunc("/v1/dependency/changes", h.DependencyChanges)
 	mux.HandleFunc("/v1/hosts", h.Hosts)
+	mux.HandleFunc("/v1/envs", h.Envs)
 	mux.HandleFunc("/v1/compare", h.Compare)
+	mux.HandleFunc("/v1/compare/auto", h.AutoCompare)
+	mux.HandleFunc("/v1/verify", h.Verify)
 	mux.HandleFunc("/v1/errors", h.Errors)
+	mux.HandleFunc("/v1/services/missing", h.ServicesMissing)
+	mux.HandleFunc("/v1/services/queue", h.ServicesQueue)
+	mux.HandleFunc("/v1/services/", h.ServiceVersions)
+	mux.HandleFunc("/v1/alerts", h.Alerts)
+	mux.HandleFunc("/v1/metrics/export", h.MetricsExport)
+	mux.HandleFunc("/v1/maintenance", h.Maintenance)
+	mux.HandleFunc("/v1/maintenance/", h.MaintenanceByID)
+	mux.HandleFunc("/v1/lookup", h.Lookup)
+	mux.HandleFunc("/v1/transactions", h.Transactions)
+	mux.HandleFunc("/v1/transactions/detail", h.TransactionDetail)
+	mux.HandleFunc("/v1/usage", h.Usage)
+	mux.HandleFunc("/v1/usage/daily", h.UsageDaily)
+	mux.HandleFunc("/v1/admin/storage", h.AdminStorage)
+	mux.HandleFunc("/v1/admin/slow-queries", h.AdminSlowQueries)
+	if cfg.UIEnabled {
+		mux.Handle("/ui/", http.StripPrefix("/ui/", webui.Handler()))
+		mux.Handle("GET /{$}", http.RedirectHandler("/ui/", http.StatusFound))
+	}
 
 	log.Printf("api listening on %s", cfg.Addr)
-	if err := http.ListenAndServe(cfg.Addr, withCORS(mux)); err != nil {
+	if err := http.ListenAndServe(cfg.Addr, withCORS(withRequestContext(withFormat(withFields(mux)), mux))); err != nil {
 		log.Fatalf("listen failed: %v", err)
 	}
 }
 
+func refreshSecrets(every time.Duration, ch *clickhouse.Client, h *handlers.Handler) {
+	for range time.Tick(every) {
+		next := config.Load()
+		if problems := config.Problems(); len(problems) > 0 {
+			log.Printf("secrets refresh skipped: %s", strings.Join(problems, "; "))
+			continue
+		}
+		ch.SetCredentials(next.ClickHouseUser, next.ClickHousePass)
+		h.SetAdminToken(next.AdminToken)
+		h.SetDecryptToken(next.DecryptToken)
+	}
+}
+
 func withCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET,POST,OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type,Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET,POST,DELETE,OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type,Authorization,X-Request-ID,X-Request-Deadline,X-Allow-Partial")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID,X-Result-Partial")
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
 			return
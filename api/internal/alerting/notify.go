@@ -0,0 +1,147 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Notifier struct {
+	client *http.Client
+	mu     sync.Mutex
+	last   map[string]time.Time
+}
+
+func NewNotifier() *Notifier {
+	return &Notifier{client: &http.Client{Timeout: 10 * time.Second}, last: map[string]time.Time{}}
+}
+
+func (n *Notifier) Notify(ctx context.Context, cfg Config, r *Rule, ev Event) []error {
+	var errs []error
+	for _, name := range r.Channels {
+		ch := cfg.Channels[name]
+		if !n.allow(ch, r.Name, ev.Status, ev.At) {
+			continue
+		}
+		text, err := r.Render(ch, ev)
+		if err == nil {
+			err = n.send(ctx, ch, r, ev, text)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s via %s: %w", r.Name, name, err))
+		}
+	}
+	return errs
+}
+
+func (n *Notifier) allow(ch *Channel, rule, status string, now time.Time) bool {
+	if ch.throttle <= 0 {
+		return true
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	key := ch.Name + "\x00" + rule + "\x00" + status
+	if last, ok := n.last[key]; ok && now.Sub(last) < ch.throttle {
+		return false
+	}
+	n.last[key] = now
+	return true
+}
+
+func (n *Notifier) send(ctx context.Context, ch *Channel, r *Rule, ev Event, text string) error {
+	switch ch.Type {
+	case "slack":
+		return n.post(ctx, ch.URL, nil, map[string]any{"text": text})
+	case "webhook":
+		return n.post(ctx, ch.URL, nil, map[string]any{
+			"rule":      ev.Rule,
+			"status":    ev.Status,
+			"service":   ev.Service,
+			"env":       ev.Env,
+			"metric":    ev.Metric,
+			"op":        ev.Op,
+			"value":     ev.Value,
+			"threshold": ev.Threshold,
+			"window":    ev.Window.String(),
+			"metrics":   ev.Metrics,
+			"starts_at": ev.StartsAt,
+			"at":        ev.At,
+			"links":     ev.Links,
+			"traces":    ev.Traces,
+			"message":   text,
+		})
+	case "opsgenie":
+		header := http.Header{"Authorization": {"GenieKey " + ch.APIKey}}
+		alias := "trace-lite:" + r.Name
+		if ev.Env != "" {
+			alias += ":" + ev.Env
+		}
+		base := strings.TrimRight(ch.URL, "/")
+		if ev.Status == "resolved" {
+			return n.post(ctx, base+"/v2/alerts/"+url.PathEscape(alias)+"/close?identifierType=alias", header, map[string]any{"note": text})
+		}
+		return n.post(ctx, base+"/v2/alerts", header, map[string]any{
+			"message":     title(text),
+			"alias":       alias,
+			"description": text,
+			"tags":        []string{"trace-lite", ev.Service, ev.Metric},
+			"details":     ev.Links,
+		})
+	case "email":
+		return sendMail(ch, title(text), text)
+	}
+	return fmt.Errorf("unsupported channel type %q", ch.Type)
+}
+
+func (n *Notifier) post(ctx context.Context, target string, header http.Header, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 2048))
+		return fmt.Errorf("%s (%s)", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func sendMail(ch *Channel, subject, text string) error {
+	var auth smtp.Auth
+	if ch.Username != "" {
+		host, _, err := net.SplitHostPort(ch.SMTP)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", ch.Username, ch.Password, host)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", ch.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(ch.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(subject))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(text, "\n", "\r\n"))
+	msg.WriteString("\r\n")
+	return smtp.SendMail(ch.SMTP, auth, ch.From, ch.To, msg.Bytes())
+}
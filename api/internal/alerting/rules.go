@@ -0,0 +1,207 @@
+package alerting
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"trace-lite/api/internal/secrets"
+)
+
+var (
+	metrics      = []string{"calls", "error_rate", "timeout_rate", "p50_ms", "p95_ms", "p99_ms"}
+	ops          = []string{">", ">=", "<", "<="}
+	channelTypes = []string{"slack", "webhook", "email", "opsgenie"}
+	safeName     = regexp.MustCompile(`^[a-zA-Z0-9._:/-]+$`)
+)
+
+type Channel struct {
+	Name     string   `json:"-"`
+	Type     string   `json:"type"`
+	URL      string   `json:"url,omitempty"`
+	APIKey   string   `json:"api_key,omitempty"`
+	SMTP     string   `json:"smtp,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+	Throttle string   `json:"throttle,omitempty"`
+	throttle time.Duration
+}
+
+type Rule struct {
+	Name      string            `json:"name"`
+	Service   string            `json:"service"`
+	Env       string            `json:"env,omitempty"`
+	Metric    string            `json:"metric"`
+	Op        string            `json:"op"`
+	Threshold float64           `json:"threshold"`
+	Window    string            `json:"window"`
+	MinCalls  int               `json:"min_calls,omitempty"`
+	Channels  []string          `json:"channels"`
+	Template  string            `json:"template,omitempty"`
+	Templates map[string]string `json:"templates,omitempty"`
+	window    time.Duration
+	compiled  map[string]*template.Template
+}
+
+type Config struct {
+	Channels map[string]*Channel `json:"channels"`
+	Rules    []*Rule             `json:"rules"`
+}
+
+func Load(ctx context.Context, path string) (Config, error) {
+	var cfg Config
+	if path == "" {
+		return cfg, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	for name, ch := range cfg.Channels {
+		if err := ch.prepare(ctx, name); err != nil {
+			return cfg, fmt.Errorf("channel %q: %w", name, err)
+		}
+	}
+	seen := map[string]bool{}
+	for i, r := range cfg.Rules {
+		if err := r.prepare(cfg.Channels); err != nil {
+			return cfg, fmt.Errorf("rule %d (%s): %w", i+1, r.Name, err)
+		}
+		if seen[r.Name] {
+			return cfg, fmt.Errorf("rule %q is defined twice", r.Name)
+		}
+		seen[r.Name] = true
+	}
+	return cfg, nil
+}
+
+func (c *Channel) prepare(ctx context.Context, name string) error {
+	c.Name = name
+	c.Type = strings.ToLower(strings.TrimSpace(c.Type))
+	if !oneOf(c.Type, channelTypes) {
+		return fmt.Errorf("type must be one of %s", strings.Join(channelTypes, ", "))
+	}
+	for _, field := range []*string{&c.URL, &c.APIKey, &c.Password} {
+		if secrets.IsRef(*field) {
+			v, err := secrets.Resolve(ctx, *field)
+			if err != nil {
+				return err
+			}
+			*field = v
+		}
+	}
+	switch c.Type {
+	case "slack", "webhook":
+		if !strings.HasPrefix(c.URL, "https://") && !strings.HasPrefix(c.URL, "http://") {
+			return fmt.Errorf("url must be an http(s) URL")
+		}
+	case "email":
+		if c.SMTP == "" || c.From == "" || len(c.To) == 0 {
+			return fmt.Errorf("smtp, from and to are required")
+		}
+	case "opsgenie":
+		if c.APIKey == "" {
+			return fmt.Errorf("api_key is required")
+		}
+		if c.URL == "" {
+			c.URL = "https://api.opsgenie.com"
+		}
+	}
+	if c.Throttle != "" {
+		d, err := time.ParseDuration(c.Throttle)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid throttle %q", c.Throttle)
+		}
+		c.throttle = d
+	}
+	return nil
+}
+
+func (r *Rule) prepare(channels map[string]*Channel) error {
+	if r.Name == "" || !safeName.MatchString(r.Name) {
+		return fmt.Errorf("name is required and may use [a-zA-Z0-9._:/-]")
+	}
+	if r.Service == "" || !safeName.MatchString(r.Service) || (r.Env != "" && !safeName.MatchString(r.Env)) {
+		return fmt.Errorf("service is required, and service and env may use [a-zA-Z0-9._:/-]")
+	}
+	if !oneOf(r.Metric, metrics) {
+		return fmt.Errorf("metric must be one of %s", strings.Join(metrics, ", "))
+	}
+	if !oneOf(r.Op, ops) {
+		return fmt.Errorf("op must be one of %s", strings.Join(ops, " "))
+	}
+	d, err := time.ParseDuration(r.Window)
+	if err != nil || d < time.Minute || d > 24*time.Hour {
+		return fmt.Errorf("window must be a duration between 1m and 24h")
+	}
+	r.window = d
+	if len(r.Channels) == 0 {
+		return fmt.Errorf("at least one channel is required")
+	}
+	for _, name := range r.Channels {
+		if channels[name] == nil {
+			return fmt.Errorf("unknown channel %q", name)
+		}
+	}
+	r.compiled = map[string]*template.Template{}
+	if r.Template != "" {
+		t, err := parseTemplate(r.Name, r.Template)
+		if err != nil {
+			return err
+		}
+		r.compiled[""] = t
+	}
+	for key, text := range r.Templates {
+		if channels[key] == nil && !oneOf(key, channelTypes) {
+			return fmt.Errorf("template key %q is neither a channel nor a channel type", key)
+		}
+		t, err := parseTemplate(r.Name+"/"+key, text)
+		if err != nil {
+			return err
+		}
+		r.compiled[key] = t
+	}
+	return nil
+}
+
+func (r *Rule) WindowDuration() time.Duration {
+	return r.window
+}
+
+func (r *Rule) Fingerprint() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%s\x00%s\x00%s\x00%g", r.Name, r.Service, r.Env, r.Metric, r.Op, r.Threshold)))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (r *Rule) Breached(v float64) bool {
+	switch r.Op {
+	case ">":
+		return v > r.Threshold
+	case ">=":
+		return v >= r.Threshold
+	case "<":
+		return v < r.Threshold
+	}
+	return v <= r.Threshold
+}
+
+func oneOf(v string, list []string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
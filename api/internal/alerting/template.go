@@ -0,0 +1,81 @@
+package alerting
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const defaultTemplate = `[{{upper .Status}}] {{.Rule}}: {{.Service}}{{if .Env}} ({{.Env}}){{end}} {{.Metric}} {{value .Metric .Value}} {{.Op}} {{value .Metric .Threshold}} over {{.Window}}
+{{with .Links.traces}}Traces: {{.}}{{end}}{{range .TraceLinks}}
+- {{.}}{{end}}`
+
+type Event struct {
+	Rule       string
+	Status     string
+	Service    string
+	Env        string
+	Metric     string
+	Op         string
+	Value      float64
+	Threshold  float64
+	Window     time.Duration
+	Metrics    map[string]float64
+	StartsAt   time.Time
+	At         time.Time
+	Links      map[string]string
+	Traces     []string
+	TraceLinks []string
+}
+
+var funcs = template.FuncMap{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"join":  strings.Join,
+	"pct":   func(v float64) string { return fmt.Sprintf("%.2f%%", v*100) },
+	"value": func(metric string, v float64) string {
+		switch {
+		case strings.HasSuffix(metric, "_rate"):
+			return fmt.Sprintf("%.2f%%", v*100)
+		case strings.HasSuffix(metric, "_ms"):
+			return fmt.Sprintf("%.0fms", v)
+		}
+		return fmt.Sprintf("%g", v)
+	},
+	"time": func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+}
+
+var fallback = template.Must(template.New("default").Funcs(funcs).Parse(defaultTemplate))
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Funcs(funcs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("template %s: %w", name, err)
+	}
+	return t, nil
+}
+
+func (r *Rule) Render(ch *Channel, ev Event) (string, error) {
+	t := fallback
+	for _, key := range []string{ch.Name, ch.Type, ""} {
+		if c := r.compiled[key]; c != nil {
+			t = c
+			break
+		}
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, ev); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+func title(text string) string {
+	line, _, _ := strings.Cut(text, "\n")
+	if len(line) > 130 {
+		line = line[:127] + "..."
+	}
+	return line
+}
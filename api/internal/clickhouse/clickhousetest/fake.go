@@ -0,0 +1,144 @@
+package clickhousetest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"trace-lite/api/internal/clickhouse"
+)
+
+type Call struct {
+	SQL    string
+	Params map[string]string
+}
+
+type Insert struct {
+	Table string
+	Rows  []map[string]any
+}
+
+type Fake struct {
+	mu      sync.Mutex
+	rules   []rule
+	calls   []Call
+	inserts []Insert
+	PingErr error
+}
+
+type rule struct {
+	match string
+	rows  []byte
+	err   error
+}
+
+func New() *Fake {
+	return &Fake{}
+}
+
+func (f *Fake) On(match string, rows ...map[string]any) *Fake {
+	if rows == nil {
+		rows = []map[string]any{}
+	}
+	b, err := json.Marshal(rows)
+	if err != nil {
+		panic(fmt.Sprintf("clickhousetest: rows for %q: %v", match, err))
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append(f.rules, rule{match: match, rows: b})
+	return f
+}
+
+func (f *Fake) Fail(match string, err error) *Fake {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append(f.rules, rule{match: match, err: err})
+	return f
+}
+
+func (f *Fake) Ping(ctx context.Context) error {
+	return f.PingErr
+}
+
+func (f *Fake) QueryWith(ctx context.Context, sql string, args map[string]string) ([]map[string]any, error) {
+	rows, err := f.match(sql, args)
+	if err != nil {
+		return nil, err
+	}
+	var out []map[string]any
+	if err := json.Unmarshal(rows, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (f *Fake) QueryEachWith(ctx context.Context, sql string, args map[string]string, fn func(clickhouse.Row) error) error {
+	rows, err := f.match(sql, args)
+	if err != nil {
+		return err
+	}
+	var out []json.RawMessage
+	if err := json.Unmarshal(rows, &out); err != nil {
+		return err
+	}
+	for _, row := range out {
+		if err := fn(clickhouse.Row(row)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Fake) match(sql string, args map[string]string) ([]byte, error) {
+	params := make(map[string]string, len(args))
+	for k, v := range args {
+		params[k] = v
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, Call{SQL: strings.TrimSpace(sql), Params: params})
+	for _, r := range f.rules {
+		if !strings.Contains(sql, r.match) {
+			continue
+		}
+		return r.rows, r.err
+	}
+	return []byte("[]"), nil
+}
+
+func (f *Fake) InsertJSONEachRow(ctx context.Context, table string, rows any) error {
+	b, err := json.Marshal(rows)
+	if err != nil {
+		return err
+	}
+	var list []map[string]any
+	if strings.HasPrefix(string(b), "[") {
+		err = json.Unmarshal(b, &list)
+	} else {
+		var row map[string]any
+		err = json.Unmarshal(b, &row)
+		list = []map[string]any{row}
+	}
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.inserts = append(f.inserts, Insert{Table: table, Rows: list})
+	return nil
+}
+
+func (f *Fake) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+func (f *Fake) Inserts() []Insert {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Insert(nil), f.inserts...)
+}
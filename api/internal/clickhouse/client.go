@@ -2,34 +2,90 @@ package clickhouse
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 )
 
 type Client struct {
 	baseURL    string
 	database   string
+	timeout    time.Duration
 	httpClient *http.Client
+	credMu     sync.RWMutex
+	user       string
+	password   string
+	queries    *QueryLog
+	compress   bool
+}
+
+type Interface interface {
+	Ping(ctx context.Context) error
+	QueryWith(ctx context.Context, sql string, args map[string]string) ([]map[string]any, error)
+	QueryEachWith(ctx context.Context, sql string, args map[string]string, fn func(Row) error) error
+	InsertJSONEachRow(ctx context.Context, table string, rows any) error
 }
 
 type queryResponse struct {
-	Data []map[string]any `json:"data"`
+	Data       []map[string]any `json:"data"`
+	Statistics struct {
+		Elapsed   float64 `json:"elapsed"`
+		RowsRead  uint64  `json:"rows_read"`
+		BytesRead uint64  `json:"bytes_read"`
+	} `json:"statistics"`
 }
 
 func NewClient(baseURL, database string) *Client {
-	return &Client{
+	c := &Client{
 		baseURL:  strings.TrimRight(baseURL, "/"),
 		database: database,
-		httpClient: &http.Client{
-			Timeout: 20 * time.Second,
-		},
+		timeout:  20 * time.Second,
+	}
+	c.httpClient = &http.Client{
+		Timeout:   25 * time.Second,
+		Transport: authTransport{client: c},
+	}
+	return c
+}
+
+func (c *Client) SetCredentials(user, password string) {
+	c.credMu.Lock()
+	defer c.credMu.Unlock()
+	c.user, c.password = user, password
+}
+
+func (c *Client) SetQueryLog(l *QueryLog) {
+	c.queries = l
+}
+
+func (c *Client) SetCompression(on bool) {
+	c.compress = on
+}
+
+type authTransport struct {
+	client *Client
+}
+
+func (t authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.client.credMu.RLock()
+	user, password := t.client.user, t.client.password
+	t.client.credMu.RUnlock()
+	if user != "" {
+		req = req.Clone(req.Context())
+		req.SetBasicAuth(user, password)
 	}
+	return http.DefaultTransport.RoundTrip(req)
 }
 
 func (c *Client) Ping(ctx context.Context) error {
@@ -49,26 +105,262 @@ func (c *Client) Ping(ctx context.Context) error {
 	return nil
 }
 
+func (c *Client) WaitReady(ctx context.Context, maxWait time.Duration) error {
+	deadline := time.Now().Add(maxWait)
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		pingCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		err := c.Ping(pingCtx)
+		cancel()
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("clickhouse reachable after %d attempts", attempt)
+			}
+			return nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return fmt.Errorf("clickhouse not reachable after %s: %w", maxWait, err)
+		}
+		log.Printf("waiting for clickhouse (attempt %d, retry in %s): %v", attempt, backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > 10*time.Second {
+			backoff = 10 * time.Second
+		}
+	}
+}
+
 func (c *Client) Query(ctx context.Context, sql string) ([]map[string]any, error) {
-	statement := fmt.Sprintf("%s FORMAT JSON", strings.TrimSuffix(strings.TrimSpace(sql), ";"))
-	queryURL := fmt.Sprintf("%s/?database=%s", c.baseURL, url.QueryEscape(c.database))
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, queryURL, bytes.NewBufferString(statement))
+	return c.QueryWith(ctx, sql, nil)
+}
+
+func (c *Client) QueryWith(ctx context.Context, sql string, args map[string]string) ([]map[string]any, error) {
+	var out queryResponse
+	err := c.execute(ctx, sql, "JSON", args, func(body io.Reader, stat *QueryStat) error {
+		if err := json.NewDecoder(body).Decode(&out); err != nil {
+			return err
+		}
+		stat.ElapsedMs = out.Statistics.Elapsed * 1000
+		stat.ReadRows = max(stat.ReadRows, out.Statistics.RowsRead)
+		stat.ReadBytes = max(stat.ReadBytes, out.Statistics.BytesRead)
+		stat.ResultRows = uint64(len(out.Data))
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	return out.Data, nil
+}
+
+func (c *Client) QueryEach(ctx context.Context, sql string, fn func(Row) error) error {
+	return c.QueryEachWith(ctx, sql, nil, fn)
+}
+
+func (c *Client) QueryEachWith(ctx context.Context, sql string, args map[string]string, fn func(Row) error) error {
+	return c.execute(ctx, sql, "JSONEachRow", args, func(body io.Reader, stat *QueryStat) error {
+		dec := json.NewDecoder(body)
+		for {
+			var raw json.RawMessage
+			err := dec.Decode(&raw)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return streamError(err, io.MultiReader(dec.Buffered(), body))
+			}
+			stat.ResultRows++
+			if err := fn(Row(raw)); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+func streamError(err error, rest io.Reader) error {
+	var syntax *json.SyntaxError
+	if !errors.As(err, &syntax) {
+		return err
+	}
+	tail, _ := io.ReadAll(io.LimitReader(rest, 8192))
+	if msg := strings.TrimSpace(string(tail)); strings.Contains(msg, "Code: ") {
+		return fmt.Errorf("query failed mid-stream: %s", msg)
+	}
+	return err
+}
+
+func (c *Client) execute(ctx context.Context, sql, format string, args map[string]string, read func(io.Reader, *QueryStat) error) (err error) {
+	statement := fmt.Sprintf("%s FORMAT %s", strings.TrimSuffix(strings.TrimSpace(sql), ";"), format)
+	limit := c.timeout
+	if dl, ok := ctx.Deadline(); ok {
+		remaining := time.Until(dl)
+		if remaining <= 0 {
+			return ErrDeadline
+		}
+		if remaining < limit {
+			limit = remaining
+		}
+	}
+
+	info := requestFrom(ctx)
+	queryID := newQueryID()
+	params := url.Values{}
+	params.Set("database", c.database)
+	params.Set("cancel_http_readonly_queries_on_client_close", "1")
+	execSeconds := int(limit.Seconds())
+	if info != nil {
+		params.Set("log_comment", "request_id="+info.id)
+		if info.allowPartial {
+			execSeconds = int((limit - 500*time.Millisecond).Seconds())
+			params.Set("timeout_overflow_mode", "break")
+		}
+	}
+	if execSeconds < 1 {
+		execSeconds = 1
+	}
+	stat := QueryStat{At: time.Now().UTC(), Route: backgroundRun, QueryID: queryID, SQL: compactSQL(sql)}
+	if info != nil {
+		stat.RequestID = info.id
+		if info.route != "" {
+			stat.Route = info.route
+		}
+	}
+	defer func() {
+		stat.DurationMs = float64(time.Since(stat.At).Microseconds()) / 1000
+		if err != nil {
+			stat.Error = ErrorCode(err)
+		}
+		if c.queries.Record(stat) {
+			log.Printf("slow query: route=%s request_id=%s query_id=%s duration=%.0fms read_rows=%d read_bytes=%d error=%q",
+				stat.Route, stat.RequestID, stat.QueryID, stat.DurationMs, stat.ReadRows, stat.ReadBytes, stat.Error)
+		}
+	}()
+	params.Set("query_id", queryID)
+	params.Set("max_execution_time", fmt.Sprintf("%d", execSeconds))
+	for name, v := range args {
+		params.Set("param_"+name, v)
+	}
+	if c.compress {
+		params.Set("enable_http_compression", "1")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, limit)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/?"+params.Encode(), bytes.NewBufferString(statement))
+	if err != nil {
+		return err
+	}
 	req.Header.Set("Content-Type", "text/plain")
+	if c.compress {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		c.killIfCanceled(ctx, queryID)
+		return err
+	}
+	defer resp.Body.Close()
+	parseSummary(resp.Header.Get("X-ClickHouse-Summary"), &stat)
+	body, err := responseBody(resp)
+	if err != nil {
+		return fmt.Errorf("query failed: %s (undecodable %s body: %v)", resp.Status, resp.Header.Get("Content-Encoding"), err)
+	}
+	defer body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(body, 8192))
+		return fmt.Errorf("query failed: %s (%s)", resp.Status, string(msg))
+	}
+	if err := read(body, &stat); err != nil {
+		c.killIfCanceled(ctx, queryID)
+		return err
+	}
+	elapsed := stat.ElapsedMs / 1000
+	if elapsed == 0 {
+		elapsed = time.Since(stat.At).Seconds()
+	}
+	if info != nil && info.allowPartial && elapsed >= 0.95*float64(execSeconds) {
+		info.partial.Store(true)
+	}
+	return nil
+}
+
+func responseBody(resp *http.Response) (io.ReadCloser, error) {
+	if !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return io.NopCloser(resp.Body), nil
+	}
+	return gzip.NewReader(resp.Body)
+}
+
+func (c *Client) InsertJSONEachRow(ctx context.Context, table string, rows any) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	switch v := rows.(type) {
+	case []map[string]any:
+		for _, row := range v {
+			if err := enc.Encode(row); err != nil {
+				return err
+			}
+		}
+	default:
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	params := url.Values{}
+	params.Set("database", c.database)
+	params.Set("query", fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", table))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/?"+params.Encode(), &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode/100 != 2 {
 		body, _ := io.ReadAll(io.LimitReader(resp.Body, 8192))
-		return nil, fmt.Errorf("query failed: %s (%s)", resp.Status, string(body))
+		return fmt.Errorf("insert into %s failed: %s (%s)", table, resp.Status, string(body))
 	}
-	var out queryResponse
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		return nil, err
+	return nil
+}
+
+func (c *Client) killIfCanceled(ctx context.Context, queryID string) {
+	if ctx.Err() == nil {
+		return
 	}
-	return out.Data, nil
+	go func() {
+		killCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		stmt := fmt.Sprintf("KILL QUERY WHERE query_id = '%s' ASYNC", queryID)
+		req, err := http.NewRequestWithContext(killCtx, http.MethodPost, c.baseURL+"/", bytes.NewBufferString(stmt))
+		if err != nil {
+			return
+		}
+		req.Header.Set("Content-Type", "text/plain")
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			log.Printf("clickhouse: kill query %s failed: %v", queryID, err)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 2048))
+			log.Printf("clickhouse: kill query %s failed: %s (%s)", queryID, resp.Status, string(body))
+		}
+	}()
+}
+
+func newQueryID() string {
+	var b [12]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("tracelite-api-%d", time.Now().UnixNano())
+	}
+	return "tracelite-api-" + hex.EncodeToString(b[:])
 }
@@ -0,0 +1,153 @@
+package clickhouse
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	slowLogSize   = 200
+	maxLoggedSQL  = 4000
+	backgroundRun = "background"
+)
+
+type QueryStat struct {
+	At         time.Time `json:"at"`
+	Route      string    `json:"route"`
+	RequestID  string    `json:"request_id,omitempty"`
+	QueryID    string    `json:"query_id"`
+	DurationMs float64   `json:"duration_ms"`
+	ElapsedMs  float64   `json:"clickhouse_elapsed_ms"`
+	ReadRows   uint64    `json:"read_rows"`
+	ReadBytes  uint64    `json:"read_bytes"`
+	ResultRows uint64    `json:"result_rows"`
+	Error      string    `json:"error,omitempty"`
+	SQL        string    `json:"sql"`
+}
+
+type RouteStats struct {
+	Route     string  `json:"route"`
+	Queries   uint64  `json:"queries"`
+	Slow      uint64  `json:"slow"`
+	Errors    uint64  `json:"errors"`
+	TotalMs   float64 `json:"total_ms"`
+	MaxMs     float64 `json:"max_ms"`
+	ReadRows  uint64  `json:"read_rows"`
+	ReadBytes uint64  `json:"read_bytes"`
+}
+
+type QueryLog struct {
+	threshold time.Duration
+	since     time.Time
+	mu        sync.Mutex
+	slow      []QueryStat
+	routes    map[string]*RouteStats
+}
+
+type querySummary struct {
+	ReadRows   string `json:"read_rows"`
+	ReadBytes  string `json:"read_bytes"`
+	ResultRows string `json:"result_rows"`
+}
+
+func NewQueryLog(threshold time.Duration) *QueryLog {
+	return &QueryLog{threshold: threshold, since: time.Now().UTC(), routes: map[string]*RouteStats{}}
+}
+
+func (l *QueryLog) Threshold() time.Duration {
+	return l.threshold
+}
+
+func (l *QueryLog) Since() time.Time {
+	return l.since
+}
+
+func (l *QueryLog) Record(s QueryStat) bool {
+	if l == nil {
+		return false
+	}
+	if s.Route == "" {
+		s.Route = backgroundRun
+	}
+	slow := s.DurationMs >= float64(l.threshold.Milliseconds())
+	l.mu.Lock()
+	r := l.routes[s.Route]
+	if r == nil {
+		r = &RouteStats{Route: s.Route}
+		l.routes[s.Route] = r
+	}
+	r.Queries++
+	r.TotalMs += s.DurationMs
+	r.MaxMs = max(r.MaxMs, s.DurationMs)
+	r.ReadRows += s.ReadRows
+	r.ReadBytes += s.ReadBytes
+	if s.Error != "" {
+		r.Errors++
+	}
+	if slow {
+		r.Slow++
+		if len(l.slow) == slowLogSize {
+			copy(l.slow, l.slow[1:])
+			l.slow = l.slow[:slowLogSize-1]
+		}
+		l.slow = append(l.slow, s)
+	}
+	l.mu.Unlock()
+	return slow
+}
+
+func (l *QueryLog) Slow() []QueryStat {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]QueryStat, len(l.slow))
+	for i, s := range l.slow {
+		out[len(l.slow)-1-i] = s
+	}
+	return out
+}
+
+func (l *QueryLog) Routes() []RouteStats {
+	l.mu.Lock()
+	out := make([]RouteStats, 0, len(l.routes))
+	for _, r := range l.routes {
+		out = append(out, *r)
+	}
+	l.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].TotalMs != out[j].TotalMs {
+			return out[i].TotalMs > out[j].TotalMs
+		}
+		return out[i].Route < out[j].Route
+	})
+	return out
+}
+
+func parseSummary(header string, s *QueryStat) {
+	if header == "" {
+		return
+	}
+	var sum querySummary
+	if err := json.Unmarshal([]byte(header), &sum); err != nil {
+		return
+	}
+	s.ReadRows = max(s.ReadRows, parseCount(sum.ReadRows))
+	s.ReadBytes = max(s.ReadBytes, parseCount(sum.ReadBytes))
+	s.ResultRows = max(s.ResultRows, parseCount(sum.ResultRows))
+}
+
+func parseCount(v string) uint64 {
+	n, _ := strconv.ParseUint(v, 10, 64)
+	return n
+}
+
+func compactSQL(sql string) string {
+	sql = strings.Join(strings.Fields(sql), " ")
+	if len(sql) > maxLoggedSQL {
+		sql = sql[:maxLoggedSQL] + "..."
+	}
+	return sql
+}
@@ -0,0 +1,54 @@
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+)
+
+type requestKey struct{}
+
+type requestInfo struct {
+	id           string
+	route        string
+	allowPartial bool
+	partial      atomic.Bool
+}
+
+var ErrDeadline = errors.New("request deadline exceeded")
+
+func WithRequest(ctx context.Context, id, route string, allowPartial bool) context.Context {
+	return context.WithValue(ctx, requestKey{}, &requestInfo{id: id, route: route, allowPartial: allowPartial})
+}
+
+func Partial(ctx context.Context) bool {
+	info, ok := ctx.Value(requestKey{}).(*requestInfo)
+	return ok && info.partial.Load()
+}
+
+func requestFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestKey{}).(*requestInfo)
+	return info
+}
+
+func ErrorCode(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrDeadline), errors.Is(err, context.DeadlineExceeded):
+		return "deadline_exceeded"
+	case errors.Is(err, context.Canceled):
+		return "client_closed"
+	}
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "TIMEOUT_EXCEEDED"), strings.Contains(msg, "Code: 159."):
+		return "deadline_exceeded"
+	case strings.Contains(msg, "QUERY_WAS_CANCELLED"), strings.Contains(msg, "Code: 394."):
+		return "client_closed"
+	case strings.Contains(msg, "TOO_MANY_SIMULTANEOUS_QUERIES"), strings.Contains(msg, "Code: 202."):
+		return "overloaded"
+	}
+	return "query_failed"
+}
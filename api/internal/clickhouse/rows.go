@@ -0,0 +1,65 @@
+package clickhouse
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+type Row []byte
+
+func (r Row) Map() (map[string]any, error) {
+	var out map[string]any
+	if err := json.Unmarshal(r, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (r Row) Scan(dst any) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return json.Unmarshal(r, dst)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(r, &fields); err != nil {
+		return err
+	}
+	s := v.Elem()
+	t := s.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		raw, ok := fields[name]
+		if !ok || string(raw) == "null" {
+			continue
+		}
+		if numeric(f.Type.Kind()) && len(raw) > 1 && raw[0] == '"' {
+			raw = raw[1 : len(raw)-1]
+		}
+		if err := json.Unmarshal(raw, s.Field(i).Addr().Interface()); err != nil {
+			return fmt.Errorf("scan %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func numeric(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
@@ -1,24 +1,338 @@
 package config
 
-import "os"
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"trace-lite/api/internal/fieldcrypt"
+)
+
+type Limit struct {
+	Default int
+	Max     int
+}
 
 type Config struct {
-	Addr          string
-	ClickHouseDSN string
-	ClickHouseDB  string
+	Addr               string
+	ClickHouseDSN      string
+	ClickHouseDB       string
+	ClickHouseUser     string
+	ClickHousePass     string
+	ClickHouseCompress bool
+	SecretsRefresh     time.Duration
+	StartupWait        time.Duration
+	TraceSource        string
+	Limits             map[string]Limit
+	AutoCompareSoak    time.Duration
+	AutoCompareEvery   time.Duration
+	AdminToken         string
+	AlertRulesFile     string
+	AlertInterval      time.Duration
+	AlertLinkBase      string
+	OTLPEndpoint       string
+	OTLPHeaders        map[string]string
+	OTLPInterval       time.Duration
+	HealthWeights      map[string]float64
+	HealthThresholds   map[string]float64
+	HealthBaseline     time.Duration
+	VerifyThresholds   map[string]float64
+	SlowQueryThreshold time.Duration
+	ChangesEvery       time.Duration
+	EdgeGoneAfter      time.Duration
+	ErrorRateStep      float64
+	Encryption         *fieldcrypt.Keyring
+	EncryptAttrs       []string
+	DecryptToken       string
+	UIEnabled          bool
+	EnvGroups          map[string][]string
 }
 
 func Load() Config {
-	return Config{
-		Addr:          getEnv("API_ADDR", ":8080"),
-		ClickHouseDSN: getEnv("CLICKHOUSE_DSN", "http://localhost:8123"),
-		ClickHouseDB:  getEnv("CLICKHOUSE_DB", "trace_lite"),
+	problems = nil
+	refCache = map[string]string{}
+	cfg := Config{
+		Addr:               getEnv("API_ADDR", ":8080"),
+		ClickHouseDSN:      getEnv("CLICKHOUSE_DSN", "http://localhost:8123"),
+		ClickHouseDB:       getEnv("CLICKHOUSE_DB", "trace_lite"),
+		ClickHouseUser:     getEnv("CLICKHOUSE_USER", ""),
+		ClickHousePass:     getEnv("CLICKHOUSE_PASSWORD", ""),
+		ClickHouseCompress: getEnvBool("CLICKHOUSE_COMPRESSION", true),
+		SecretsRefresh:     getEnvDuration("SECRETS_REFRESH", 5*time.Minute),
+		StartupWait:        getEnvDuration("CLICKHOUSE_STARTUP_WAIT", 0),
+		TraceSource:        getEnv("TRACE_SOURCE", "reconstructor"),
+		Limits:             parseLimits(getEnv("API_LIMITS", "")),
+		AutoCompareSoak:    getEnvDuration("AUTO_COMPARE_SOAK", 30*time.Minute),
+		AutoCompareEvery:   getEnvDuration("AUTO_COMPARE_INTERVAL", time.Minute),
+		AdminToken:         getEnv("ADMIN_TOKEN", ""),
+		AlertRulesFile:     getEnv("ALERT_RULES_FILE", ""),
+		AlertInterval:      getEnvDuration("ALERT_INTERVAL", time.Minute),
+		AlertLinkBase:      strings.TrimRight(getEnv("ALERT_LINK_BASE", ""), "/"),
+		OTLPEndpoint:       strings.TrimRight(getEnv("OTLP_ENDPOINT", ""), "/"),
+		OTLPHeaders:        parseHeaders(getEnv("OTLP_HEADERS", "")),
+		OTLPInterval:       getEnvDuration("OTLP_INTERVAL", time.Minute),
+		HealthWeights:      parseWeights("HEALTH_WEIGHTS", map[string]float64{"error": 0.5, "latency": 0.3, "saturation": 0.2}),
+		HealthThresholds:   parseWeights("HEALTH_THRESHOLDS", map[string]float64{"green": 80, "yellow": 50}),
+		HealthBaseline:     getEnvDuration("HEALTH_BASELINE", 24*time.Hour),
+		VerifyThresholds:   parseWeights("VERIFY_THRESHOLDS", map[string]float64{"p95_delta_pct": 20, "error_rate_delta": 0.01, "new_error_groups": 0, "min_calls": 50}),
+		SlowQueryThreshold: getEnvDuration("SLOW_QUERY_THRESHOLD", time.Second),
+		ChangesEvery:       getEnvDuration("DEPENDENCY_CHANGES_INTERVAL", 5*time.Minute),
+		EdgeGoneAfter:      getEnvDuration("DEPENDENCY_GONE_AFTER", 24*time.Hour),
+		ErrorRateStep:      getEnvFloat("DEPENDENCY_ERROR_STEP", 0.05),
+		Encryption:         loadKeyring(),
+		EncryptAttrs:       getEnvList("ENCRYPT_ATTRS", ""),
+		DecryptToken:       getEnv("DECRYPT_TOKEN", ""),
+		UIEnabled:          getEnvBool("UI_ENABLED", true),
+		EnvGroups:          parseEnvGroups(getEnv("ENV_GROUPS", "")),
+	}
+	if cfg.AutoCompareSoak < 0 {
+		problem("AUTO_COMPARE_SOAK must not be negative")
+	}
+	if cfg.AutoCompareEvery <= 0 {
+		problem("AUTO_COMPARE_INTERVAL must be positive")
+	}
+	if cfg.AlertInterval <= 0 {
+		problem("ALERT_INTERVAL must be positive")
+	}
+	if cfg.OTLPInterval <= 0 {
+		problem("OTLP_INTERVAL must be positive")
+	}
+	if u, err := url.Parse(cfg.OTLPEndpoint); cfg.OTLPEndpoint != "" && (err != nil || u.Scheme == "" || u.Host == "") {
+		problem("OTLP_ENDPOINT must be an http(s) URL")
+	}
+	if cfg.HealthWeights["error"]+cfg.HealthWeights["latency"]+cfg.HealthWeights["saturation"] <= 0 {
+		problem("HEALTH_WEIGHTS must have a positive weight")
+	}
+	if cfg.HealthThresholds["yellow"] > cfg.HealthThresholds["green"] {
+		problem("HEALTH_THRESHOLDS yellow must not be above green")
+	}
+	if cfg.HealthBaseline <= 0 {
+		problem("HEALTH_BASELINE must be positive")
+	}
+	if cfg.SlowQueryThreshold <= 0 {
+		problem("SLOW_QUERY_THRESHOLD must be positive")
+	}
+	if cfg.ChangesEvery < 0 {
+		problem("DEPENDENCY_CHANGES_INTERVAL must not be negative")
+	}
+	if cfg.EdgeGoneAfter < time.Hour {
+		problem("DEPENDENCY_GONE_AFTER must be at least 1h")
+	}
+	if cfg.ErrorRateStep <= 0 || cfg.ErrorRateStep >= 1 {
+		problem("DEPENDENCY_ERROR_STEP must be between 0 and 1")
+	}
+	if cfg.DecryptToken != "" && cfg.Encryption == nil {
+		problem("DECRYPT_TOKEN needs ENCRYPTION_KEY")
+	}
+	checkOneOf("TRACE_SOURCE", cfg.TraceSource, "reconstructor", "mv")
+	if u, err := url.Parse(cfg.ClickHouseDSN); err != nil || u.Scheme == "" || u.Host == "" {
+		problem("CLICKHOUSE_DSN must be an http(s) URL")
+	}
+	return cfg
+}
+
+func parseLimits(v string) map[string]Limit {
+	limits := map[string]Limit{
+		"traces":       {Default: 200, Max: 5000},
+		"edges":        {Default: 1000, Max: 5000},
+		"hosts":        {Default: 2000, Max: 10000},
+		"deltas":       {Default: 200, Max: 2000},
+		"transactions": {Default: 200, Max: 2000},
+		"lookup":       {Default: 100, Max: 1000},
+		"compares":     {Default: 50, Max: 500},
+		"alerts":       {Default: 200, Max: 2000},
+		"changes":      {Default: 200, Max: 2000},
+		"logs":         {Default: 1000, Max: 10000},
+		"usage":        {Default: 200, Max: 2000},
+		"clusters":     {Default: 20, Max: 200},
+		"spans":        {Default: 10000, Max: 50000},
+	}
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, rest, ok := strings.Cut(entry, "=")
+		def, maxRaw, _ := strings.Cut(rest, "/")
+		d, err := strconv.Atoi(strings.TrimSpace(def))
+		if !ok || err != nil || d <= 0 {
+			problem("ignoring malformed API_LIMITS entry %q", entry)
+			continue
+		}
+		l := Limit{Default: d, Max: limits[strings.TrimSpace(name)].Max}
+		if m, err := strconv.Atoi(strings.TrimSpace(maxRaw)); err == nil && m > 0 {
+			l.Max = m
+		}
+		if l.Max < l.Default {
+			l.Max = l.Default
+		}
+		limits[strings.TrimSpace(name)] = l
+	}
+	return limits
+}
+
+func parseWeights(key string, defaults map[string]float64) map[string]float64 {
+	for _, entry := range strings.Split(getEnv(key, ""), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, raw, _ := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		f, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if _, known := defaults[name]; !known || err != nil || f < 0 {
+			problem("ignoring malformed %s entry %q", key, entry)
+			continue
+		}
+		defaults[name] = f
+	}
+	return defaults
+}
+
+func loadKeyring() *fieldcrypt.Keyring {
+	raw, id := getEnv("ENCRYPTION_KEY", ""), getEnv("ENCRYPTION_KEY_ID", "k1")
+	prevRaw, prevID := getEnv("ENCRYPTION_PREVIOUS_KEY", ""), getEnv("ENCRYPTION_PREVIOUS_KEY_ID", "")
+	if raw == "" {
+		if prevRaw != "" {
+			problem("ENCRYPTION_PREVIOUS_KEY needs ENCRYPTION_KEY")
+		}
+		return nil
 	}
+	key, err := fieldcrypt.ParseKey(raw)
+	if err != nil {
+		problem("ENCRYPTION_KEY: %v", err)
+		return nil
+	}
+	ring, err := fieldcrypt.New(id, key)
+	if err != nil {
+		problem("ENCRYPTION_KEY_ID: %v", err)
+		return nil
+	}
+	if prevRaw == "" {
+		return ring
+	}
+	if prevID == "" || prevID == id {
+		problem("ENCRYPTION_PREVIOUS_KEY_ID must be set and differ from ENCRYPTION_KEY_ID")
+		return ring
+	}
+	if key, err = fieldcrypt.ParseKey(prevRaw); err == nil {
+		err = ring.Add(prevID, key)
+	}
+	if err != nil {
+		problem("ENCRYPTION_PREVIOUS_KEY: %v", err)
+	}
+	return ring
+}
+
+func parseEnvGroups(v string) map[string][]string {
+	out := map[string][]string{}
+	owner := map[string]string{}
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		group, rest, ok := strings.Cut(entry, "=")
+		group = strings.TrimSpace(group)
+		var members []string
+		for _, m := range strings.Split(rest, "|") {
+			if m = strings.TrimSpace(m); m != "" {
+				members = append(members, m)
+			}
+		}
+		if !ok || group == "" || len(members) == 0 || out[group] != nil {
+			problem("ignoring malformed ENV_GROUPS entry %q", entry)
+			continue
+		}
+		for _, m := range members {
+			if other, taken := owner[m]; taken {
+				problem("ENV_GROUPS puts env %q in both %q and %q", m, other, group)
+			}
+			owner[m] = group
+		}
+		out[group] = members
+	}
+	return out
+}
+
+func parseHeaders(v string) map[string]string {
+	out := map[string]string{}
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			problem("ignoring malformed OTLP_HEADERS entry for %q", strings.TrimSpace(name))
+			continue
+		}
+		out[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return out
 }
 
 func getEnv(key, fallback string) string {
-	if v := os.Getenv(key); v != "" {
-		return v
+	v := envValue(key)
+	if v == "" {
+		v = fallback
+	}
+	record(key, v)
+	return v
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	v := envValue(key)
+	if v == "" {
+		record(key, fallback)
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		problem("%s=%q is not a valid duration", key, v)
+		d = fallback
+	}
+	record(key, d)
+	return d
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	v := envValue(key)
+	if v == "" {
+		record(key, fallback)
+		return fallback
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		problem("%s=%q is not a valid number", key, v)
+		f = fallback
+	}
+	record(key, f)
+	return f
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	v := envValue(key)
+	if v == "" {
+		record(key, fallback)
+		return fallback
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		problem("%s=%q is not a valid bool", key, v)
+		b = fallback
+	}
+	record(key, b)
+	return b
+}
+
+func getEnvList(key, fallback string) []string {
+	var out []string
+	for _, v := range strings.Split(getEnv(key, fallback), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
 	}
-	return fallback
+	return out
 }
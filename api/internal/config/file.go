@@ -0,0 +1,260 @@
+package config
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"trace-lite/api/internal/secrets"
+)
+
+type Setting struct {
+	Key    string
+	Value  string
+	Source string
+}
+
+var (
+	settings  []Setting
+	settingAt = map[string]int{}
+	fileKeys  = map[string]int{}
+	fileSet   = map[string]bool{}
+	problems  []string
+	secretSet = map[string]bool{}
+	refCache  = map[string]string{}
+)
+
+func LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return fmt.Errorf("%s:%d: malformed section header", path, n)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: expected key = value", path, n)
+		}
+		key := strings.ToUpper(strings.TrimSpace(k))
+		if section != "" {
+			key = strings.ToUpper(section) + "_" + key
+		}
+		key = strings.NewReplacer(".", "_", "-", "_").Replace(key)
+		val, err := parseFileValue(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s: %w", path, n, key, err)
+		}
+		if _, dup := fileKeys[key]; dup {
+			return fmt.Errorf("%s:%d: %s set twice", path, n, key)
+		}
+		fileKeys[key] = n
+		if _, set := os.LookupEnv(key); set {
+			continue
+		}
+		if err := os.Setenv(key, val); err != nil {
+			return err
+		}
+		fileSet[key] = true
+	}
+	return scanner.Err()
+}
+
+func stripComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0 && c == '\\' && quote == '"':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func parseFileValue(v string) (string, error) {
+	switch {
+	case v == "":
+		return "", fmt.Errorf("missing value")
+	case strings.HasPrefix(v, `"`):
+		return strconv.Unquote(v)
+	case strings.HasPrefix(v, "'"):
+		if len(v) < 2 || !strings.HasSuffix(v, "'") {
+			return "", fmt.Errorf("unterminated string")
+		}
+		return v[1 : len(v)-1], nil
+	case strings.HasPrefix(v, "["):
+		if !strings.HasSuffix(v, "]") {
+			return "", fmt.Errorf("unterminated array")
+		}
+		var items []string
+		for _, item := range strings.Split(v[1:len(v)-1], ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			s, err := parseFileValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return v, nil
+}
+
+func envValue(key string) string {
+	v := os.Getenv(key)
+	if v == "" {
+		if path := os.Getenv(key + "_FILE"); path != "" {
+			v = "file://" + path
+		}
+	}
+	if !secrets.IsRef(v) {
+		return v
+	}
+	secretSet[key] = true
+	if resolved, ok := refCache[v]; ok {
+		return resolved
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resolved, err := secrets.Resolve(ctx, v)
+	if err != nil {
+		problem("%s: %v", key, err)
+		return ""
+	}
+	refCache[v] = resolved
+	return resolved
+}
+
+func HasSecrets() bool {
+	return len(secretSet) > 0
+}
+
+func record(key string, value any) {
+	source := "default"
+	if secretSet[key] {
+		source = "secret"
+	} else if fileSet[key] {
+		source = "file"
+	} else if os.Getenv(key) != "" {
+		source = "env"
+	}
+	s := Setting{Key: key, Value: fmt.Sprint(value), Source: source}
+	if i, ok := settingAt[key]; ok {
+		settings[i] = s
+		return
+	}
+	settingAt[key] = len(settings)
+	settings = append(settings, s)
+}
+
+func problem(format string, args ...any) {
+	problems = append(problems, fmt.Sprintf(format, args...))
+}
+
+func checkOneOf(key, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	problem("%s=%q must be one of %s", key, value, strings.Join(allowed, ", "))
+}
+
+func Problems() []string {
+	out := append([]string(nil), problems...)
+	var unknown []string
+	for key := range fileKeys {
+		if _, ok := settingAt[strings.TrimSuffix(key, "_FILE")]; !ok {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		out = append(out, fmt.Sprintf("config file line %d: unknown option %s", fileKeys[key], key))
+	}
+	return out
+}
+
+func Effective() []Setting {
+	out := make([]Setting, len(settings))
+	for i, s := range settings {
+		s.Value = redact(s.Key, s.Value)
+		out[i] = s
+	}
+	return out
+}
+
+func redact(key, value string) string {
+	if value == "" {
+		return value
+	}
+	switch {
+	case secretSet[key] && !strings.HasSuffix(key, "_DSN"):
+		return "<redacted>"
+	case strings.Contains(key, "TOKEN"), strings.Contains(key, "PASSWORD"), strings.Contains(key, "SECRET"), strings.HasSuffix(key, "_HEADERS"), strings.HasSuffix(key, "_KEY"):
+		return "<redacted>"
+	case strings.HasSuffix(key, "_DSN"):
+		if u, err := url.Parse(value); err == nil {
+			return u.Redacted()
+		}
+	}
+	return value
+}
+
+func PrintEffective(w *os.File) {
+	for _, s := range Effective() {
+		fmt.Fprintf(w, "%-36s %-8s %s\n", s.Key, s.Source, s.Value)
+	}
+}
+
+func MustLoad(path string, check bool) Config {
+	if path != "" {
+		if err := LoadFile(path); err != nil {
+			log.Fatalf("config file: %v", err)
+		}
+	}
+	cfg := Load()
+	problems := Problems()
+	if check {
+		PrintEffective(os.Stdout)
+	}
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, "config error: %s\n", p)
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+	if check {
+		os.Exit(0)
+	}
+	return cfg
+}
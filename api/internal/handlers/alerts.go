@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"trace-lite/api/internal/alerting"
+	"trace-lite/api/internal/query"
+)
+
+type alertState struct {
+	firing   bool
+	silenced bool
+	since    time.Time
+	value    float64
+}
+
+type alertEvent struct {
+	Fingerprint string  `json:"fingerprint"`
+	Rule        string  `json:"rule"`
+	Service     string  `json:"service"`
+	Env         string  `json:"env"`
+	Metric      string  `json:"metric"`
+	Op          string  `json:"op"`
+	Threshold   float64 `json:"threshold"`
+	Status      string  `json:"status"`
+	Value       float64 `json:"value"`
+	Silenced    uint8   `json:"silenced"`
+	StartsAt    string  `json:"starts_at"`
+	At          string  `json:"at"`
+}
+
+func (h *Handler) RunAlerts(cfg alerting.Config, every time.Duration) {
+	if len(cfg.Rules) == 0 {
+		return
+	}
+	log.Printf("alerting: evaluating %d rules every %s", len(cfg.Rules), every)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	if err := h.restoreAlerts(ctx); err != nil {
+		log.Printf("alerting: restoring alert state: %v", err)
+	}
+	cancel()
+	for range time.Tick(every) {
+		for _, rule := range cfg.Rules {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			if err := h.evaluateRule(ctx, cfg, rule); err != nil {
+				log.Printf("alerting: rule %s: %v", rule.Name, err)
+			}
+			cancel()
+		}
+	}
+}
+
+func (h *Handler) evaluateRule(ctx context.Context, cfg alerting.Config, rule *alerting.Rule) error {
+	now := h.now().UTC()
+	window := rule.WindowDuration()
+	rows, err := h.run(ctx, query.New().
+		Select("count() AS calls",
+			"if(calls = 0, 0, avg(is_error)) AS error_rate",
+			"if(calls = 0, 0, avg(status = 'timeout')) AS timeout_rate",
+			"if(calls = 0, 0, quantile(0.50)(duration_ms)) AS p50_ms",
+			"if(calls = 0, 0, quantile(0.95)(duration_ms)) AS p95_ms",
+			"if(calls = 0, 0, quantile(0.99)(duration_ms)) AS p99_ms",
+			"arraySlice(groupUniqArrayIf(trace_id, is_error = 1), 1, 3) AS error_traces",
+			"argMax(trace_id, duration_ms) AS slowest_trace").
+		From(h.spansTable).
+		Eq("service", rule.Service).
+		TimeRange("start_ts", now.Add(-window), now).
+		FilterIn("env", h.envs(rule.Env)))
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	row := rows[0]
+	values := map[string]float64{}
+	for _, m := range []string{"calls", "error_rate", "timeout_rate", "p50_ms", "p95_ms", "p99_ms"} {
+		values[m] = toFloat(row[m])
+	}
+	if values["calls"] < float64(rule.MinCalls) {
+		return nil
+	}
+	value := values[rule.Metric]
+	breached := rule.Breached(value)
+
+	fingerprint := rule.Fingerprint()
+	h.alertsMu.Lock()
+	st := h.alerts[fingerprint]
+	if st == nil {
+		st = &alertState{}
+		h.alerts[fingerprint] = st
+	}
+	st.value = value
+	if breached == st.firing {
+		h.alertsMu.Unlock()
+		return nil
+	}
+	status := "resolved"
+	if breached {
+		status = "firing"
+		st.since = now
+		windows, err := h.maintenanceWindows(ctx, rule.Env, rule.Service, now, now.Add(time.Second))
+		if err != nil {
+			log.Printf("alerting: maintenance windows for %s: %v", rule.Service, err)
+		}
+		st.silenced = len(windows) > 0
+	}
+	st.firing = breached
+	silenced, since := st.silenced, st.since
+	h.alertsMu.Unlock()
+
+	event := alertEvent{
+		Fingerprint: fingerprint,
+		Rule:        rule.Name,
+		Service:     rule.Service,
+		Env:         rule.Env,
+		Metric:      rule.Metric,
+		Op:          rule.Op,
+		Threshold:   rule.Threshold,
+		Status:      status,
+		Value:       value,
+		Silenced:    boolToUint8(silenced),
+		StartsAt:    chTime(since),
+		At:          chTime(now),
+	}
+	if err := h.ch.InsertJSONEachRow(ctx, "alert_events", event); err != nil {
+		log.Printf("alerting: persisting %s %s: %v", rule.Name, status, err)
+	}
+
+	if silenced {
+		log.Printf("alerting: %s %s during maintenance, not notifying", rule.Name, status)
+		return nil
+	}
+
+	var traces []string
+	switch {
+	case strings.HasSuffix(rule.Metric, "_rate"):
+		if list, ok := row["error_traces"].([]any); ok {
+			for _, id := range list {
+				traces = append(traces, toString(id))
+			}
+		}
+	case strings.HasSuffix(rule.Metric, "_ms"):
+		if id := toString(row["slowest_trace"]); id != "" {
+			traces = append(traces, id)
+		}
+	}
+	ev := alerting.Event{
+		Rule:      rule.Name,
+		Status:    status,
+		Service:   rule.Service,
+		Env:       rule.Env,
+		Metric:    rule.Metric,
+		Op:        rule.Op,
+		Value:     value,
+		Threshold: rule.Threshold,
+		Window:    window,
+		Metrics:   values,
+		StartsAt:  since,
+		At:        now,
+		Links:     map[string]string{},
+		Traces:    traces,
+	}
+	if h.linkBase != "" {
+		q := url.Values{}
+		q.Set("service", rule.Service)
+		if rule.Env != "" {
+			q.Set("env", rule.Env)
+		}
+		q.Set("from", now.Add(-window).Format(time.RFC3339))
+		q.Set("to", now.Format(time.RFC3339))
+		ev.Links["traces"] = h.linkBase + "/v1/traces?" + q.Encode()
+		ev.Links["errors"] = h.linkBase + "/v1/errors?" + q.Encode()
+		for _, id := range traces {
+			ev.TraceLinks = append(ev.TraceLinks, h.linkBase+"/v1/traces/"+url.PathEscape(id)+"/waterfall")
+		}
+	}
+	for _, err := range h.notifier.Notify(ctx, cfg, rule, ev) {
+		log.Printf("alerting: %v", err)
+	}
+	return nil
+}
+
+func (h *Handler) restoreAlerts(ctx context.Context) error {
+	rows, err := h.run(ctx, query.New().
+		Select("fingerprint, argMax(status, at) AS status, argMax(silenced, at) AS silenced, argMax(starts_at, at) AS starts_at, argMax(value, at) AS value").
+		From("alert_events").
+		Where("at >= now64(3) - INTERVAL 30 DAY").
+		GroupBy("fingerprint").
+		Having("status = 'firing'"))
+	if err != nil {
+		return err
+	}
+	h.alertsMu.Lock()
+	defer h.alertsMu.Unlock()
+	for _, row := range rows {
+		h.alerts[toString(row["fingerprint"])] = &alertState{
+			firing:   true,
+			silenced: toFloat(row["silenced"]) == 1,
+			since:    parseCHTime(toString(row["starts_at"])),
+			value:    toFloat(row["value"]),
+		}
+	}
+	if len(rows) > 0 {
+		log.Printf("alerting: restored %d firing alerts", len(rows))
+	}
+	return nil
+}
+
+func (h *Handler) Alerts(w http.ResponseWriter, r *http.Request) {
+	from, to := h.parseRange(r)
+	params := r.URL.Query()
+	filter := func(q *query.Query) *query.Query {
+		return q.From("alert_events").
+			Filter("rule", sanitize(params.Get("rule"))).
+			Filter("service", sanitize(params.Get("service"))).
+			FilterIn("env", h.envs(sanitize(params.Get("env"))))
+	}
+	limit := h.limitFor(r, "alerts", "limit")
+
+	current, err := h.run(r.Context(), filter(query.New().Where("at >= now64(3) - INTERVAL 30 DAY")).
+		Select("fingerprint",
+			"argMax(rule, at) AS rule, argMax(service, at) AS service, argMax(env, at) AS env",
+			"argMax(metric, at) AS metric, argMax(op, at) AS op, argMax(threshold, at) AS threshold",
+			"argMax(status, at) AS status, argMax(value, at) AS value, argMax(silenced, at) AS silenced",
+			"argMax(starts_at, at) AS starts_at, max(at) AS last_change").
+		GroupBy("fingerprint").
+		Having("status = 'firing'").
+		OrderBy("starts_at ASC"))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	h.alertsMu.Lock()
+	for _, row := range current {
+		if st := h.alerts[toString(row["fingerprint"])]; st != nil {
+			row["value"] = st.value
+		}
+	}
+	h.alertsMu.Unlock()
+
+	history, err := h.run(r.Context(), filter(query.New().TimeRange("at", from, to)).
+		Select("fingerprint, rule, service, env, metric, op, threshold, status, value, silenced, starts_at, at", "count() OVER () AS _total").
+		OrderBy("at DESC").
+		Limit(limit))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	history, page := splitTotal(history, limit)
+	page["firing"] = current
+	page["history"] = history
+	writeJSON(w, http.StatusOK, page)
+}
+
+func boolToUint8(v bool) uint8 {
+	if v {
+		return 1
+	}
+	return 0
+}
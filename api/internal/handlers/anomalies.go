@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"trace-lite/api/internal/query"
+)
+
+const (
+	latencyBadgePct  = 100
+	errorBadgePct    = 50
+	timeoutBadgePct  = 50
+	trafficBadgePct  = 100
+	deviationScale   = 300
+	evidenceOpsLimit = 3
+	evidenceSpans    = 3
+)
+
+func buildAnomalyBadges(rows []map[string]any, q compareQuery, deltas []map[string]any) []map[string]any {
+	if len(rows) == 0 {
+		return nil
+	}
+	r := rows[0]
+	baseP95 := toFloat(r["base_p95"])
+	candP95 := toFloat(r["cand_p95"])
+	baseErr := toFloat(r["base_error_rate"])
+	candErr := toFloat(r["cand_error_rate"])
+	baseCalls := toFloat(r["base_calls"])
+	candCalls := toFloat(r["cand_calls"])
+
+	latPct := pctDelta(baseP95, candP95)
+	errPct := pctDelta(baseErr, candErr)
+	callPct := pctDelta(baseCalls, candCalls)
+
+	deviation := clamp(math.Max(math.Abs(latPct)/deviationScale, math.Max(math.Abs(errPct)/deviationScale, math.Abs(callPct)/deviationScale)), 0, 1)
+	evidence := func(metric string, base, cand, deltaPct, threshold float64) map[string]any {
+		return map[string]any{
+			"metric":        metric,
+			"base":          base,
+			"cand":          cand,
+			"delta_pct":     round(deltaPct, 2),
+			"threshold_pct": threshold,
+			"base_calls":    baseCalls,
+			"cand_calls":    candCalls,
+			"window":        map[string]any{"from": q.from, "to": q.to},
+			"deviation": map[string]any{
+				"latency_pct": round(latPct, 2),
+				"error_pct":   round(errPct, 2),
+				"calls_pct":   round(callPct, 2),
+				"scale_pct":   deviationScale,
+			},
+		}
+	}
+
+	badges := make([]map[string]any, 0)
+	if latPct >= latencyBadgePct {
+		ev := evidence("p95_ms", baseP95, candP95, latPct, latencyBadgePct)
+		ev["operations"] = slowestOperations(deltas, q.opCols)
+		badges = append(badges, map[string]any{
+			"level":           "orange",
+			"title":           "Latency spike detected",
+			"message":         fmt.Sprintf("p95 +%.1f%%", latPct),
+			"deviation_score": round(deviation, 3),
+			"evidence":        ev,
+		})
+	}
+	if errPct >= errorBadgePct {
+		badges = append(badges, map[string]any{
+			"level":           "red",
+			"title":           "Error anomaly detected",
+			"message":         fmt.Sprintf("error rate +%.1f%%", errPct),
+			"deviation_score": round(deviation, 3),
+			"evidence":        evidence("error_rate", baseErr, candErr, errPct, errorBadgePct),
+		})
+	}
+	baseTimeout := toFloat(r["base_timeout_rate"])
+	candTimeout := toFloat(r["cand_timeout_rate"])
+	if timeoutPct := pctDelta(baseTimeout, candTimeout); timeoutPct >= timeoutBadgePct && candTimeout > 0 {
+		badges = append(badges, map[string]any{
+			"level":           "red",
+			"title":           "Timeout anomaly detected",
+			"message":         fmt.Sprintf("timeout rate %.2f%% -> %.2f%%", baseTimeout*100, candTimeout*100),
+			"deviation_score": round(deviation, 3),
+			"evidence":        evidence("timeout_rate", baseTimeout, candTimeout, timeoutPct, timeoutBadgePct),
+		})
+	}
+	if callPct >= trafficBadgePct {
+		badges = append(badges, map[string]any{
+			"level":           "yellow",
+			"title":           "Traffic spike detected",
+			"message":         fmt.Sprintf("calls +%.1f%%", callPct),
+			"deviation_score": round(deviation, 3),
+			"evidence":        evidence("calls", baseCalls, candCalls, callPct, trafficBadgePct),
+		})
+	}
+	return badges
+}
+
+func slowestOperations(deltas []map[string]any, opCols string) []map[string]any {
+	out := []map[string]any{}
+	for _, row := range deltas {
+		if len(out) == evidenceOpsLimit {
+			break
+		}
+		if toFloat(row["delta_p95_ms"]) <= 0 {
+			continue
+		}
+		op := map[string]any{}
+		for _, k := range append(strings.Split(opCols, ", "), "base_p95_ms", "cand_p95_ms", "delta_p95_ms", "significance") {
+			op[k] = row[k]
+		}
+		out = append(out, op)
+	}
+	return out
+}
+
+func (h *Handler) addBadgeSpans(ctx context.Context, spans *query.Query, cand string, badges []map[string]any) error {
+	for _, b := range badges {
+		ev, _ := b["evidence"].(map[string]any)
+		if ev == nil {
+			continue
+		}
+		q := spans.Clone().Eq("version", cand)
+		switch toString(ev["metric"]) {
+		case "error_rate":
+			q.Where("is_error = 1").OrderBy("start_ts DESC")
+		case "timeout_rate":
+			q.Where("status = 'timeout'").OrderBy("start_ts DESC")
+		default:
+			q.OrderBy("duration_ms DESC")
+		}
+		rows, err := h.run(ctx, q.
+			Select("trace_id, span_id, operation, duration_ms, status, start_ts").
+			Limit(evidenceSpans))
+		if err != nil {
+			return err
+		}
+		if rows == nil {
+			rows = []map[string]any{}
+		}
+		ev["spans"] = rows
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"trace-lite/api/internal/query"
+)
+
+type autoCompareRow struct {
+	Env           string  `json:"env"`
+	Service       string  `json:"service"`
+	BaseVersion   string  `json:"base_version"`
+	CandVersion   string  `json:"cand_version"`
+	DeployedAt    string  `json:"deployed_at"`
+	EvaluatedAt   string  `json:"evaluated_at"`
+	SoakSeconds   uint32  `json:"soak_seconds"`
+	Verdict       string  `json:"verdict"`
+	Silenced      uint8   `json:"silenced"`
+	MaintenanceID string  `json:"maintenance_id"`
+	BaseCalls     uint64  `json:"base_calls"`
+	CandCalls     uint64  `json:"cand_calls"`
+	BaseP95       float64 `json:"base_p95"`
+	CandP95       float64 `json:"cand_p95"`
+	BaseErrorRate float64 `json:"base_error_rate"`
+	CandErrorRate float64 `json:"cand_error_rate"`
+	Result        string  `json:"result"`
+}
+
+func (h *Handler) RunAutoCompare(every time.Duration) {
+	if h.autoSoak <= 0 {
+		return
+	}
+	for range time.Tick(every) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		if err := h.autoCompareOnce(ctx); err != nil {
+			log.Printf("auto compare: %v", err)
+		}
+		cancel()
+	}
+}
+
+func (h *Handler) autoCompareOnce(ctx context.Context) error {
+	soak := int(h.autoSoak.Seconds())
+	q := query.New()
+	firstSeen := q.Sub().
+		Select("env, service, version, min(bucket_ts) AS first_seen").
+		From("service_versions_minute").
+		Where("bucket_ts >= now() - INTERVAL 7 DAY", "version != ''").
+		GroupBy("env, service, version")
+	candidates, err := h.run(ctx, q.
+		Select("env, service, version, first_seen").
+		FromQuery(firstSeen, "").
+		Where(fmt.Sprintf("first_seen >= now() - INTERVAL 1 DAY - INTERVAL %d SECOND", soak),
+			fmt.Sprintf("first_seen <= now() - INTERVAL %d SECOND", soak)).
+		NotInQuery("(env, service, version)", q.Sub().Select("env, service, cand_version").From("compare_auto")).
+		OrderBy("first_seen ASC").
+		Limit(20))
+	if err != nil {
+		return err
+	}
+	for _, c := range candidates {
+		env, service, cand := toString(c["env"]), toString(c["service"]), toString(c["version"])
+		if sanitize(service) != service || sanitize(cand) != cand || (env != "" && sanitize(env) != env) {
+			continue
+		}
+		row, err := h.autoCompare(ctx, env, service, cand, parseCHTime(toString(c["first_seen"])))
+		if err != nil {
+			log.Printf("auto compare %s %s@%s: %v", env, service, cand, err)
+			continue
+		}
+		if err := h.ch.InsertJSONEachRow(ctx, "compare_auto", row); err != nil {
+			return err
+		}
+		log.Printf("auto compare %s %s %s -> %s: %s", env, service, row.BaseVersion, cand, row.Verdict)
+	}
+	return nil
+}
+
+func (h *Handler) autoCompare(ctx context.Context, env, service, cand string, deployed time.Time) (autoCompareRow, error) {
+	row := autoCompareRow{
+		Env:         env,
+		Service:     service,
+		CandVersion: cand,
+		DeployedAt:  chMinute(deployed),
+		EvaluatedAt: chTime(h.now().UTC()),
+		SoakSeconds: uint32(h.autoSoak.Seconds()),
+		Verdict:     "no_baseline",
+		Result:      "{}",
+	}
+	q := query.New()
+	deployedAt := q.Minute(deployed)
+	prev, err := h.run(ctx, q.
+		Select("version, sum(calls) AS calls, max(bucket_ts) AS last_seen").
+		From("service_versions_minute").
+		Eq("env", env).
+		Eq("service", service).
+		Where("version != ''", "version != "+q.String(cand)).
+		Where("bucket_ts >= "+deployedAt+" - INTERVAL 1 DAY", "bucket_ts < "+deployedAt).
+		GroupBy("version").
+		OrderBy("last_seen DESC", "calls DESC").
+		Limit(1))
+	if err != nil {
+		return row, err
+	}
+	if len(prev) == 0 || sanitize(toString(prev[0]["version"])) == "" {
+		return row, nil
+	}
+	row.BaseVersion = toString(prev[0]["version"])
+
+	result, summary, err := h.runCompare(ctx, compareQuery{
+		from:       deployed.Add(-h.autoSoak),
+		to:         deployed.Add(h.autoSoak),
+		env:        env,
+		service:    service,
+		base:       row.BaseVersion,
+		cand:       cand,
+		opCols:     "operation",
+		deltaLimit: 20,
+	})
+	if err != nil {
+		return row, err
+	}
+	row.BaseCalls = uint64(toFloat(summary["base_calls"]))
+	row.CandCalls = uint64(toFloat(summary["cand_calls"]))
+	row.BaseP95 = toFloat(summary["base_p95"])
+	row.CandP95 = toFloat(summary["cand_p95"])
+	row.BaseErrorRate = toFloat(summary["base_error_rate"])
+	row.CandErrorRate = toFloat(summary["cand_error_rate"])
+	row.Verdict = compareVerdict(row, result["anomalies"])
+	if windows, _ := result["maintenance"].([]map[string]any); len(windows) > 0 {
+		row.Silenced = 1
+		row.MaintenanceID = toString(windows[0]["id"])
+	}
+	if b, err := json.Marshal(result); err == nil {
+		row.Result = string(b)
+	}
+	return row, nil
+}
+
+func compareVerdict(row autoCompareRow, anomalies any) string {
+	if row.BaseCalls == 0 || row.CandCalls == 0 {
+		return "insufficient_data"
+	}
+	badges, _ := anomalies.([]map[string]any)
+	for _, b := range badges {
+		if level := toString(b["level"]); level == "red" || level == "orange" {
+			return "regression"
+		}
+	}
+	return "ok"
+}
+
+func (h *Handler) AutoCompare(w http.ResponseWriter, r *http.Request) {
+	service := sanitize(r.URL.Query().Get("service"))
+	if service == "" {
+		WriteError(w, http.StatusBadRequest, "invalid_request", "service is required", nil)
+		return
+	}
+	env := sanitize(r.URL.Query().Get("env"))
+	limit := h.limitFor(r, "compares", "limit")
+
+	d, err := h.run(r.Context(), query.New().
+		Select("env, service, base_version, cand_version, deployed_at, evaluated_at, soak_seconds, verdict, silenced, maintenance_id",
+			"base_calls, cand_calls, base_p95, cand_p95, base_error_rate, cand_error_rate, result",
+			"count() OVER () AS _total").
+		From("compare_auto FINAL").
+		Eq("service", service).
+		FilterIn("env", h.envs(env)).
+		OrderBy("deployed_at DESC").
+		Limit(limit))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	d, page := splitTotal(d, limit)
+	for _, row := range d {
+		var result map[string]any
+		if json.Unmarshal([]byte(toString(row["result"])), &result) == nil {
+			row["result"] = result
+		}
+	}
+	page["service"] = service
+	page["soak"] = h.autoSoak.String()
+	page["compares"] = d
+	writeJSON(w, http.StatusOK, page)
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"trace-lite/api/internal/query"
+)
+
+const (
+	baselineWindow     = 7 * 24 * time.Hour
+	baselineMinSamples = 20
+	unknownOutlier     = 0.25
+)
+
+type opBaseline struct {
+	Calls float64
+	P50   float64
+	P90   float64
+	P99   float64
+}
+
+func baselineKey(service, operation string) string {
+	return service + "\x00" + operation
+}
+
+func (h *Handler) operationBaselines(ctx context.Context, rows []map[string]any) (map[string]opBaseline, error) {
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	var start time.Time
+	seen := map[string]bool{}
+	q := query.New()
+	var pairs []string
+	for _, row := range rows {
+		if ts := parseCHTime(toString(row["start_ts"])); start.IsZero() || ts.Before(start) {
+			start = ts
+		}
+		svc, op := toString(row["service"]), toString(row["operation"])
+		if k := baselineKey(svc, op); !seen[k] {
+			seen[k] = true
+			pairs = append(pairs, fmt.Sprintf("(%s, %s)", q.String(svc), q.String(op)))
+		}
+	}
+	found, err := h.run(ctx, q.
+		Select("service, operation",
+			"sum(calls) AS calls",
+			"quantilesTDigestMerge(0.5, 0.9, 0.99)(duration_q) AS q").
+		From("operation_latency_hourly").
+		MinuteRange("bucket_ts", start.Add(-baselineWindow), start.Truncate(time.Hour)).
+		Filter("env", toString(rows[0]["env"])).
+		Where(fmt.Sprintf("(service, operation) IN (%s)", strings.Join(pairs, ", "))).
+		GroupBy("service, operation"))
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]opBaseline, len(found))
+	for _, row := range found {
+		qs, _ := row["q"].([]any)
+		if len(qs) < 3 {
+			continue
+		}
+		out[baselineKey(toString(row["service"]), toString(row["operation"]))] = opBaseline{
+			Calls: toFloat(row["calls"]),
+			P50:   toFloat(qs[0]),
+			P90:   toFloat(qs[1]),
+			P99:   toFloat(qs[2]),
+		}
+	}
+	return out, nil
+}
+
+func (b opBaseline) outlier(durationMs uint32) (float64, string) {
+	d := float64(durationMs)
+	band := "normal"
+	switch {
+	case d > b.P99:
+		band = "outlier"
+	case d > b.P90:
+		band = "slow"
+	}
+	return clamp((d-b.P90)/max(b.P99-b.P90, 1), 0, 1), band
+}
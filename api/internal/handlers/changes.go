@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"trace-lite/api/internal/query"
+)
+
+var changeKinds = []string{"new_edge", "edge_gone", "error_rate_up", "error_rate_down"}
+
+type changeConfig struct {
+	goneAfter time.Duration
+	errorStep float64
+}
+
+type dependencyChange struct {
+	At            string  `json:"at"`
+	DetectedAt    string  `json:"detected_at"`
+	Env           string  `json:"env"`
+	CallerService string  `json:"caller_service"`
+	CalleeService string  `json:"callee_service"`
+	Kind          string  `json:"kind"`
+	Calls         uint64  `json:"calls"`
+	Before        float64 `json:"before"`
+	After         float64 `json:"after"`
+}
+
+func (h *Handler) RunDependencyChanges(every time.Duration) {
+	if every <= 0 {
+		return
+	}
+	for range time.Tick(every) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		if err := h.detectChanges(ctx); err != nil {
+			log.Printf("dependency changes: %v", err)
+		}
+		cancel()
+	}
+}
+
+func (h *Handler) detectChanges(ctx context.Context) error {
+	now := h.now().UTC()
+	detected := chTime(now)
+	var changes []dependencyChange
+
+	q := query.New()
+	firstSeen := q.Sub().
+		Select("env, caller_service, callee_service, min(bucket_ts) AS first_seen, sum(calls) AS calls").
+		From("dependency_edges_minute").
+		Where("bucket_ts >= now() - INTERVAL 30 DAY").
+		GroupBy("env, caller_service, callee_service")
+	reported := q.Sub().
+		Select("env, caller_service, callee_service").
+		From("dependency_changes").
+		Where("kind = 'new_edge'", "at >= now() - INTERVAL 2 DAY")
+	added, err := h.run(ctx, q.
+		Select("env, caller_service, callee_service, first_seen, calls").
+		FromQuery(firstSeen, "").
+		Where("first_seen >= now() - INTERVAL 1 DAY").
+		NotInQuery("(env, caller_service, callee_service)", reported).
+		Limit(1000))
+	if err != nil {
+		return err
+	}
+	for _, row := range added {
+		changes = append(changes, dependencyChange{
+			At:            toString(row["first_seen"]),
+			DetectedAt:    detected,
+			Env:           toString(row["env"]),
+			CallerService: toString(row["caller_service"]),
+			CalleeService: toString(row["callee_service"]),
+			Kind:          "new_edge",
+			Calls:         uint64(toFloat(row["calls"])),
+		})
+	}
+
+	gone := int(h.changes.goneAfter.Seconds())
+	q = query.New()
+	lastSeen := q.Sub().
+		Select("env, caller_service, callee_service, max(bucket_ts) AS last_seen, sum(calls) AS calls").
+		From("dependency_edges_minute").
+		Where(fmt.Sprintf("bucket_ts >= now() - INTERVAL %d SECOND - INTERVAL 7 DAY", gone)).
+		GroupBy("env, caller_service, callee_service")
+	removed, err := h.run(ctx, q.
+		Select("env, caller_service, callee_service, last_seen, calls").
+		FromQuery(lastSeen, "").
+		Where(fmt.Sprintf("last_seen < now() - INTERVAL %d SECOND", gone)).
+		NotInQuery("(env, caller_service, callee_service, last_seen)",
+			q.Sub().Select("env, caller_service, callee_service, at").From("dependency_changes").Where("kind = 'edge_gone'")).
+		Limit(1000))
+	if err != nil {
+		return err
+	}
+	for _, row := range removed {
+		changes = append(changes, dependencyChange{
+			At:            toString(row["last_seen"]),
+			DetectedAt:    detected,
+			Env:           toString(row["env"]),
+			CallerService: toString(row["caller_service"]),
+			CalleeService: toString(row["callee_service"]),
+			Kind:          "edge_gone",
+			Calls:         uint64(toFloat(row["calls"])),
+		})
+	}
+
+	q = query.New()
+	hourly := q.Sub().
+		Select("env, caller_service, callee_service",
+			"sumIf(calls, bucket_ts >= toStartOfMinute(now()) - INTERVAL 1 HOUR) AS cur_calls",
+			"sumIf(error_calls, bucket_ts >= toStartOfMinute(now()) - INTERVAL 1 HOUR) AS cur_errors",
+			"sumIf(calls, bucket_ts < toStartOfMinute(now()) - INTERVAL 1 HOUR) AS base_calls",
+			"sumIf(error_calls, bucket_ts < toStartOfMinute(now()) - INTERVAL 1 HOUR) AS base_errors").
+		From("dependency_edges_minute").
+		Where("bucket_ts >= toStartOfMinute(now()) - INTERVAL 25 HOUR", "bucket_ts < toStartOfMinute(now())").
+		GroupBy("env, caller_service, callee_service")
+	steps, err := h.run(ctx, q.
+		Select("env, caller_service, callee_service, cur_calls, cur_errors, base_calls, base_errors").
+		FromQuery(hourly, "").
+		Where("cur_calls >= 100", "base_calls >= 100"))
+	if err != nil {
+		return err
+	}
+	recent, err := h.run(ctx, query.New().
+		Select("DISTINCT env, caller_service, callee_service, kind").
+		From("dependency_changes").
+		Where("kind IN ('error_rate_up', 'error_rate_down')", "at >= now() - INTERVAL 1 DAY"))
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for _, row := range recent {
+		seen[strings.Join([]string{toString(row["env"]), toString(row["caller_service"]), toString(row["callee_service"]), toString(row["kind"])}, "|")] = true
+	}
+	at := chSecond(now.Truncate(time.Minute).Add(-time.Hour))
+	for _, row := range steps {
+		before := ratio(toFloat(row["base_errors"]), toFloat(row["base_calls"]))
+		after := ratio(toFloat(row["cur_errors"]), toFloat(row["cur_calls"]))
+		if math.Abs(after-before) < h.changes.errorStep {
+			continue
+		}
+		kind := "error_rate_up"
+		if after < before {
+			kind = "error_rate_down"
+		}
+		env, caller, callee := toString(row["env"]), toString(row["caller_service"]), toString(row["callee_service"])
+		if seen[strings.Join([]string{env, caller, callee, kind}, "|")] {
+			continue
+		}
+		changes = append(changes, dependencyChange{
+			At:            at,
+			DetectedAt:    detected,
+			Env:           env,
+			CallerService: caller,
+			CalleeService: callee,
+			Kind:          kind,
+			Calls:         uint64(toFloat(row["cur_calls"])),
+			Before:        round(before, 4),
+			After:         round(after, 4),
+		})
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+	return h.ch.InsertJSONEachRow(ctx, "dependency_changes", changes)
+}
+
+func (h *Handler) DependencyChanges(w http.ResponseWriter, r *http.Request) {
+	from, to := h.parseRange(r)
+	params := r.URL.Query()
+	q := query.New().SecondRange("at", from, to)
+	q.FilterIn("env", h.envs(sanitize(params.Get("env"))))
+	if service := sanitize(params.Get("service")); service != "" {
+		q.EqAny(service, "caller_service", "callee_service")
+	}
+	if kind := params.Get("kind"); kind != "" {
+		known := false
+		for _, k := range changeKinds {
+			known = known || k == kind
+		}
+		if !known {
+			WriteError(w, http.StatusBadRequest, "invalid_request", "unknown kind "+kind, map[string]any{"allowed": changeKinds})
+			return
+		}
+		q.Eq("kind", kind)
+	}
+	limit := h.limitFor(r, "changes", "limit")
+
+	d, err := h.run(r.Context(), q.
+		Select("at, detected_at, env, caller_service, callee_service, kind, calls, before, after", "count() OVER () AS _total").
+		From("dependency_changes FINAL").
+		OrderBy("at DESC").
+		Limit(limit))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	d, page := splitTotal(d, limit)
+	page["changes"] = d
+	writeJSON(w, http.StatusOK, page)
+}
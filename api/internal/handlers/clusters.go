@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"trace-lite/api/internal/query"
+)
+
+const (
+	maxClusterTraces = 5000
+	clusterExamples  = 3
+)
+
+func (h *Handler) TraceClusters(w http.ResponseWriter, r *http.Request) {
+	from, to := h.parseRange(r)
+	limit := h.limitFor(r, "clusters", "limit")
+	env := sanitize(r.URL.Query().Get("env"))
+	service := sanitize(r.URL.Query().Get("service"))
+	if service == "" {
+		WriteError(w, http.StatusBadRequest, "invalid_request", "service is required", nil)
+		return
+	}
+	place := parsePlace(r)
+	minMs := -1
+	if raw := r.URL.Query().Get("min_duration_ms"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v < 0 {
+			WriteError(w, http.StatusBadRequest, "invalid_request", "min_duration_ms must be a non-negative integer", nil)
+			return
+		}
+		minMs = v
+	}
+
+	traces := func(q *query.Query) *query.Query {
+		q.TimeRange("start_ts", from, to).FilterIn("env", h.envs(env)).Eq("root_service", service)
+		place.traces(q)
+		h.latestTraces(q, from, to)
+		return q
+	}
+	stats, err := h.run(r.Context(), traces(query.New()).
+		Select("count() AS traces", "round(quantile(0.90)(duration_ms), 2) AS p90_ms"))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	var total, p90 float64
+	if len(stats) > 0 {
+		total, p90 = toFloat(stats[0]["traces"]), toFloat(stats[0]["p90_ms"])
+	}
+	threshold := minMs
+	if threshold < 0 {
+		threshold = int(p90)
+	}
+
+	root := query.New()
+	slow := traces(root.Sub()).
+		Select("trace_id").
+		Where(fmt.Sprintf("duration_ms >= %d", threshold)).
+		OrderBy("duration_ms DESC").
+		Limit(maxClusterTraces)
+	perTrace := root.Sub().
+		Select("trace_id",
+			"dateDiff('millisecond', min(start_ts), max(end_ts)) AS duration_ms",
+			"arraySort(groupUniqArray(service)) AS services",
+			"cityHash64(arraySort(groupUniqArray(concat(service, '|', operation)))) AS shape_id",
+			"argMax(service, self_time_ms) AS bottleneck_service",
+			"argMax(operation, self_time_ms) AS bottleneck_operation",
+			"argMax(parent_span_id, self_time_ms) AS bottleneck_parent",
+			"max(self_time_ms) AS bottleneck_ms",
+			"groupArray((span_id, service)) AS span_services").
+		From(h.spansTable).
+		InQuery("trace_id", slow).
+		GroupBy("trace_id")
+	rows, err := h.run(r.Context(), root.
+		Select("toString(shape_id) AS shape",
+			"any(services) AS services",
+			"tupleElement(arrayFirst(s -> s.1 = bottleneck_parent, span_services), 2) AS bottleneck_caller",
+			"bottleneck_service, bottleneck_operation",
+			"count() AS traces",
+			"round(quantile(0.50)(duration_ms), 2) AS p50_ms",
+			"round(quantile(0.95)(duration_ms), 2) AS p95_ms",
+			"round(avg(bottleneck_ms), 2) AS avg_bottleneck_ms",
+			"round(avg(bottleneck_ms / greatest(duration_ms, 1)), 4) AS bottleneck_share",
+			fmt.Sprintf("groupArray(%d)(trace_id) AS example_trace_ids", clusterExamples),
+			"sum(count()) OVER () AS _slow",
+			"count() OVER () AS _total").
+		FromQuery(perTrace, "").
+		GroupBy("shape_id, bottleneck_caller, bottleneck_service, bottleneck_operation").
+		OrderBy("traces DESC", "p95_ms DESC").
+		Limit(limit))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+
+	var slowTraces float64
+	for _, row := range rows {
+		slowTraces = toFloat(row["_slow"])
+		delete(row, "_slow")
+		caller, callee := toString(row["bottleneck_caller"]), toString(row["bottleneck_service"])
+		n := uint64(toFloat(row["traces"]))
+		if caller == "" || caller == callee {
+			row["label"] = fmt.Sprintf("%d traces slow in %s (%s)", n, callee, toString(row["bottleneck_operation"]))
+		} else {
+			row["label"] = fmt.Sprintf("%d traces slow on %s->%s", n, caller, callee)
+		}
+	}
+	rows, page := splitTotal(rows, limit)
+	page["service"] = service
+	page["threshold_ms"] = threshold
+	page["traces"] = uint64(total)
+	page["slow_traces"] = uint64(slowTraces)
+	page["sampled"] = slowTraces >= maxClusterTraces
+	page["clusters"] = rows
+	writeJSON(w, http.StatusOK, page)
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+var spanDimensions = map[string][]string{
+	"operation":    {"operation"},
+	"route":        {"route"},
+	"method":       {"method"},
+	"method_route": {"method", "route"},
+	"region":       {"region"},
+	"zone":         {"zone"},
+}
+
+var edgeDimensions = map[string][]string{
+	"service":      nil,
+	"route":        {"callee_route"},
+	"method":       {"callee_method"},
+	"method_route": {"callee_method", "callee_route"},
+	"region":       {"caller_region", "callee_region"},
+	"zone":         {"caller_zone", "callee_zone"},
+}
+
+func groupColumns(w http.ResponseWriter, r *http.Request, dims map[string][]string, fallback string) (string, bool) {
+	v := strings.TrimSpace(r.URL.Query().Get("group_by"))
+	if v == "" {
+		v = fallback
+	}
+	cols, ok := dims[v]
+	if !ok {
+		names := make([]string, 0, len(dims))
+		for name := range dims {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		WriteError(w, http.StatusBadRequest, "invalid_request", "unknown group_by "+v, map[string]any{"allowed": names})
+		return "", false
+	}
+	return strings.Join(cols, ", "), true
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+
+	"trace-lite/api/internal/query"
+)
+
+func (h *Handler) envs(env string) []string {
+	if env == "" {
+		return nil
+	}
+	if members, ok := h.envGroups[env]; ok {
+		return members
+	}
+	return []string{env}
+}
+
+func (h *Handler) envGroup(env string) string {
+	for group, members := range h.envGroups {
+		for _, m := range members {
+			if m == env {
+				return group
+			}
+		}
+	}
+	return ""
+}
+
+func (h *Handler) Envs(w http.ResponseWriter, r *http.Request) {
+	from, to := h.parseRange(r)
+	q := query.New().
+		Select("env", "count() AS traces").
+		TimeRange("start_ts", from, to).
+		GroupBy("env").
+		OrderBy("env ASC")
+	h.latestTraces(q, from, to)
+	rows, err := h.run(r.Context(), q)
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	envs := make([]map[string]any, 0, len(rows))
+	for _, row := range rows {
+		env := toString(row["env"])
+		envs = append(envs, map[string]any{"env": env, "group": h.envGroup(env), "traces": toFloat(row["traces"])})
+	}
+	names := make([]string, 0, len(h.envGroups))
+	for name := range h.envGroups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	groups := make([]map[string]any, 0, len(names))
+	for _, name := range names {
+		groups = append(groups, map[string]any{"name": name, "envs": h.envGroups[name]})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"envs": envs, "groups": groups})
+}
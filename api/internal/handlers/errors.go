@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"trace-lite/api/internal/clickhouse"
+)
+
+type errorBody struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Retryable bool   `json:"retryable"`
+	Details   any    `json:"details,omitempty"`
+}
+
+var queryErrorMessages = map[string]string{
+	"deadline_exceeded": "query exceeded the request deadline",
+	"client_closed":     "request was cancelled by the client",
+	"overloaded":        "storage is overloaded, retry later",
+	"query_failed":      "storage query failed",
+}
+
+func WriteError(w http.ResponseWriter, status int, code, message string, details any) {
+	writeJSON(w, status, map[string]errorBody{"error": {
+		Code:      code,
+		Message:   message,
+		Retryable: status == http.StatusTooManyRequests || status >= 500 && status != http.StatusNotImplemented,
+		Details:   details,
+	}})
+}
+
+func writeQueryError(w http.ResponseWriter, err error) {
+	code := clickhouse.ErrorCode(err)
+	status := http.StatusBadGateway
+	switch code {
+	case "deadline_exceeded":
+		status = http.StatusGatewayTimeout
+	case "client_closed":
+		status = 499
+	case "overloaded":
+		status = http.StatusServiceUnavailable
+	}
+	requestID := w.Header().Get("X-Request-ID")
+	log.Printf("query failed (request %s, %s): %v", requestID, code, err)
+
+	var details any
+	if requestID != "" {
+		details = map[string]string{"request_id": requestID}
+	}
+	WriteError(w, status, code, queryErrorMessages[code], details)
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"trace-lite/api/internal/clickhouse"
+	"trace-lite/api/internal/query"
+)
+
+type promSeries struct {
+	name   string
+	help   string
+	labels [][2]string
+	value  float64
+}
+
+type serviceRateRow struct {
+	Env     string  `json:"env"`
+	Service string  `json:"service"`
+	Calls   float64 `json:"calls"`
+	Errors  float64 `json:"errors"`
+}
+
+type serviceLatencyRow struct {
+	Env     string  `json:"env"`
+	Service string  `json:"service"`
+	P50     float64 `json:"p50_ms"`
+	P95     float64 `json:"p95_ms"`
+	P99     float64 `json:"p99_ms"`
+}
+
+type edgeRateRow struct {
+	Env          string  `json:"env"`
+	Caller       string  `json:"caller_service"`
+	Callee       string  `json:"callee_service"`
+	Calls        float64 `json:"calls"`
+	ErrorCalls   float64 `json:"error_calls"`
+	TimeoutCalls float64 `json:"timeout_calls"`
+}
+
+func (h *Handler) MetricsExport(w http.ResponseWriter, r *http.Request) {
+	window := 5 * time.Minute
+	if raw := r.URL.Query().Get("window"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < time.Minute || d > time.Hour {
+			WriteError(w, http.StatusBadRequest, "invalid_request", "window must be a duration between 1m and 1h", nil)
+			return
+		}
+		window = d.Truncate(time.Minute)
+	}
+	series, err := h.derivedSeries(r.Context(), window, sanitize(r.URL.Query().Get("env")), sanitize(r.URL.Query().Get("service")))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(encodeProm(series))
+}
+
+func (h *Handler) derivedSeries(ctx context.Context, window time.Duration, env, service string) ([]promSeries, error) {
+	to := h.now().UTC().Truncate(time.Minute)
+	from := to.Add(-window)
+	seconds := window.Seconds()
+
+	var series []promSeries
+	err := h.each(ctx, query.New().
+		Select("env, service, sum(calls) AS calls, sum(errors) AS errors").
+		From("service_versions_minute").
+		MinuteRange("bucket_ts", from, to).
+		FilterIn("env", h.envs(env)).
+		Filter("service", service).
+		GroupBy("env, service"), func(row clickhouse.Row) error {
+		var r serviceRateRow
+		if err := row.Scan(&r); err != nil {
+			return err
+		}
+		labels := [][2]string{{"env", r.Env}, {"service", r.Service}}
+		series = append(series,
+			promSeries{"tracelite_service_requests_per_second", "Requests entering the service per second.", labels, r.Calls / seconds},
+			promSeries{"tracelite_service_error_ratio", "Share of requests entering the service that failed.", labels, ratio(r.Errors, r.Calls)},
+		)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = h.each(ctx, query.New().
+		Select("env, service",
+			"quantile(0.50)(duration_ms) AS p50_ms",
+			"quantile(0.95)(duration_ms) AS p95_ms",
+			"quantile(0.99)(duration_ms) AS p99_ms").
+		From(h.spansTable).
+		TimeRange("start_ts", from, to).
+		FilterIn("env", h.envs(env)).
+		Filter("service", service).
+		GroupBy("env, service"), func(row clickhouse.Row) error {
+		var r serviceLatencyRow
+		if err := row.Scan(&r); err != nil {
+			return err
+		}
+		for _, q := range []struct {
+			label string
+			ms    float64
+		}{{"0.5", r.P50}, {"0.95", r.P95}, {"0.99", r.P99}} {
+			labels := [][2]string{{"env", r.Env}, {"service", r.Service}, {"quantile", q.label}}
+			series = append(series, promSeries{"tracelite_service_span_duration_seconds", "Span duration quantiles of the service.", labels, q.ms / 1000})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = h.each(ctx, query.New().
+		Select("env, caller_service, callee_service, sum(calls) AS calls, sum(error_calls) AS error_calls, sum(timeout_calls) AS timeout_calls").
+		From("dependency_edges_minute").
+		MinuteRange("bucket_ts", from, to).
+		FilterIn("env", h.envs(env)).
+		Filter("callee_service", service).
+		GroupBy("env, caller_service, callee_service"), func(row clickhouse.Row) error {
+		var r edgeRateRow
+		if err := row.Scan(&r); err != nil {
+			return err
+		}
+		labels := [][2]string{{"env", r.Env}, {"caller", r.Caller}, {"callee", r.Callee}}
+		series = append(series,
+			promSeries{"tracelite_edge_calls_per_second", "Calls from caller to callee per second.", labels, r.Calls / seconds},
+			promSeries{"tracelite_edge_error_ratio", "Share of calls from caller to callee that failed.", labels, ratio(r.ErrorCalls, r.Calls)},
+			promSeries{"tracelite_edge_timeout_ratio", "Share of calls from caller to callee that timed out.", labels, ratio(r.TimeoutCalls, r.Calls)},
+		)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	series = append(series, promSeries{"tracelite_export_window_seconds", "Length of the window the exported values cover.", nil, seconds})
+	return series, nil
+}
+
+func encodeProm(series []promSeries) []byte {
+	sort.SliceStable(series, func(i, j int) bool { return series[i].name < series[j].name })
+	var buf bytes.Buffer
+	last := ""
+	for _, s := range series {
+		if s.name != last {
+			fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s gauge\n", s.name, s.help, s.name)
+			last = s.name
+		}
+		buf.WriteString(s.name)
+		if len(s.labels) > 0 {
+			buf.WriteByte('{')
+			for i, l := range s.labels {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				buf.WriteString(l[0] + `="` + promEscape(l[1]) + `"`)
+			}
+			buf.WriteByte('}')
+		}
+		buf.WriteString(" " + strconv.FormatFloat(s.value, 'g', -1, 64) + "\n")
+	}
+	return buf.Bytes()
+}
+
+func promEscape(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func ratio(part, total float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return part / total
+}
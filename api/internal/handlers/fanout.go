@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"sort"
+	"time"
+)
+
+func buildFanout(span *traceSpan) (map[string]any, map[string]int) {
+	if len(span.Children) == 0 {
+		return nil, nil
+	}
+	children := append([]*traceSpan(nil), span.Children...)
+	sort.Slice(children, func(i, j int) bool {
+		if !children[i].StartTime.Equal(children[j].StartTime) {
+			return children[i].StartTime.Before(children[j].StartTime)
+		}
+		return children[i].SpanID < children[j].SpanID
+	})
+
+	lanes := map[string]int{}
+	var laneEnds []time.Time
+	type edge struct {
+		at    time.Time
+		delta int
+	}
+	edges := make([]edge, 0, 2*len(children))
+	var busy, union time.Duration
+	var coveredTo time.Time
+	for _, c := range children {
+		lane := -1
+		for i, end := range laneEnds {
+			if !end.After(c.StartTime) {
+				lane = i
+				break
+			}
+		}
+		if lane < 0 {
+			lane = len(laneEnds)
+			laneEnds = append(laneEnds, time.Time{})
+		}
+		laneEnds[lane] = c.EndTime
+		lanes[c.SpanID] = lane
+
+		busy += c.EndTime.Sub(c.StartTime)
+		start := c.StartTime
+		if start.Before(coveredTo) {
+			start = coveredTo
+		}
+		if c.EndTime.After(start) {
+			union += c.EndTime.Sub(start)
+			coveredTo = c.EndTime
+		}
+		edges = append(edges, edge{c.StartTime, 1}, edge{c.EndTime, -1})
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if !edges[i].at.Equal(edges[j].at) {
+			return edges[i].at.Before(edges[j].at)
+		}
+		return edges[i].delta < edges[j].delta
+	})
+
+	timeline := make([]map[string]any, 0, len(edges))
+	concurrent, maxConcurrent := 0, 0
+	for i, e := range edges {
+		concurrent += e.delta
+		maxConcurrent = max(maxConcurrent, concurrent)
+		if i+1 < len(edges) && edges[i+1].at.Equal(e.at) {
+			continue
+		}
+		offset := e.at.Sub(span.StartTime).Milliseconds()
+		if n := len(timeline); n > 0 && timeline[n-1]["concurrent"] == concurrent {
+			continue
+		}
+		timeline = append(timeline, map[string]any{"offset_ms": offset, "concurrent": concurrent})
+	}
+
+	parallelism := 1.0
+	if union > 0 {
+		parallelism = float64(busy) / float64(union)
+	}
+	mode := "serial"
+	switch {
+	case len(children) == 1:
+		mode = "single"
+	case maxConcurrent == len(children):
+		mode = "parallel"
+	case maxConcurrent > 1:
+		mode = "mixed"
+	}
+	return map[string]any{
+		"children":       len(children),
+		"lanes":          len(laneEnds),
+		"max_concurrent": maxConcurrent,
+		"parallelism":    round(parallelism, 2),
+		"mode":           mode,
+		"timeline":       timeline,
+	}, lanes
+}
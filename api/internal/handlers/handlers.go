@@ -4,23 +4,52 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"math"
 	"net/http"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"trace-lite/api/internal/alerting"
 	"trace-lite/api/internal/clickhouse"
+	"trace-lite/api/internal/config"
+	"trace-lite/api/internal/fieldcrypt"
+	"trace-lite/api/internal/query"
 )
 
 type Handler struct {
-	ch *clickhouse.Client
+	ch          clickhouse.Interface
+	now         func() time.Time
+	spansTable  string
+	tracesTable string
+	limits      map[string]config.Limit
+	autoSoak    time.Duration
+	adminMu     sync.RWMutex
+	adminToken  string
+	decryptKey  string
+	crypt       *fieldcrypt.Keyring
+	encrypted   map[string]bool
+	linkBase    string
+	notifier    *alerting.Notifier
+	alertsMu    sync.Mutex
+	alerts      map[string]*alertState
+	health      healthConfig
+	changes     changeConfig
+	verify      map[string]float64
+	queries     *clickhouse.QueryLog
+	envGroups   map[string][]string
 }
 
 var safeToken = regexp.MustCompile(`^[a-zA-Z0-9._:/-]+$`)
 
+const traceDedupSlack = time.Hour
+
+const traceColumns = "trace_id, env, root_service, root_operation, root_status_code, transaction, start_ts, end_ts, duration_ms, span_count, service_count, error_count, critical_path_ms, versions, regions, zones, truncated, dropped_spans, partial, labels, integrity, inferred_spans, orphan_spans, skewed_spans"
+
 type traceSpan struct {
 	TraceID       string
 	SpanID        string
@@ -28,17 +57,27 @@ type traceSpan struct {
 	Service       string
 	Env           string
 	Host          string
+	Region        string
+	Zone          string
 	Version       string
 	Operation     string
+	Method        string
+	Route         string
 	StartTS       string
 	EndTS         string
 	StartTime     time.Time
 	EndTime       time.Time
 	DurationMs    uint32
 	SelfTimeMs    uint32
+	QueueMs       uint32
 	StatusCode    uint16
 	IsError       bool
+	Status        string
+	ErrorMessage  string
+	ErrorType     string
 	Source        string
+	Proxy         string
+	Conflicts     []string
 	Depth         int
 	WaitMs        uint32
 	BlockingRatio float64
@@ -50,115 +89,275 @@ type traceSpan struct {
 }
 
 type rootCauseRank struct {
-	Service         string  `json:"service"`
-	Score           float64 `json:"score"`
-	LatencyDeltaPct float64 `json:"latency_delta_pct"`
-	ErrorDeltaPct   float64 `json:"error_delta_pct"`
-	CallDeltaPct    float64 `json:"call_delta_pct"`
-	BlockingRatio   float64 `json:"blocking_ratio"`
-	Reason          string  `json:"reason"`
+	Service           string            `json:"service"`
+	Score             float64           `json:"score"`
+	LatencyDeltaPct   float64           `json:"latency_delta_pct"`
+	ErrorDeltaPct     float64           `json:"error_delta_pct"`
+	CallDeltaPct      float64           `json:"call_delta_pct"`
+	BlockingRatio     float64           `json:"blocking_ratio"`
+	SelfTimeDeltaPct  float64           `json:"self_time_delta_pct"`
+	CriticalPathShare float64           `json:"critical_path_share"`
+	ExoneratedBy      string            `json:"exonerated_by,omitempty"`
+	Reason            string            `json:"reason"`
+	Evidence          rootCauseEvidence `json:"evidence"`
 }
 
-func New(ch *clickhouse.Client) *Handler {
-	return &Handler{ch: ch}
+func New(ch clickhouse.Interface, cfg config.Config) *Handler {
+	h := &Handler{
+		ch:          ch,
+		now:         time.Now,
+		spansTable:  "spans",
+		tracesTable: "traces",
+		limits:      cfg.Limits,
+		autoSoak:    cfg.AutoCompareSoak,
+		adminToken:  cfg.AdminToken,
+		decryptKey:  cfg.DecryptToken,
+		crypt:       cfg.Encryption,
+		encrypted:   map[string]bool{},
+		linkBase:    cfg.AlertLinkBase,
+		notifier:    alerting.NewNotifier(),
+		alerts:      map[string]*alertState{},
+		health:      healthConfig{weights: cfg.HealthWeights, thresholds: cfg.HealthThresholds, baseline: cfg.HealthBaseline},
+		changes:     changeConfig{goneAfter: cfg.EdgeGoneAfter, errorStep: cfg.ErrorRateStep},
+		verify:      cfg.VerifyThresholds,
+		queries:     clickhouse.NewQueryLog(cfg.SlowQueryThreshold),
+		envGroups:   cfg.EnvGroups,
+	}
+	for _, k := range cfg.EncryptAttrs {
+		h.encrypted[k] = true
+	}
+	if cfg.TraceSource == "mv" {
+		h.spansTable = "spans_mv"
+		h.tracesTable = "traces_mv"
+	}
+	return h
+}
+
+func (h *Handler) Livez(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"status": "ok"})
 }
 
-func (h *Handler) Healthz(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) Readyz(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
 	defer cancel()
-	if err := h.ch.Ping(ctx); err != nil {
-		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	start := time.Now()
+	err := h.ch.Ping(ctx)
+	check := map[string]any{"ok": err == nil, "latency_ms": float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		log.Printf("readiness: clickhouse ping failed: %v", err)
+		check["error"] = "clickhouse is not reachable"
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{"status": "not_ready", "checks": map[string]any{"clickhouse": check}})
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"status": "ok"})
+	writeJSON(w, http.StatusOK, map[string]any{"status": "ready", "checks": map[string]any{"clickhouse": check}})
 }
 
 func (h *Handler) Traces(w http.ResponseWriter, r *http.Request) {
-	from, to := parseRange(r)
-	limit := parseLimit(r, 200)
+	from, to := h.parseRange(r)
+	limit := h.limitFor(r, "traces", "limit")
 	env := sanitize(r.URL.Query().Get("env"))
 	service := sanitize(r.URL.Query().Get("service"))
+	truncated := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("truncated")))
+	transaction := strings.TrimSpace(r.URL.Query().Get("transaction"))
+	var versions []string
+	for _, v := range strings.Split(r.URL.Query().Get("version"), ",") {
+		if v = sanitize(v); v != "" {
+			versions = append(versions, v)
+		}
+	}
 
-	where := []string{
-		fmt.Sprintf("start_ts >= toDateTime64('%s', 3, 'UTC')", chTime(from)),
-		fmt.Sprintf("start_ts < toDateTime64('%s', 3, 'UTC')", chTime(to)),
+	q := query.New().TimeRange("start_ts", from, to)
+	q.FilterIn("env", h.envs(env))
+	q.Filter("root_service", service)
+	q.Filter("transaction", transaction)
+	q.Filter("root_operation", strings.TrimSpace(r.URL.Query().Get("root_operation")))
+	parsePlace(r).traces(q)
+	if cond, ok := statusCodeCond("root_status_code", r.URL.Query().Get("root_status_code")); ok {
+		q.Where(cond)
 	}
-	if env != "" {
-		where = append(where, fmt.Sprintf("env = '%s'", env))
+	if len(versions) > 0 {
+		list := q.Strings(versions)
+		if strings.EqualFold(r.URL.Query().Get("version_match"), "only") {
+			q.Where(fmt.Sprintf("notEmpty(versions) AND arrayAll(v -> v IN (%s), versions)", list))
+		} else {
+			q.Where(fmt.Sprintf("hasAny(versions, [%s])", list))
+		}
 	}
-	if service != "" {
-		where = append(where, fmt.Sprintf("root_service = '%s'", service))
+	var labels []string
+	for _, l := range strings.Split(r.URL.Query().Get("label"), ",") {
+		if l = sanitize(l); l != "" {
+			labels = append(labels, l)
+		}
 	}
+	if len(labels) > 0 {
+		fn := "hasAll"
+		if strings.EqualFold(r.URL.Query().Get("label_match"), "any") {
+			fn = "hasAny"
+		}
+		q.Where(fmt.Sprintf("%s(labels, [%s])", fn, q.Strings(labels)))
+	}
+	switch truncated {
+	case "true", "1":
+		q.Where("truncated = 1")
+	case "false", "0":
+		q.Where("truncated = 0")
+	}
+	if raw := r.URL.Query().Get("min_integrity"); raw != "" {
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil || v < 0 || v > 1 {
+			WriteError(w, http.StatusBadRequest, "invalid_request", "min_integrity must be a number between 0 and 1", nil)
+			return
+		}
+		q.Where(fmt.Sprintf("integrity >= toFloat32(%g)", v))
+	}
+	h.latestTraces(q, from, to)
 
-	sql := fmt.Sprintf(`
-SELECT trace_id, env, root_service, start_ts, end_ts, duration_ms, span_count, service_count, error_count, critical_path_ms, versions
-FROM traces
-WHERE %s
-ORDER BY start_ts DESC
-LIMIT %d`, strings.Join(where, " AND "), limit)
+	if strings.EqualFold(r.URL.Query().Get("sample"), "stratified") {
+		resp, err := h.stratifiedTraces(r.Context(), q, limit)
+		if err != nil {
+			writeQueryError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
 
-	d, err := h.ch.Query(r.Context(), sql)
+	q.Select(traceColumns, "count() OVER () AS _total").OrderBy("start_ts DESC").Limit(limit)
+	d, err := h.run(r.Context(), q)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		writeQueryError(w, err)
+		return
+	}
+	d, page := splitTotal(d, limit)
+	page["data"] = d
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (h *Handler) latestTraces(q *query.Query, from, to time.Time) {
+	if h.tracesTable != "traces" {
+		q.From(h.tracesTable)
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"data": d})
+	matching := q.Sub().Select("trace_id").From("traces").Where(q.Cond())
+	latest := q.Sub().Select("*").From("traces").
+		TimeRange("start_ts", from.Add(-traceDedupSlack), to.Add(traceDedupSlack)).
+		InQuery("trace_id", matching).
+		OrderBy("updated_at DESC").
+		LimitBy(1, "trace_id")
+	q.FromQuery(latest, "")
+}
+
+func (h *Handler) run(ctx context.Context, q *query.Query) ([]map[string]any, error) {
+	return h.ch.QueryWith(ctx, q.SQL(), q.Params())
+}
+
+func (h *Handler) each(ctx context.Context, q *query.Query, fn func(clickhouse.Row) error) error {
+	return h.ch.QueryEachWith(ctx, q.SQL(), q.Params(), fn)
 }
 
 func (h *Handler) TraceByID(w http.ResponseWriter, r *http.Request) {
 	tail := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/traces/"), "/")
 	if tail == "" {
-		http.Error(w, "invalid trace id", http.StatusBadRequest)
+		WriteError(w, http.StatusBadRequest, "invalid_request", "invalid trace id", nil)
 		return
 	}
 	parts := strings.Split(tail, "/")
 	id := sanitize(parts[0])
 	if id == "" {
-		http.Error(w, "invalid trace id", http.StatusBadRequest)
+		WriteError(w, http.StatusBadRequest, "invalid_request", "invalid trace id", nil)
 		return
 	}
 	mode := ""
 	if len(parts) > 1 {
 		mode = strings.ToLower(strings.TrimSpace(parts[1]))
 	}
-
-	traceSQL := fmt.Sprintf(`
-SELECT trace_id, env, root_service, start_ts, end_ts, duration_ms, span_count, service_count, error_count, critical_path_ms, versions
-FROM traces
-WHERE trace_id = '%s'
-ORDER BY updated_at DESC
-LIMIT 1`, id)
-	traceRows, err := h.ch.Query(r.Context(), traceSQL)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+	switch mode {
+	case "logs":
+		h.traceLogs(w, r, id)
+		return
+	case "render":
+		h.traceRender(w, r, id)
+		return
+	case "snapshot":
+		h.traceSnapshot(w, r, id, parts[2:])
 		return
 	}
 
-	spanSQL := fmt.Sprintf(`
-SELECT trace_id, span_id, parent_span_id, service, env, host, version, operation, start_ts, end_ts, duration_ms, self_time_ms, status_code, is_error, source
-FROM spans
-WHERE trace_id = '%s'
-ORDER BY start_ts ASC`, id)
-	spanRows, err := h.ch.Query(r.Context(), spanSQL)
+	traceRows, spanRows, truncated, err := h.loadTrace(r.Context(), id, h.limitFor(r, "spans", "span_limit"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		writeQueryError(w, err)
 		return
 	}
 
+	var resp map[string]any
 	if mode == "waterfall" || mode == "drilldown" {
-		drill := buildTraceDrilldown(spanRows)
-		writeJSON(w, http.StatusOK, map[string]any{
-			"trace":         firstOrNil(traceRows),
-			"waterfall":     drill["waterfall"],
-			"critical_path": drill["critical_path"],
-			"error_chains":  drill["error_chains"],
-			"slow_spots":    drill["slow_spots"],
-			"trace_window":  drill["trace_window"],
-		})
-		return
+		resp = h.traceDrilldown(r.Context(), id, traceRows, spanRows)
+	} else {
+		resp = map[string]any{"trace": firstOrNil(traceRows), "spans": spanRows}
+	}
+	resp["spans_truncated"] = truncated
+
+	if ok, depth := wantLinks(r); ok {
+		links, linked, err := h.traceLinks(r.Context(), id, depth)
+		if err != nil {
+			writeQueryError(w, err)
+			return
+		}
+		resp["links"] = links
+		resp["linked_traces"] = linked
 	}
+	writeJSON(w, http.StatusOK, resp)
+}
 
-	writeJSON(w, http.StatusOK, map[string]any{"trace": firstOrNil(traceRows), "spans": spanRows})
+func (h *Handler) traceDrilldown(ctx context.Context, id string, traceRows, spanRows []map[string]any) map[string]any {
+	baselines, err := h.operationBaselines(ctx, spanRows)
+	if err != nil {
+		log.Printf("operation baselines for trace %s: %v", id, err)
+	}
+	drill := buildTraceDrilldown(spanRows, baselines)
+	return map[string]any{
+		"trace":         firstOrNil(traceRows),
+		"waterfall":     drill["waterfall"],
+		"critical_path": drill["critical_path"],
+		"error_chains":  drill["error_chains"],
+		"conflicts":     drill["conflicts"],
+		"slow_spots":    drill["slow_spots"],
+		"trace_window":  drill["trace_window"],
+	}
+}
+
+func (h *Handler) loadTrace(ctx context.Context, id string, limit int) ([]map[string]any, []map[string]any, bool, error) {
+	traceRows, err := h.run(ctx, query.New().
+		Select(traceColumns).
+		From(h.tracesTable).
+		Eq("trace_id", id).
+		OrderBy("updated_at DESC").
+		Limit(1))
+	if err != nil {
+		return nil, nil, false, err
+	}
+	spanRows, truncated := []map[string]any{}, false
+	err = h.each(ctx, query.New().
+		Select("trace_id, span_id, parent_span_id, service, env, host, region, zone, version, operation, method, route, start_ts, end_ts, duration_ms, self_time_ms, queue_ms, status_code, is_error, status, error_message, error_type, source, proxy, conflicts").
+		From(h.spansTable).
+		Eq("trace_id", id).
+		OrderBy("start_ts ASC").
+		Limit(limit+1), func(row clickhouse.Row) error {
+		if len(spanRows) == limit {
+			truncated = true
+			return nil
+		}
+		span, err := row.Map()
+		if err != nil {
+			return err
+		}
+		spanRows = append(spanRows, span)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return traceRows, spanRows, truncated, nil
 }
 
 func (h *Handler) Dependency(w http.ResponseWriter, r *http.Request) {
@@ -167,91 +366,125 @@ func (h *Handler) Dependency(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	from, to := parseRange(r)
+	from, to := h.parseRange(r)
 	env := sanitize(r.URL.Query().Get("env"))
-	where := []string{
-		fmt.Sprintf("bucket_ts >= toDateTime('%s', 'UTC')", chMinute(from)),
-		fmt.Sprintf("bucket_ts < toDateTime('%s', 'UTC')", chMinute(to)),
-	}
-	if env != "" {
-		where = append(where, fmt.Sprintf("env = '%s'", env))
-	}
-
-sql := fmt.Sprintf(`
-SELECT
-  caller_service, callee_service, calls, error_calls, avg_latency_ms, p95_latency_ms AS p95_ms, max_ms,
-  round(if(calls = 0, 0, error_calls / calls), 4) AS error_rate
-FROM (
-  SELECT
-    caller_service,
-    callee_service,
-    sum(calls) AS calls,
-    sum(error_calls) AS error_calls,
-    round(avg((p50_ms + p95_ms)/2), 2) AS avg_latency_ms,
-    round(avg(p95_ms), 2) AS p95_latency_ms,
-    max(max_ms) AS max_ms
-  FROM dependency_edges_minute
-  WHERE %s
-  GROUP BY caller_service, callee_service
-)
-ORDER BY calls DESC
-LIMIT 1000`, strings.Join(where, " AND "))
+	limit := h.limitFor(r, "edges", "limit")
+	filter := query.New().MinuteRange("bucket_ts", from, to).FilterIn("env", h.envs(env))
+	parsePlace(r).edges(filter)
 
-	d, err := h.ch.Query(r.Context(), sql)
+	groupBy, ok := groupColumns(w, r, edgeDimensions, "service")
+	if !ok {
+		return
+	}
+	keys := "caller_service, callee_service"
+	if groupBy != "" {
+		keys += ", " + groupBy
+	}
+
+	edges := filter.Clone().
+		Select(keys,
+			"sum(calls) AS calls",
+			"sum(error_calls) AS error_calls",
+			"sum(cancelled_calls) AS cancelled_calls",
+			"sum(timeout_calls) AS timeout_calls",
+			"round(avg((p50_ms + p95_ms)/2), 2) AS avg_latency_ms",
+			"round(avg(p95_ms), 2) AS p95_latency_ms",
+			"max(max_ms) AS max_ms").
+		From("dependency_edges_minute").
+		GroupBy(keys)
+	d, err := h.run(r.Context(), filter.Sub().
+		Select(keys, "calls, error_calls, cancelled_calls, timeout_calls, avg_latency_ms, p95_latency_ms AS p95_ms, max_ms",
+			"round(if(calls = 0, 0, error_calls / calls), 4) AS error_rate",
+			"round(if(calls = 0, 0, cancelled_calls / calls), 4) AS cancel_rate",
+			"round(if(calls = 0, 0, timeout_calls / calls), 4) AS timeout_rate",
+			"count() OVER () AS _total").
+		FromQuery(edges, "").
+		OrderBy("calls DESC").
+		Limit(limit))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		writeQueryError(w, err)
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"edges": d})
+	d, page := splitTotal(d, limit)
+	if r.URL.Query().Get("health") != "false" {
+		if err := h.edgeHealth(r.Context(), d, groupBy, from, to, env); err != nil {
+			writeQueryError(w, err)
+			return
+		}
+		nodes, err := h.serviceHealth(r.Context(), from, to, env)
+		if err != nil {
+			writeQueryError(w, err)
+			return
+		}
+		page["nodes"] = nodes
+	}
+	page["edges"] = d
+	if r.URL.Query().Get("internal") == "true" {
+		modules := filter.Clone().
+			Select("service, caller_module, callee_module",
+				"sum(calls) AS calls",
+				"sum(error_calls) AS error_calls",
+				"round(avg(p95_ms), 2) AS p95_ms",
+				"max(max_ms) AS max_ms",
+				"max(max_depth) AS max_depth").
+			From("internal_edges_minute").
+			GroupBy("service, caller_module, callee_module")
+		internal, err := h.run(r.Context(), filter.Sub().
+			Select("service, caller_module, callee_module, calls, error_calls, p95_ms, max_ms, max_depth",
+				"round(if(calls = 0, 0, error_calls / calls), 4) AS error_rate").
+			FromQuery(modules, "").
+			OrderBy("calls DESC").
+			Limit(limit))
+		if err != nil {
+			writeQueryError(w, err)
+			return
+		}
+		page["internal_edges"] = internal
+	}
+	writeJSON(w, http.StatusOK, page)
 }
 
 func (h *Handler) DependencyDiff(w http.ResponseWriter, r *http.Request) {
-	from, to := parseRange(r)
+	from, to := h.parseRange(r)
 	env := sanitize(r.URL.Query().Get("env"))
 	service := sanitize(r.URL.Query().Get("service"))
 	base := sanitize(r.URL.Query().Get("base"))
 	cand := sanitize(r.URL.Query().Get("cand"))
 	if base == "" || cand == "" {
-		http.Error(w, "base/cand are required", http.StatusBadRequest)
+		WriteError(w, http.StatusBadRequest, "invalid_request", "base/cand are required", nil)
 		return
 	}
 
-	commonWhere := []string{
-		fmt.Sprintf("bucket_ts >= toDateTime('%s', 'UTC')", chMinute(from)),
-		fmt.Sprintf("bucket_ts < toDateTime('%s', 'UTC')", chMinute(to)),
-	}
-	if env != "" {
-		commonWhere = append(commonWhere, fmt.Sprintf("env = '%s'", env))
-	}
+	filter := query.New().MinuteRange("bucket_ts", from, to).FilterIn("env", h.envs(env))
+	parsePlace(r).edges(filter)
 	if service != "" {
-		commonWhere = append(commonWhere, fmt.Sprintf("(caller_service = '%s' OR callee_service = '%s')", service, service))
-	}
-
-	edgeSQL := func(version string) string {
-		where := append([]string{}, commonWhere...)
-		where = append(where, fmt.Sprintf("(caller_version = '%s' OR callee_version = '%s')", version, version))
-		return fmt.Sprintf(`
-SELECT caller_service, callee_service, calls, p95_ms,
-       round(if(calls = 0, 0, error_calls / calls), 4) AS error_rate
-FROM (
-  SELECT caller_service, callee_service,
-         sum(calls) AS calls,
-         sum(error_calls) AS error_calls,
-         round(avg(p95_ms), 2) AS p95_ms
-  FROM dependency_edges_minute
-  WHERE %s
-  GROUP BY caller_service, callee_service
-)`, strings.Join(where, " AND "))
-	}
-
-	baseRows, err := h.ch.Query(r.Context(), edgeSQL(base))
+		filter.EqAny(service, "caller_service", "callee_service")
+	}
+
+	edgeQuery := func(version string) *query.Query {
+		q := filter.Clone().EqAny(version, "caller_version", "callee_version")
+		q.Select("caller_service, callee_service",
+			"sum(calls) AS calls",
+			"sum(error_calls) AS error_calls",
+			"sum(timeout_calls) AS timeout_calls",
+			"round(avg(p95_ms), 2) AS p95_ms").
+			From("dependency_edges_minute").
+			GroupBy("caller_service, callee_service")
+		return q.Sub().
+			Select("caller_service, callee_service, calls, p95_ms",
+				"round(if(calls = 0, 0, error_calls / calls), 4) AS error_rate",
+				"round(if(calls = 0, 0, timeout_calls / calls), 4) AS timeout_rate").
+			FromQuery(q, "")
+	}
+
+	baseRows, err := h.run(r.Context(), edgeQuery(base))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		writeQueryError(w, err)
 		return
 	}
-	candRows, err := h.ch.Query(r.Context(), edgeSQL(cand))
+	candRows, err := h.run(r.Context(), edgeQuery(cand))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		writeQueryError(w, err)
 		return
 	}
 
@@ -333,248 +566,277 @@ FROM (
 }
 
 func (h *Handler) Hosts(w http.ResponseWriter, r *http.Request) {
-	from, to := parseRange(r)
+	from, to := h.parseRange(r)
 	env := sanitize(r.URL.Query().Get("env"))
-	where := []string{
-		fmt.Sprintf("bucket_ts >= toDateTime('%s', 'UTC')", chMinute(from)),
-		fmt.Sprintf("bucket_ts < toDateTime('%s', 'UTC')", chMinute(to)),
-	}
-	if env != "" {
-		where = append(where, fmt.Sprintf("env = '%s'", env))
-	}
-
-	sql := fmt.Sprintf(`
-SELECT
-  host, logs, errors, last_seen, active_services,
-  round(if(logs = 0, 0, errors / logs), 4) AS error_rate
-FROM
-(
-  SELECT
-    host,
-    sum(logs) AS logs,
-    sum(errors) AS errors,
-    max(last_seen_ts) AS last_seen,
-    max(distinct_services) AS active_services
-  FROM host_stats_minute
-  WHERE %s
-  GROUP BY host
-)
-ORDER BY logs DESC
-LIMIT 2000`, strings.Join(where, " AND "))
-
-	d, err := h.ch.Query(r.Context(), sql)
+	limit := h.limitFor(r, "hosts", "limit")
+	hosts := query.New().
+		Select("host",
+			"sum(logs) AS logs",
+			"sum(errors) AS errors",
+			"max(last_seen_ts) AS last_seen",
+			"max(distinct_services) AS active_services").
+		From("host_stats_minute").
+		MinuteRange("bucket_ts", from, to).
+		FilterIn("env", h.envs(env)).
+		GroupBy("host")
+
+	d, err := h.run(r.Context(), hosts.Sub().
+		Select("host, logs, errors, last_seen, active_services",
+			"round(if(logs = 0, 0, errors / logs), 4) AS error_rate",
+			"count() OVER () AS _total").
+		FromQuery(hosts, "").
+		OrderBy("logs DESC").
+		Limit(limit))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		writeQueryError(w, err)
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"hosts": d})
+	d, page := splitTotal(d, limit)
+	page["hosts"] = d
+	writeJSON(w, http.StatusOK, page)
 }
 
 func (h *Handler) Compare(w http.ResponseWriter, r *http.Request) {
-	from, to := parseRange(r)
+	from, to := h.parseRange(r)
 	env := sanitize(r.URL.Query().Get("env"))
 	service := sanitize(r.URL.Query().Get("service"))
 	base := sanitize(r.URL.Query().Get("base"))
 	cand := sanitize(r.URL.Query().Get("cand"))
+	deltaLimit := h.limitFor(r, "deltas", "delta_limit")
 
+	if raw := r.URL.Query().Get("versions"); raw != "" && service != "" {
+		versions, ok := compareVersions(raw)
+		if !ok {
+			WriteError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("versions must list 2 to %d distinct versions", maxCompareVersions), nil)
+			return
+		}
+		opCols, ok := groupColumns(w, r, spanDimensions, "operation")
+		if !ok {
+			return
+		}
+		h.compareMany(w, r, compareQuery{
+			from: from, to: to, env: env, service: service,
+			opCols: opCols, deltaLimit: deltaLimit, place: parsePlace(r),
+		}, versions)
+		return
+	}
 	if service == "" || base == "" || cand == "" {
-		http.Error(w, "service/base/cand are required", http.StatusBadRequest)
+		WriteError(w, http.StatusBadRequest, "invalid_request", "service and base/cand (or versions) are required", nil)
 		return
 	}
-
-	traceWhere := []string{
-		fmt.Sprintf("start_ts >= toDateTime64('%s', 3, 'UTC')", chTime(from)),
-		fmt.Sprintf("start_ts < toDateTime64('%s', 3, 'UTC')", chTime(to)),
-		fmt.Sprintf("root_service = '%s'", service),
-	}
-	if env != "" {
-		traceWhere = append(traceWhere, fmt.Sprintf("env = '%s'", env))
-	}
-	traceSubquery := fmt.Sprintf("SELECT trace_id FROM traces WHERE %s", strings.Join(traceWhere, " AND "))
-	spanWhereAll := fmt.Sprintf("trace_id IN (%s) AND version IN ('%s', '%s')", traceSubquery, base, cand)
-	spanWhereService := fmt.Sprintf("%s AND service = '%s'", spanWhereAll, service)
-
-	metricsSQL := fmt.Sprintf(`
-SELECT
-  version,
-  count() AS spans,
-  round(quantile(0.50)(duration_ms), 2) AS p50_ms,
-  round(quantile(0.95)(duration_ms), 2) AS p95_ms,
-  round(quantile(0.99)(duration_ms), 2) AS p99_ms,
-  round(avg(is_error), 4) AS error_rate
-FROM spans
-WHERE %s
-GROUP BY version`, spanWhereService)
-
-	deltaSQL := fmt.Sprintf(`
-SELECT
-  operation,
-  round(quantileIf(0.95)(duration_ms, version = '%s'), 2) AS base_p95_ms,
-  round(quantileIf(0.95)(duration_ms, version = '%s'), 2) AS cand_p95_ms,
-  round(cand_p95_ms - base_p95_ms, 2) AS delta_p95_ms,
-  countIf(version = '%s') AS base_calls,
-  countIf(version = '%s') AS cand_calls
-FROM spans
-WHERE %s
-GROUP BY operation
-HAVING base_calls > 0 AND cand_calls > 0
-ORDER BY delta_p95_ms DESC
-LIMIT 200`, base, cand, base, cand, spanWhereService)
-
-	rootCauseSQL := fmt.Sprintf(`
-SELECT
-  service,
-  version,
-  count() AS calls,
-  round(quantile(0.95)(duration_ms), 2) AS p95_ms,
-  round(avg(is_error), 4) AS error_rate,
-  round(avg(greatest(duration_ms - self_time_ms, 0)), 2) AS wait_ms,
-  round(avg(if(duration_ms = 0, 0, greatest(duration_ms - self_time_ms, 0) / duration_ms)), 4) AS blocking_ratio
-FROM spans
-WHERE %s
-GROUP BY service, version`, spanWhereAll)
-
-	summarySQL := fmt.Sprintf(`
-SELECT
-  round(quantileIf(0.95)(duration_ms, version = '%s'), 2) AS base_p95,
-  round(quantileIf(0.95)(duration_ms, version = '%s'), 2) AS cand_p95,
-  round(avgIf(is_error, version = '%s'), 4) AS base_error_rate,
-  round(avgIf(is_error, version = '%s'), 4) AS cand_error_rate,
-  countIf(version = '%s') AS base_calls,
-  countIf(version = '%s') AS cand_calls
-FROM spans
-WHERE %s`, base, cand, base, cand, base, cand, spanWhereService)
-
-	metrics, err := h.ch.Query(r.Context(), metricsSQL)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+	opCols, ok := groupColumns(w, r, spanDimensions, "operation")
+	if !ok {
 		return
 	}
-	deltas, err := h.ch.Query(r.Context(), deltaSQL)
+
+	resp, _, err := h.runCompare(r.Context(), compareQuery{
+		from: from, to: to, env: env, service: service, base: base, cand: cand,
+		opCols: opCols, deltaLimit: deltaLimit, place: parsePlace(r),
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		writeQueryError(w, err)
 		return
 	}
-	rootRows, err := h.ch.Query(r.Context(), rootCauseSQL)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type compareQuery struct {
+	from, to                 time.Time
+	env, service, base, cand string
+	opCols                   string
+	deltaLimit               int
+	place                    placeFilter
+}
+
+func (h *Handler) runCompare(ctx context.Context, q compareQuery) (map[string]any, map[string]any, error) {
+	from, to, env, service, base, cand := q.from, q.to, q.env, q.service, q.base, q.cand
+	opCols, deltaLimit := q.opCols, q.deltaLimit
+
+	spans := query.New()
+	traceIDs := spans.Sub().Select("trace_id").
+		TimeRange("start_ts", from, to).
+		Eq("root_service", service).
+		FilterIn("env", h.envs(env))
+	h.latestTraces(traceIDs, from, to)
+	spans.From(h.spansTable).InQuery("trace_id", traceIDs).In("version", []string{base, cand})
+	q.place.spans(spans)
+	all := spans.Clone()
+	spans.Eq("service", service)
+	ownSpans := spans.Clone()
+	b, c := spans.String(base), spans.String(cand)
+
+	metrics, err := h.run(ctx, spans.Clone().
+		Select("version",
+			"count() AS spans",
+			"round(quantile(0.50)(duration_ms), 2) AS p50_ms",
+			"round(quantile(0.95)(duration_ms), 2) AS p95_ms",
+			"round(quantile(0.99)(duration_ms), 2) AS p99_ms",
+			"round(avg(is_error), 4) AS error_rate",
+			"round(avg(status = 'timeout'), 4) AS timeout_rate",
+			"round(avg(status = 'cancelled'), 4) AS cancel_rate").
+		GroupBy("version"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
-		return
+		return nil, nil, err
+	}
+	deltas, err := h.run(ctx, spans.Clone().
+		Select(opCols,
+			fmt.Sprintf("round(quantileIf(0.95)(duration_ms, version = %s), 2) AS base_p95_ms", b),
+			fmt.Sprintf("round(quantileIf(0.95)(duration_ms, version = %s), 2) AS cand_p95_ms", c),
+			"round(cand_p95_ms - base_p95_ms, 2) AS delta_p95_ms",
+			fmt.Sprintf("countIf(version = %s) AS base_calls", b),
+			fmt.Sprintf("countIf(version = %s) AS cand_calls", c),
+			"count() OVER () AS _total").
+		GroupBy(opCols).
+		Having("base_calls > 0", "cand_calls > 0").
+		OrderBy("delta_p95_ms DESC").
+		Limit(deltaLimit))
+	if err != nil {
+		return nil, nil, err
+	}
+	rootRows, err := h.run(ctx, all.
+		Select("service",
+			"version",
+			"count() AS calls",
+			"round(quantile(0.95)(duration_ms), 2) AS p95_ms",
+			"round(avg(is_error), 4) AS error_rate",
+			"round(avg(greatest(duration_ms - self_time_ms, 0)), 2) AS wait_ms",
+			"round(avg(if(duration_ms = 0, 0, greatest(duration_ms - self_time_ms, 0) / duration_ms)), 4) AS blocking_ratio",
+			"round(sum(self_time_ms) / uniqExact(trace_id), 2) AS self_ms_per_trace").
+		GroupBy("service, version"))
+	if err != nil {
+		return nil, nil, err
 	}
-	summaryRows, err := h.ch.Query(r.Context(), summarySQL)
+	topology, err := h.compareTopology(ctx, rootRows, from, to, env)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
-		return
+		return nil, nil, err
+	}
+	summaryRows, err := h.run(ctx, spans.
+		Select(fmt.Sprintf("round(quantileIf(0.95)(duration_ms, version = %s), 2) AS base_p95", b),
+			fmt.Sprintf("round(quantileIf(0.95)(duration_ms, version = %s), 2) AS cand_p95", c),
+			fmt.Sprintf("round(avgIf(is_error, version = %s), 4) AS base_error_rate", b),
+			fmt.Sprintf("round(avgIf(is_error, version = %s), 4) AS cand_error_rate", c),
+			fmt.Sprintf("round(avgIf(status = 'timeout', version = %s), 4) AS base_timeout_rate", b),
+			fmt.Sprintf("round(avgIf(status = 'timeout', version = %s), 4) AS cand_timeout_rate", c),
+			fmt.Sprintf("countIf(version = %s) AS base_calls", b),
+			fmt.Sprintf("countIf(version = %s) AS cand_calls", c)))
+	if err != nil {
+		return nil, nil, err
 	}
 
-	rootCauses := buildRootCauseRanking(rootRows, base, cand)
-	anomalies := buildAnomalyBadges(summaryRows)
+	deltas, deltaPage := splitTotal(deltas, deltaLimit)
+	if err := h.addSignificance(ctx, ownSpans, opCols, cand, deltas); err != nil {
+		return nil, nil, err
+	}
+	rootCauses := buildRootCauseRanking(rootRows, topology, base, cand)
+	anomalies := buildAnomalyBadges(summaryRows, q, deltas)
+	if err := h.addBadgeSpans(ctx, ownSpans, cand, anomalies); err != nil {
+		return nil, nil, err
+	}
+	windows, err := h.maintenanceWindows(ctx, env, service, from, to)
+	if err != nil {
+		log.Printf("maintenance windows for %s: %v", service, err)
+	}
+	silenceBadges(anomalies, windows)
 
-	writeJSON(w, http.StatusOK, map[string]any{
-		"metrics":        metrics,
-		"operation_diff": deltas,
-		"root_causes":    rootCauses,
-		"anomalies":      anomalies,
-	})
+	var summary map[string]any
+	if len(summaryRows) > 0 {
+		summary = summaryRows[0]
+	}
+	return map[string]any{
+		"metrics":                  metrics,
+		"operation_diff":           deltas,
+		"operation_diff_total":     deltaPage["total"],
+		"operation_diff_truncated": deltaPage["truncated"],
+		"root_causes":              rootCauses,
+		"anomalies":                anomalies,
+		"maintenance":              windows,
+	}, summary, nil
 }
 
 func (h *Handler) Errors(w http.ResponseWriter, r *http.Request) {
-	from, to := parseRange(r)
+	from, to := h.parseRange(r)
 	env := sanitize(r.URL.Query().Get("env"))
 	service := sanitize(r.URL.Query().Get("service"))
 	base := sanitize(r.URL.Query().Get("base"))
 	cand := sanitize(r.URL.Query().Get("cand"))
-
-	traceWhere := []string{
-		fmt.Sprintf("start_ts >= toDateTime64('%s', 3, 'UTC')", chTime(from)),
-		fmt.Sprintf("start_ts < toDateTime64('%s', 3, 'UTC')", chTime(to)),
-	}
-	if env != "" {
-		traceWhere = append(traceWhere, fmt.Sprintf("env = '%s'", env))
-	}
-	if service != "" {
-		traceWhere = append(traceWhere, fmt.Sprintf("root_service = '%s'", service))
-	}
-	traceSubquery := fmt.Sprintf("SELECT trace_id FROM traces WHERE %s", strings.Join(traceWhere, " AND "))
-	spanWhere := fmt.Sprintf("trace_id IN (%s)", traceSubquery)
-
-	serviceBreakdownSQL := fmt.Sprintf(`
-SELECT service,
-       countIf(is_error = 1) AS errors,
-       count() AS calls,
-       round(countIf(is_error = 1) / greatest(count(), 1), 4) AS error_rate
-FROM spans
-WHERE %s
-GROUP BY service
-ORDER BY errors DESC, calls DESC`, spanWhere)
-
-	topOpsSQL := fmt.Sprintf(`
-SELECT service, operation,
-       countIf(is_error = 1) AS errors,
-       count() AS calls,
-       round(countIf(is_error = 1) / greatest(count(), 1), 4) AS error_rate
-FROM spans
-WHERE %s
-GROUP BY service, operation
-HAVING errors > 0
-ORDER BY errors DESC, error_rate DESC
-LIMIT 20`, spanWhere)
-
-	edgeWhere := []string{
-		fmt.Sprintf("bucket_ts >= toDateTime('%s', 'UTC')", chMinute(from)),
-		fmt.Sprintf("bucket_ts < toDateTime('%s', 'UTC')", chMinute(to)),
-	}
-	if env != "" {
-		edgeWhere = append(edgeWhere, fmt.Sprintf("env = '%s'", env))
+	opCols, ok := groupColumns(w, r, spanDimensions, "operation")
+	if !ok {
+		return
 	}
-	if service != "" {
-		edgeWhere = append(edgeWhere, fmt.Sprintf("(caller_service = '%s' OR callee_service = '%s')", service, service))
-	}
-	propagationSQL := fmt.Sprintf(`
-SELECT caller_service, callee_service, error_calls, calls,
-       round(if(calls = 0, 0, error_calls / calls), 4) AS error_rate
-FROM (
-  SELECT caller_service, callee_service,
-         sum(error_calls) AS error_calls,
-         sum(calls) AS calls
-  FROM dependency_edges_minute
-  WHERE %s
-  GROUP BY caller_service, callee_service
-)
-WHERE error_calls > 0
-ORDER BY error_calls DESC
-LIMIT 20`, strings.Join(edgeWhere, " AND "))
 
-	breakdown, err := h.ch.Query(r.Context(), serviceBreakdownSQL)
+	spans := query.New()
+	traceIDs := spans.Sub().Select("trace_id").
+		TimeRange("start_ts", from, to).
+		FilterIn("env", h.envs(env)).
+		Filter("root_service", service)
+	h.latestTraces(traceIDs, from, to)
+	spans.From(h.spansTable).InQuery("trace_id", traceIDs)
+	place := parsePlace(r)
+	place.spans(spans)
+
+	breakdown, err := h.run(r.Context(), spans.Clone().
+		Select("service",
+			"countIf(is_error = 1) AS errors",
+			"count() AS calls",
+			"round(countIf(is_error = 1) / greatest(count(), 1), 4) AS error_rate").
+		GroupBy("service").
+		OrderBy("errors DESC", "calls DESC"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		writeQueryError(w, err)
 		return
 	}
-	topOps, err := h.ch.Query(r.Context(), topOpsSQL)
+	topOps, err := h.run(r.Context(), spans.Clone().
+		Select("service", opCols,
+			"countIf(is_error = 1) AS errors",
+			"count() AS calls",
+			"round(countIf(is_error = 1) / greatest(count(), 1), 4) AS error_rate").
+		GroupBy("service", opCols).
+		Having("errors > 0").
+		OrderBy("errors DESC", "error_rate DESC").
+		Limit(20))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		writeQueryError(w, err)
 		return
 	}
-	propagation, err := h.ch.Query(r.Context(), propagationSQL)
+
+	edges := query.New().
+		Select("caller_service, callee_service",
+			"sum(error_calls) AS error_calls",
+			"sum(timeout_calls) AS timeout_calls",
+			"sum(calls) AS calls").
+		From("dependency_edges_minute").
+		MinuteRange("bucket_ts", from, to).
+		FilterIn("env", h.envs(env)).
+		GroupBy("caller_service, callee_service")
+	place.edges(edges)
+	if service != "" {
+		edges.EqAny(service, "caller_service", "callee_service")
+	}
+	propagation, err := h.run(r.Context(), edges.Sub().
+		Select("caller_service, callee_service, error_calls, timeout_calls, calls",
+			"round(if(calls = 0, 0, error_calls / calls), 4) AS error_rate").
+		FromQuery(edges, "").
+		Where("error_calls > 0").
+		OrderBy("error_calls DESC").
+		Limit(20))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		writeQueryError(w, err)
 		return
 	}
 
 	newErrors := []map[string]any{}
 	if base != "" && cand != "" {
-		newErrSQL := fmt.Sprintf(`
-SELECT service, operation,
-       countIf(is_error = 1 AND version = '%s') AS base_errors,
-       countIf(is_error = 1 AND version = '%s') AS cand_errors
-FROM spans
-WHERE %s AND version IN ('%s', '%s')
-GROUP BY service, operation
-HAVING base_errors = 0 AND cand_errors > 0
-ORDER BY cand_errors DESC
-LIMIT 20`, base, cand, spanWhere, base, cand)
-		newErrors, err = h.ch.Query(r.Context(), newErrSQL)
+		q := spans.Clone().In("version", []string{base, cand})
+		newErrors, err = h.run(r.Context(), q.
+			Select("service", opCols,
+				fmt.Sprintf("countIf(is_error = 1 AND version = %s) AS base_errors", q.String(base)),
+				fmt.Sprintf("countIf(is_error = 1 AND version = %s) AS cand_errors", q.String(cand))).
+			GroupBy("service", opCols).
+			Having("base_errors = 0", "cand_errors > 0").
+			OrderBy("cand_errors DESC").
+			Limit(20))
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadGateway)
+			writeQueryError(w, err)
 			return
 		}
 	}
@@ -594,8 +856,8 @@ func firstOrNil(v []map[string]any) any {
 	return v[0]
 }
 
-func parseRange(r *http.Request) (time.Time, time.Time) {
-	to := time.Now().UTC()
+func (h *Handler) parseRange(r *http.Request) (time.Time, time.Time) {
+	to := h.now().UTC()
 	from := to.Add(-7 * 24 * time.Hour)
 	if rawTo := r.URL.Query().Get("to"); rawTo != "" {
 		if parsed, err := time.Parse(time.RFC3339, rawTo); err == nil {
@@ -613,21 +875,6 @@ func parseRange(r *http.Request) (time.Time, time.Time) {
 	return from, to
 }
 
-func parseLimit(r *http.Request, fallback int) int {
-	raw := r.URL.Query().Get("limit")
-	if raw == "" {
-		return fallback
-	}
-	v, err := strconv.Atoi(raw)
-	if err != nil || v <= 0 {
-		return fallback
-	}
-	if v > 5000 {
-		return 5000
-	}
-	return v
-}
-
 func sanitize(v string) string {
 	v = strings.TrimSpace(v)
 	if v == "" {
@@ -639,6 +886,18 @@ func sanitize(v string) string {
 	return v
 }
 
+func statusCodeCond(column, v string) (string, bool) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	if len(v) == 3 && strings.HasSuffix(v, "xx") && v[0] >= '1' && v[0] <= '5' {
+		lo := int(v[0]-'0') * 100
+		return fmt.Sprintf("%s BETWEEN %d AND %d", column, lo, lo+99), true
+	}
+	if n, err := strconv.Atoi(v); err == nil && n >= 0 && n < 1000 {
+		return fmt.Sprintf("%s = %d", column, n), true
+	}
+	return "", false
+}
+
 func chTime(t time.Time) string {
 	return t.UTC().Format("2006-01-02 15:04:05.000")
 }
@@ -647,7 +906,11 @@ func chMinute(t time.Time) string {
 	return t.UTC().Format("2006-01-02 15:04:00")
 }
 
-func buildTraceDrilldown(rows []map[string]any) map[string]any {
+func chSecond(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+func buildTraceDrilldown(rows []map[string]any, baselines map[string]opBaseline) map[string]any {
 	spans := make([]*traceSpan, 0, len(rows))
 	byID := map[string]*traceSpan{}
 	for _, row := range rows {
@@ -658,19 +921,32 @@ func buildTraceDrilldown(rows []map[string]any) map[string]any {
 			Service:      toString(row["service"]),
 			Env:          toString(row["env"]),
 			Host:         toString(row["host"]),
+			Region:       toString(row["region"]),
+			Zone:         toString(row["zone"]),
 			Version:      toString(row["version"]),
 			Operation:    toString(row["operation"]),
+			Method:       toString(row["method"]),
+			Route:        toString(row["route"]),
 			StartTS:      toString(row["start_ts"]),
 			EndTS:        toString(row["end_ts"]),
 			DurationMs:   toUint32(row["duration_ms"]),
 			SelfTimeMs:   toUint32(row["self_time_ms"]),
+			QueueMs:      toUint32(row["queue_ms"]),
 			StatusCode:   uint16(toUint32(row["status_code"])),
 			IsError:      toFloat(row["is_error"]) > 0,
+			Status:       toString(row["status"]),
+			ErrorMessage: toString(row["error_message"]),
+			ErrorType:    toString(row["error_type"]),
 			Source:       toString(row["source"]),
+			Proxy:        toString(row["proxy"]),
+			Conflicts:    toStrings(row["conflicts"]),
 		}
 		if span.SelfTimeMs > span.DurationMs {
 			span.SelfTimeMs = span.DurationMs
 		}
+		if span.QueueMs > span.SelfTimeMs {
+			span.QueueMs = span.SelfTimeMs
+		}
 		if span.DurationMs > span.SelfTimeMs {
 			span.WaitMs = span.DurationMs - span.SelfTimeMs
 		}
@@ -756,6 +1032,8 @@ func buildTraceDrilldown(rows []map[string]any) map[string]any {
 		if span.IsError {
 			errorChains = append(errorChains, map[string]any{
 				"error_span_id": span.SpanID,
+				"error_type":    span.ErrorType,
+				"error_message": span.ErrorMessage,
 				"path":          buildErrorPath(span, byID),
 			})
 		}
@@ -779,12 +1057,22 @@ func buildTraceDrilldown(rows []map[string]any) map[string]any {
 		} else {
 			span.Explanation = fmt.Sprintf("%s total:%dms self:%dms waiting:%dms", span.Service, span.DurationMs, span.SelfTimeMs, span.WaitMs)
 		}
+		if span.QueueMs > 0 {
+			span.Explanation += fmt.Sprintf(" queued:%dms", span.QueueMs)
+		}
 	}
 
 	slow := make([]map[string]any, 0, len(spans))
 	for _, span := range spans {
-		score := 0.6*(float64(span.WaitMs)/float64(maxWait)) + 0.4*span.BlockingRatio
-		slow = append(slow, map[string]any{
+		anomaly := unknownOutlier
+		b, known := baselines[baselineKey(span.Service, span.Operation)]
+		known = known && b.Calls >= baselineMinSamples
+		band := "unknown"
+		if known {
+			anomaly, band = b.outlier(span.DurationMs)
+		}
+		score := 0.6*anomaly + 0.25*(float64(span.WaitMs)/float64(maxWait)) + 0.15*span.BlockingRatio
+		spot := map[string]any{
 			"span_id":          span.SpanID,
 			"service":          span.Service,
 			"operation":        span.Operation,
@@ -793,12 +1081,20 @@ func buildTraceDrilldown(rows []map[string]any) map[string]any {
 			"wait_ms":          span.WaitMs,
 			"blocking_ratio":   round(scoreToPct(span.BlockingRatio), 2),
 			"score":            round(score, 4),
+			"latency_band":     band,
+			"outlier_score":    nil,
+			"baseline":         nil,
 			"is_critical":      span.IsCritical,
 			"is_error":         span.IsError,
 			"explanation":      span.Explanation,
 			"parent_span_id":   span.ParentSpanID,
 			"child_span_count": len(span.Children),
-		})
+		}
+		if known {
+			spot["outlier_score"] = round(anomaly, 4)
+			spot["baseline"] = map[string]any{"calls": uint64(b.Calls), "p50_ms": round(b.P50, 2), "p90_ms": round(b.P90, 2), "p99_ms": round(b.P99, 2)}
+		}
+		slow = append(slow, spot)
 	}
 	sort.Slice(slow, func(i, j int) bool {
 		return toFloat(slow[i]["score"]) > toFloat(slow[j]["score"])
@@ -809,7 +1105,20 @@ func buildTraceDrilldown(rows []map[string]any) map[string]any {
 
 	waterfall := make([]map[string]any, 0, len(spans))
 	sort.Slice(spans, func(i, j int) bool { return spans[i].StartTime.Before(spans[j].StartTime) })
+	fanouts := make(map[string]map[string]any, len(spans))
+	lanes := make(map[string]int, len(spans))
+	for _, span := range spans {
+		fanout, childLanes := buildFanout(span)
+		fanouts[span.SpanID] = fanout
+		for id, lane := range childLanes {
+			lanes[id] = lane
+		}
+	}
+	conflicts := make([]map[string]any, 0)
 	for _, span := range spans {
+		if len(span.Conflicts) > 0 {
+			conflicts = append(conflicts, map[string]any{"span_id": span.SpanID, "service": span.Service, "fields": span.Conflicts})
+		}
 		childIDs := make([]string, 0, len(span.Children))
 		for _, c := range span.Children {
 			childIDs = append(childIDs, c.SpanID)
@@ -820,21 +1129,38 @@ func buildTraceDrilldown(rows []map[string]any) map[string]any {
 			"parent_span_id": span.ParentSpanID,
 			"service":        span.Service,
 			"host":           span.Host,
+			"region":         span.Region,
+			"zone":           span.Zone,
 			"version":        span.Version,
 			"operation":      span.Operation,
+			"method":         span.Method,
+			"route":          span.Route,
 			"start_ts":       span.StartTS,
 			"end_ts":         span.EndTS,
 			"duration_ms":    span.DurationMs,
 			"self_time_ms":   span.SelfTimeMs,
 			"wait_ms":        span.WaitMs,
 			"blocking_ratio": round(scoreToPct(span.BlockingRatio), 2),
-			"depth":          span.Depth,
-			"is_critical":    span.IsCritical,
-			"is_error":       span.IsError,
-			"left_pct":       round(span.LeftPct, 2),
-			"width_pct":      round(span.WidthPct, 2),
-			"children":       childIDs,
-			"explanation":    span.Explanation,
+			"segments": map[string]any{
+				"queue_ms":      span.QueueMs,
+				"exec_ms":       span.SelfTimeMs - span.QueueMs,
+				"downstream_ms": span.WaitMs,
+			},
+			"depth":         span.Depth,
+			"is_critical":   span.IsCritical,
+			"is_error":      span.IsError,
+			"status":        span.Status,
+			"error_message": span.ErrorMessage,
+			"error_type":    span.ErrorType,
+			"proxy":         span.Proxy,
+			"source":        span.Source,
+			"conflicts":     span.Conflicts,
+			"left_pct":      round(span.LeftPct, 2),
+			"width_pct":     round(span.WidthPct, 2),
+			"children":      childIDs,
+			"lane":          lanes[span.SpanID],
+			"fanout":        fanouts[span.SpanID],
+			"explanation":   span.Explanation,
 		})
 	}
 
@@ -842,6 +1168,7 @@ func buildTraceDrilldown(rows []map[string]any) map[string]any {
 		"waterfall":     waterfall,
 		"critical_path": criticalIDs,
 		"error_chains":  errorChains,
+		"conflicts":     conflicts,
 		"slow_spots":    slow,
 		"trace_window": map[string]any{
 			"start_ts": traceStart.UTC().Format("2006-01-02 15:04:05.000"),
@@ -896,113 +1223,15 @@ func buildErrorPath(errSpan *traceSpan, byID map[string]*traceSpan) []string {
 	return path
 }
 
-func buildRootCauseRanking(rows []map[string]any, base, cand string) []rootCauseRank {
-	type stats struct {
-		Calls         float64
-		P95           float64
-		ErrorRate     float64
-		BlockingRatio float64
-	}
-	baseStats := map[string]stats{}
-	candStats := map[string]stats{}
-
-	for _, row := range rows {
-		s := stats{
-			Calls:         toFloat(row["calls"]),
-			P95:           toFloat(row["p95_ms"]),
-			ErrorRate:     toFloat(row["error_rate"]),
-			BlockingRatio: toFloat(row["blocking_ratio"]),
-		}
-		svc := toString(row["service"])
-		version := toString(row["version"])
-		if version == base {
-			baseStats[svc] = s
-		}
-		if version == cand {
-			candStats[svc] = s
-		}
-	}
-
-	services := map[string]struct{}{}
-	for svc := range baseStats {
-		services[svc] = struct{}{}
-	}
-	for svc := range candStats {
-		services[svc] = struct{}{}
-	}
-
-	out := make([]rootCauseRank, 0, len(services))
-	for svc := range services {
-		b := baseStats[svc]
-		c := candStats[svc]
-		latPct := pctDelta(b.P95, c.P95)
-		errPct := pctDelta(b.ErrorRate, c.ErrorRate)
-		callPct := pctDelta(b.Calls, c.Calls)
-		score := 0.5*clamp(latPct/300, 0, 1) + 0.25*clamp(errPct/300, 0, 1) + 0.15*clamp(callPct/300, 0, 1) + 0.10*clamp(c.BlockingRatio, 0, 1)
-		reason := fmt.Sprintf("latency %+0.1f%%, error %+0.1f%%, calls %+0.1f%%", latPct, errPct, callPct)
-		out = append(out, rootCauseRank{
-			Service:         svc,
-			Score:           round(score, 4),
-			LatencyDeltaPct: round(latPct, 2),
-			ErrorDeltaPct:   round(errPct, 2),
-			CallDeltaPct:    round(callPct, 2),
-			BlockingRatio:   round(c.BlockingRatio, 4),
-			Reason:          reason,
-		})
-	}
-
-	sort.Slice(out, func(i, j int) bool { return out[i].Score > out[j].Score })
-	if len(out) > 10 {
-		out = out[:10]
+func toStrings(v any) []string {
+	list, _ := v.([]any)
+	out := make([]string, 0, len(list))
+	for _, item := range list {
+		out = append(out, toString(item))
 	}
 	return out
 }
 
-func buildAnomalyBadges(rows []map[string]any) []map[string]any {
-	if len(rows) == 0 {
-		return nil
-	}
-	r := rows[0]
-	baseP95 := toFloat(r["base_p95"])
-	candP95 := toFloat(r["cand_p95"])
-	baseErr := toFloat(r["base_error_rate"])
-	candErr := toFloat(r["cand_error_rate"])
-	baseCalls := toFloat(r["base_calls"])
-	candCalls := toFloat(r["cand_calls"])
-
-	latPct := pctDelta(baseP95, candP95)
-	errPct := pctDelta(baseErr, candErr)
-	callPct := pctDelta(baseCalls, candCalls)
-
-	deviation := clamp(math.Max(math.Abs(latPct)/300, math.Max(math.Abs(errPct)/300, math.Abs(callPct)/300)), 0, 1)
-	badges := make([]map[string]any, 0)
-	if latPct >= 100 {
-		badges = append(badges, map[string]any{
-			"level":           "orange",
-			"title":           "Latency spike detected",
-			"message":         fmt.Sprintf("p95 +%.1f%%", latPct),
-			"deviation_score": round(deviation, 3),
-		})
-	}
-	if errPct >= 50 {
-		badges = append(badges, map[string]any{
-			"level":           "red",
-			"title":           "Error anomaly detected",
-			"message":         fmt.Sprintf("error rate +%.1f%%", errPct),
-			"deviation_score": round(deviation, 3),
-		})
-	}
-	if callPct >= 100 {
-		badges = append(badges, map[string]any{
-			"level":           "yellow",
-			"title":           "Traffic spike detected",
-			"message":         fmt.Sprintf("calls +%.1f%%", callPct),
-			"deviation_score": round(deviation, 3),
-		})
-	}
-	return badges
-}
-
 func toString(v any) string {
 	switch t := v.(type) {
 	case nil:
@@ -0,0 +1,468 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"trace-lite/api/internal/clickhouse"
+	"trace-lite/api/internal/clickhouse/clickhousetest"
+	"trace-lite/api/internal/config"
+	"trace-lite/api/internal/fieldcrypt"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+var testNow = time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+
+const testRange = "from=2026-01-01T00:00:00Z&to=2026-01-02T00:00:00Z"
+
+type endpointCase struct {
+	name    string
+	method  string
+	url     string
+	body    string
+	headers map[string]string
+	setup   func(f *clickhousetest.Fake)
+}
+
+func trace(id string, duration float64) map[string]any {
+	return map[string]any{
+		"trace_id": id, "env": "prod", "root_service": "gateway", "root_operation": "GET /checkout", "root_status_code": 200,
+		"transaction": "checkout", "start_ts": "2026-01-01 10:00:00.000", "end_ts": "2026-01-01 10:00:00.250", "duration_ms": duration,
+		"span_count": 3, "service_count": 2, "error_count": 0, "critical_path_ms": 240, "versions": []string{"v1"},
+		"regions": []string{"eu-west-1"}, "zones": []string{"eu-west-1a"}, "truncated": 0, "dropped_spans": 0, "partial": 0, "labels": []string{"canary"},
+		"integrity": 0.7, "inferred_spans": 3, "orphan_spans": 0, "skewed_spans": 0,
+	}
+}
+
+func span(id, parent, service, start, end string, duration, self float64, isError int) map[string]any {
+	status := "ok"
+	if isError == 1 {
+		status = "error"
+	}
+	return map[string]any{
+		"trace_id": "t1", "span_id": id, "parent_span_id": parent, "service": service, "env": "prod", "host": "h1",
+		"region": "eu-west-1", "zone": "eu-west-1a", "version": "v1", "operation": "GET /" + service, "method": "GET", "route": "/" + service, "start_ts": start, "end_ts": end,
+		"duration_ms": duration, "self_time_ms": self, "status_code": 200 + 300*isError, "is_error": isError, "status": status,
+		"error_message": "", "error_type": "", "source": "log", "proxy": "", "conflicts": []string{},
+	}
+}
+
+func edge(caller, callee string, calls, errs float64) map[string]any {
+	return map[string]any{
+		"caller_service": caller, "callee_service": callee, "calls": calls, "error_calls": errs, "cancelled_calls": 0,
+		"timeout_calls": 1, "avg_latency_ms": 20.5, "p95_ms": 40, "max_ms": 90, "error_rate": errs / calls,
+		"cancel_rate": 0, "timeout_rate": 1 / calls, "_total": 2,
+	}
+}
+
+func waterfallTrace(f *clickhousetest.Fake) {
+	cart := span("s2", "s1", "cart", "2026-01-01 10:00:00.010", "2026-01-01 10:00:00.110", 100, 100, 0)
+	cart["queue_ms"] = 35
+	payments := span("s3", "s1", "payments", "2026-01-01 10:00:00.120", "2026-01-01 10:00:00.240", 120, 20, 1)
+	payments["conflicts"] = []string{"duration", "service"}
+	f.On("FROM spans",
+		span("s1", "", "gateway", "2026-01-01 10:00:00.000", "2026-01-01 10:00:00.250", 250, 30, 0),
+		cart,
+		payments,
+		span("s4", "s3", "bank", "2026-01-01 10:00:00.130", "2026-01-01 10:00:00.230", 100, 100, 1))
+	f.On("operation_latency_hourly",
+		map[string]any{"service": "cart", "operation": "GET /cart", "calls": "5000", "q": []any{90, 100, 110}},
+		map[string]any{"service": "bank", "operation": "GET /bank", "calls": "800", "q": []any{20, 30, 40}},
+		map[string]any{"service": "gateway", "operation": "GET /gateway", "calls": "5", "q": []any{100, 200, 300}})
+	f.On("FROM trace_links", map[string]any{"trace_id": "t1", "linked_trace_id": "t9", "link_type": "async", "service": "cart", "env": "prod", "span_id": "s2", "ts": "2026-01-01 10:00:00.050"})
+	f.On("FROM traces", trace("t1", 250))
+}
+
+var endpointCases = []endpointCase{
+	{name: "livez", url: "/livez"},
+	{name: "readyz", url: "/readyz"},
+	{name: "readyz_down", url: "/readyz", setup: func(f *clickhousetest.Fake) { f.PingErr = errors.New("connection refused") }},
+	{name: "traces", url: "/v1/traces?" + testRange + "&limit=2", setup: func(f *clickhousetest.Fake) {
+		a, b := trace("t1", 250), trace("t2", 90)
+		a["_total"], b["_total"] = 5, 5
+		f.On("FROM (", a, b)
+	}},
+	{name: "traces_env_group", url: "/v1/traces?" + testRange + "&env=production"},
+	{name: "envs", url: "/v1/envs?" + testRange, setup: func(f *clickhousetest.Fake) {
+		f.On("GROUP BY env", map[string]any{"env": "prod-eu", "traces": 120}, map[string]any{"env": "staging", "traces": 8})
+	}},
+	{name: "traces_region", url: "/v1/traces?" + testRange + "&region=eu-west-1&zone=eu-west-1a"},
+	{name: "dependency_region", url: "/v1/dependency?" + testRange + "&region=eu-west-1&group_by=region&health=false", setup: func(f *clickhousetest.Fake) {
+		f.On("dependency_edges_minute", map[string]any{"caller_service": "gateway", "callee_service": "cart", "caller_region": "eu-west-1", "callee_region": "us-east-1", "calls": 40, "error_calls": 2, "cancelled_calls": 0, "timeout_calls": 1, "avg_latency_ms": 88.5, "p95_ms": 140, "max_ms": 210, "error_rate": 0.05, "cancel_rate": 0, "timeout_rate": 0.025, "_total": 1})
+	}},
+	{name: "services_queue_zone", url: "/v1/services/queue?" + testRange + "&zone=eu-west-1a"},
+	{name: "traces_min_integrity", url: "/v1/traces?" + testRange + "&min_integrity=0.8"},
+	{name: "traces_bad_integrity", url: "/v1/traces?" + testRange + "&min_integrity=high"},
+	{name: "traces_filtered", url: "/v1/traces?" + testRange + "&env=prod&service=gateway&transaction=it's&root_operation=GET%20/x&root_status_code=5xx&version=v1,v2&version_match=only&label=a,b&label_match=any&truncated=true"},
+	{name: "traces_stratified", url: "/v1/traces?" + testRange + "&sample=stratified&limit=8", setup: func(f *clickhousetest.Fake) {
+		f.On("AS p99", map[string]any{"total": 40, "p50": 100, "p90": 200, "p99": 400})
+		fast, slow := trace("t1", 50), trace("t2", 300)
+		fast["duration_bucket"], slow["duration_bucket"] = "fast", "slow"
+		f.On("duration_bucket", fast, slow)
+	}},
+	{name: "trace_by_id", url: "/v1/traces/t1", setup: func(f *clickhousetest.Fake) {
+		f.On("FROM spans", span("s1", "", "gateway", "2026-01-01 10:00:00.000", "2026-01-01 10:00:00.250", 250, 50, 0))
+		f.On("FROM traces", trace("t1", 250))
+	}},
+	{name: "trace_waterfall", url: "/v1/traces/t1/waterfall?links=true", setup: waterfallTrace},
+	{name: "trace_render_svg", url: "/v1/traces/t1/render", setup: waterfallTrace},
+	{name: "trace_render_txt", url: "/v1/traces/t1/render?format=txt&width=100", setup: waterfallTrace},
+	{name: "trace_render_span_limit", url: "/v1/traces/t1/render?format=txt&width=100&span_limit=2", setup: waterfallTrace},
+	{name: "trace_waterfall_span_limit", url: "/v1/traces/t1/waterfall?span_limit=2", setup: waterfallTrace},
+	{name: "trace_render_bad_format", url: "/v1/traces/t1/render?format=png"},
+	{name: "trace_render_not_found", url: "/v1/traces/t9/render?format=txt"},
+	{name: "trace_snapshot_create", method: http.MethodPost, url: "/v1/traces/t1/snapshot", setup: waterfallTrace},
+	{name: "trace_snapshot_not_found", method: http.MethodPost, url: "/v1/traces/t9/snapshot"},
+	{name: "trace_snapshot_get", url: "/v1/traces/t1/snapshot/9f2c4e0b6a1d3f5e7c9b2a4d6f8e0c1b3a5d7f9e2c4b6a8d0f1e3c5b7a9d2f4e", setup: func(f *clickhousetest.Fake) {
+		f.On("trace_snapshots", map[string]any{"id": "9f2c4e0b6a1d3f5e7c9b2a4d6f8e0c1b3a5d7f9e2c4b6a8d0f1e3c5b7a9d2f4e", "trace_id": "t1", "created_at": "2026-01-01 12:00:00.000", "bytes": 27, "body": `{"trace":{"trace_id":"t1"}}`})
+	}},
+	{name: "trace_snapshot_bad_id", url: "/v1/traces/t1/snapshot/not-a-digest"},
+	{name: "trace_logs", url: "/v1/traces/t1/logs", setup: func(f *clickhousetest.Fake) {
+		f.On("FROM raw_logs", map[string]any{
+			"ts": "2026-01-01 10:00:00.000", "service": "gateway", "env": "prod", "host": "h1", "version": "v1", "level": "info",
+			"message": "request done", "span_id": "s1", "parent_span_id": "", "event": "request", "route": "/checkout", "method": "GET",
+			"status_code": 200, "duration_ms": 250, "attrs": map[string]any{"user.id": "enc:v1:k1:abc", "region": "eu"},
+			"raw_json": "{}", "_total": 1,
+		})
+	}},
+	{name: "dependency", url: "/v1/dependency?" + testRange + "&env=prod&internal=true", setup: func(f *clickhousetest.Fake) {
+		f.On("internal_edges_minute", map[string]any{"service": "cart", "caller_module": "api", "callee_module": "db", "calls": 10, "error_calls": 1, "p95_ms": 12, "max_ms": 30, "max_depth": 2, "error_rate": 0.1})
+		f.On("callee_service AS service", map[string]any{"service": "cart", "p95_ms": 40, "base_p95_ms": 30})
+		f.On("service_versions_minute", map[string]any{"service": "cart", "calls": 1000, "error_calls": 20, "base_peak": 2})
+		f.On("base_peak", map[string]any{"caller_service": "gateway", "callee_service": "cart", "base_p95_ms": 35, "base_peak": 1})
+		f.On("dependency_edges_minute", edge("gateway", "cart", 1000, 20), edge("cart", "db", 400, 0))
+	}},
+	{name: "dependency_group_by", url: "/v1/dependency?" + testRange + "&group_by=method_route&health=false"},
+	{name: "dependency_bad_group_by", url: "/v1/dependency?" + testRange + "&group_by=host"},
+	{name: "dependency_diff", url: "/v1/dependency/diff?" + testRange + "&service=cart&base=v1&cand=v2", setup: func(f *clickhousetest.Fake) {
+		f.On("caller_version = {p4:String}", map[string]any{"caller_service": "gateway", "callee_service": "cart", "calls": 300, "p95_ms": 50, "error_rate": 0.02})
+		f.On("caller_version = {p3:String}", map[string]any{"caller_service": "gateway", "callee_service": "cart", "calls": 100, "p95_ms": 40, "error_rate": 0.01},
+			map[string]any{"caller_service": "cart", "callee_service": "cache", "calls": 50, "p95_ms": 2, "error_rate": 0})
+	}},
+	{name: "dependency_diff_missing_versions", url: "/v1/dependency/diff?" + testRange},
+	{name: "dependency_changes", url: "/v1/dependency/changes?" + testRange + "&service=cart&kind=new_edge", setup: func(f *clickhousetest.Fake) {
+		f.On("dependency_changes", map[string]any{"at": "2026-01-01 09:00:00", "detected_at": "2026-01-01 09:05:00.000", "env": "prod", "caller_service": "cart", "callee_service": "cache", "kind": "new_edge", "calls": "120", "before": 0, "after": 0, "_total": 1})
+	}},
+	{name: "dependency_changes_bad_kind", url: "/v1/dependency/changes?kind=moved"},
+	{name: "hosts", url: "/v1/hosts?" + testRange, setup: func(f *clickhousetest.Fake) {
+		f.On("host_stats_minute", map[string]any{"host": "h1", "logs": 500, "errors": 5, "last_seen": "2026-01-01 23:59:00", "active_services": 3, "error_rate": 0.01, "_total": 1})
+	}},
+	{name: "trace_clusters", url: "/v1/traces/clusters?" + testRange + "&service=gateway", setup: func(f *clickhousetest.Fake) {
+		f.On("p90_ms", map[string]any{"traces": "1000", "p90_ms": 850})
+		f.On("bottleneck_caller",
+			map[string]any{"shape": "1234", "services": []any{"auth", "db", "gateway"}, "bottleneck_caller": "auth", "bottleneck_service": "db", "bottleneck_operation": "SELECT users", "traces": "82", "p50_ms": 1200, "p95_ms": 2400, "avg_bottleneck_ms": 900, "bottleneck_share": 0.71, "example_trace_ids": []any{"t1", "t2", "t3"}, "_slow": "100", "_total": 2},
+			map[string]any{"shape": "5678", "services": []any{"gateway"}, "bottleneck_caller": "", "bottleneck_service": "gateway", "bottleneck_operation": "GET /checkout", "traces": "18", "p50_ms": 950, "p95_ms": 1100, "avg_bottleneck_ms": 800, "bottleneck_share": 0.84, "example_trace_ids": []any{"t9"}, "_slow": "100", "_total": 2})
+	}},
+	{name: "trace_clusters_threshold", url: "/v1/traces/clusters?" + testRange + "&service=gateway&min_duration_ms=2000"},
+	{name: "trace_clusters_missing_service", url: "/v1/traces/clusters?" + testRange},
+	{name: "compare", url: "/v1/compare?" + testRange + "&service=cart&base=v1&cand=v2", setup: func(f *clickhousetest.Fake) {
+		f.On("maintenance_windows", map[string]any{"id": "mw-1", "env": "", "service": "cart", "starts_at": "2026-01-01 00:00:00", "ends_at": "2026-01-01 06:00:00", "reason": "migration"})
+		f.On("base_timeout_rate", map[string]any{"base_p95": 40, "cand_p95": 80, "base_error_rate": 0.01, "cand_error_rate": 0.05, "base_timeout_rate": 0, "cand_timeout_rate": 0.02, "base_calls": "1000", "cand_calls": "1100"})
+		f.On("blocking_ratio",
+			map[string]any{"service": "cart", "version": "v1", "calls": "1000", "p95_ms": 40, "error_rate": 0.01, "wait_ms": 10, "blocking_ratio": 0.2, "self_ms_per_trace": 30},
+			map[string]any{"service": "cart", "version": "v2", "calls": "1100", "p95_ms": 80, "error_rate": 0.05, "wait_ms": 40, "blocking_ratio": 0.5, "self_ms_per_trace": 34},
+			map[string]any{"service": "db", "version": "v1", "calls": "500", "p95_ms": 10, "error_rate": 0, "wait_ms": 0, "blocking_ratio": 0, "self_ms_per_trace": 8},
+			map[string]any{"service": "db", "version": "v2", "calls": "560", "p95_ms": 35, "error_rate": 0.04, "wait_ms": 0, "blocking_ratio": 0, "self_ms_per_trace": 30})
+		f.On("dependency_edges_minute", map[string]any{"caller_service": "cart", "callee_service": "db", "calls": "560"})
+		f.On("span_id, operation, duration_ms",
+			map[string]any{"trace_id": "t-slow", "span_id": "s1", "operation": "GET /cart", "duration_ms": 900, "status": "ok", "start_ts": "2026-01-01 12:00:00.000"})
+		f.On("delta_p95_ms", map[string]any{"operation": "GET /cart", "base_p95_ms": 40, "cand_p95_ms": 80, "delta_p95_ms": 40, "base_calls": "1000", "cand_calls": "1100", "_total": 3})
+		f.On("groupArraySample",
+			map[string]any{"operation": "GET /cart", "is_cand": 0, "samples": durations(30, 20, 2)},
+			map[string]any{"operation": "GET /cart", "is_cand": 1, "samples": durations(30, 40, 3)})
+		f.On("GROUP BY version", map[string]any{"version": "v1", "spans": "1000", "p50_ms": 20, "p95_ms": 40, "p99_ms": 60, "error_rate": 0.01, "timeout_rate": 0, "cancel_rate": 0})
+	}},
+	{name: "compare_missing_service", url: "/v1/compare?" + testRange},
+	{name: "compare_versions", url: "/v1/compare?" + testRange + "&service=cart&versions=v1,v2,v3", setup: func(f *clickhousetest.Fake) {
+		f.On("spread_p95_ms",
+			map[string]any{"operation": "GET /cart", "op_versions": []any{"v1", "v2", "v3"}, "op_p95_ms": []any{40, 80, 45}, "op_calls": []any{"1000", "400", "380"}, "spread_p95_ms": 40, "_total": 2},
+			map[string]any{"operation": "POST /cart", "op_versions": []any{"v3", "v1", "v2"}, "op_p95_ms": []any{30, 25, 26}, "op_calls": []any{"90", "300", "120"}, "spread_p95_ms": 5, "_total": 2})
+		f.On("GROUP BY version",
+			map[string]any{"version": "v2", "spans": "400", "p50_ms": 30, "p95_ms": 80, "p99_ms": 120, "error_rate": 0.03, "timeout_rate": 0.01, "cancel_rate": 0},
+			map[string]any{"version": "v1", "spans": "1300", "p50_ms": 20, "p95_ms": 40, "p99_ms": 60, "error_rate": 0.01, "timeout_rate": 0, "cancel_rate": 0})
+	}},
+	{name: "compare_versions_too_few", url: "/v1/compare?" + testRange + "&service=cart&versions=v1,v1"},
+	{name: "compare_auto", url: "/v1/compare/auto?service=cart", setup: func(f *clickhousetest.Fake) {
+		f.On("compare_auto", map[string]any{"env": "prod", "service": "cart", "base_version": "v1", "cand_version": "v2", "deployed_at": "2026-01-01 10:00:00", "evaluated_at": "2026-01-01 10:30:00.000", "soak_seconds": 1800, "verdict": "ok", "silenced": 0, "maintenance_id": "", "base_calls": "10", "cand_calls": "12", "base_p95": 40, "cand_p95": 41, "base_error_rate": 0, "cand_error_rate": 0, "result": `{"anomalies":[]}`, "_total": 1})
+	}},
+	{name: "verify", url: "/v1/verify?service=cart&version=v2&env=prod&from=2026-01-01T23:00:00Z&to=2026-01-02T00:00:00Z&p95_delta_pct=50", setup: func(f *clickhousetest.Fake) {
+		f.On("service_versions_minute", map[string]any{"version": "v1", "calls": "5000", "last_seen": "2026-01-01 22:59:00"})
+		f.On("cand_errors", map[string]any{"operation": "POST /cart", "error_type": "TimeoutError", "base_errors": "0", "cand_errors": "4", "_total": 1})
+		f.On("base_calls", map[string]any{"base_calls": "900", "cand_calls": "300", "base_p95_ms": 40, "cand_p95_ms": 52, "base_error_rate": 0.01, "cand_error_rate": 0.015})
+	}},
+	{name: "verify_no_baseline", url: "/v1/verify?service=cart&version=v2&from=2026-01-01T23:00:00Z"},
+	{name: "verify_bad_threshold", url: "/v1/verify?service=cart&version=v2&from=2026-01-01T23:00:00Z&min_calls=-1"},
+	{name: "verify_missing_version", url: "/v1/verify?service=cart"},
+	{name: "errors", url: "/v1/errors?" + testRange + "&service=cart&base=v1&cand=v2", setup: func(f *clickhousetest.Fake) {
+		f.On("cand_errors", map[string]any{"service": "cart", "operation": "POST /pay", "base_errors": 0, "cand_errors": 7})
+		f.On("dependency_edges_minute", map[string]any{"caller_service": "gateway", "callee_service": "cart", "error_calls": 9, "timeout_calls": 2, "calls": 100, "error_rate": 0.09})
+		f.On("HAVING errors > 0", map[string]any{"service": "cart", "operation": "POST /pay", "errors": 7, "calls": 70, "error_rate": 0.1})
+		f.On("GROUP BY service", map[string]any{"service": "cart", "errors": 9, "calls": 300, "error_rate": 0.03})
+	}},
+	{name: "services_queue", url: "/v1/services/queue?" + testRange + "&env=prod", setup: func(f *clickhousetest.Fake) {
+		f.On("queue_share",
+			map[string]any{"service": "orders", "spans": "2000", "queued_spans": "1500", "queued_rate": 0.75, "avg_queue_ms": 42.5, "p95_queue_ms": 180, "max_queue_ms": 900, "queue_share": 0.38, "exec_share": 0.22, "downstream_share": 0.4},
+			map[string]any{"service": "cart", "spans": "1000", "queued_spans": "0", "queued_rate": 0, "avg_queue_ms": 0, "p95_queue_ms": 0, "max_queue_ms": 0, "queue_share": 0, "exec_share": 0.6, "downstream_share": 0.4})
+	}},
+	{name: "services_missing", url: "/v1/services/missing?minutes=30&env=prod", setup: func(f *clickhousetest.Fake) {
+		f.On("UNION ALL", map[string]any{"service": "billing", "env": "prod", "last_seen": "2026-01-01 22:00:00.000", "last_heartbeat": "2026-01-01 22:00:00.000", "last_log": "1970-01-01 00:00:00.000", "silent_seconds": "7200"})
+	}},
+	{name: "service_versions", url: "/v1/services/cart/versions?" + testRange, setup: func(f *clickhousetest.Fake) {
+		f.On("PARTITION BY bucket", map[string]any{"bucket": "2026-01-01 00:00:00", "version": "v2", "calls": "60", "errors": "1", "share": 0.6})
+		f.On("first_seen", map[string]any{"version": "v2", "calls": "60", "errors": "1", "error_rate": 0.0167, "share": 0.6, "first_seen": "2026-01-01 00:00:00", "last_seen": "2026-01-01 23:00:00"})
+	}},
+	{name: "service_versions_unknown", url: "/v1/services/cart/owners"},
+	{name: "alerts", url: "/v1/alerts?" + testRange + "&service=cart", setup: func(f *clickhousetest.Fake) {
+		f.On("HAVING status = 'firing'", map[string]any{"fingerprint": "ab12", "rule": "cart-p95", "service": "cart", "env": "prod", "metric": "p95_ms", "op": ">", "threshold": 500, "status": "firing", "value": 640, "silenced": 0, "starts_at": "2026-01-01 12:00:00.000", "last_change": "2026-01-01 12:00:00.000"})
+		f.On("alert_events", map[string]any{"fingerprint": "ab12", "rule": "cart-p95", "service": "cart", "env": "prod", "metric": "p95_ms", "op": ">", "threshold": 500, "status": "firing", "value": 640, "silenced": 0, "starts_at": "2026-01-01 12:00:00.000", "at": "2026-01-01 12:00:00.000", "_total": 1})
+	}},
+	{name: "metrics_export", url: "/v1/metrics/export?window=5m&service=cart", setup: func(f *clickhousetest.Fake) {
+		f.On("quantile(0.99)", map[string]any{"env": "prod", "service": "cart", "p50_ms": 20, "p95_ms": 40, "p99_ms": 80})
+		f.On("service_versions_minute", map[string]any{"env": "prod", "service": "cart", "calls": "600", "errors": "6"})
+		f.On("dependency_edges_minute", map[string]any{"env": "prod", "caller_service": "gateway", "callee_service": "cart", "calls": "600", "error_calls": "6", "timeout_calls": "3"})
+	}},
+	{name: "metrics_export_bad_window", url: "/v1/metrics/export?window=5s"},
+	{name: "maintenance_list", url: "/v1/maintenance?state=active&service=cart", setup: func(f *clickhousetest.Fake) {
+		f.On("maintenance_windows", map[string]any{"id": "mw-1", "env": "", "service": "cart", "starts_at": "2026-01-01 20:00:00", "ends_at": "2026-01-02 02:00:00", "reason": "migration", "created_at": "2026-01-01 19:00:00.000", "updated_at": "2026-01-01 19:00:00.000"})
+	}},
+	{name: "maintenance_create", method: http.MethodPost, url: "/v1/maintenance", body: `{"service":"cart","duration":"2h","reason":"db failover"}`},
+	{name: "maintenance_create_invalid", method: http.MethodPost, url: "/v1/maintenance", body: `{"service":"cart","reason":"db failover"}`},
+	{name: "maintenance_expire", method: http.MethodDelete, url: "/v1/maintenance/mw-1", setup: func(f *clickhousetest.Fake) {
+		f.On("maintenance_windows", map[string]any{"id": "mw-1", "env": "", "service": "cart", "starts_at": "2026-01-01 20:00:00", "ends_at": "2026-01-02 02:00:00", "reason": "migration", "created_at": "2026-01-01 19:00:00.000", "updated_at": "2026-01-01 19:00:00.000"})
+	}},
+	{name: "maintenance_not_found", url: "/v1/maintenance/mw-2"},
+	{name: "lookup", url: "/v1/lookup?" + testRange + "&key=user.id&value=u-42&env=prod", setup: func(f *clickhousetest.Fake) {
+		f.On("attr_lookup", map[string]any{"trace_id": "t1", "first_seen": "2026-01-01 10:00:00.000", "services": []string{"gateway", "cart"}, "env": "prod", "root_service": "gateway", "transaction": "checkout", "start_ts": "2026-01-01 10:00:00.000", "duration_ms": 250, "span_count": 3, "error_count": 0})
+	}},
+	{name: "lookup_missing_value", url: "/v1/lookup?key=user.id"},
+	{name: "transactions", url: "/v1/transactions?" + testRange, setup: func(f *clickhousetest.Fake) {
+		f.On("per_minute", map[string]any{"transaction": "checkout", "traces": "1440", "error_traces": "14", "error_rate": 0.0097, "per_minute": 1, "avg_ms": 210, "p50_ms": 200, "p95_ms": 300, "p99_ms": 450, "max_services": 4, "root_services": []string{"gateway"}, "last_seen": "2026-01-01 23:59:00.000", "_total": 1})
+	}},
+	{name: "transaction_detail", url: "/v1/transactions/detail?" + testRange + "&name=checkout", setup: func(f *clickhousetest.Fake) {
+		f.On("avg_self_ms", map[string]any{"service": "cart", "spans": "1440", "errors": "3", "avg_ms": 80, "p95_ms": 120, "avg_self_ms": 60})
+		f.On("bucket_ts", map[string]any{"bucket_ts": "2026-01-01 00:00:00", "traces": "60", "error_traces": "1", "p50_ms": 200, "p95_ms": 300})
+		f.On("ORDER BY duration_ms DESC", trace("t1", 900))
+	}},
+	{name: "usage", url: "/v1/usage?from=2026-01-01T00:00:00Z&to=2026-01-01T12:00:00Z&env=prod&limit=2", setup: func(f *clickhousetest.Fake) {
+		f.On("bytes_share",
+			map[string]any{"env": "prod", "service": "checkout", "events": "120000", "bytes": "96000000", "spans": "40000", "traces": "9000", "prev_bytes": "24000000", "bytes_share": 0.8, "bytes_change_pct": 300, "_total": 3},
+			map[string]any{"env": "prod", "service": "cart", "events": "30000", "bytes": "18000000", "spans": "30000", "traces": "0", "prev_bytes": "17000000", "bytes_share": 0.15, "bytes_change_pct": 5.88, "_total": 3})
+	}},
+	{name: "usage_daily", url: "/v1/usage/daily?" + testRange + "&service=checkout", setup: func(f *clickhousetest.Fake) {
+		f.On("usage_daily",
+			map[string]any{"day": "2026-01-01", "events": "120000", "bytes": "96000000", "spans": "40000", "traces": "9000"},
+			map[string]any{"day": "2026-01-02", "events": "2000", "bytes": "1500000", "spans": "700", "traces": "150"})
+	}},
+	{name: "admin_storage", url: "/v1/admin/storage", setup: func(f *clickhousetest.Fake) {
+		f.On("system.tables",
+			map[string]any{"table": "raw_logs", "engine": "MergeTree", "ttl": "toDateTime(ts) + toIntervalDay(30)"},
+			map[string]any{"table": "spans", "engine": "ReplacingMergeTree", "ttl": "toDateTime(start_ts) + toIntervalDay(90)"},
+			map[string]any{"table": "spans_mv", "engine": "View", "ttl": ""})
+		f.On("system.parts",
+			map[string]any{"table": "spans", "parts": "40", "partitions": "20", "max_parts_per_partition": "6", "rows": "9000000", "bytes_on_disk": "800000000", "compressed_bytes": "790000000", "uncompressed_bytes": "4000000000", "compression_ratio": 5.06, "oldest_day": "2025-12-13", "newest_day": "2026-01-01", "ttl_expired_parts": "0"},
+			map[string]any{"table": "raw_logs", "parts": "95", "partitions": "30", "max_parts_per_partition": "12", "rows": "30000000", "bytes_on_disk": "2500000000", "compressed_bytes": "2480000000", "uncompressed_bytes": "20000000000", "compression_ratio": 8.06, "oldest_day": "2025-12-03", "newest_day": "2026-01-01", "ttl_expired_parts": "2"})
+		f.On("system.mutations", map[string]any{"table": "raw_logs", "pending_mutations": "1"})
+	}},
+	{name: "admin_slow_queries", url: "/v1/admin/slow-queries"},
+	{name: "admin_slow_queries_route", url: "/v1/admin/slow-queries?route=/v1/traces"},
+	{name: "query_failure", url: "/v1/hosts?" + testRange, headers: map[string]string{"X-Request-ID": "req-1"}, setup: func(f *clickhousetest.Fake) {
+		f.Fail("host_stats_minute", errors.New("query failed: 500 (Code: 202. TOO_MANY_SIMULTANEOUS_QUERIES)"))
+	}},
+}
+
+func durations(n int, start, step float64) []float64 {
+	out := make([]float64, n)
+	for i := range out {
+		out[i] = start + float64(i)*step
+	}
+	return out
+}
+
+func newTestHandler(t *testing.T, f *clickhousetest.Fake) *Handler {
+	t.Helper()
+	key, err := fieldcrypt.New("k1", bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := New(f, config.Config{
+		Limits:             map[string]config.Limit{},
+		AutoCompareSoak:    30 * time.Minute,
+		HealthWeights:      map[string]float64{"error": 0.5, "latency": 0.3, "saturation": 0.2},
+		HealthThresholds:   map[string]float64{"green": 90, "yellow": 70},
+		HealthBaseline:     24 * time.Hour,
+		VerifyThresholds:   map[string]float64{"p95_delta_pct": 20, "error_rate_delta": 0.01, "new_error_groups": 0, "min_calls": 50},
+		SlowQueryThreshold: time.Second,
+		Encryption:         key,
+		EncryptAttrs:       []string{"user.id"},
+		EnvGroups:          map[string][]string{"production": {"prod-eu", "prod-us"}},
+	})
+	h.now = func() time.Time { return testNow }
+	for _, s := range []clickhouse.QueryStat{
+		{At: testNow.Add(-3 * time.Minute), Route: "/v1/traces", RequestID: "req-1", QueryID: "tracelite-api-5d1c2e7a90b34f18a6e0c3d2", DurationMs: 1840.5, ElapsedMs: 1822, ReadRows: 52000000, ReadBytes: 4100000000, ResultRows: 200, SQL: "SELECT trace_id FROM traces WHERE start_ts >= {p1:DateTime64(3)}"},
+		{At: testNow.Add(-2 * time.Minute), Route: "/v1/traces", RequestID: "req-2", QueryID: "tracelite-api-9b04f6e1c27d3a85e4f1b609", DurationMs: 120, ElapsedMs: 110, ReadRows: 90000, ReadBytes: 7000000, ResultRows: 200, SQL: "SELECT trace_id FROM traces WHERE start_ts >= {p1:DateTime64(3)}"},
+		{At: testNow.Add(-time.Minute), Route: "/v1/hosts", RequestID: "req-3", QueryID: "tracelite-api-e27a3c90d14b5f6678a2c01e", DurationMs: 20000, Error: "deadline_exceeded", SQL: "SELECT host FROM host_stats_minute"},
+		{At: testNow.Add(-time.Minute), QueryID: "tracelite-api-0f0f", DurationMs: 45, ReadRows: 1200, ReadBytes: 96000, ResultRows: 3, SQL: "SELECT version FROM service_versions_minute"},
+	} {
+		h.queries.Record(s)
+	}
+	return h
+}
+
+func testMux(h *Handler) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livez", h.Livez)
+	mux.HandleFunc("/readyz", h.Readyz)
+	mux.HandleFunc("/v1/traces", h.Traces)
+	mux.HandleFunc("/v1/traces/", h.TraceByID)
+	mux.HandleFunc("/v1/traces/clusters", h.TraceClusters)
+	mux.HandleFunc("/v1/dependency", h.Dependency)
+	mux.HandleFunc("/v1/dependency/diff", h.DependencyDiff)
+	mux.HandleFunc("/v1/dependency/changes", h.DependencyChanges)
+	mux.HandleFunc("/v1/hosts", h.Hosts)
+	mux.HandleFunc("/v1/envs", h.Envs)
+	mux.HandleFunc("/v1/compare", h.Compare)
+	mux.HandleFunc("/v1/compare/auto", h.AutoCompare)
+	mux.HandleFunc("/v1/verify", h.Verify)
+	mux.HandleFunc("/v1/errors", h.Errors)
+	mux.HandleFunc("/v1/services/missing", h.ServicesMissing)
+	mux.HandleFunc("/v1/services/queue", h.ServicesQueue)
+	mux.HandleFunc("/v1/services/", h.ServiceVersions)
+	mux.HandleFunc("/v1/alerts", h.Alerts)
+	mux.HandleFunc("/v1/metrics/export", h.MetricsExport)
+	mux.HandleFunc("/v1/maintenance", h.Maintenance)
+	mux.HandleFunc("/v1/maintenance/", h.MaintenanceByID)
+	mux.HandleFunc("/v1/lookup", h.Lookup)
+	mux.HandleFunc("/v1/transactions", h.Transactions)
+	mux.HandleFunc("/v1/transactions/detail", h.TransactionDetail)
+	mux.HandleFunc("/v1/usage", h.Usage)
+	mux.HandleFunc("/v1/usage/daily", h.UsageDaily)
+	mux.HandleFunc("/v1/admin/storage", h.AdminStorage)
+	mux.HandleFunc("/v1/admin/slow-queries", h.AdminSlowQueries)
+	return mux
+}
+
+var volatile = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`"latency_ms": [0-9.e-]+`), `"latency_ms": 0`},
+	{regexp.MustCompile(`mw-[0-9a-f]{16}`), "mw-<id>"},
+	{regexp.MustCompile(`"since": "[^"]+"`), `"since": "<since>"`},
+}
+
+func TestEndpointsGolden(t *testing.T) {
+	for _, tc := range endpointCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := clickhousetest.New()
+			if tc.setup != nil {
+				tc.setup(f)
+			}
+			h := newTestHandler(t, f)
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tc.url, strings.NewReader(tc.body))
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			w.Header().Set("X-Request-ID", req.Header.Get("X-Request-ID"))
+			testMux(h).ServeHTTP(w, req)
+			checkGolden(t, tc.name, renderExchange(method+" "+tc.url, f, w))
+		})
+	}
+}
+
+func renderExchange(request string, f *clickhousetest.Fake, w *httptest.ResponseRecorder) string {
+	var b strings.Builder
+	b.WriteString(request + "\n")
+	for i, c := range f.Calls() {
+		fmt.Fprintf(&b, "\n-- query %d\n%s\n", i+1, c.SQL)
+		names := make([]string, 0, len(c.Params))
+		for name := range c.Params {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			a, _ := strconv.Atoi(strings.TrimPrefix(names[i], "p"))
+			b, _ := strconv.Atoi(strings.TrimPrefix(names[j], "p"))
+			return a < b
+		})
+		for _, name := range names {
+			fmt.Fprintf(&b, "-- %s = %s\n", name, c.Params[name])
+		}
+	}
+	for _, ins := range f.Inserts() {
+		fmt.Fprintf(&b, "\n-- insert into %s\n", ins.Table)
+		for _, row := range ins.Rows {
+			line, _ := json.Marshal(row)
+			b.Write(line)
+			b.WriteByte('\n')
+		}
+	}
+	body := w.Body.Bytes()
+	if strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		var out bytes.Buffer
+		if json.Indent(&out, bytes.TrimSpace(body), "", "  ") == nil {
+			body = append(out.Bytes(), '\n')
+		}
+	}
+	fmt.Fprintf(&b, "\n-- response %d %s\n%s", w.Code, w.Header().Get("Content-Type"), body)
+	s := b.String()
+	for _, v := range volatile {
+		s = v.re.ReplaceAllString(s, v.repl)
+	}
+	return s
+}
+
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.MkdirAll("testdata", 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs from the golden file (run go test -update and review the diff):\n%s", path, firstDiff(string(want), got))
+	}
+}
+
+func firstDiff(want, got string) string {
+	wl, gl := strings.Split(want, "\n"), strings.Split(got, "\n")
+	for i := 0; i < len(wl) || i < len(gl); i++ {
+		var w, g string
+		if i < len(wl) {
+			w = wl[i]
+		}
+		if i < len(gl) {
+			g = gl[i]
+		}
+		if w != g {
+			return fmt.Sprintf("line %d\nwant: %s\ngot:  %s", i+1, w, g)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"trace-lite/api/internal/query"
+)
+
+type healthConfig struct {
+	weights    map[string]float64
+	thresholds map[string]float64
+	baseline   time.Duration
+}
+
+type healthStats struct {
+	calls      float64
+	errorCalls float64
+	p95        float64
+	baseP95    float64
+	perMinute  float64
+	basePeak   float64
+}
+
+func (c healthConfig) score(s healthStats) map[string]any {
+	parts := map[string]any{"error": nil, "latency": nil, "saturation": nil}
+	var total, weight float64
+	add := func(name string, v float64) {
+		v = math.Max(0, math.Min(1, v))
+		parts[name] = round(v, 3)
+		total += c.weights[name] * v
+		weight += c.weights[name]
+	}
+	if s.calls > 0 {
+		add("error", 1-(s.errorCalls/s.calls)/0.10)
+	}
+	if s.p95 > 0 && s.baseP95 > 0 {
+		add("latency", 2-s.p95/s.baseP95)
+	}
+	if s.basePeak > 0 {
+		add("saturation", (1.2-s.perMinute/s.basePeak)/0.4)
+	}
+	out := map[string]any{"score": nil, "status": "unknown", "components": parts}
+	if weight == 0 {
+		return out
+	}
+	score := 100 * total / weight
+	out["score"] = round(score, 1)
+	switch {
+	case score >= c.thresholds["green"]:
+		out["status"] = "green"
+	case score >= c.thresholds["yellow"]:
+		out["status"] = "yellow"
+	default:
+		out["status"] = "red"
+	}
+	return out
+}
+
+func (h *Handler) edgeHealth(ctx context.Context, edges []map[string]any, groupBy string, from, to time.Time, env string) error {
+	cols := []string{"caller_service", "callee_service"}
+	if groupBy != "" {
+		cols = append(cols, strings.Split(groupBy, ", ")...)
+	}
+	key := func(row map[string]any) string {
+		parts := make([]string, len(cols))
+		for i, c := range cols {
+			parts[i] = toString(row[c])
+		}
+		return strings.Join(parts, "\x00")
+	}
+	keys := strings.Join(cols, ", ")
+	perMinute := query.New().
+		Select(keys, "bucket_ts", "sum(calls) AS minute_calls", "avg(p95_ms) AS p95").
+		From("dependency_edges_minute").
+		MinuteRange("bucket_ts", from.Add(-h.health.baseline), from).
+		FilterIn("env", h.envs(env)).
+		GroupBy(keys, "bucket_ts")
+	rows, err := h.run(ctx, perMinute.Sub().
+		Select(keys, "round(avg(p95), 2) AS base_p95_ms", "max(minute_calls) AS base_peak").
+		FromQuery(perMinute, "").
+		GroupBy(keys))
+	if err != nil {
+		return err
+	}
+	base := make(map[string]map[string]any, len(rows))
+	for _, row := range rows {
+		base[key(row)] = row
+	}
+
+	minutes := math.Max(1, to.Sub(from).Minutes())
+	for _, e := range edges {
+		b := base[key(e)]
+		e["health"] = h.health.score(healthStats{
+			calls:      toFloat(e["calls"]),
+			errorCalls: toFloat(e["error_calls"]),
+			p95:        toFloat(e["p95_ms"]),
+			baseP95:    toFloat(b["base_p95_ms"]),
+			perMinute:  toFloat(e["calls"]) / minutes,
+			basePeak:   toFloat(b["base_peak"]),
+		})
+	}
+	return nil
+}
+
+func (h *Handler) serviceHealth(ctx context.Context, from, to time.Time, env string) ([]map[string]any, error) {
+	q := query.New()
+	window := func(table string, columns ...string) *query.Query {
+		return q.Sub().
+			Select(columns...).
+			Select("bucket_ts >= "+q.Minute(from)+" AS cur").
+			From(table).
+			MinuteRange("bucket_ts", from.Add(-h.health.baseline), to).
+			FilterIn("env", h.envs(env))
+	}
+	perMinute := window("service_versions_minute", "service").
+		Select("sum(calls) AS minute_calls", "sum(errors) AS minute_errors").
+		GroupBy("service", "bucket_ts")
+	rows, err := h.run(ctx, q.
+		Select("service",
+			"sumIf(minute_calls, cur) AS calls",
+			"sumIf(minute_errors, cur) AS error_calls",
+			"maxIf(minute_calls, NOT cur) AS base_peak").
+		FromQuery(perMinute, "").
+		GroupBy("service").
+		Having("calls > 0"))
+	if err != nil {
+		return nil, err
+	}
+	latency, err := h.run(ctx, q.Sub().
+		Select("callee_service AS service",
+			"round(sumIf(p95_ms * calls, cur) / greatest(sumIf(calls, cur), 1), 2) AS p95_ms",
+			"round(sumIf(p95_ms * calls, NOT cur) / greatest(sumIf(calls, NOT cur), 1), 2) AS base_p95_ms").
+		FromQuery(window("dependency_edges_minute", "callee_service, calls, p95_ms"), "").
+		GroupBy("service"))
+	if err != nil {
+		return nil, err
+	}
+	bySvc := make(map[string]map[string]any, len(latency))
+	for _, row := range latency {
+		bySvc[toString(row["service"])] = row
+	}
+
+	minutes := math.Max(1, to.Sub(from).Minutes())
+	nodes := make([]map[string]any, 0, len(rows))
+	for _, row := range rows {
+		l := bySvc[toString(row["service"])]
+		calls, errs := toFloat(row["calls"]), toFloat(row["error_calls"])
+		node := map[string]any{
+			"service":     row["service"],
+			"calls":       calls,
+			"error_calls": errs,
+			"error_rate":  round(ratio(errs, calls), 4),
+			"p95_ms":      nil,
+		}
+		if l != nil && toFloat(l["p95_ms"]) > 0 {
+			node["p95_ms"] = toFloat(l["p95_ms"])
+		}
+		node["health"] = h.health.score(healthStats{
+			calls:      calls,
+			errorCalls: errs,
+			p95:        toFloat(l["p95_ms"]),
+			baseP95:    toFloat(l["base_p95_ms"]),
+			perMinute:  calls / minutes,
+			basePeak:   toFloat(row["base_peak"]),
+		})
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return toString(nodes[i]["service"]) < toString(nodes[j]["service"]) })
+	return nodes, nil
+}
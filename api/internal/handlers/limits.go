@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"trace-lite/api/internal/config"
+)
+
+func (h *Handler) limitFor(r *http.Request, name, param string) int {
+	l, ok := h.limits[name]
+	if !ok {
+		l = config.Limit{Default: 200, Max: 5000}
+	}
+	v, err := strconv.Atoi(r.URL.Query().Get(param))
+	if err != nil || v <= 0 {
+		return l.Default
+	}
+	if v > l.Max {
+		return l.Max
+	}
+	return v
+}
+
+func splitTotal(rows []map[string]any, limit int) ([]map[string]any, map[string]any) {
+	total := int64(len(rows))
+	for _, row := range rows {
+		if v, ok := row["_total"]; ok {
+			total = int64(toFloat(v))
+		}
+		delete(row, "_total")
+	}
+	return rows, map[string]any{
+		"limit":     limit,
+		"total":     total,
+		"truncated": total > int64(len(rows)),
+	}
+}
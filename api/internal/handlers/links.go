@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"trace-lite/api/internal/query"
+)
+
+const maxLinkedTraces = 200
+
+func wantLinks(r *http.Request) (bool, int) {
+	on, _ := strconv.ParseBool(r.URL.Query().Get("links"))
+	if !on {
+		return false, 0
+	}
+	depth := 1
+	if raw := r.URL.Query().Get("link_depth"); raw != "" {
+		if v, err := strconv.Atoi(raw); err == nil && v > 0 {
+			depth = v
+		}
+	}
+	if depth > 5 {
+		depth = 5
+	}
+	return true, depth
+}
+
+func (h *Handler) traceLinks(ctx context.Context, traceID string, depth int) ([]map[string]any, []map[string]any, error) {
+	seen := map[string]struct{}{traceID: {}}
+	frontier := []string{traceID}
+	links := make([]map[string]any, 0)
+	var linked []string
+
+	for level := 1; level <= depth && len(frontier) > 0; level++ {
+		q := query.New()
+		ids := q.Strings(frontier)
+		rows, err := h.run(ctx, q.
+			Select("trace_id, linked_trace_id, link_type, service, env, span_id, min(ts) AS ts").
+			From("trace_links").
+			Where(fmt.Sprintf("(trace_id IN (%s) OR linked_trace_id IN (%s))", ids, ids)).
+			GroupBy("trace_id, linked_trace_id, link_type, service, env, span_id").
+			OrderBy("ts ASC").
+			Limit(maxLinkedTraces))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var next []string
+		for _, row := range rows {
+			from := toString(row["trace_id"])
+			to := toString(row["linked_trace_id"])
+			row["depth"] = level
+			links = append(links, row)
+			for _, id := range []string{from, to} {
+				if _, ok := seen[id]; ok || sanitize(id) == "" {
+					continue
+				}
+				seen[id] = struct{}{}
+				next = append(next, id)
+				linked = append(linked, id)
+			}
+		}
+		if len(linked) >= maxLinkedTraces {
+			break
+		}
+		frontier = next
+	}
+
+	traces := make([]map[string]any, 0)
+	if len(linked) == 0 {
+		return links, traces, nil
+	}
+	rows, err := h.run(ctx, query.New().
+		Select(traceColumns).
+		From(h.tracesTable).
+		In("trace_id", linked).
+		OrderBy("updated_at DESC").
+		LimitBy(1, "trace_id"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return links, append(traces, rows...), nil
+}
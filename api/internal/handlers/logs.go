@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"trace-lite/api/internal/fieldcrypt"
+	"trace-lite/api/internal/query"
+)
+
+const maskedValue = "[encrypted]"
+
+func (h *Handler) traceLogs(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
+		return
+	}
+	decrypt := r.URL.Query().Get("decrypt") == "true"
+	if decrypt && !h.canDecrypt(r) {
+		WriteError(w, http.StatusForbidden, "forbidden", "decrypt requires the decrypt bearer token", nil)
+		return
+	}
+	limit := h.limitFor(r, "logs", "limit")
+	rows, err := h.run(r.Context(), query.New().
+		Select("ts, service, env, host, version, level, message, span_id, parent_span_id, event, route, method, status_code, duration_ms, attrs, raw_json", "count() OVER () AS _total").
+		From("raw_logs").
+		Eq("trace_id", id).
+		OrderBy("ts ASC").
+		Limit(limit))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	rows, page := splitTotal(rows, limit)
+	failed := 0
+	for _, row := range rows {
+		if attrs, ok := row["attrs"].(map[string]any); ok {
+			for k, v := range attrs {
+				attrs[k] = h.revealValue(k, toString(v), decrypt, &failed)
+			}
+		}
+		row["raw_json"] = h.revealValue("raw_json", toString(row["raw_json"]), decrypt, &failed)
+	}
+	if failed > 0 {
+		log.Printf("trace %s: %d encrypted values could not be decrypted", id, failed)
+	}
+	page["trace_id"] = id
+	page["logs"] = rows
+	page["decrypted"] = decrypt
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (h *Handler) revealValue(field, v string, decrypt bool, failed *int) string {
+	if !fieldcrypt.IsSealed(v) {
+		return v
+	}
+	if !decrypt {
+		return maskedValue
+	}
+	plain, err := h.crypt.Open(field, v)
+	if err != nil {
+		*failed++
+		return maskedValue
+	}
+	return plain
+}
+
+func (h *Handler) valueSet(key, value string) []string {
+	list := []string{value}
+	if h.encrypted[key] {
+		list = append(list, h.crypt.SealAll(key, value)...)
+	}
+	return list
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"trace-lite/api/internal/query"
+)
+
+func (h *Handler) Lookup(w http.ResponseWriter, r *http.Request) {
+	key := sanitize(r.URL.Query().Get("key"))
+	value := strings.TrimSpace(r.URL.Query().Get("value"))
+	if key == "" || value == "" || len(value) > 256 {
+		WriteError(w, http.StatusBadRequest, "invalid_request", "key and value are required", nil)
+		return
+	}
+	from, to := h.parseRange(r)
+	limit := h.limitFor(r, "lookup", "limit")
+	env := sanitize(r.URL.Query().Get("env"))
+
+	filter := func(q *query.Query) *query.Query {
+		return q.From("attr_lookup").
+			Eq("key", key).
+			In("value", h.valueSet(key, value)).
+			TimeRange("ts", from, to).
+			FilterIn("env", h.envs(env))
+	}
+	q := query.New()
+	matches := filter(q.Sub()).
+		Select("trace_id", "min(ts) AS first_seen", "groupUniqArray(service) AS services").
+		GroupBy("trace_id").
+		OrderBy("first_seen DESC").
+		Limit(limit)
+	traces := q.Sub().
+		Select("trace_id, env, root_service, root_operation, root_status_code, transaction, start_ts, duration_ms, span_count, error_count").
+		From(h.tracesTable).
+		InQuery("trace_id", filter(q.Sub()).Select("trace_id")).
+		OrderBy("updated_at DESC").
+		LimitBy(1, "trace_id")
+	parsePlace(r).traces(traces)
+
+	d, err := h.run(r.Context(), q.
+		Select("l.trace_id AS trace_id",
+			"l.first_seen AS first_seen",
+			"l.services AS services",
+			"t.env AS env",
+			"t.root_service AS root_service",
+			"t.transaction AS transaction",
+			"t.start_ts AS start_ts",
+			"t.duration_ms AS duration_ms",
+			"t.span_count AS span_count",
+			"t.error_count AS error_count").
+		FromQuery(matches, "l").
+		Join("LEFT", traces, "t", "t.trace_id = l.trace_id").
+		OrderBy("first_seen DESC"))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"key": key, "value": value, "traces": d})
+}
@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"trace-lite/api/internal/query"
+)
+
+const maxMaintenance = 30 * 24 * time.Hour
+
+const maintenanceColumns = "id, env, service, starts_at, ends_at, reason, created_at, updated_at"
+
+type maintenanceWindow struct {
+	ID        string `json:"id"`
+	Env       string `json:"env"`
+	Service   string `json:"service"`
+	StartsAt  string `json:"starts_at"`
+	EndsAt    string `json:"ends_at"`
+	Reason    string `json:"reason"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+func (h *Handler) SetAdminToken(token string) {
+	h.adminMu.Lock()
+	defer h.adminMu.Unlock()
+	h.adminToken = token
+}
+
+func (h *Handler) SetDecryptToken(token string) {
+	h.adminMu.Lock()
+	defer h.adminMu.Unlock()
+	h.decryptKey = token
+}
+
+func (h *Handler) canDecrypt(r *http.Request) bool {
+	h.adminMu.RLock()
+	token := h.decryptKey
+	h.adminMu.RUnlock()
+	if token == "" || h.crypt == nil {
+		return false
+	}
+	scheme, got, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	return ok && strings.EqualFold(scheme, "Bearer") && subtle.ConstantTimeCompare([]byte(strings.TrimSpace(got)), []byte(token)) == 1
+}
+
+func (h *Handler) authorizeWrite(w http.ResponseWriter, r *http.Request) bool {
+	h.adminMu.RLock()
+	token := h.adminToken
+	h.adminMu.RUnlock()
+	if token == "" {
+		return true
+	}
+	scheme, got, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(got)), []byte(token)) != 1 {
+		WriteError(w, http.StatusUnauthorized, "unauthorized", "missing or invalid bearer token", nil)
+		return false
+	}
+	return true
+}
+
+func (h *Handler) Maintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.listMaintenance(w, r)
+	case http.MethodPost:
+		if h.authorizeWrite(w, r) {
+			h.createMaintenance(w, r)
+		}
+	default:
+		WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
+	}
+}
+
+func (h *Handler) listMaintenance(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	now := h.now().UTC()
+	q := query.New()
+	switch params.Get("state") {
+	case "", "current":
+		q.Where("ends_at > " + q.Second(now))
+	case "active":
+		q.Where("starts_at <= "+q.Second(now), "ends_at > "+q.Second(now))
+	case "all":
+		q.Where("ends_at > " + q.Second(now.Add(-maxMaintenance)))
+	default:
+		WriteError(w, http.StatusBadRequest, "invalid_request", "state must be current, active or all", map[string]any{"allowed": []string{"current", "active", "all"}})
+		return
+	}
+	if service := sanitize(params.Get("service")); service != "" {
+		q.In("service", []string{"", service})
+	}
+	if env := sanitize(params.Get("env")); env != "" {
+		q.In("env", []string{"", env})
+	}
+	d, err := h.run(r.Context(), q.
+		Select(maintenanceColumns).
+		From("maintenance_windows FINAL").
+		OrderBy("starts_at DESC").
+		Limit(1000))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"windows": d})
+}
+
+func (h *Handler) createMaintenance(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Service  string `json:"service"`
+		Env      string `json:"env"`
+		Start    string `json:"start"`
+		End      string `json:"end"`
+		Duration string `json:"duration"`
+		Reason   string `json:"reason"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&body); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid_request", "body must be a JSON object", nil)
+		return
+	}
+	service, env := strings.TrimSpace(body.Service), strings.TrimSpace(body.Env)
+	if service == "*" {
+		service = ""
+	}
+	if (service != "" && sanitize(service) == "") || (env != "" && sanitize(env) == "") {
+		WriteError(w, http.StatusBadRequest, "invalid_request", "invalid service or env", nil)
+		return
+	}
+	reason := strings.TrimSpace(body.Reason)
+	if reason == "" || len(reason) > 1024 {
+		WriteError(w, http.StatusBadRequest, "invalid_request", "reason is required (max 1024 bytes)", nil)
+		return
+	}
+
+	now := h.now().UTC()
+	start := now
+	if body.Start != "" {
+		t, err := time.Parse(time.RFC3339, body.Start)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "invalid_request", "start must be RFC3339", nil)
+			return
+		}
+		start = t.UTC()
+	}
+	var end time.Time
+	switch {
+	case body.End != "" && body.Duration != "":
+		WriteError(w, http.StatusBadRequest, "invalid_request", "give end or duration, not both", nil)
+		return
+	case body.End != "":
+		t, err := time.Parse(time.RFC3339, body.End)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "invalid_request", "end must be RFC3339", nil)
+			return
+		}
+		end = t.UTC()
+	case body.Duration != "":
+		d, err := time.ParseDuration(body.Duration)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "invalid_request", "duration must be a Go duration such as 2h", nil)
+			return
+		}
+		end = start.Add(d)
+	default:
+		WriteError(w, http.StatusBadRequest, "invalid_request", "end or duration is required", nil)
+		return
+	}
+	if !end.After(start) || !end.After(now) || end.Sub(start) > maxMaintenance {
+		WriteError(w, http.StatusBadRequest, "invalid_request", "window must end in the future, after its start, and last at most 30 days", nil)
+		return
+	}
+
+	win := maintenanceWindow{
+		ID:        newWindowID(),
+		Env:       env,
+		Service:   service,
+		StartsAt:  chSecond(start),
+		EndsAt:    chSecond(end),
+		Reason:    reason,
+		CreatedAt: chTime(now),
+		UpdatedAt: chTime(now),
+	}
+	if err := h.ch.InsertJSONEachRow(r.Context(), "maintenance_windows", win); err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"window": win})
+}
+
+func (h *Handler) MaintenanceByID(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/maintenance/"), "/")
+	if id == "" || len(id) > 64 || sanitize(id) != id {
+		WriteError(w, http.StatusBadRequest, "invalid_request", "invalid window id", nil)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
+		return
+	}
+	if r.Method == http.MethodDelete && !h.authorizeWrite(w, r) {
+		return
+	}
+
+	rows, err := h.run(r.Context(), query.New().
+		Select(maintenanceColumns).
+		From("maintenance_windows FINAL").
+		Eq("id", id).
+		Limit(1))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	if len(rows) == 0 {
+		WriteError(w, http.StatusNotFound, "not_found", "maintenance window not found", nil)
+		return
+	}
+	win := maintenanceWindow{
+		ID:        toString(rows[0]["id"]),
+		Env:       toString(rows[0]["env"]),
+		Service:   toString(rows[0]["service"]),
+		StartsAt:  toString(rows[0]["starts_at"]),
+		EndsAt:    toString(rows[0]["ends_at"]),
+		Reason:    toString(rows[0]["reason"]),
+		CreatedAt: toString(rows[0]["created_at"]),
+		UpdatedAt: toString(rows[0]["updated_at"]),
+	}
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, map[string]any{"window": win})
+		return
+	}
+
+	now := h.now().UTC()
+	if parseCHTime(win.EndsAt).After(now) {
+		end := now
+		if start := parseCHTime(win.StartsAt); start.After(now) {
+			end = start
+		}
+		win.EndsAt = chSecond(end)
+		win.UpdatedAt = chTime(now)
+		if err := h.ch.InsertJSONEachRow(r.Context(), "maintenance_windows", win); err != nil {
+			writeQueryError(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"window": win, "expired": true})
+}
+
+func (h *Handler) maintenanceWindows(ctx context.Context, env, service string, from, to time.Time) ([]map[string]any, error) {
+	scope := []string{"", env}
+	if group := h.envGroup(env); group != "" {
+		scope = append(scope, group)
+	}
+	if members, ok := h.envGroups[env]; ok {
+		scope = append(scope, members...)
+	}
+	q := query.New()
+	return h.run(ctx, q.
+		Select("id, env, service, starts_at, ends_at, reason").
+		From("maintenance_windows FINAL").
+		Where("starts_at < "+q.Second(to), "ends_at > "+q.Second(from)).
+		In("service", []string{"", service}).
+		In("env", scope).
+		OrderBy("starts_at ASC"))
+}
+
+func silenceBadges(badges []map[string]any, windows []map[string]any) {
+	if len(windows) == 0 {
+		return
+	}
+	for _, b := range badges {
+		b["silenced"] = true
+		b["maintenance_id"] = toString(windows[0]["id"])
+	}
+}
+
+func newWindowID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "mw-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return "mw-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"trace-lite/api/internal/query"
+)
+
+const maxCompareVersions = 8
+
+func compareVersions(raw string) ([]string, bool) {
+	var out []string
+	seen := map[string]bool{}
+	for _, v := range strings.Split(raw, ",") {
+		v = sanitize(v)
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		out = append(out, v)
+	}
+	return out, len(out) >= 2 && len(out) <= maxCompareVersions
+}
+
+func (h *Handler) compareMany(w http.ResponseWriter, r *http.Request, q compareQuery, versions []string) {
+	from, to, env, service := q.from, q.to, q.env, q.service
+	opCols, deltaLimit := q.opCols, q.deltaLimit
+
+	spans := query.New()
+	traceIDs := spans.Sub().Select("trace_id").
+		TimeRange("start_ts", from, to).
+		Eq("root_service", service).
+		FilterIn("env", h.envs(env))
+	h.latestTraces(traceIDs, from, to)
+	spans.From(h.spansTable).
+		InQuery("trace_id", traceIDs).
+		In("version", versions).
+		Eq("service", service)
+	q.place.spans(spans)
+
+	metricRows, err := h.run(r.Context(), spans.Clone().
+		Select("version",
+			"count() AS spans",
+			"round(quantile(0.50)(duration_ms), 2) AS p50_ms",
+			"round(quantile(0.95)(duration_ms), 2) AS p95_ms",
+			"round(quantile(0.99)(duration_ms), 2) AS p99_ms",
+			"round(avg(is_error), 4) AS error_rate",
+			"round(avg(status = 'timeout'), 4) AS timeout_rate",
+			"round(avg(status = 'cancelled'), 4) AS cancel_rate").
+		GroupBy("version"))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	perOp := spans.Clone().
+		Select(opCols, "version",
+			"round(quantile(0.95)(duration_ms), 2) AS p95_ms",
+			"count() AS calls").
+		GroupBy(opCols, "version")
+	ops, err := h.run(r.Context(), spans.Sub().
+		Select(opCols,
+			"groupArray(version) AS op_versions",
+			"groupArray(p95_ms) AS op_p95_ms",
+			"groupArray(calls) AS op_calls",
+			"round(max(p95_ms) - min(p95_ms), 2) AS spread_p95_ms",
+			"count() OVER () AS _total").
+		FromQuery(perOp, "").
+		GroupBy(opCols).
+		Having(fmt.Sprintf("count() = %d", len(versions))).
+		OrderBy("spread_p95_ms DESC").
+		Limit(deltaLimit))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	ops, page := splitTotal(ops, deltaLimit)
+	windows, err := h.maintenanceWindows(r.Context(), env, service, from, to)
+	if err != nil {
+		log.Printf("maintenance windows for %s: %v", service, err)
+	}
+
+	byVersion := map[string]map[string]any{}
+	for _, row := range metricRows {
+		byVersion[toString(row["version"])] = row
+	}
+	metrics := make([]map[string]any, 0, len(versions))
+	for _, v := range versions {
+		row, ok := byVersion[v]
+		if !ok {
+			row = map[string]any{"version": v, "spans": 0, "p50_ms": 0, "p95_ms": 0, "p99_ms": 0, "error_rate": 0, "timeout_rate": 0, "cancel_rate": 0}
+		}
+		metrics = append(metrics, row)
+	}
+	pairs := make([]map[string]any, 0, len(versions)*(len(versions)-1)/2)
+	for i, base := range metrics {
+		for _, cand := range metrics[i+1:] {
+			pairs = append(pairs, map[string]any{
+				"base":               base["version"],
+				"cand":               cand["version"],
+				"base_spans":         base["spans"],
+				"cand_spans":         cand["spans"],
+				"p50_delta_ms":       round(toFloat(cand["p50_ms"])-toFloat(base["p50_ms"]), 2),
+				"p95_delta_ms":       round(toFloat(cand["p95_ms"])-toFloat(base["p95_ms"]), 2),
+				"p95_delta_pct":      round(pctDelta(toFloat(base["p95_ms"]), toFloat(cand["p95_ms"])), 2),
+				"p99_delta_ms":       round(toFloat(cand["p99_ms"])-toFloat(base["p99_ms"]), 2),
+				"error_rate_delta":   round(toFloat(cand["error_rate"])-toFloat(base["error_rate"]), 4),
+				"timeout_rate_delta": round(toFloat(cand["timeout_rate"])-toFloat(base["timeout_rate"]), 4),
+			})
+		}
+	}
+
+	cols := strings.Split(opCols, ", ")
+	operations := make([]map[string]any, 0, len(ops))
+	for _, row := range ops {
+		names, _ := row["op_versions"].([]any)
+		p95s, _ := row["op_p95_ms"].([]any)
+		calls, _ := row["op_calls"].([]any)
+		p95ByVersion := map[string]any{}
+		callsByVersion := map[string]any{}
+		for i, name := range names {
+			if i < len(p95s) && i < len(calls) {
+				p95ByVersion[toString(name)] = p95s[i]
+				callsByVersion[toString(name)] = calls[i]
+			}
+		}
+		op := map[string]any{"spread_p95_ms": row["spread_p95_ms"], "p95_ms": p95ByVersion, "calls": callsByVersion}
+		for _, c := range cols {
+			op[c] = row[c]
+		}
+		operations = append(operations, op)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"versions":             versions,
+		"metrics":              metrics,
+		"pairs":                pairs,
+		"operations":           operations,
+		"operations_total":     page["total"],
+		"operations_truncated": page["truncated"],
+		"maintenance":          windows,
+	})
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (h *Handler) RunOTLPExport(endpoint string, headers map[string]string, every time.Duration) {
+	if endpoint == "" {
+		return
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	window := every.Truncate(time.Minute)
+	if window < 5*time.Minute {
+		window = 5 * time.Minute
+	}
+	log.Printf("exporting derived metrics to %s every %s", endpoint, every)
+	for range time.Tick(every) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := h.exportOTLP(ctx, client, endpoint, headers, window)
+		cancel()
+		if err != nil {
+			log.Printf("otlp export: %v", err)
+		}
+	}
+}
+
+func (h *Handler) exportOTLP(ctx context.Context, client *http.Client, endpoint string, headers map[string]string, window time.Duration) error {
+	series, err := h.derivedSeries(ctx, window, "", "")
+	if err != nil {
+		return err
+	}
+	h.alertsMu.Lock()
+	firing := 0
+	for _, st := range h.alerts {
+		if st.firing {
+			firing++
+		}
+	}
+	h.alertsMu.Unlock()
+	series = append(series, promSeries{"tracelite_api_alerts_firing", "Alerts firing on this API instance.", nil, float64(firing)})
+
+	payload, err := json.Marshal(otlpMetrics(series, time.Now()))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/v1/metrics", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 2048))
+		return fmt.Errorf("%s (%s)", resp.Status, string(body))
+	}
+	return nil
+}
+
+func otlpMetrics(series []promSeries, now time.Time) map[string]any {
+	nowNano := strconv.FormatInt(now.UnixNano(), 10)
+	var metrics []map[string]any
+	byName := map[string]map[string]any{}
+	for _, s := range series {
+		m := byName[s.name]
+		if m == nil {
+			unit := "1"
+			switch {
+			case strings.HasSuffix(s.name, "_per_second"):
+				unit = "1/s"
+			case strings.HasSuffix(s.name, "_seconds"):
+				unit = "s"
+			}
+			m = map[string]any{"name": s.name, "description": s.help, "unit": unit, "gauge": map[string]any{"dataPoints": []map[string]any{}}}
+			byName[s.name] = m
+			metrics = append(metrics, m)
+		}
+		attrs := make([]map[string]any, 0, len(s.labels))
+		for _, l := range s.labels {
+			attrs = append(attrs, map[string]any{"key": l[0], "value": map[string]any{"stringValue": l[1]}})
+		}
+		gauge := m["gauge"].(map[string]any)
+		gauge["dataPoints"] = append(gauge["dataPoints"].([]map[string]any), map[string]any{
+			"attributes":   attrs,
+			"timeUnixNano": nowNano,
+			"asDouble":     s.value,
+		})
+	}
+	return map[string]any{
+		"resourceMetrics": []map[string]any{{
+			"resource": map[string]any{"attributes": []map[string]any{
+				{"key": "service.name", "value": map[string]any{"stringValue": "trace-lite-api"}},
+			}},
+			"scopeMetrics": []map[string]any{{
+				"scope":   map[string]any{"name": "trace-lite"},
+				"metrics": metrics,
+			}},
+		}},
+	}
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"net/http"
+
+	"trace-lite/api/internal/query"
+)
+
+func (h *Handler) ServicesQueue(w http.ResponseWriter, r *http.Request) {
+	from, to := h.parseRange(r)
+	env := sanitize(r.URL.Query().Get("env"))
+
+	q := query.New().
+		Select("service",
+			"count() AS spans",
+			"countIf(queue_ms > 0) AS queued_spans",
+			"round(queued_spans / spans, 4) AS queued_rate",
+			"round(avg(queue_ms), 2) AS avg_queue_ms",
+			"round(quantile(0.95)(queue_ms), 2) AS p95_queue_ms",
+			"max(queue_ms) AS max_queue_ms",
+			"round(if(sum(duration_ms) = 0, 0, sum(queue_ms) / sum(duration_ms)), 4) AS queue_share",
+			"round(if(sum(duration_ms) = 0, 0, sum(greatest(toInt64(self_time_ms) - queue_ms, 0)) / sum(duration_ms)), 4) AS exec_share",
+			"round(if(sum(duration_ms) = 0, 0, sum(greatest(toInt64(duration_ms) - self_time_ms, 0)) / sum(duration_ms)), 4) AS downstream_share").
+		From(h.spansTable).
+		TimeRange("start_ts", from, to).
+		FilterIn("env", h.envs(env)).
+		Filter("service", sanitize(r.URL.Query().Get("service"))).
+		GroupBy("service").
+		OrderBy("queue_share DESC", "service ASC")
+	parsePlace(r).spans(q)
+	d, err := h.run(r.Context(), q)
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"services": d})
+}
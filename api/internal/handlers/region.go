@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+
+	"trace-lite/api/internal/query"
+)
+
+type placeFilter struct {
+	region string
+	zone   string
+}
+
+func parsePlace(r *http.Request) placeFilter {
+	return placeFilter{region: sanitize(r.URL.Query().Get("region")), zone: sanitize(r.URL.Query().Get("zone"))}
+}
+
+func (p placeFilter) spans(q *query.Query) *query.Query {
+	return q.Filter("region", p.region).Filter("zone", p.zone)
+}
+
+func (p placeFilter) traces(q *query.Query) *query.Query {
+	if p.region != "" {
+		q.Where("has(regions, " + q.String(p.region) + ")")
+	}
+	if p.zone != "" {
+		q.Where("has(zones, " + q.String(p.zone) + ")")
+	}
+	return q
+}
+
+func (p placeFilter) edges(q *query.Query) *query.Query {
+	if p.region != "" {
+		q.EqAny(p.region, "caller_region", "callee_region")
+	}
+	if p.zone != "" {
+		q.EqAny(p.zone, "caller_zone", "callee_zone")
+	}
+	return q
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxRenderSpans = 500
+
+var renderFormats = []string{"svg", "txt"}
+
+type renderLimits struct {
+	def, min, max int
+}
+
+var renderWidths = map[string]renderLimits{
+	"svg": {def: 1000, min: 400, max: 3000},
+	"txt": {def: 120, min: 60, max: 400},
+}
+
+func (h *Handler) traceRender(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
+		return
+	}
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = "svg"
+	}
+	limits, ok := renderWidths[format]
+	if !ok {
+		WriteError(w, http.StatusBadRequest, "invalid_request", "unknown format "+format, map[string]any{"allowed": renderFormats})
+		return
+	}
+	width := limits.def
+	if raw := r.URL.Query().Get("width"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < limits.min || n > limits.max {
+			WriteError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("width must be between %d and %d for format %s", limits.min, limits.max, format), nil)
+			return
+		}
+		width = n
+	}
+
+	limit := h.limitFor(r, "spans", "span_limit")
+	traceRows, spanRows, truncated, err := h.loadTrace(r.Context(), id, limit)
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	if len(spanRows) == 0 {
+		WriteError(w, http.StatusNotFound, "not_found", "trace not found", nil)
+		return
+	}
+	drill := buildTraceDrilldown(spanRows, nil)
+	spans, _ := drill["waterfall"].([]map[string]any)
+	window, _ := drill["trace_window"].(map[string]any)
+	totalMs := toFloat(window["total_ms"])
+	title := renderTitle(id, firstOrNil(traceRows), spans)
+	if truncated {
+		title += fmt.Sprintf("  only the first %d spans loaded", limit)
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	if format == "txt" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(renderWaterfallText(title, spans, totalMs, width))
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(renderWaterfallSVG(title, spans, totalMs, width))
+}
+
+func renderTitle(id string, trace any, spans []map[string]any) string {
+	row, _ := trace.(map[string]any)
+	if row == nil {
+		return fmt.Sprintf("trace %s  %d spans", id, len(spans))
+	}
+	return fmt.Sprintf("trace %s  %s %s  %sms  %d spans  %d errors", id,
+		toString(row["root_service"]), toString(row["root_operation"]),
+		strconv.FormatFloat(toFloat(row["duration_ms"]), 'f', -1, 64), len(spans), int(toFloat(row["error_count"])))
+}
+
+func spanLabel(span map[string]any) string {
+	return toString(span["service"]) + " " + toString(span["operation"])
+}
+
+func renderWaterfallText(title string, spans []map[string]any, total float64, width int) []byte {
+	labelWidth := width * 2 / 5
+	const timeWidth = 9
+	cols := width - labelWidth - timeWidth - 2
+	shown, hidden := spans, 0
+	if len(shown) > maxRenderSpans {
+		shown, hidden = shown[:maxRenderSpans], len(shown)-maxRenderSpans
+	}
+
+	var b bytes.Buffer
+	b.WriteString(title + "\n\n")
+	axis := []rune(strings.Repeat(" ", cols+2))
+	writeAt := func(pos int, s string) {
+		for i, c := range s {
+			if pos+i >= 0 && pos+i < len(axis) {
+				axis[pos+i] = c
+			}
+		}
+	}
+	writeAt(0, "0ms")
+	mid := fmt.Sprintf("%gms", math.Round(total/2))
+	writeAt((cols+2)/2-len(mid)/2, mid)
+	end := fmt.Sprintf("%gms", total)
+	writeAt(cols+2-len(end), end)
+	b.WriteString(strings.Repeat(" ", labelWidth+timeWidth) + string(axis) + "\n")
+
+	for _, span := range shown {
+		indent := strings.Repeat("  ", int(toFloat(span["depth"])))
+		label := truncateRunes(indent+spanLabel(span), labelWidth-1)
+		fill := byte('=')
+		switch {
+		case span["is_error"] == true:
+			fill = '!'
+		case span["is_critical"] == true:
+			fill = '#'
+		}
+		start := int(toFloat(span["left_pct"]) / 100 * float64(cols))
+		n := int(math.Round(toFloat(span["width_pct"]) / 100 * float64(cols)))
+		start = max(0, min(start, cols-1))
+		n = max(1, min(n, cols-start))
+		bar := strings.Repeat(" ", start) + strings.Repeat(string(fill), n) + strings.Repeat(" ", cols-start-n)
+		fmt.Fprintf(&b, "%-*s%*s |%s|\n", labelWidth, label, timeWidth-1, fmt.Sprintf("%dms", toUint32(span["duration_ms"])), bar)
+	}
+	if hidden > 0 {
+		fmt.Fprintf(&b, "... %d more spans\n", hidden)
+	}
+	b.WriteString("\n# critical path   = other span   ! error\n")
+	return b.Bytes()
+}
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	r := []rune(s)
+	return string(r[:n-1]) + "~"
+}
+
+func renderWaterfallSVG(title string, spans []map[string]any, total float64, width int) []byte {
+	const (
+		rowHeight = 20
+		top       = 52
+		pad       = 10
+		timeWidth = 70
+	)
+	labelWidth := width * 3 / 10
+	barX := labelWidth + pad
+	barWidth := width - barX - timeWidth - pad
+	shown, hidden := spans, 0
+	if len(shown) > maxRenderSpans {
+		shown, hidden = shown[:maxRenderSpans], len(shown)-maxRenderSpans
+	}
+	height := top + len(shown)*rowHeight + pad
+	if hidden > 0 {
+		height += rowHeight
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="Helvetica, Arial, sans-serif" font-size="12">`+"\n", width, height, width, height)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#ffffff"/>`+"\n", width, height)
+	fmt.Fprintf(&b, `<text x="%d" y="20" font-size="14" font-weight="bold" fill="#1f2933">%s</text>`+"\n", pad, html.EscapeString(title))
+	for i := 0; i <= 4; i++ {
+		x := barX + barWidth*i/4
+		anchor := "middle"
+		switch i {
+		case 0:
+			anchor = "start"
+		case 4:
+			anchor = "end"
+		}
+		fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#e4e7eb"/>`+"\n", x, top-8, x, height-pad)
+		fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="%s" fill="#7b8794">%gms</text>`+"\n", x, top-12, anchor, math.Round(total*float64(i)/4))
+	}
+	for i, span := range shown {
+		y := top + i*rowHeight
+		color := "#7aa2e3"
+		switch {
+		case span["is_error"] == true:
+			color = "#e5484d"
+		case span["is_critical"] == true:
+			color = "#3b6fd4"
+		}
+		x := barX + int(toFloat(span["left_pct"])/100*float64(barWidth))
+		w := max(2, int(toFloat(span["width_pct"])/100*float64(barWidth)))
+		w = min(w, barX+barWidth-x)
+		indent := pad + min(12*int(toFloat(span["depth"])), labelWidth/2)
+		label := truncateRunes(spanLabel(span), max(8, (labelWidth-indent)/7))
+		fmt.Fprintf(&b, `<g><title>%s</title>`, html.EscapeString(toString(span["explanation"])))
+		fmt.Fprintf(&b, `<text x="%d" y="%d" fill="#1f2933">%s</text>`, indent, y+14, html.EscapeString(label))
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="12" rx="2" fill="%s"/>`, x, y+4, w, color)
+		fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end" fill="#52606d">%dms</text></g>`+"\n", width-pad, y+14, toUint32(span["duration_ms"]))
+	}
+	if hidden > 0 {
+		fmt.Fprintf(&b, `<text x="%d" y="%d" fill="#7b8794">%d more spans not shown</text>`+"\n", pad, top+len(shown)*rowHeight+14, hidden)
+	}
+	b.WriteString("</svg>\n")
+	return b.Bytes()
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"trace-lite/api/internal/query"
+)
+
+const (
+	rootCauseLimit = 10
+	exonerateFloor = 0.1
+)
+
+type rootCauseEvidence struct {
+	Base        rootCauseSide      `json:"base"`
+	Cand        rootCauseSide      `json:"cand"`
+	AddedSelfMs float64            `json:"added_self_ms"`
+	Components  map[string]float64 `json:"components"`
+	Weights     map[string]float64 `json:"weights"`
+	Callees     []rootCauseEdge    `json:"callees"`
+	Path        []string           `json:"path,omitempty"`
+}
+
+type rootCauseSide struct {
+	Calls     float64 `json:"calls"`
+	P95Ms     float64 `json:"p95_ms"`
+	ErrorRate float64 `json:"error_rate"`
+	SelfMs    float64 `json:"self_ms_per_trace"`
+}
+
+type rootCauseEdge struct {
+	Callee string  `json:"callee"`
+	Calls  float64 `json:"calls"`
+}
+
+var rootCauseWeights = map[string]float64{"critical_path": 0.5, "self_time": 0.2, "errors": 0.2, "calls": 0.1}
+
+func (h *Handler) compareTopology(ctx context.Context, rows []map[string]any, from, to time.Time, env string) ([]map[string]any, error) {
+	seen := map[string]bool{}
+	var services []string
+	for _, row := range rows {
+		svc := toString(row["service"])
+		if !seen[svc] {
+			seen[svc] = true
+			services = append(services, svc)
+		}
+	}
+	if len(services) < 2 {
+		return nil, nil
+	}
+	return h.run(ctx, query.New().
+		Select("caller_service, callee_service", "sum(calls) AS calls").
+		From("dependency_edges_minute").
+		MinuteRange("bucket_ts", from, to).
+		FilterIn("env", h.envs(env)).
+		In("caller_service", services).
+		In("callee_service", services).
+		Where("caller_service != callee_service").
+		GroupBy("caller_service, callee_service"))
+}
+
+func buildRootCauseRanking(rows, edges []map[string]any, base, cand string) []rootCauseRank {
+	type stats struct {
+		Calls         float64
+		P95           float64
+		ErrorRate     float64
+		BlockingRatio float64
+		SelfMs        float64
+	}
+	baseStats := map[string]stats{}
+	candStats := map[string]stats{}
+
+	for _, row := range rows {
+		s := stats{
+			Calls:         toFloat(row["calls"]),
+			P95:           toFloat(row["p95_ms"]),
+			ErrorRate:     toFloat(row["error_rate"]),
+			BlockingRatio: toFloat(row["blocking_ratio"]),
+			SelfMs:        toFloat(row["self_ms_per_trace"]),
+		}
+		svc := toString(row["service"])
+		version := toString(row["version"])
+		if version == base {
+			baseStats[svc] = s
+		}
+		if version == cand {
+			candStats[svc] = s
+		}
+	}
+
+	services := map[string]struct{}{}
+	for svc := range baseStats {
+		services[svc] = struct{}{}
+	}
+	for svc := range candStats {
+		services[svc] = struct{}{}
+	}
+
+	var addedMs float64
+	for svc := range services {
+		addedMs += max(candStats[svc].SelfMs-baseStats[svc].SelfMs, 0)
+	}
+
+	type signal struct {
+		latPct, errPct, callPct, selfPct, share float64
+		components                              map[string]float64
+		own, path                               float64
+	}
+	signals := make(map[string]signal, len(services))
+	for svc := range services {
+		b, c := baseStats[svc], candStats[svc]
+		s := signal{
+			latPct:  pctDelta(b.P95, c.P95),
+			errPct:  pctDelta(b.ErrorRate, c.ErrorRate),
+			callPct: pctDelta(b.Calls, c.Calls),
+			selfPct: pctDelta(b.SelfMs, c.SelfMs),
+		}
+		if addedMs > 0 {
+			s.share = max(c.SelfMs-b.SelfMs, 0) / addedMs
+		}
+		s.components = map[string]float64{
+			"critical_path": round(rootCauseWeights["critical_path"]*s.share, 4),
+			"self_time":     round(rootCauseWeights["self_time"]*clamp(s.selfPct/300, 0, 1), 4),
+			"errors":        round(rootCauseWeights["errors"]*clamp(s.errPct/300, 0, 1), 4),
+			"calls":         round(rootCauseWeights["calls"]*clamp(s.callPct/300, 0, 1), 4),
+		}
+		s.path = s.components["critical_path"] + s.components["self_time"]
+		s.own = s.path + s.components["errors"] + s.components["calls"]
+		signals[svc] = s
+	}
+
+	callees := map[string][]rootCauseEdge{}
+	for _, e := range edges {
+		caller := toString(e["caller_service"])
+		callees[caller] = append(callees[caller], rootCauseEdge{Callee: toString(e["callee_service"]), Calls: toFloat(e["calls"])})
+	}
+	culprit := func(svc string) []string {
+		best, bestScore := "", max(signals[svc].own, exonerateFloor)
+		parent := map[string]string{svc: ""}
+		queue := []string{svc}
+		for len(queue) > 0 {
+			cur := queue[0]
+			queue = queue[1:]
+			for _, e := range callees[cur] {
+				if _, seen := parent[e.Callee]; seen {
+					continue
+				}
+				parent[e.Callee] = cur
+				queue = append(queue, e.Callee)
+				if s, ok := signals[e.Callee]; ok && s.own > bestScore {
+					best, bestScore = e.Callee, s.own
+				}
+			}
+		}
+		if best == "" {
+			return nil
+		}
+		var path []string
+		for cur := best; cur != ""; cur = parent[cur] {
+			path = append([]string{cur}, path...)
+		}
+		return path
+	}
+
+	out := make([]rootCauseRank, 0, len(services))
+	for svc := range services {
+		s := signals[svc]
+		score := s.own
+		reason := fmt.Sprintf("latency %+0.1f%%, own time %+0.1f%% (%.0f%% of added time), error %+0.1f%%, calls %+0.1f%%",
+			s.latPct, s.selfPct, s.share*100, s.errPct, s.callPct)
+		path := culprit(svc)
+		downstream := ""
+		if len(path) > 0 {
+			downstream = path[len(path)-1]
+			score = s.path
+			reason += "; errors and call changes attributed to downstream " + downstream
+		}
+		b, c := baseStats[svc], candStats[svc]
+		direct := callees[svc]
+		if direct == nil {
+			direct = []rootCauseEdge{}
+		}
+		out = append(out, rootCauseRank{
+			Service:           svc,
+			Score:             round(score, 4),
+			LatencyDeltaPct:   round(s.latPct, 2),
+			ErrorDeltaPct:     round(s.errPct, 2),
+			CallDeltaPct:      round(s.callPct, 2),
+			BlockingRatio:     round(c.BlockingRatio, 4),
+			SelfTimeDeltaPct:  round(s.selfPct, 2),
+			CriticalPathShare: round(s.share, 4),
+			ExoneratedBy:      downstream,
+			Reason:            reason,
+			Evidence: rootCauseEvidence{
+				Base:        rootCauseSide{Calls: b.Calls, P95Ms: b.P95, ErrorRate: b.ErrorRate, SelfMs: b.SelfMs},
+				Cand:        rootCauseSide{Calls: c.Calls, P95Ms: c.P95, ErrorRate: c.ErrorRate, SelfMs: c.SelfMs},
+				AddedSelfMs: round(addedMs, 2),
+				Components:  s.components,
+				Weights:     rootCauseWeights,
+				Callees:     direct,
+				Path:        path,
+			},
+		})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		return out[i].Service < out[j].Service
+	})
+	if len(out) > rootCauseLimit {
+		out = out[:rootCauseLimit]
+	}
+	return out
+}
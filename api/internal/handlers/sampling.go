@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"trace-lite/api/internal/query"
+)
+
+var durationBuckets = []string{"fast", "median", "slow", "outlier"}
+
+func (h *Handler) stratifiedTraces(ctx context.Context, traces *query.Query, limit int) (map[string]any, error) {
+	qRows, err := h.run(ctx, traces.Clone().Select(
+		"count() AS total",
+		"quantile(0.50)(duration_ms) AS p50",
+		"quantile(0.90)(duration_ms) AS p90",
+		"quantile(0.99)(duration_ms) AS p99"))
+	if err != nil {
+		return nil, err
+	}
+	var p50, p90, p99, total float64
+	if len(qRows) > 0 {
+		total = toFloat(qRows[0]["total"])
+		p50 = toFloat(qRows[0]["p50"])
+		p90 = toFloat(qRows[0]["p90"])
+		p99 = toFloat(qRows[0]["p99"])
+	}
+
+	perBucket := limit / len(durationBuckets)
+	if perBucket < 1 {
+		perBucket = 1
+	}
+	rows, err := h.run(ctx, traces.Clone().
+		Select(traceColumns,
+			fmt.Sprintf("multiIf(duration_ms < %f, 'fast', duration_ms < %f, 'median', duration_ms < %f, 'slow', 'outlier') AS duration_bucket", p50, p90, p99)).
+		OrderBy("duration_bucket", "cityHash64(trace_id)").
+		LimitBy(perBucket, "duration_bucket"))
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	for _, row := range rows {
+		counts[toString(row["duration_bucket"])]++
+	}
+	buckets := make([]map[string]any, 0, len(durationBuckets))
+	bounds := [][2]float64{{0, p50}, {p50, p90}, {p90, p99}, {p99, -1}}
+	for i, name := range durationBuckets {
+		b := map[string]any{"bucket": name, "min_ms": bounds[i][0], "sampled": counts[name]}
+		if bounds[i][1] >= 0 {
+			b["max_ms"] = bounds[i][1]
+		}
+		buckets = append(buckets, b)
+	}
+	return map[string]any{
+		"data": rows,
+		"sample": map[string]any{
+			"mode":       "stratified",
+			"total":      int(total),
+			"per_bucket": perBucket,
+			"buckets":    buckets,
+		},
+	}, nil
+}
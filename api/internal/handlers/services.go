@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"trace-lite/api/internal/query"
+)
+
+func (h *Handler) ServicesMissing(w http.ResponseWriter, r *http.Request) {
+	minutes := 15
+	if raw := r.URL.Query().Get("minutes"); raw != "" {
+		if v, err := strconv.Atoi(raw); err == nil && v > 0 && v <= 7*24*60 {
+			minutes = v
+		}
+	}
+	lookback := 24 * time.Hour
+	if raw := r.URL.Query().Get("lookback"); raw != "" {
+		if d, err := time.ParseDuration(raw); err == nil && d > 0 && d <= 30*24*time.Hour {
+			lookback = d
+		}
+	}
+	if lookback <= time.Duration(minutes)*time.Minute {
+		lookback = time.Duration(minutes)*time.Minute + time.Hour
+	}
+	env := sanitize(r.URL.Query().Get("env"))
+
+	since := h.now().UTC().Add(-lookback)
+	q := query.New()
+	latest := func(table, heartbeat, log string) *query.Query {
+		return q.Sub().
+			Select("service, env, max(ts) AS last_seen", heartbeat+" AS last_heartbeat", log+" AS last_log").
+			From(table).
+			Since("ts", since).
+			FilterIn("env", h.envs(env)).
+			GroupBy("service, env")
+	}
+	seen := latest("service_heartbeats", "max(ts)", "toDateTime64(0, 3, 'UTC')").
+		UnionAll(latest("raw_logs", "toDateTime64(0, 3, 'UTC')", "max(ts)"))
+
+	d, err := h.run(r.Context(), q.
+		Select("service, env",
+			"max(last_seen) AS last_seen",
+			"max(last_heartbeat) AS last_heartbeat",
+			"max(last_log) AS last_log",
+			"dateDiff('second', max(last_seen), now64(3)) AS silent_seconds").
+		FromQuery(seen, "").
+		GroupBy("service, env").
+		Having(fmt.Sprintf("last_seen < now64(3) - INTERVAL %d MINUTE", minutes)).
+		OrderBy("last_seen ASC"))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"minutes":  minutes,
+		"lookback": lookback.String(),
+		"services": d,
+	})
+}
+
+func (h *Handler) ServiceVersions(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/services/"), "/"), "/")
+	if len(parts) != 2 || parts[1] != "versions" {
+		WriteError(w, http.StatusNotFound, "not_found", "unknown services endpoint", nil)
+		return
+	}
+	service := sanitize(parts[0])
+	if service == "" {
+		WriteError(w, http.StatusBadRequest, "invalid_request", "invalid service", nil)
+		return
+	}
+	from, to := h.parseRange(r)
+	env := sanitize(r.URL.Query().Get("env"))
+
+	step := bucketStep(to.Sub(from))
+	filter := query.New().
+		From("service_versions_minute").
+		MinuteRange("bucket_ts", from, to).
+		Eq("service", service).
+		FilterIn("env", h.envs(env))
+
+	buckets := filter.Clone().
+		Select(fmt.Sprintf("toStartOfInterval(bucket_ts, INTERVAL %d MINUTE) AS bucket", int(step.Minutes())),
+			"version",
+			"sum(calls) AS calls",
+			"sum(errors) AS errors").
+		GroupBy("bucket, version")
+	series, err := h.run(r.Context(), filter.Sub().
+		Select("bucket, version, calls, errors, round(calls / sum(calls) OVER (PARTITION BY bucket), 4) AS share").
+		FromQuery(buckets, "").
+		OrderBy("bucket ASC", "calls DESC"))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+
+	byVersion := filter.Clone().
+		Select("version",
+			"sum(calls) AS calls",
+			"sum(errors) AS errors",
+			"min(bucket_ts) AS first_seen",
+			"max(bucket_ts) AS last_seen").
+		GroupBy("version")
+	versions, err := h.run(r.Context(), filter.Sub().
+		Select("version, calls, errors",
+			"round(if(calls = 0, 0, errors / calls), 4) AS error_rate",
+			"round(calls / sum(calls) OVER (), 4) AS share",
+			"first_seen, last_seen").
+		FromQuery(byVersion, "").
+		OrderBy("last_seen DESC", "calls DESC"))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"service":      service,
+		"step_minutes": int(step.Minutes()),
+		"versions":     versions,
+		"series":       series,
+	})
+}
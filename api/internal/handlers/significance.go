@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"trace-lite/api/internal/query"
+)
+
+const (
+	compareSampleSize = 1000
+	compareMinSamples = 20
+	compareAlpha      = 0.05
+)
+
+func mannWhitney(base, cand []float64) (pValue, probSlower float64) {
+	n1, n2 := float64(len(base)), float64(len(cand))
+	type ranked struct {
+		v    float64
+		cand bool
+	}
+	all := make([]ranked, 0, len(base)+len(cand))
+	for _, v := range base {
+		all = append(all, ranked{v: v})
+	}
+	for _, v := range cand {
+		all = append(all, ranked{v: v, cand: true})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+
+	var candRanks, ties float64
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].cand {
+				candRanks += rank
+			}
+		}
+		if t := float64(j - i); t > 1 {
+			ties += t*t*t - t
+		}
+		i = j
+	}
+	u := candRanks - n2*(n2+1)/2
+	probSlower = u / (n1 * n2)
+	n := n1 + n2
+	variance := n1 * n2 / 12 * ((n + 1) - ties/(n*(n-1)))
+	if variance <= 0 {
+		return 1, probSlower
+	}
+	z := (math.Abs(u-n1*n2/2) - 0.5) / math.Sqrt(variance)
+	if z < 0 {
+		z = 0
+	}
+	return math.Erfc(z / math.Sqrt2), probSlower
+}
+
+func (h *Handler) addSignificance(ctx context.Context, spans *query.Query, opCols, cand string, deltas []map[string]any) error {
+	if len(deltas) == 0 {
+		return nil
+	}
+	cols := strings.Split(opCols, ", ")
+	q := spans.Clone()
+	tuples := make([]string, 0, len(deltas))
+	for _, row := range deltas {
+		vals := make([]string, len(cols))
+		for i, c := range cols {
+			vals[i] = q.String(toString(row[c]))
+		}
+		if len(vals) == 1 {
+			tuples = append(tuples, vals[0])
+		} else {
+			tuples = append(tuples, "("+strings.Join(vals, ", ")+")")
+		}
+	}
+	target := opCols
+	if len(cols) > 1 {
+		target = "(" + opCols + ")"
+	}
+	samples, err := h.run(ctx, q.
+		Select(opCols,
+			fmt.Sprintf("version = %s AS is_cand", q.String(cand)),
+			fmt.Sprintf("groupArraySample(%d, 1)(duration_ms) AS samples", compareSampleSize)).
+		Where(fmt.Sprintf("%s IN (%s)", target, strings.Join(tuples, ", "))).
+		GroupBy(opCols, "is_cand"))
+	if err != nil {
+		return err
+	}
+
+	key := func(row map[string]any) string {
+		parts := make([]string, len(cols))
+		for i, c := range cols {
+			parts[i] = toString(row[c])
+		}
+		return strings.Join(parts, "\x00")
+	}
+	type pair struct{ base, cand []float64 }
+	byOp := map[string]*pair{}
+	for _, row := range samples {
+		k := key(row)
+		if byOp[k] == nil {
+			byOp[k] = &pair{}
+		}
+		list, _ := row["samples"].([]any)
+		values := make([]float64, len(list))
+		for i, v := range list {
+			values[i] = toFloat(v)
+		}
+		if toFloat(row["is_cand"]) == 1 {
+			byOp[k].cand = values
+		} else {
+			byOp[k].base = values
+		}
+	}
+	for _, row := range deltas {
+		p := byOp[key(row)]
+		if p == nil || len(p.base) < compareMinSamples || len(p.cand) < compareMinSamples {
+			row["significance"], row["p_value"], row["prob_cand_slower"] = "insufficient_data", nil, nil
+			continue
+		}
+		pValue, slower := mannWhitney(p.base, p.cand)
+		row["p_value"] = round(pValue, 4)
+		row["prob_cand_slower"] = round(slower, 3)
+		row["significance"] = "noise"
+		if pValue < compareAlpha {
+			row["significance"] = "significant"
+		}
+	}
+	return nil
+}
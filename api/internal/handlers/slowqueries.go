@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"trace-lite/api/internal/clickhouse"
+)
+
+func (h *Handler) SetQueryLog(l *clickhouse.QueryLog) {
+	h.queries = l
+}
+
+func (h *Handler) AdminSlowQueries(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeWrite(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
+		return
+	}
+	route := r.URL.Query().Get("route")
+	slow := []clickhouse.QueryStat{}
+	for _, s := range h.queries.Slow() {
+		if route == "" || s.Route == route {
+			slow = append(slow, s)
+		}
+	}
+	routes := []clickhouse.RouteStats{}
+	for _, s := range h.queries.Routes() {
+		if route == "" || s.Route == route {
+			s.TotalMs = round(s.TotalMs, 1)
+			routes = append(routes, s)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"threshold_ms": h.queries.Threshold().Milliseconds(),
+		"since":        h.queries.Since().Format(time.RFC3339),
+		"slow_queries": slow,
+		"routes":       routes,
+	})
+}
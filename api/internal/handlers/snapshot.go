@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+
+	"trace-lite/api/internal/query"
+)
+
+const snapshotColumns = "id, trace_id, created_at, bytes"
+
+type traceSnapshot struct {
+	ID        string `json:"id"`
+	TraceID   string `json:"trace_id"`
+	CreatedAt string `json:"created_at"`
+	Bytes     int    `json:"bytes"`
+	URL       string `json:"url"`
+}
+
+type snapshotRow struct {
+	ID        string `json:"id"`
+	TraceID   string `json:"trace_id"`
+	CreatedAt string `json:"created_at"`
+	Bytes     int    `json:"bytes"`
+	Body      string `json:"body"`
+}
+
+func snapshotURL(traceID, id string) string {
+	return "/v1/traces/" + traceID + "/snapshot/" + id
+}
+
+func snapshotFromRow(row map[string]any) traceSnapshot {
+	id, traceID := toString(row["id"]), toString(row["trace_id"])
+	return traceSnapshot{
+		ID:        id,
+		TraceID:   traceID,
+		CreatedAt: toString(row["created_at"]),
+		Bytes:     int(toFloat(row["bytes"])),
+		URL:       snapshotURL(traceID, id),
+	}
+}
+
+func validSnapshotID(id string) bool {
+	if len(id) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+func (h *Handler) traceSnapshot(w http.ResponseWriter, r *http.Request, traceID string, rest []string) {
+	switch {
+	case len(rest) == 0 && r.Method == http.MethodPost:
+		if h.authorizeWrite(w, r) {
+			h.createSnapshot(w, r, traceID)
+		}
+	case len(rest) == 0 && r.Method == http.MethodGet:
+		h.listSnapshots(w, r, traceID)
+	case len(rest) == 1 && r.Method == http.MethodGet:
+		h.getSnapshot(w, r, traceID, rest[0])
+	case len(rest) > 1:
+		WriteError(w, http.StatusNotFound, "not_found", "unknown snapshot path", nil)
+	default:
+		WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
+	}
+}
+
+func (h *Handler) createSnapshot(w http.ResponseWriter, r *http.Request, traceID string) {
+	traceRows, spanRows, truncated, err := h.loadTrace(r.Context(), traceID, h.limitFor(r, "spans", "span_limit"))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	if len(spanRows) == 0 {
+		WriteError(w, http.StatusNotFound, "not_found", "trace not found", nil)
+		return
+	}
+	drill := h.traceDrilldown(r.Context(), traceID, traceRows, spanRows)
+	drill["spans_truncated"] = truncated
+	body, err := json.Marshal(drill)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "internal", "could not encode snapshot", nil)
+		return
+	}
+	sum := sha256.Sum256(body)
+	id := hex.EncodeToString(sum[:])
+
+	existing, err := h.run(r.Context(), query.New().
+		Select(snapshotColumns).
+		From("trace_snapshots").
+		Eq("id", id).
+		Limit(1))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	if len(existing) > 0 {
+		writeJSON(w, http.StatusOK, map[string]any{"snapshot": snapshotFromRow(existing[0]), "created": false})
+		return
+	}
+
+	row := snapshotRow{
+		ID:        id,
+		TraceID:   traceID,
+		CreatedAt: chTime(h.now()),
+		Bytes:     len(body),
+		Body:      string(body),
+	}
+	if err := h.ch.InsertJSONEachRow(r.Context(), "trace_snapshots", row); err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"snapshot": traceSnapshot{ID: id, TraceID: traceID, CreatedAt: row.CreatedAt, Bytes: row.Bytes, URL: snapshotURL(traceID, id)},
+		"created":  true,
+	})
+}
+
+func (h *Handler) listSnapshots(w http.ResponseWriter, r *http.Request, traceID string) {
+	rows, err := h.run(r.Context(), query.New().
+		Select(snapshotColumns).
+		From("trace_snapshots FINAL").
+		Eq("trace_id", traceID).
+		OrderBy("created_at DESC").
+		Limit(100))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	snapshots := make([]traceSnapshot, 0, len(rows))
+	for _, row := range rows {
+		snapshots = append(snapshots, snapshotFromRow(row))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"trace_id": traceID, "snapshots": snapshots})
+}
+
+func (h *Handler) getSnapshot(w http.ResponseWriter, r *http.Request, traceID, id string) {
+	if !validSnapshotID(id) {
+		WriteError(w, http.StatusBadRequest, "invalid_request", "snapshot id must be a sha256 hex digest", nil)
+		return
+	}
+	rows, err := h.run(r.Context(), query.New().
+		Select(snapshotColumns, "body").
+		From("trace_snapshots FINAL").
+		Eq("id", id).
+		Eq("trace_id", traceID).
+		Limit(1))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	if len(rows) == 0 {
+		WriteError(w, http.StatusNotFound, "not_found", "snapshot not found", nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"snapshot":  snapshotFromRow(rows[0]),
+		"drilldown": json.RawMessage(toString(rows[0]["body"])),
+	})
+}
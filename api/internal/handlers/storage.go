@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+
+	"trace-lite/api/internal/query"
+)
+
+var storageCounters = []string{"parts", "partitions", "max_parts_per_partition", "rows", "bytes_on_disk",
+	"compressed_bytes", "uncompressed_bytes", "ttl_expired_parts", "pending_mutations"}
+
+func (h *Handler) AdminStorage(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeWrite(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
+		return
+	}
+
+	tables, err := h.run(r.Context(), query.New().
+		Select("name AS table, engine",
+			"extract(engine_full, 'TTL (.+?)(?: SETTINGS |$)') AS ttl").
+		From("system.tables").
+		Where("database = currentDatabase()", "NOT is_temporary").
+		OrderBy("name"))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	q := query.New()
+	partitions := q.Sub().
+		Select("table, partition",
+			"count() AS parts",
+			"sum(rows) AS rows",
+			"sum(bytes_on_disk) AS bytes_on_disk",
+			"sum(data_compressed_bytes) AS compressed_bytes",
+			"sum(data_uncompressed_bytes) AS uncompressed_bytes",
+			"minIf(min_date, min_date > toDate(0)) AS oldest_day",
+			"maxIf(max_date, max_date > toDate(0)) AS newest_day",
+			"countIf(delete_ttl_info_min > toDateTime(0) AND delete_ttl_info_min <= now()) AS ttl_expired_parts").
+		From("system.parts").
+		Where("database = currentDatabase()", "active").
+		GroupBy("table, partition")
+	parts, err := h.run(r.Context(), q.
+		Select("table",
+			"sum(parts) AS parts",
+			"count() AS partitions",
+			"max(parts) AS max_parts_per_partition",
+			"sum(rows) AS rows",
+			"sum(bytes_on_disk) AS bytes_on_disk",
+			"sum(compressed_bytes) AS compressed_bytes",
+			"sum(uncompressed_bytes) AS uncompressed_bytes",
+			"round(if(sum(compressed_bytes) = 0, 0, sum(uncompressed_bytes) / sum(compressed_bytes)), 2) AS compression_ratio",
+			"min(oldest_day) AS oldest_day",
+			"max(newest_day) AS newest_day",
+			"sum(ttl_expired_parts) AS ttl_expired_parts").
+		FromQuery(partitions, "").
+		GroupBy("table"))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	mutations, err := h.run(r.Context(), query.New().
+		Select("table, count() AS pending_mutations").
+		From("system.mutations").
+		Where("database = currentDatabase()", "NOT is_done").
+		GroupBy("table"))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+
+	byTable := map[string]map[string]any{}
+	for _, row := range parts {
+		byTable[toString(row["table"])] = row
+	}
+	pending := map[string]any{}
+	for _, row := range mutations {
+		pending[toString(row["table"])] = row["pending_mutations"]
+	}
+	var totalBytes, totalRows, totalParts uint64
+	out := make([]map[string]any, 0, len(tables))
+	for _, t := range tables {
+		name := toString(t["table"])
+		row := map[string]any{
+			"table": name, "engine": t["engine"], "ttl": t["ttl"],
+			"parts": 0, "partitions": 0, "max_parts_per_partition": 0, "rows": 0,
+			"bytes_on_disk": 0, "compressed_bytes": 0, "uncompressed_bytes": 0, "compression_ratio": 0,
+			"oldest_day": nil, "newest_day": nil, "ttl_expired_parts": 0, "pending_mutations": 0,
+		}
+		if p, ok := byTable[name]; ok {
+			for k, v := range p {
+				row[k] = v
+			}
+		}
+		if n, ok := pending[name]; ok {
+			row["pending_mutations"] = n
+		}
+		for _, k := range storageCounters {
+			row[k] = uint64(toFloat(row[k]))
+		}
+		totalBytes += row["bytes_on_disk"].(uint64)
+		totalRows += row["rows"].(uint64)
+		totalParts += row["parts"].(uint64)
+		out = append(out, row)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return toFloat(out[i]["bytes_on_disk"]) > toFloat(out[j]["bytes_on_disk"])
+	})
+	writeJSON(w, http.StatusOK, map[string]any{
+		"tables": out,
+		"totals": map[string]any{"bytes_on_disk": totalBytes, "rows": totalRows, "parts": totalParts},
+	})
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"trace-lite/api/internal/query"
+)
+
+func (h *Handler) Transactions(w http.ResponseWriter, r *http.Request) {
+	from, to := h.parseRange(r)
+	limit := h.limitFor(r, "transactions", "limit")
+	env := sanitize(r.URL.Query().Get("env"))
+
+	minutes := to.Sub(from).Minutes()
+	if minutes < 1 {
+		minutes = 1
+	}
+
+	q := query.New().
+		Select("transaction",
+			"count() AS traces",
+			"countIf(error_count > 0) AS error_traces",
+			"round(error_traces / traces, 4) AS error_rate",
+			fmt.Sprintf("round(traces / %f, 4) AS per_minute", minutes),
+			"round(avg(duration_ms), 2) AS avg_ms",
+			"round(quantile(0.50)(duration_ms), 2) AS p50_ms",
+			"round(quantile(0.95)(duration_ms), 2) AS p95_ms",
+			"round(quantile(0.99)(duration_ms), 2) AS p99_ms",
+			"max(service_count) AS max_services",
+			"groupUniqArray(root_service) AS root_services",
+			"max(start_ts) AS last_seen",
+			"count() OVER () AS _total").
+		TimeRange("start_ts", from, to).
+		Where("transaction != ''").
+		FilterIn("env", h.envs(env)).
+		GroupBy("transaction").
+		OrderBy("traces DESC").
+		Limit(limit)
+	parsePlace(r).traces(q)
+	h.latestTraces(q, from, to)
+	d, err := h.run(r.Context(), q)
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	d, page := splitTotal(d, limit)
+	page["transactions"] = d
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (h *Handler) TransactionDetail(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" || len(name) > 512 {
+		WriteError(w, http.StatusBadRequest, "invalid_request", "name is required", nil)
+		return
+	}
+	from, to := h.parseRange(r)
+	env := sanitize(r.URL.Query().Get("env"))
+
+	step := bucketStep(to.Sub(from))
+	traces := query.New().
+		TimeRange("start_ts", from, to).
+		Eq("transaction", name).
+		FilterIn("env", h.envs(env))
+	place := parsePlace(r)
+	place.traces(traces)
+	h.latestTraces(traces, from, to)
+
+	series, err := h.run(r.Context(), traces.Clone().
+		Select(fmt.Sprintf("toStartOfInterval(start_ts, INTERVAL %d MINUTE) AS bucket_ts", int(step.Minutes())),
+			"count() AS traces",
+			"countIf(error_count > 0) AS error_traces",
+			"round(quantile(0.50)(duration_ms), 2) AS p50_ms",
+			"round(quantile(0.95)(duration_ms), 2) AS p95_ms").
+		GroupBy("bucket_ts").
+		OrderBy("bucket_ts ASC"))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+
+	perService := traces.Sub().
+		Select("service",
+			"count() AS spans",
+			"countIf(is_error = 1) AS errors",
+			"round(avg(duration_ms), 2) AS avg_ms",
+			"round(quantile(0.95)(duration_ms), 2) AS p95_ms",
+			"round(avg(self_time_ms), 2) AS avg_self_ms").
+		From(h.spansTable).
+		InQuery("trace_id", traces.Clone().Select("trace_id")).
+		Since("start_ts", from).
+		GroupBy("service").
+		OrderBy("avg_self_ms DESC").
+		Limit(100)
+	place.spans(perService)
+	services, err := h.run(r.Context(), perService)
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+
+	slowest, err := h.run(r.Context(), traces.Clone().
+		Select("trace_id, env, root_service, start_ts, duration_ms, span_count, service_count, error_count").
+		OrderBy("duration_ms DESC").
+		Limit(20))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"transaction":  name,
+		"step_minutes": int(step.Minutes()),
+		"series":       series,
+		"services":     services,
+		"slowest":      slowest,
+	})
+}
+
+func bucketStep(span time.Duration) time.Duration {
+	switch {
+	case span <= 6*time.Hour:
+		return time.Minute
+	case span <= 48*time.Hour:
+		return 15 * time.Minute
+	default:
+		return time.Hour
+	}
+}
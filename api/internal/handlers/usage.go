@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"trace-lite/api/internal/query"
+)
+
+const usageDay = 24 * time.Hour
+
+func usageDays(from, to time.Time) (time.Time, time.Time) {
+	return from.UTC().Truncate(usageDay), to.UTC().Add(-time.Nanosecond).Truncate(usageDay).Add(usageDay)
+}
+
+func (h *Handler) Usage(w http.ResponseWriter, r *http.Request) {
+	from, to := h.parseRange(r)
+	start, end := usageDays(from, to)
+	prev := start.Add(-end.Sub(start))
+	env := sanitize(r.URL.Query().Get("env"))
+	service := sanitize(r.URL.Query().Get("service"))
+	limit := h.limitFor(r, "usage", "limit")
+
+	q := query.New()
+	cur := "day >= " + q.Day(start)
+	usage := q.Sub().
+		Select("env, service",
+			"sumIf(events, "+cur+") AS cur_events",
+			"sumIf(bytes, "+cur+") AS cur_bytes",
+			"sumIf(spans, "+cur+") AS cur_spans",
+			"sumIf(traces, "+cur+") AS cur_traces",
+			"sumIf(bytes, NOT ("+cur+")) AS prev_bytes").
+		From("usage_daily").
+		DayRange("day", prev, end).
+		FilterIn("env", h.envs(env)).
+		Filter("service", service).
+		GroupBy("env, service").
+		Having("cur_events > 0 OR cur_spans > 0")
+
+	d, err := h.run(r.Context(), q.
+		Select("env, service",
+			"cur_events AS events, cur_bytes AS bytes, cur_spans AS spans, cur_traces AS traces, prev_bytes",
+			"round(if(bytes = 0, 0, bytes / sum(bytes) OVER ()), 4) AS bytes_share",
+			"round(if(prev_bytes = 0, 0, (bytes - prev_bytes) / prev_bytes * 100), 2) AS bytes_change_pct",
+			"count() OVER () AS _total").
+		FromQuery(usage, "").
+		OrderBy("bytes DESC", "spans DESC").
+		Limit(limit))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	d, page := splitTotal(d, limit)
+	page["from_day"] = start.Format("2006-01-02")
+	page["to_day"] = end.Add(-usageDay).Format("2006-01-02")
+	page["previous_from_day"] = prev.Format("2006-01-02")
+	page["usage"] = d
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (h *Handler) UsageDaily(w http.ResponseWriter, r *http.Request) {
+	from, to := h.parseRange(r)
+	start, end := usageDays(from, to)
+	env := sanitize(r.URL.Query().Get("env"))
+	service := sanitize(r.URL.Query().Get("service"))
+
+	d, err := h.run(r.Context(), query.New().
+		Select("day",
+			"sum(events) AS events",
+			"sum(bytes) AS bytes",
+			"sum(spans) AS spans",
+			"sum(traces) AS traces").
+		From("usage_daily").
+		DayRange("day", start, end).
+		FilterIn("env", h.envs(env)).
+		Filter("service", service).
+		GroupBy("day").
+		OrderBy("day ASC"))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"env":      env,
+		"service":  service,
+		"from_day": start.Format("2006-01-02"),
+		"to_day":   end.Add(-usageDay).Format("2006-01-02"),
+		"days":     d,
+	})
+}
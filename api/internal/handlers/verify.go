@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"trace-lite/api/internal/query"
+)
+
+var verifyChecks = []string{"p95_delta_pct", "error_rate_delta", "new_error_groups"}
+
+func (h *Handler) Verify(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	service := sanitize(params.Get("service"))
+	version := sanitize(params.Get("version"))
+	if service == "" || version == "" || params.Get("from") == "" {
+		WriteError(w, http.StatusBadRequest, "invalid_request", "service/version/from are required", nil)
+		return
+	}
+	env := sanitize(params.Get("env"))
+	base := sanitize(params.Get("base"))
+	from, to := h.parseRange(r)
+	thresholds := make(map[string]float64, len(h.verify))
+	for name, v := range h.verify {
+		thresholds[name] = v
+		if raw := params.Get(name); raw != "" {
+			f, err := strconv.ParseFloat(raw, 64)
+			if err != nil || f < 0 {
+				WriteError(w, http.StatusBadRequest, "invalid_request", name+" must be a non-negative number", nil)
+				return
+			}
+			thresholds[name] = f
+		}
+	}
+
+	resp := map[string]any{
+		"service":          service,
+		"env":              env,
+		"version":          version,
+		"from":             from.Format(time.RFC3339),
+		"to":               to.Format(time.RFC3339),
+		"thresholds":       thresholds,
+		"checks":           []map[string]any{},
+		"new_error_groups": []map[string]any{},
+	}
+	if base == "" {
+		q := query.New()
+		prev, err := h.run(r.Context(), q.
+			Select("version, sum(calls) AS calls, max(bucket_ts) AS last_seen").
+			From("service_versions_minute").
+			FilterIn("env", h.envs(env)).
+			Eq("service", service).
+			Where("version != ''", "version != "+q.String(version)).
+			Where("bucket_ts >= "+q.Minute(from)+" - INTERVAL 1 DAY", "bucket_ts < "+q.Minute(from)).
+			GroupBy("version").
+			OrderBy("last_seen DESC", "calls DESC").
+			Limit(1))
+		if err != nil {
+			writeQueryError(w, err)
+			return
+		}
+		if len(prev) > 0 {
+			base = sanitize(toString(prev[0]["version"]))
+		}
+	}
+	resp["base_version"] = base
+	if base == "" || base == version {
+		resp["verdict"], resp["pass"] = "no_baseline", false
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	baseFrom := from.Add(-to.Sub(from))
+	spans := query.New()
+	spans.From(h.spansTable).
+		TimeRange("start_ts", baseFrom, to).
+		Eq("service", service).
+		FilterIn("env", h.envs(env)).
+		In("version", []string{base, version})
+	isBase := "version = " + spans.String(base)
+	isCand := fmt.Sprintf("version = %s AND start_ts >= %s", spans.String(version), spans.Time(from))
+
+	summaryRows, err := h.run(r.Context(), spans.Clone().
+		Select(fmt.Sprintf("countIf(%s) AS base_calls", isBase),
+			fmt.Sprintf("countIf(%s) AS cand_calls", isCand),
+			fmt.Sprintf("round(quantileIf(0.95)(duration_ms, %s), 2) AS base_p95_ms", isBase),
+			fmt.Sprintf("round(quantileIf(0.95)(duration_ms, %s), 2) AS cand_p95_ms", isCand),
+			fmt.Sprintf("round(avgIf(is_error, %s), 4) AS base_error_rate", isBase),
+			fmt.Sprintf("round(avgIf(is_error, %s), 4) AS cand_error_rate", isCand)))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	groups, err := h.run(r.Context(), spans.
+		Select("operation, error_type",
+			fmt.Sprintf("countIf(is_error = 1 AND %s) AS base_errors", isBase),
+			fmt.Sprintf("countIf(is_error = 1 AND %s) AS cand_errors", isCand),
+			"count() OVER () AS _total").
+		GroupBy("operation, error_type").
+		Having("base_errors = 0", "cand_errors > 0").
+		OrderBy("cand_errors DESC").
+		Limit(20))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	groups, page := splitTotal(groups, 20)
+
+	var summary map[string]any
+	if len(summaryRows) > 0 {
+		summary = summaryRows[0]
+	}
+	resp["baseline_from"] = baseFrom.Format(time.RFC3339)
+	resp["summary"] = summary
+	if len(groups) > 0 {
+		resp["new_error_groups"] = groups
+	}
+
+	values := map[string]float64{
+		"p95_delta_pct":    round(pctDelta(toFloat(summary["base_p95_ms"]), toFloat(summary["cand_p95_ms"])), 2),
+		"error_rate_delta": round(toFloat(summary["cand_error_rate"])-toFloat(summary["base_error_rate"]), 4),
+		"new_error_groups": toFloat(page["total"]),
+	}
+	verdict := "pass"
+	checks := make([]map[string]any, 0, len(verifyChecks))
+	for _, name := range verifyChecks {
+		ok := values[name] <= thresholds[name]
+		if !ok {
+			verdict = "fail"
+		}
+		checks = append(checks, map[string]any{"name": name, "value": values[name], "threshold": thresholds[name], "pass": ok})
+	}
+	if minCalls := thresholds["min_calls"]; toFloat(summary["base_calls"]) < minCalls || toFloat(summary["cand_calls"]) < minCalls {
+		verdict = "insufficient_data"
+	}
+	resp["checks"] = checks
+	resp["verdict"], resp["pass"] = verdict, verdict == "pass"
+	writeJSON(w, http.StatusOK, resp)
+}
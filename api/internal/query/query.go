@@ -0,0 +1,259 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Query struct {
+	args     *args
+	columns  []string
+	from     string
+	joins    []string
+	where    []string
+	groupBy  []string
+	having   []string
+	orderBy  []string
+	limitBy  string
+	limit    int
+	unionAll []*Query
+}
+
+type args struct {
+	values map[string]string
+}
+
+func New() *Query {
+	return &Query{args: &args{values: map[string]string{}}}
+}
+
+func (q *Query) Sub() *Query {
+	return &Query{args: q.args}
+}
+
+func (q *Query) Clone() *Query {
+	c := *q
+	c.columns = append([]string(nil), q.columns...)
+	c.joins = append([]string(nil), q.joins...)
+	c.where = append([]string(nil), q.where...)
+	c.groupBy = append([]string(nil), q.groupBy...)
+	c.having = append([]string(nil), q.having...)
+	c.orderBy = append([]string(nil), q.orderBy...)
+	c.unionAll = append([]*Query(nil), q.unionAll...)
+	return &c
+}
+
+func (q *Query) bind(typ, value string) string {
+	name := "p" + strconv.Itoa(len(q.args.values))
+	q.args.values[name] = value
+	return "{" + name + ":" + typ + "}"
+}
+
+func (q *Query) String(v string) string {
+	return q.bind("String", escape(v))
+}
+
+func (q *Query) Strings(list []string) string {
+	out := make([]string, len(list))
+	for i, v := range list {
+		out[i] = q.String(v)
+	}
+	return strings.Join(out, ", ")
+}
+
+func (q *Query) Time(t time.Time) string {
+	return q.bind("DateTime64(3, 'UTC')", t.UTC().Format("2006-01-02 15:04:05.000"))
+}
+
+func (q *Query) Second(t time.Time) string {
+	return q.bind("DateTime('UTC')", t.UTC().Format("2006-01-02 15:04:05"))
+}
+
+func (q *Query) Minute(t time.Time) string {
+	return q.bind("DateTime('UTC')", t.UTC().Format("2006-01-02 15:04:00"))
+}
+
+func (q *Query) Day(t time.Time) string {
+	return q.bind("Date", t.UTC().Format("2006-01-02"))
+}
+
+func (q *Query) Select(columns ...string) *Query {
+	q.columns = append(q.columns, columns...)
+	return q
+}
+
+func (q *Query) From(table string) *Query {
+	q.from = table
+	return q
+}
+
+func (q *Query) FromQuery(sub *Query, alias string) *Query {
+	q.from = sub.nested() + as(alias)
+	return q
+}
+
+func (q *Query) Join(kind string, sub *Query, alias, on string) *Query {
+	q.joins = append(q.joins, kind+" JOIN\n"+sub.nested()+as(alias)+" ON "+on)
+	return q
+}
+
+func (q *Query) UnionAll(other *Query) *Query {
+	q.unionAll = append(q.unionAll, other)
+	return q
+}
+
+func (q *Query) Where(conds ...string) *Query {
+	q.where = append(q.where, conds...)
+	return q
+}
+
+func (q *Query) Eq(column, value string) *Query {
+	return q.Where(column + " = " + q.String(value))
+}
+
+func (q *Query) Filter(column, value string) *Query {
+	if value == "" {
+		return q
+	}
+	return q.Eq(column, value)
+}
+
+func (q *Query) FilterIn(column string, values []string) *Query {
+	switch len(values) {
+	case 0:
+		return q
+	case 1:
+		return q.Filter(column, values[0])
+	}
+	return q.In(column, values)
+}
+
+func (q *Query) EqAny(value string, columns ...string) *Query {
+	v := q.String(value)
+	conds := make([]string, len(columns))
+	for i, c := range columns {
+		conds[i] = c + " = " + v
+	}
+	return q.Where("(" + strings.Join(conds, " OR ") + ")")
+}
+
+func (q *Query) In(column string, values []string) *Query {
+	return q.Where(column + " IN (" + q.Strings(values) + ")")
+}
+
+func (q *Query) InQuery(column string, sub *Query) *Query {
+	return q.Where(column + " IN " + sub.nested())
+}
+
+func (q *Query) NotInQuery(column string, sub *Query) *Query {
+	return q.Where(column + " NOT IN " + sub.nested())
+}
+
+func (q *Query) Since(column string, t time.Time) *Query {
+	return q.Where(column + " >= " + q.Time(t))
+}
+
+func (q *Query) TimeRange(column string, from, to time.Time) *Query {
+	return q.Where(column+" >= "+q.Time(from), column+" < "+q.Time(to))
+}
+
+func (q *Query) SecondRange(column string, from, to time.Time) *Query {
+	return q.Where(column+" >= "+q.Second(from), column+" < "+q.Second(to))
+}
+
+func (q *Query) MinuteRange(column string, from, to time.Time) *Query {
+	return q.Where(column+" >= "+q.Minute(from), column+" < "+q.Minute(to))
+}
+
+func (q *Query) DayRange(column string, from, to time.Time) *Query {
+	return q.Where(column+" >= "+q.Day(from), column+" < "+q.Day(to))
+}
+
+func (q *Query) GroupBy(columns ...string) *Query {
+	q.groupBy = append(q.groupBy, columns...)
+	return q
+}
+
+func (q *Query) Having(conds ...string) *Query {
+	q.having = append(q.having, conds...)
+	return q
+}
+
+func (q *Query) OrderBy(terms ...string) *Query {
+	q.orderBy = append(q.orderBy, terms...)
+	return q
+}
+
+func (q *Query) LimitBy(n int, columns ...string) *Query {
+	q.limitBy = fmt.Sprintf("%d BY %s", n, strings.Join(columns, ", "))
+	return q
+}
+
+func (q *Query) Limit(n int) *Query {
+	q.limit = n
+	return q
+}
+
+func (q *Query) Cond() string {
+	if len(q.where) == 0 {
+		return "1"
+	}
+	return strings.Join(q.where, " AND ")
+}
+
+func (q *Query) Params() map[string]string {
+	return q.args.values
+}
+
+func (q *Query) SQL() string {
+	var b strings.Builder
+	b.WriteString("SELECT " + strings.Join(q.columns, ", "))
+	if q.from != "" {
+		b.WriteString("\nFROM " + q.from)
+	}
+	for _, j := range q.joins {
+		b.WriteString("\n" + j)
+	}
+	if len(q.where) > 0 {
+		b.WriteString("\nWHERE " + q.Cond())
+	}
+	if len(q.groupBy) > 0 {
+		b.WriteString("\nGROUP BY " + strings.Join(q.groupBy, ", "))
+	}
+	if len(q.having) > 0 {
+		b.WriteString("\nHAVING " + strings.Join(q.having, " AND "))
+	}
+	if len(q.orderBy) > 0 {
+		b.WriteString("\nORDER BY " + strings.Join(q.orderBy, ", "))
+	}
+	if q.limitBy != "" {
+		b.WriteString("\nLIMIT " + q.limitBy)
+	}
+	if q.limit > 0 {
+		b.WriteString("\nLIMIT " + strconv.Itoa(q.limit))
+	}
+	for _, u := range q.unionAll {
+		b.WriteString("\nUNION ALL\n" + u.SQL())
+	}
+	return b.String()
+}
+
+func (q *Query) nested() string {
+	return "(\n  " + strings.ReplaceAll(q.SQL(), "\n", "\n  ") + "\n)"
+}
+
+func as(alias string) string {
+	if alias == "" {
+		return ""
+	}
+	return " AS " + alias
+}
+
+func escape(v string) string {
+	if !strings.ContainsAny(v, "\\\t\n") {
+		return v
+	}
+	return strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`).Replace(v)
+}
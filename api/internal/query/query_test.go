@@ -0,0 +1,110 @@
+package query
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBinding(t *testing.T) {
+	from := time.Date(2026, 1, 1, 10, 0, 0, 0, time.FixedZone("CET", 3600))
+	cases := []struct {
+		name   string
+		build  func(q *Query)
+		sql    string
+		params map[string]string
+	}{
+		{
+			name:   "eq binds a string placeholder",
+			build:  func(q *Query) { q.Select("trace_id").From("traces").Eq("env", "prod") },
+			sql:    "SELECT trace_id\nFROM traces\nWHERE env = {p0:String}",
+			params: map[string]string{"p0": "prod"},
+		},
+		{
+			name:   "quotes and sql stay inside the parameter",
+			build:  func(q *Query) { q.Select("1").Eq("service", "x' OR 1=1 --") },
+			sql:    "SELECT 1\nWHERE service = {p0:String}",
+			params: map[string]string{"p0": "x' OR 1=1 --"},
+		},
+		{
+			name:   "backslash tab and newline are escaped",
+			build:  func(q *Query) { q.Select("1").Eq("message", "a\\b\tc\nd") },
+			sql:    "SELECT 1\nWHERE message = {p0:String}",
+			params: map[string]string{"p0": `a\\b\tc\nd`},
+		},
+		{
+			name:   "empty filters add nothing",
+			build:  func(q *Query) { q.Select("1").From("spans").Filter("env", "").FilterIn("service", nil) },
+			sql:    "SELECT 1\nFROM spans",
+			params: map[string]string{},
+		},
+		{
+			name:   "single value filter in is an equality",
+			build:  func(q *Query) { q.Select("1").FilterIn("service", []string{"cart"}) },
+			sql:    "SELECT 1\nWHERE service = {p0:String}",
+			params: map[string]string{"p0": "cart"},
+		},
+		{
+			name:   "in binds every value",
+			build:  func(q *Query) { q.Select("1").In("version", []string{"v1", "v2"}) },
+			sql:    "SELECT 1\nWHERE version IN ({p0:String}, {p1:String})",
+			params: map[string]string{"p0": "v1", "p1": "v2"},
+		},
+		{
+			name:   "eq any reuses one parameter",
+			build:  func(q *Query) { q.Select("1").EqAny("checkout", "caller", "callee") },
+			sql:    "SELECT 1\nWHERE (caller = {p0:String} OR callee = {p0:String})",
+			params: map[string]string{"p0": "checkout"},
+		},
+		{
+			name: "time ranges are bound in utc",
+			build: func(q *Query) {
+				q.Select("1").TimeRange("ts", from, from.Add(time.Hour)).MinuteRange("bucket_ts", from, from)
+			},
+			sql: "SELECT 1\nWHERE ts >= {p0:DateTime64(3, 'UTC')} AND ts < {p1:DateTime64(3, 'UTC')}" +
+				" AND bucket_ts >= {p2:DateTime('UTC')} AND bucket_ts < {p3:DateTime('UTC')}",
+			params: map[string]string{"p0": "2026-01-01 09:00:00.000", "p1": "2026-01-01 10:00:00.000", "p2": "2026-01-01 09:00:00", "p3": "2026-01-01 09:00:00"},
+		},
+		{
+			name: "sub queries share the parameter numbering",
+			build: func(q *Query) {
+				sub := q.Sub().Select("trace_id").From("spans").Eq("service", "cart")
+				q.Select("count()").From("traces").Eq("env", "prod").InQuery("trace_id", sub)
+			},
+			sql:    "SELECT count()\nFROM traces\nWHERE env = {p1:String} AND trace_id IN (\n  SELECT trace_id\n  FROM spans\n  WHERE service = {p0:String}\n)",
+			params: map[string]string{"p0": "cart", "p1": "prod"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := New()
+			tc.build(q)
+			if got := q.SQL(); got != tc.sql {
+				t.Errorf("SQL:\n%s\nwant:\n%s", got, tc.sql)
+			}
+			got := q.Params()
+			if len(got) != len(tc.params) {
+				t.Fatalf("params = %v, want %v", got, tc.params)
+			}
+			for k, v := range tc.params {
+				if got[k] != v {
+					t.Errorf("param %s = %q, want %q", k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestCloneKeepsClausesApart(t *testing.T) {
+	base := New().Select("trace_id").From("traces").Eq("env", "prod")
+	c := base.Clone().Eq("service", "cart").Limit(5)
+	if strings.Contains(base.SQL(), "service") || strings.Contains(base.SQL(), "LIMIT") {
+		t.Fatalf("clone changed the original: %s", base.SQL())
+	}
+	if want := "SELECT trace_id\nFROM traces\nWHERE env = {p0:String} AND service = {p1:String}\nLIMIT 5"; c.SQL() != want {
+		t.Fatalf("clone SQL:\n%s\nwant:\n%s", c.SQL(), want)
+	}
+	if base.Cond() != "env = {p0:String}" || New().Cond() != "1" {
+		t.Fatalf("Cond = %q, %q", base.Cond(), New().Cond())
+	}
+}
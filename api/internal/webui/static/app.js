@@ -0,0 +1,358 @@
+"use strict";
+
+const view = document.getElementById("view");
+
+const lookbacks = [["15m", 15], ["1h", 60], ["6h", 360], ["24h", 1440], ["7d", 10080]];
+
+function el(tag, attrs, ...children) {
+  const node = document.createElement(tag);
+  for (const [k, v] of Object.entries(attrs || {})) {
+    if (v === undefined || v === null || v === false) continue;
+    if (k.startsWith("on")) node.addEventListener(k.slice(2), v);
+    else node.setAttribute(k, v === true ? "" : v);
+  }
+  for (const c of children.flat()) {
+    if (c === undefined || c === null || c === false) continue;
+    node.append(c instanceof Node ? c : String(c));
+  }
+  return node;
+}
+
+function svg(tag, attrs, ...children) {
+  const node = document.createElementNS("http://www.w3.org/2000/svg", tag);
+  for (const [k, v] of Object.entries(attrs || {})) node.setAttribute(k, v);
+  for (const c of children.flat()) node.append(c instanceof Node ? c : String(c));
+  return node;
+}
+
+function range(lookback) {
+  const minutes = (lookbacks.find(([name]) => name === lookback) || lookbacks[3])[1];
+  const to = new Date();
+  return { from: new Date(to - minutes * 60000).toISOString(), to: to.toISOString() };
+}
+
+async function api(path, params) {
+  const url = new URL("../v1/" + path, location.href);
+  for (const [k, v] of Object.entries(params || {})) {
+    if (v !== undefined && v !== "") url.searchParams.set(k, v);
+  }
+  const res = await fetch(url, { headers: { Accept: "application/json" } });
+  const body = await res.json().catch(() => ({}));
+  if (!res.ok) {
+    const err = body.error || {};
+    throw new Error((err.message || res.statusText) + (err.details && err.details.request_id ? " (request " + err.details.request_id + ")" : ""));
+  }
+  return body;
+}
+
+function num(v, digits) {
+  const n = Number(v);
+  if (!Number.isFinite(n)) return "";
+  return n.toLocaleString(undefined, { maximumFractionDigits: digits === undefined ? 1 : digits });
+}
+
+function pct(v) {
+  return num(Number(v) * 100, 2) + "%";
+}
+
+function table(columns, rows, rowAttrs) {
+  return el("table", null,
+    el("thead", null, el("tr", null, columns.map((c) => el("th", { class: c.num ? "num" : null }, c.title)))),
+    el("tbody", null, rows.map((row) => el("tr", rowAttrs ? rowAttrs(row) : null,
+      columns.map((c) => el("td", { class: c.num ? "num" : null }, c.render ? c.render(row) : row[c.key]))))));
+}
+
+function notice(text, isError) {
+  return el("div", { class: isError ? "notice error" : "notice" }, text);
+}
+
+function field(name, title, value, attrs) {
+  return el("label", null, title, el("input", Object.assign({ name, value: value || "" }, attrs)));
+}
+
+function lookbackField(value) {
+  return el("label", null, "Lookback",
+    el("select", { name: "lookback" }, lookbacks.map(([name]) => el("option", { value: name, selected: name === (value || "24h") }, name))));
+}
+
+function searchForm(fields, submitLabel) {
+  return el("form", {
+    onsubmit(ev) {
+      ev.preventDefault();
+      const next = new URLSearchParams();
+      for (const [k, v] of new FormData(ev.target)) if (v !== "") next.set(k, v);
+      location.hash = currentPath() + "?" + next.toString();
+    },
+  }, fields, el("button", { type: "submit" }, submitLabel || "Search"));
+}
+
+function currentPath() {
+  return location.hash.slice(1).split("?")[0] || "/traces";
+}
+
+async function load(target, work) {
+  target.replaceChildren(notice("Loading…"));
+  try {
+    target.replaceChildren(...[].concat(await work()));
+  } catch (err) {
+    target.replaceChildren(notice(err.message, true));
+  }
+}
+
+function traceLink(id) {
+  return el("a", { href: "#/trace/" + encodeURIComponent(id) }, id.length > 16 ? id.slice(0, 16) + "…" : id);
+}
+
+function tracesView(params) {
+  const results = el("div");
+  view.replaceChildren(
+    el("h2", null, "Traces"),
+    searchForm([
+      lookbackField(params.get("lookback")),
+      field("env", "Env", params.get("env")),
+      field("service", "Service", params.get("service")),
+      field("transaction", "Transaction", params.get("transaction")),
+      field("root_status_code", "Status", params.get("root_status_code"), { placeholder: "5xx" }),
+      field("label", "Labels", params.get("label")),
+      field("limit", "Limit", params.get("limit") || "100", { type: "number", min: "1" }),
+    ]),
+    results);
+  const q = Object.assign(range(params.get("lookback")), Object.fromEntries(params));
+  delete q.lookback;
+  load(results, async () => {
+    const res = await api("traces", q);
+    if (!res.data.length) return notice("No traces in this range.");
+    return [
+      el("p", { class: "muted" }, res.truncated ? `Showing ${res.data.length} of ${res.total} traces.` : `${res.data.length} traces.`),
+      table([
+        { title: "Start", key: "start_ts" },
+        { title: "Trace", render: (r) => traceLink(r.trace_id) },
+        { title: "Env", key: "env" },
+        { title: "Root", render: (r) => r.root_service + " " + r.root_operation },
+        { title: "Status", key: "root_status_code", num: true },
+        { title: "Transaction", key: "transaction" },
+        { title: "Duration ms", render: (r) => num(r.duration_ms), num: true },
+        { title: "Spans", key: "span_count", num: true },
+        { title: "Services", key: "service_count", num: true },
+        { title: "Errors", key: "error_count", num: true },
+      ], res.data, (r) => ({ class: Number(r.error_count) > 0 ? "error" : null })),
+    ];
+  });
+}
+
+function traceView(id) {
+  const results = el("div");
+  view.replaceChildren(el("h2", null, "Trace ", el("span", { class: "muted" }, id)), results);
+  load(results, async () => {
+    const res = await api("traces/" + encodeURIComponent(id) + "/waterfall", { links: "true" });
+    const t = res.trace || {};
+    const out = [
+      el("div", { class: "summary" },
+        [["Root", t.root_service + " " + t.root_operation], ["Env", t.env], ["Start", t.start_ts],
+          ["Duration", num(t.duration_ms) + " ms"], ["Spans", t.span_count], ["Errors", t.error_count],
+          ["Transaction", t.transaction]].map(([k, v]) => el("div", null, el("span", null, k), el("span", null, v))),
+        el("div", null, el("span", null, "Export"), el("span", null,
+          el("a", { href: "../v1/traces/" + encodeURIComponent(id) + "/render?format=svg", target: "_blank" }, "SVG"), " ",
+          el("a", { href: "../v1/traces/" + encodeURIComponent(id) + "/render?format=txt", target: "_blank" }, "Text")))),
+      waterfall(res.waterfall || []),
+    ];
+    if ((res.error_chains || []).length) {
+      out.push(el("h3", null, "Error chains"), table([
+        { title: "Span", key: "error_span_id" },
+        { title: "Path", render: (r) => r.path.join(" → ") },
+        { title: "Type", key: "error_type" },
+        { title: "Message", key: "error_message" },
+      ], res.error_chains));
+    }
+    if ((res.linked_traces || []).length) {
+      out.push(el("h3", null, "Linked traces"), table([
+        { title: "Trace", render: (r) => traceLink(r.trace_id) },
+        { title: "Root", render: (r) => (r.root_service || "") + " " + (r.root_operation || "") },
+        { title: "Duration ms", render: (r) => num(r.duration_ms), num: true },
+      ], res.linked_traces));
+    }
+    return out;
+  });
+}
+
+function waterfall(spans) {
+  const box = el("div", { class: "waterfall" });
+  for (const s of spans) {
+    const bar = el("div", { class: "span-bar" + (s.is_error ? " error" : s.is_critical ? " critical" : "") });
+    bar.style.left = s.left_pct + "%";
+    bar.style.width = s.width_pct + "%";
+    const name = el("div", { class: "span-name", title: s.operation }, s.service + " ", el("span", { class: "muted" }, s.operation));
+    name.style.paddingLeft = 8 + s.depth * 14 + "px";
+    const detail = el("div", { class: "span-detail", hidden: true },
+      [s.explanation, `span ${s.span_id} · ${s.host} · ${s.version} · status ${s.status}${s.status_code ? " " + s.status_code : ""}`,
+        s.error_message ? "error: " + (s.error_type ? s.error_type + ": " : "") + s.error_message : ""].filter(Boolean).join("\n"));
+    box.append(
+      el("div", { class: "span-row", onclick: () => { detail.hidden = !detail.hidden; } },
+        name, el("div", { class: "span-track" }, bar), el("div", { class: "span-time" }, num(s.duration_ms) + " ms")),
+      detail);
+  }
+  return box;
+}
+
+function dependencyView(params) {
+  const results = el("div");
+  view.replaceChildren(
+    el("h2", null, "Dependencies"),
+    searchForm([lookbackField(params.get("lookback")), field("env", "Env", params.get("env"))], "Show"),
+    results);
+  const q = Object.assign(range(params.get("lookback")), { env: params.get("env") || "" });
+  load(results, async () => {
+    const res = await api("dependency", q);
+    if (!res.edges.length) return notice("No calls between services in this range.");
+    return [
+      graph(res.nodes || [], res.edges),
+      el("h3", null, "Edges"),
+      table([
+        { title: "Caller", key: "caller_service" },
+        { title: "Callee", key: "callee_service" },
+        { title: "Calls", render: (r) => num(r.calls, 0), num: true },
+        { title: "Error rate", render: (r) => pct(r.error_rate), num: true },
+        { title: "Timeout rate", render: (r) => pct(r.timeout_rate), num: true },
+        { title: "p95 ms", render: (r) => num(r.p95_ms), num: true },
+        { title: "Health", render: (r) => healthBadge(r.health) },
+      ], res.edges),
+    ];
+  });
+}
+
+function healthBadge(h) {
+  if (!h) return "";
+  return el("span", { class: "badge status-" + h.status }, h.status + (h.status === "unknown" ? "" : " " + num(h.score, 0)));
+}
+
+function graph(nodes, edges) {
+  const health = new Map(nodes.map((n) => [n.service, n.health]));
+  const names = new Set();
+  for (const e of edges) names.add(e.caller_service).add(e.callee_service);
+  const layer = new Map([...names].map((n) => [n, 0]));
+  for (let i = 0; i < names.size; i++) {
+    let moved = false;
+    for (const e of edges) {
+      if (e.caller_service === e.callee_service) continue;
+      const next = layer.get(e.caller_service) + 1;
+      if (next > layer.get(e.callee_service) && next < names.size) {
+        layer.set(e.callee_service, next);
+        moved = true;
+      }
+    }
+    if (!moved) break;
+  }
+  const columns = [];
+  for (const name of [...names].sort()) {
+    const l = layer.get(name);
+    (columns[l] = columns[l] || []).push(name);
+  }
+  const nodeW = 150, nodeH = 34, gapX = 90, gapY = 22, pad = 20;
+  const pos = new Map();
+  columns.forEach((col, x) => (col || []).forEach((name, y) => pos.set(name, { x: pad + x * (nodeW + gapX), y: pad + y * (nodeH + gapY) })));
+  const width = pad * 2 + columns.length * (nodeW + gapX) - gapX;
+  const height = pad * 2 + Math.max(...columns.map((c) => (c || []).length)) * (nodeH + gapY) - gapY;
+  const root = svg("svg", { class: "graph", viewBox: `0 0 ${width} ${height}`, height });
+  root.append(svg("defs", null, svg("marker", { id: "arrow", viewBox: "0 0 10 10", refX: "10", refY: "5", markerWidth: "6", markerHeight: "6", orient: "auto" },
+    svg("path", { d: "M0,0 L10,5 L0,10 z", fill: "#9aa5b1" }))));
+  for (const e of edges) {
+    const a = pos.get(e.caller_service), b = pos.get(e.callee_service);
+    const x1 = a.x + nodeW, y1 = a.y + nodeH / 2, x2 = b.x, y2 = b.y + nodeH / 2;
+    const mid = (x1 + x2) / 2;
+    const status = (e.health && e.health.status) || "unknown";
+    root.append(svg("path", { class: "edge status-" + status, d: `M${x1},${y1} C${mid},${y1} ${mid},${y2} ${x2},${y2}`, "marker-end": "url(#arrow)" },
+      svg("title", null, `${e.caller_service} → ${e.callee_service}\n${num(e.calls, 0)} calls, ${pct(e.error_rate)} errors, p95 ${num(e.p95_ms)} ms`)));
+  }
+  for (const [name, p] of pos) {
+    const h = health.get(name);
+    const status = (h && h.status) || "unknown";
+    const label = name.length > 20 ? name.slice(0, 19) + "…" : name;
+    root.append(svg("g", null,
+      svg("rect", { class: "status-" + status, x: p.x, y: p.y, width: nodeW, height: nodeH, rx: 5 }),
+      svg("text", { x: p.x + nodeW / 2, y: p.y + nodeH / 2 + 4, "text-anchor": "middle" }, label),
+      svg("title", null, name + (h ? `\nhealth ${status} ${num(h.score, 0)}` : ""))));
+  }
+  return root;
+}
+
+function compareView(params) {
+  const results = el("div");
+  view.replaceChildren(
+    el("h2", null, "Compare versions"),
+    searchForm([
+      lookbackField(params.get("lookback")),
+      field("env", "Env", params.get("env")),
+      field("service", "Service", params.get("service"), { required: true }),
+      field("base", "Base version", params.get("base"), { required: true }),
+      field("cand", "Candidate version", params.get("cand"), { required: true }),
+    ], "Compare"),
+    results);
+  if (!params.get("service") || !params.get("base") || !params.get("cand")) {
+    results.replaceChildren(notice("Pick a service and two of its versions."));
+    return;
+  }
+  const q = Object.assign(range(params.get("lookback")), Object.fromEntries(params));
+  delete q.lookback;
+  load(results, async () => {
+    const res = await api("compare", q);
+    const out = [];
+    out.push(el("h3", null, "Anomalies"));
+    out.push((res.anomalies || []).length
+      ? table([
+        { title: "Level", render: (r) => el("span", { class: "badge " + r.level }, r.level) },
+        { title: "Anomaly", key: "title" },
+        { title: "Change", key: "message" },
+        { title: "Silenced", render: (r) => (r.silenced ? "maintenance " + r.maintenance_id : "") },
+      ], res.anomalies)
+      : notice("No latency, error or timeout anomalies."));
+    out.push(el("h3", null, "Versions"), table([
+      { title: "Version", key: "version" },
+      { title: "Spans", render: (r) => num(r.spans, 0), num: true },
+      { title: "p50 ms", render: (r) => num(r.p50_ms), num: true },
+      { title: "p95 ms", render: (r) => num(r.p95_ms), num: true },
+      { title: "p99 ms", render: (r) => num(r.p99_ms), num: true },
+      { title: "Error rate", render: (r) => pct(r.error_rate), num: true },
+      { title: "Timeout rate", render: (r) => pct(r.timeout_rate), num: true },
+    ], res.metrics || []));
+    if ((res.root_causes || []).length) {
+      out.push(el("h3", null, "Likely root causes"), table([
+        { title: "Service", key: "service" },
+        { title: "Score", render: (r) => num(r.score, 3), num: true },
+        { title: "Reason", key: "reason" },
+      ], res.root_causes));
+    }
+    if ((res.operation_diff || []).length) {
+      out.push(el("h3", null, "Operations by p95 change"), table([
+        { title: "Operation", key: "operation" },
+        { title: "Base p95 ms", render: (r) => num(r.base_p95_ms), num: true },
+        { title: "Candidate p95 ms", render: (r) => num(r.cand_p95_ms), num: true },
+        { title: "Delta ms", render: (r) => num(r.delta_p95_ms), num: true },
+        { title: "Base calls", render: (r) => num(r.base_calls, 0), num: true },
+        { title: "Candidate calls", render: (r) => num(r.cand_calls, 0), num: true },
+      ], res.operation_diff));
+    }
+    return out;
+  });
+}
+
+function route() {
+  const [path, query] = location.hash.slice(1).split("?");
+  const params = new URLSearchParams(query || "");
+  const parts = (path || "/traces").split("/").filter(Boolean);
+  for (const a of document.querySelectorAll("header nav a")) {
+    a.classList.toggle("active", a.dataset.view === (parts[0] === "trace" ? "traces" : parts[0]));
+  }
+  switch (parts[0]) {
+    case "trace":
+      return traceView(decodeURIComponent(parts.slice(1).join("/")));
+    case "dependency":
+      return dependencyView(params);
+    case "compare":
+      return compareView(params);
+    default:
+      return tracesView(params);
+  }
+}
+
+window.addEventListener("hashchange", route);
+route();
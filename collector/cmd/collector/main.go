@@ -8,56 +8,190 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"flag"
+	"fmt"
 	"log"
 	"math/big"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"trace-lite/collector/internal/clickhouse"
 	"trace-lite/collector/internal/config"
+	"trace-lite/collector/internal/federation"
 	"trace-lite/collector/internal/reconstruct"
+	"trace-lite/collector/internal/redisstream"
+	"trace-lite/collector/internal/relay"
 	"trace-lite/collector/internal/server"
+	"trace-lite/collector/internal/spool"
+	"trace-lite/collector/internal/webhook"
 )
 
 func main() {
-	cfg := config.Load()
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "TOML config file; environment variables override it")
+	checkConfig := flag.Bool("check-config", false, "print the effective configuration, validate it and exit")
+	flag.Parse()
+	cfg := config.MustLoad(*configPath, *checkConfig)
 	ch := clickhouse.NewClient(cfg.ClickHouseDSN, cfg.ClickHouseDB)
-	recon := reconstruct.New(ch, cfg.TraceWindow, cfg.FlushInterval)
-	h := server.NewHandler(cfg.IngestToken, ch, recon)
+	ch.SetCredentials(cfg.ClickHouseUser, cfg.ClickHousePass)
+	ch.SetCompression(cfg.ClickHouseCompress)
+	ch.SetSchemaDir(cfg.SchemaDir)
+	if err := ch.SetSkipIndexes(cfg.SkipIndexes); err != nil {
+		log.Fatalf("SKIP_INDEXES: %v", err)
+	}
+	if cfg.IngestBuffer != "relay" {
+		prepareClickHouse(ch, cfg)
+	}
+	recon := reconstruct.New(ch, cfg.TraceWindow, cfg.FlushInterval, cfg.MaxSpansPerTrace, cfg.TransactionAttr)
+	recon.SetFlushWorkers(cfg.FlushWorkers)
+	recon.SetOverrides(windowOverrides(cfg.WindowOverrides))
+	recon.SetMaxAge(cfg.TraceMaxAge)
+	recon.SetErrorRules(cfg.ErrorRules)
+	recon.SetClientTimeouts(cfg.ClientTimeouts)
+	recon.SetLabels(cfg.TraceLabels)
+	recon.SetProxies(cfg.ProxyServices, cfg.ProxyMode)
+	recon.SetInternalEdges(cfg.InternalEdges, cfg.ModuleAttr)
+	recon.SetQueueAttrs(cfg.QueueAttrs)
+	recon.SetConflictPolicy(cfg.SpanConflicts)
+	recon.SetRetention(cfg.RetentionTiers)
+	recon.SetPriorityServices(cfg.PriorityServices)
+	if cfg.TraceMerge == "partials" {
+		recon.SetPartials(cfg.CollectorID)
+	}
+
+	var producer *redisstream.Producer
+	var consumer *redisstream.Consumer
+	if cfg.IngestBuffer == "redis" {
+		producer = redisstream.NewProducer(redisstream.NewClient(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB), cfg.RedisStream, cfg.RedisGroup, cfg.RedisMaxLen)
+	}
+	h := server.NewHandler(cfg, ch, recon, producer)
+	hooks, err := webhook.New(cfg.WebhookAllowHosts)
+	if err != nil {
+		log.Fatalf("trace webhooks: %v", err)
+	}
+	hooks.Set(loadWebhooks(hooks, cfg.TraceWebhooksFile))
+	recon.SetOnFinalize(hooks.Finalized)
+	h.SetWebhooks(hooks)
+	if cfg.Federation == "forward" {
+		peers := federation.New(cfg.FederationRegion, cfg.FederationPeers, cfg.FederationToken)
+		recon.SetFederation(peers)
+		h.SetFederation(peers, cfg.FederationToken)
+		log.Printf("federation: region %s forwards cross-region traces to their owner among %v", cfg.FederationRegion, peers.Stats().Regions)
+	}
+	var relaySpool *spool.Spool
+	if cfg.IngestBuffer == "relay" {
+		var err error
+		if relaySpool, err = spool.Open(cfg.RelaySpoolDir, cfg.RelaySpoolMax); err != nil {
+			log.Fatalf("relay spool: %v", err)
+		}
+		h.SetRelay(relaySpool)
+	}
+	var overflow *spool.Spool
+	if cfg.OverflowDir != "" {
+		var err error
+		if overflow, err = spool.Open(cfg.OverflowDir, cfg.OverflowMax); err != nil {
+			log.Fatalf("overflow spool: %v", err)
+		}
+		overflow.SetMaxAge(cfg.OverflowMaxAge)
+		h.SetOverflow(overflow)
+	}
+	if producer != nil && cfg.RedisConsume {
+		consumer = redisstream.NewConsumer(redisstream.NewClient(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB), cfg.RedisStream, cfg.RedisGroup, cfg.RedisConsumer, h.StoreBuffered)
+		consumer.SetClaimIdle(cfg.RedisClaimIdle)
+		consumer.SetExclusive(cfg.ReconstructMode != "off" && cfg.TraceMerge != "partials")
+	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/v1/healthz", h.Healthz)
+	mux.HandleFunc("/livez", h.Livez)
+	mux.HandleFunc("/readyz", h.Readyz)
+	mux.HandleFunc("/v1/healthz", h.Readyz)
 	mux.HandleFunc("/v1/ingest/logs", h.IngestLogs)
+	mux.HandleFunc("/v2/ingest/logs", h.IngestLogs)
+	mux.HandleFunc("/v1/ingest/validate", h.IngestLogs)
+	mux.HandleFunc("/v1/ingest/diagnose", h.Diagnose)
+	mux.HandleFunc("/v1/ingest/rum", h.IngestRUM)
+	mux.HandleFunc("/v1/admin/reconstructor/traces", h.AdminTraces)
+	mux.HandleFunc("/v1/admin/reconstructor/flush", h.AdminFlush)
+	mux.HandleFunc("/v1/admin/reconstructor/windows", h.AdminWindows)
+	mux.HandleFunc("/v1/admin/reconstructor/error-rules", h.AdminErrorRules)
+	mux.HandleFunc("/v1/admin/reconstructor/webhooks", h.AdminWebhooks)
+	mux.HandleFunc("/v1/admin/ingest/drop-rules", h.AdminDropRules)
+	mux.HandleFunc("/v1/admin/purge", h.AdminPurge)
+	mux.HandleFunc("/v1/admin/schema/indexes", h.AdminSkipIndexes)
+	mux.HandleFunc("/v1/admin/ingest/rejected", h.AdminRejected)
+	mux.HandleFunc("/v1/admin/ingest/rejected/replay", h.AdminReplayRejected)
+	mux.HandleFunc("/v1/admin/ingest/latency", h.AdminIngestLatency)
+	mux.HandleFunc("/v1/admin/federation", h.AdminFederation)
+	mux.HandleFunc(federation.SpansPath, h.FederationSpans)
+	mux.HandleFunc(federation.ClaimsPath, h.FederationClaims)
+	h.SetRelayAcceptToken(cfg.RelayAcceptToken)
+	mux.HandleFunc(relay.BatchesPath, h.RelayBatches)
 
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(cfg.HTTP2)
 	srv := &http.Server{
 		Addr:              cfg.Addr,
 		Handler:           mux,
-		ReadHeaderTimeout: 10 * time.Second,
-		ReadTimeout:       30 * time.Second,
-		WriteTimeout:      30 * time.Second,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		Protocols:         protocols,
+		HTTP2: &http.HTTP2Config{
+			MaxConcurrentStreams: cfg.H2MaxStreams,
+			PingTimeout:          cfg.H2PingTimeout,
+		},
 	}
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	go recon.Run(ctx)
+	if cfg.ReconstructMode != "off" {
+		go recon.Run(ctx)
+		go recon.RunRetention(ctx, cfg.RetentionEvery)
+		go recon.RunTraceMerge(ctx, cfg.TraceMergeEvery, cfg.TraceMergeDelay)
+	} else {
+		log.Printf("in-memory reconstruction disabled; spans and traces come from ClickHouse materialized views")
+	}
+	go h.RunOTLPExport(ctx, cfg.OTLPEndpoint, cfg.OTLPHeaders, cfg.OTLPInterval)
+	if config.HasSecrets() && cfg.SecretsRefresh > 0 {
+		go refreshSecrets(ctx, cfg.SecretsRefresh, ch, h)
+	}
+	if relaySpool != nil {
+		go relaySpool.Drain(ctx, cfg.RelayBackoffMax, relay.New(cfg.RelayUpstream, cfg.RelayToken).Send)
+		log.Printf("relaying ingest to %s through spool %s (%d batches pending)", cfg.RelayUpstream, cfg.RelaySpoolDir, relaySpool.Stats().Files)
+	}
+	if overflow != nil {
+		go overflow.Drain(ctx, time.Minute, h.StoreBatch)
+		log.Printf("overflow spool %s (%d batches pending)", cfg.OverflowDir, overflow.Stats().Files)
+	}
+	if consumer != nil {
+		go consumer.Run(ctx)
+		log.Printf("redis stream consumer %s reading %s (group %s)", cfg.RedisConsumer, cfg.RedisStream, cfg.RedisGroup)
+	}
 
 	ln, err := net.Listen("tcp", cfg.Addr)
 	if err != nil {
 		log.Fatalf("listen: %v", err)
 	}
+	if cfg.MaxConns > 0 {
+		ln = newLimitListener(ln, cfg.MaxConns)
+	}
 
-	cert, err := loadOrCreateCert(cfg)
+	tlsCfg, err := buildTLSConfig(cfg)
 	if err != nil {
 		log.Fatalf("tls cert: %v", err)
 	}
 
-	tlsLn := tls.NewListener(ln, &tls.Config{Certificates: []tls.Certificate{cert}})
-	log.Printf("collector listening https://0.0.0.0%s", cfg.Addr)
+	tlsLn := tls.NewListener(ln, tlsCfg)
+	log.Printf("collector listening https://0.0.0.0%s (h2=%t max_conns=%d)", cfg.Addr, cfg.HTTP2, cfg.MaxConns)
 
 	go func() {
 		if err := srv.Serve(tlsLn); err != nil && err != http.ErrServerClosed {
@@ -65,11 +199,138 @@ func main() {
 		}
 	}()
 
+	if cfg.UDSPath != "" {
+		udsLn, err := listenUnix(cfg.UDSPath, cfg.UDSMode)
+		if err != nil {
+			log.Fatalf("listen unix: %v", err)
+		}
+		log.Printf("collector listening http+unix://%s", cfg.UDSPath)
+		go func() {
+			if err := srv.Serve(udsLn); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("serve unix: %v", err)
+			}
+		}()
+	}
+
 	<-ctx.Done()
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownCancel()
 	_ = srv.Shutdown(shutdownCtx)
 	recon.FlushNow(shutdownCtx)
+	hooks.Close(shutdownCtx)
+}
+
+func windowOverrides(list []config.WindowOverride) reconstruct.Overrides {
+	o := reconstruct.Overrides{Env: map[string]reconstruct.Tuning{}, Service: map[string]reconstruct.Tuning{}}
+	for _, w := range list {
+		t := reconstruct.Tuning{Window: w.Window, Flush: w.Flush}
+		if w.Scope == "service" {
+			o.Service[w.Name] = t
+		} else {
+			o.Env[w.Name] = t
+		}
+	}
+	return o
+}
+
+func loadWebhooks(d *webhook.Dispatcher, path string) []webhook.Hook {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	list, err := d.Load(ctx, path)
+	if err != nil {
+		log.Fatalf("trace webhooks: %v", err)
+	}
+	if len(list) > 0 {
+		log.Printf("trace webhooks: %d registered from %s", len(list), path)
+	}
+	return list
+}
+
+func prepareClickHouse(ch *clickhouse.Client, cfg config.Config) {
+	ctx := context.Background()
+	if cfg.StartupWait > 0 {
+		if err := ch.WaitReady(ctx, cfg.StartupWait); err != nil {
+			log.Fatalf("startup: %v", err)
+		}
+	}
+	if cfg.SchemaDir != "" {
+		if err := ch.ApplySchema(ctx); err != nil {
+			if cfg.StartupWait > 0 {
+				log.Fatalf("startup: apply schema: %v", err)
+			}
+			log.Printf("startup: apply schema: %v (will retry when a table is missing)", err)
+		}
+	}
+}
+
+func refreshSecrets(ctx context.Context, every time.Duration, ch *clickhouse.Client, h *server.Handler) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		next := config.Load()
+		if problems := config.Problems(); len(problems) > 0 {
+			log.Printf("secrets refresh skipped: %s", strings.Join(problems, "; "))
+			continue
+		}
+		ch.SetCredentials(next.ClickHouseUser, next.ClickHousePass)
+		h.SetTokens(next.IngestTokens, next.AdminToken)
+	}
+}
+
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+func buildTLSConfig(cfg config.Config) (*tls.Config, error) {
+	cert, err := loadOrCreateCert(cfg)
+	if err != nil {
+		return nil, err
+	}
+	tlsCfg := &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"http/1.1"}}
+	if cfg.HTTP2 {
+		tlsCfg.NextProtos = []string{"h2", "http/1.1"}
+	}
+	if len(cfg.VHosts) == 0 {
+		return tlsCfg, nil
+	}
+
+	certs := map[string]*tls.Certificate{}
+	for _, vh := range cfg.VHosts {
+		c, err := tls.LoadX509KeyPair(vh.CertFile, vh.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("vhost %s: %w", vh.Host, err)
+		}
+		certs[vh.Host] = &c
+		log.Printf("tls vhost %s (env=%q tenant=%q)", vh.Host, vh.Env, vh.Tenant)
+	}
+	tlsCfg.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if vh, ok := config.MatchVHost(cfg.VHosts, hello.ServerName); ok {
+			return certs[vh.Host], nil
+		}
+		return nil, nil
+	}
+	return tlsCfg, nil
 }
 
 func loadOrCreateCert(cfg config.Config) (tls.Certificate, error) {
@@ -113,3 +374,34 @@ func generateSelfSigned() (tls.Certificate, error) {
 	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
 	return tls.X509KeyPair(certPEM, keyPEM)
 }
+
+type limitListener struct {
+	net.Listener
+	sem chan struct{}
+}
+
+func newLimitListener(ln net.Listener, n int) net.Listener {
+	return &limitListener{Listener: ln, sem: make(chan struct{}, n)}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	l.sem <- struct{}{}
+	c, err := l.Listener.Accept()
+	if err != nil {
+		<-l.sem
+		return nil, err
+	}
+	return &limitConn{Conn: c, release: func() { <-l.sem }}, nil
+}
+
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"regexp"
+	"syscall"
+	"time"
+
+	"trace-lite/collector/internal/clickhouse"
+	"trace-lite/collector/internal/config"
+	"trace-lite/collector/internal/fieldcrypt"
+	"trace-lite/collector/internal/model"
+	"trace-lite/collector/internal/reconstruct"
+)
+
+var safeToken = regexp.MustCompile(`^[a-zA-Z0-9._:/-]+$`)
+
+const stagingEdges = "dependency_edges_rebuild"
+
+type stats struct {
+	rows   int
+	traces int
+	spans  int
+	edges  int
+}
+
+func main() {
+	fromFlag := flag.String("from", "", "start of range (RFC3339, required)")
+	toFlag := flag.String("to", "", "end of range (RFC3339, required)")
+	env := flag.String("env", "", "only rebuild this env")
+	slack := flag.Duration("slack", time.Hour, "how far outside the range to read raw logs of traces that touch it")
+	batch := flag.Int("batch", 2000, "traces per insert batch")
+	dryRun := flag.Bool("dry-run", false, "reconstruct and report counts without deleting or writing")
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "TOML config file; environment variables override it")
+	flag.Parse()
+
+	from, err := time.Parse(time.RFC3339, *fromFlag)
+	if err != nil {
+		log.Fatalf("invalid -from: %v", err)
+	}
+	to, err := time.Parse(time.RFC3339, *toFlag)
+	if err != nil {
+		log.Fatalf("invalid -to: %v", err)
+	}
+	if !from.Equal(from.Truncate(time.Minute)) || !to.Equal(to.Truncate(time.Minute)) {
+		log.Fatalf("-from and -to must be whole minutes, because dependency edges are rebuilt per minute bucket")
+	}
+	if !from.Before(to) {
+		log.Fatalf("-from must be before -to")
+	}
+	if *env != "" && !safeToken.MatchString(*env) {
+		log.Fatalf("invalid -env")
+	}
+
+	cfg := config.MustLoad(*configPath, false)
+	ch := clickhouse.NewClient(cfg.ClickHouseDSN, cfg.ClickHouseDB)
+	ch.SetCredentials(cfg.ClickHouseUser, cfg.ClickHousePass)
+	ch.SetCompression(cfg.ClickHouseCompress)
+	recon := reconstruct.New(ch, cfg.TraceWindow, cfg.FlushInterval, cfg.MaxSpansPerTrace, cfg.TransactionAttr)
+	recon.SetFlushWorkers(cfg.FlushWorkers)
+	recon.SetErrorRules(cfg.ErrorRules)
+	recon.SetClientTimeouts(cfg.ClientTimeouts)
+	recon.SetLabels(cfg.TraceLabels)
+	recon.SetProxies(cfg.ProxyServices, cfg.ProxyMode)
+	recon.SetInternalEdges(cfg.InternalEdges, cfg.ModuleAttr)
+	recon.SetQueueAttrs(cfg.QueueAttrs)
+	recon.SetConflictPolicy(cfg.SpanConflicts)
+	recon.SetRetention(cfg.RetentionTiers)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	rangeCond := fmt.Sprintf("ts >= toDateTime64('%s', 3, 'UTC') AND ts < toDateTime64('%s', 3, 'UTC')",
+		model.FormatCHTime(from), model.FormatCHTime(to))
+	if *env != "" {
+		rangeCond += fmt.Sprintf(" AND env = '%s'", *env)
+	}
+	traceIDs := "SELECT DISTINCT trace_id FROM raw_logs WHERE " + rangeCond
+
+	var cutoff string
+	if !*dryRun {
+		if cutoff, err = serverNow(ctx, ch); err != nil {
+			log.Fatalf("read clickhouse clock: %v", err)
+		}
+		if err := prepareStaging(ctx, ch); err != nil {
+			log.Fatalf("prepare %s: %v", stagingEdges, err)
+		}
+	}
+
+	query := fmt.Sprintf(`
+SELECT ts, service, env, host, region, zone, version, level, message, trace_id, span_id, parent_span_id, event, route, method, status_code, duration_ms, attrs, raw_json, retain_days
+FROM raw_logs
+WHERE trace_id IN (%s)
+  AND ts >= toDateTime64('%s', 3, 'UTC') AND ts < toDateTime64('%s', 3, 'UTC')
+ORDER BY trace_id, ts`, traceIDs, model.FormatCHTime(from.Add(-*slack)), model.FormatCHTime(to.Add(*slack)))
+
+	var st stats
+	var rows []model.RawLogRow
+	var times []time.Time
+	currentTrace := ""
+	tracesInBatch := 0
+	flush := func() error {
+		if len(rows) == 0 {
+			return nil
+		}
+		spans, traces, edges := recon.Preview(rows, times)
+		edges = edgesInRange(edges, from, to)
+		st.spans += len(spans)
+		st.traces += len(traces)
+		st.edges += len(edges)
+		rows, times, tracesInBatch = rows[:0], times[:0], 0
+		if *dryRun {
+			return nil
+		}
+		if err := ch.InsertJSONEachRow(ctx, "spans", spans); err != nil {
+			return err
+		}
+		if err := ch.InsertJSONEachRow(ctx, "traces", traces); err != nil {
+			return err
+		}
+		return ch.InsertJSONEachRow(ctx, stagingEdges, edges)
+	}
+
+	err = ch.QueryEachRow(ctx, query, func(line []byte) error {
+		var row model.RawLogRow
+		if err := json.Unmarshal(line, &row); err != nil {
+			return fmt.Errorf("decode raw row: %w", err)
+		}
+		ts, err := model.ParseCHTime(row.TS)
+		if err != nil {
+			return fmt.Errorf("row ts %q: %w", row.TS, err)
+		}
+		if err := openRow(cfg.Encryption, &row); err != nil {
+			return fmt.Errorf("trace %s: %w", row.TraceID, err)
+		}
+		if row.TraceID != currentTrace {
+			if tracesInBatch >= *batch {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+			currentTrace = row.TraceID
+			tracesInBatch++
+		}
+		rows = append(rows, row)
+		times = append(times, ts)
+		st.rows++
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		log.Fatalf("rebuild failed after %d raw rows: %v; the previous derived rows are still in place", st.rows, err)
+	}
+	if !*dryRun {
+		log.Printf("replacing derived rows for traces in [%s, %s)", from.Format(time.RFC3339), to.Format(time.RFC3339))
+		if err := replaceDerived(ctx, ch, traceIDs, cutoff, from, to, *env); err != nil {
+			log.Fatalf("replace failed: %v; rerun the rebuild for this range", err)
+		}
+	}
+
+	mode := "rebuilt"
+	if *dryRun {
+		mode = "dry run"
+	}
+	log.Printf("%s: %d raw rows -> %d traces, %d spans, %d edge rows", mode, st.rows, st.traces, st.spans, st.edges)
+}
+
+func serverNow(ctx context.Context, ch *clickhouse.Client) (string, error) {
+	var now string
+	err := ch.QueryEachRow(ctx, "SELECT toString(now64(3, 'UTC')) AS now", func(line []byte) error {
+		var row struct {
+			Now string `json:"now"`
+		}
+		if err := json.Unmarshal(line, &row); err != nil {
+			return err
+		}
+		now = row.Now
+		return nil
+	})
+	if err == nil && now == "" {
+		err = fmt.Errorf("no rows")
+	}
+	return now, err
+}
+
+func prepareStaging(ctx context.Context, ch *clickhouse.Client) error {
+	if err := ch.Exec(ctx, "DROP TABLE IF EXISTS "+stagingEdges, nil); err != nil {
+		return err
+	}
+	return ch.Exec(ctx, "CREATE TABLE "+stagingEdges+" AS dependency_edges_minute", nil)
+}
+
+func replaceDerived(ctx context.Context, ch *clickhouse.Client, traceIDs, cutoff string, from, to time.Time, env string) error {
+	settings := url.Values{}
+	settings.Set("mutations_sync", "1")
+	for _, table := range []string{"spans", "traces"} {
+		stmt := fmt.Sprintf("ALTER TABLE %s DELETE WHERE trace_id IN (%s) AND updated_at < toDateTime64('%s', 3, 'UTC')", table, traceIDs, cutoff)
+		if err := ch.Exec(ctx, stmt, settings); err != nil {
+			return fmt.Errorf("%s: %w", table, err)
+		}
+	}
+	edgeCond := fmt.Sprintf("bucket_ts >= toDateTime('%s', 'UTC') AND bucket_ts < toDateTime('%s', 'UTC')",
+		from.UTC().Format("2006-01-02 15:04:00"), to.UTC().Format("2006-01-02 15:04:00"))
+	if env != "" {
+		edgeCond += fmt.Sprintf(" AND env = '%s'", env)
+	}
+	if err := ch.Exec(ctx, "ALTER TABLE dependency_edges_minute DELETE WHERE "+edgeCond, settings); err != nil {
+		return fmt.Errorf("dependency_edges_minute: %w", err)
+	}
+	if err := ch.Exec(ctx, "INSERT INTO dependency_edges_minute SELECT * FROM "+stagingEdges, nil); err != nil {
+		return fmt.Errorf("dependency_edges_minute: %w", err)
+	}
+	return ch.Exec(ctx, "DROP TABLE IF EXISTS "+stagingEdges, nil)
+}
+
+func openRow(ring *fieldcrypt.Keyring, row *model.RawLogRow) error {
+	raw, err := ring.Open("raw_json", row.RawJSON)
+	if err != nil {
+		return err
+	}
+	row.RawJSON = raw
+	for k, v := range row.Attrs {
+		if !fieldcrypt.IsSealed(v) {
+			continue
+		}
+		if row.Attrs[k], err = ring.Open(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func edgesInRange(edges []model.DependencyEdgeRow, from, to time.Time) []model.DependencyEdgeRow {
+	out := edges[:0]
+	for _, e := range edges {
+		ts, err := time.Parse("2006-01-02 15:04:05", e.BucketTS)
+		if err != nil {
+			continue
+		}
+		if !ts.Before(from) && ts.Before(to) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
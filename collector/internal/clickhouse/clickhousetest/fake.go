@@ -0,0 +1,207 @@
+package clickhousetest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"trace-lite/collector/internal/clickhouse"
+)
+
+type Exec struct {
+	Query    string
+	Settings url.Values
+}
+
+type Insert struct {
+	Table string
+	Rows  []map[string]any
+	Token string
+}
+
+type Fake struct {
+	PingErr error
+	Managed bool
+	mu      sync.Mutex
+	rules   []rule
+	execs   []Exec
+	queries []string
+	inserts []Insert
+	indexes []string
+}
+
+type rule struct {
+	match string
+	lines [][]byte
+	err   error
+}
+
+func New() *Fake {
+	return &Fake{}
+}
+
+func (f *Fake) On(match string, rows ...any) *Fake {
+	lines := make([][]byte, len(rows))
+	for i, row := range rows {
+		b, err := json.Marshal(row)
+		if err != nil {
+			panic(fmt.Sprintf("clickhousetest: rows for %q: %v", match, err))
+		}
+		lines[i] = b
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append(f.rules, rule{match: match, lines: lines})
+	return f
+}
+
+func (f *Fake) Fail(match string, err error) *Fake {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append(f.rules, rule{match: match, err: err})
+	return f
+}
+
+func (f *Fake) match(query string) (rule, bool) {
+	for _, r := range f.rules {
+		if strings.Contains(query, r.match) {
+			return r, true
+		}
+	}
+	return rule{}, false
+}
+
+func (f *Fake) InsertJSONEachRow(ctx context.Context, table string, rows any) error {
+	return f.InsertJSONEachRowDedup(ctx, table, rows, "")
+}
+
+func (f *Fake) InsertJSONEachRowDedup(ctx context.Context, table string, rows any, token string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r, ok := f.match(table); ok && r.err != nil {
+		return r.err
+	}
+	list, err := decodeRows(rows)
+	if err != nil {
+		return err
+	}
+	f.inserts = append(f.inserts, Insert{Table: table, Rows: list, Token: token})
+	return nil
+}
+
+func (f *Fake) Exec(ctx context.Context, query string, settings url.Values) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	copied := url.Values{}
+	for k, v := range settings {
+		copied[k] = append([]string(nil), v...)
+	}
+	f.execs = append(f.execs, Exec{Query: strings.TrimSpace(query), Settings: copied})
+	if r, ok := f.match(query); ok {
+		return r.err
+	}
+	return nil
+}
+
+func (f *Fake) QueryEachRow(ctx context.Context, query string, fn func([]byte) error) error {
+	f.mu.Lock()
+	f.queries = append(f.queries, strings.TrimSpace(query))
+	r, _ := f.match(query)
+	f.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	for _, line := range r.lines {
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Fake) Ping(ctx context.Context) error {
+	return f.PingErr
+}
+
+func (f *Fake) SchemaManaged() bool {
+	return f.Managed
+}
+
+func (f *Fake) SetSkipIndexes(names []string) error {
+	for _, name := range names {
+		if _, ok := clickhouse.LookupSkipIndex(name); !ok {
+			return fmt.Errorf("unknown skipping index %q", name)
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.indexes = append([]string(nil), names...)
+	return nil
+}
+
+func (f *Fake) ApplySkipIndexes(ctx context.Context, materialize bool) error {
+	return nil
+}
+
+func (f *Fake) SkipIndexStates(ctx context.Context) ([]clickhouse.SkipIndexState, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]clickhouse.SkipIndexState, 0, len(clickhouse.SkipIndexes))
+	for _, ix := range clickhouse.SkipIndexes {
+		out = append(out, clickhouse.SkipIndexState{SkipIndex: ix, Index: ix.Index(), Enabled: slices.Contains(f.indexes, ix.Name)})
+	}
+	return out, nil
+}
+
+func (f *Fake) Execs() []Exec {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Exec(nil), f.execs...)
+}
+
+func (f *Fake) Queries() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.queries...)
+}
+
+func (f *Fake) Inserts() []Insert {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Insert(nil), f.inserts...)
+}
+
+func decodeRows(rows any) ([]map[string]any, error) {
+	val := reflect.ValueOf(rows)
+	if val.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("clickhousetest: rows must be a slice, got %T", rows)
+	}
+	list := make([]map[string]any, 0, val.Len())
+	for i := 0; i < val.Len(); i++ {
+		item := val.Index(i)
+		var line []byte
+		if a, ok := item.Addr().Interface().(clickhouse.JSONAppender); ok {
+			line = a.AppendJSON(nil)
+		} else {
+			b, err := json.Marshal(item.Interface())
+			if err != nil {
+				return nil, err
+			}
+			line = b
+		}
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.UseNumber()
+		var row map[string]any
+		if err := dec.Decode(&row); err != nil {
+			return nil, err
+		}
+		list = append(list, row)
+	}
+	return list, nil
+}
@@ -1,32 +1,84 @@
 package clickhouse
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 )
 
 type Client struct {
-	baseURL    string
-	database   string
-	httpClient *http.Client
+	baseURL     string
+	database    string
+	httpClient  *http.Client
+	schemaDir   string
+	healMu      sync.Mutex
+	lastHeal    time.Time
+	credMu      sync.RWMutex
+	user        string
+	password    string
+	indexMu     sync.Mutex
+	skipIndexes []string
+	compress    bool
+}
+
+type Interface interface {
+	Ping(ctx context.Context) error
+	InsertJSONEachRow(ctx context.Context, table string, rows any) error
+	InsertJSONEachRowDedup(ctx context.Context, table string, rows any, token string) error
+	Exec(ctx context.Context, query string, settings url.Values) error
+	QueryEachRow(ctx context.Context, query string, fn func([]byte) error) error
+	SchemaManaged() bool
+	SetSkipIndexes(names []string) error
+	ApplySkipIndexes(ctx context.Context, materialize bool) error
+	SkipIndexStates(ctx context.Context) ([]SkipIndexState, error)
 }
 
 func NewClient(baseURL, database string) *Client {
-	return &Client{
+	c := &Client{
 		baseURL:  strings.TrimRight(baseURL, "/"),
 		database: database,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
 	}
+	c.httpClient = &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: authTransport{client: c},
+	}
+	return c
+}
+
+func (c *Client) SetCredentials(user, password string) {
+	c.credMu.Lock()
+	defer c.credMu.Unlock()
+	c.user, c.password = user, password
+}
+
+func (c *Client) SetCompression(on bool) {
+	c.compress = on
+}
+
+type authTransport struct {
+	client *Client
+}
+
+func (t authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.client.credMu.RLock()
+	user, password := t.client.user, t.client.password
+	t.client.credMu.RUnlock()
+	if user != "" {
+		req = req.Clone(req.Context())
+		req.SetBasicAuth(user, password)
+	}
+	return http.DefaultTransport.RoundTrip(req)
 }
 
 func (c *Client) Ping(ctx context.Context) error {
@@ -47,18 +99,52 @@ func (c *Client) Ping(ctx context.Context) error {
 }
 
 func (c *Client) InsertJSONEachRow(ctx context.Context, table string, rows any) error {
-	payload, err := toNDJSON(rows)
-	if err != nil {
-		return err
+	return c.insert(ctx, table, rows, nil)
+}
+
+func (c *Client) InsertJSONEachRowDedup(ctx context.Context, table string, rows any, token string) error {
+	params := url.Values{}
+	if token != "" {
+		params.Set("insert_deduplication_token", token)
 	}
-	if len(payload) == 0 {
+	return c.insert(ctx, table, rows, params)
+}
+
+func (c *Client) insert(ctx context.Context, table string, rows any, params url.Values) error {
+	if rowCount(rows) == 0 {
 		return nil
 	}
+	err := c.insertRows(ctx, table, rows, params)
+	if isMissingSchema(err) && c.healSchema(ctx) {
+		err = c.insertRows(ctx, table, rows, params)
+	}
+	return err
+}
 
-	query := fmt.Sprintf("INSERT INTO %s.%s FORMAT JSONEachRow", c.database, table)
-	insertURL := fmt.Sprintf("%s/?query=%s", c.baseURL, url.QueryEscape(query))
+func (c *Client) insertRows(ctx context.Context, table string, rows any, params url.Values) error {
+	pr, pw := io.Pipe()
+	encoded := make(chan error, 1)
+	go func() {
+		err := writeNDJSON(pw, rows)
+		pw.CloseWithError(err)
+		encoded <- err
+	}()
+	err := c.insertPayload(ctx, table, pr, params)
+	pr.Close()
+	if encErr := <-encoded; encErr != nil && !errors.Is(encErr, io.ErrClosedPipe) {
+		return encErr
+	}
+	return err
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, insertURL, bytes.NewReader(payload))
+func (c *Client) insertPayload(ctx context.Context, table string, payload io.Reader, params url.Values) error {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("query", fmt.Sprintf("INSERT INTO %s.%s FORMAT JSONEachRow", c.database, table))
+	insertURL := fmt.Sprintf("%s/?%s", c.baseURL, params.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, insertURL, payload)
 	if err != nil {
 		return err
 	}
@@ -77,40 +163,135 @@ func (c *Client) InsertJSONEachRow(ctx context.Context, table string, rows any)
 	return nil
 }
 
-func toNDJSON(rows any) ([]byte, error) {
-	v := reflectRows(rows)
-	if len(v) == 0 {
-		return nil, nil
+func (c *Client) Exec(ctx context.Context, query string, settings url.Values) error {
+	params := url.Values{}
+	for k, v := range settings {
+		params[k] = v
+	}
+	params.Set("database", c.database)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/?"+params.Encode(), strings.NewReader(query))
+	if err != nil {
+		return err
 	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
 
-	var buf bytes.Buffer
-	enc := json.NewEncoder(&buf)
-	enc.SetEscapeHTML(false)
-	for _, item := range v {
-		if err := enc.Encode(item); err != nil {
-			return nil, err
-		}
+	if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 8192))
+		return fmt.Errorf("clickhouse exec failed: %s (%s)", resp.Status, string(b))
 	}
-	return buf.Bytes(), nil
+	return nil
 }
 
-func reflectRows(rows any) []any {
-	switch r := rows.(type) {
-	case []map[string]any:
-		out := make([]any, 0, len(r))
-		for i := range r {
-			out = append(out, r[i])
+func (c *Client) QueryEachRow(ctx context.Context, query string, fn func([]byte) error) error {
+	params := url.Values{}
+	params.Set("database", c.database)
+	params.Set("default_format", "JSONEachRow")
+	if c.compress {
+		params.Set("enable_http_compression", "1")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/?"+params.Encode(), strings.NewReader(query))
+	if err != nil {
+		return err
+	}
+	if c.compress {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+	client := *c.httpClient
+	client.Timeout = 0
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := responseBody(resp)
+	if err != nil {
+		return fmt.Errorf("clickhouse query failed: %s (undecodable %s body: %v)", resp.Status, resp.Header.Get("Content-Encoding"), err)
+	}
+	defer body.Close()
+	if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(body, 8192))
+		return fmt.Errorf("clickhouse query failed: %s (%s)", resp.Status, string(b))
+	}
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return err
 		}
-		return out
 	}
+	return scanner.Err()
+}
 
+func responseBody(resp *http.Response) (io.ReadCloser, error) {
+	if !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return io.NopCloser(resp.Body), nil
+	}
+	return gzip.NewReader(resp.Body)
+}
+
+type JSONAppender interface {
+	AppendJSON(dst []byte) []byte
+}
+
+var chunkPool = sync.Pool{New: func() any {
+	b := make([]byte, 0, 64*1024)
+	return &b
+}}
+
+func rowCount(rows any) int {
+	val := reflect.ValueOf(rows)
+	if val.Kind() != reflect.Slice {
+		return 0
+	}
+	return val.Len()
+}
+
+func writeNDJSON(w io.Writer, rows any) error {
 	val := reflect.ValueOf(rows)
 	if val.Kind() != reflect.Slice {
 		return nil
 	}
-	out := make([]any, 0, val.Len())
+	chunk := chunkPool.Get().(*[]byte)
+	buf := (*chunk)[:0]
+	defer func() {
+		if cap(buf) <= 1024*1024 {
+			*chunk = buf[:0]
+			chunkPool.Put(chunk)
+		}
+	}()
+	var scratch bytes.Buffer
+	enc := json.NewEncoder(&scratch)
+	enc.SetEscapeHTML(false)
 	for i := 0; i < val.Len(); i++ {
-		out = append(out, val.Index(i).Interface())
+		item := val.Index(i)
+		if a, ok := item.Addr().Interface().(JSONAppender); ok {
+			buf = append(a.AppendJSON(buf), '\n')
+		} else {
+			scratch.Reset()
+			if err := enc.Encode(item.Interface()); err != nil {
+				return err
+			}
+			buf = append(buf, scratch.Bytes()...)
+		}
+		if len(buf) >= 32*1024 {
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+	}
+	if len(buf) > 0 {
+		_, err := w.Write(buf)
+		return err
 	}
-	return out
+	return nil
 }
@@ -0,0 +1,151 @@
+package clickhouse
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type SkipIndex struct {
+	Name        string `json:"name"`
+	Table       string `json:"table"`
+	Expr        string `json:"expr"`
+	Type        string `json:"type"`
+	Granularity int    `json:"granularity"`
+}
+
+type SkipIndexState struct {
+	SkipIndex
+	Index           string `json:"index"`
+	Enabled         bool   `json:"enabled"`
+	Present         bool   `json:"present"`
+	CompressedBytes uint64 `json:"compressed_bytes"`
+	Marks           uint64 `json:"marks"`
+	Materializing   bool   `json:"materializing"`
+}
+
+var SkipIndexes = []SkipIndex{
+	{Name: "attr_keys", Table: "raw_logs", Expr: "mapKeys(attrs)", Type: "bloom_filter(0.01)", Granularity: 4},
+	{Name: "attr_values", Table: "raw_logs", Expr: "mapValues(attrs)", Type: "bloom_filter(0.01)", Granularity: 4},
+	{Name: "log_duration", Table: "raw_logs", Expr: "duration_ms", Type: "minmax", Granularity: 4},
+	{Name: "span_duration", Table: "spans", Expr: "duration_ms", Type: "minmax", Granularity: 4},
+	{Name: "trace_duration", Table: "traces", Expr: "duration_ms", Type: "minmax", Granularity: 4},
+}
+
+func LookupSkipIndex(name string) (SkipIndex, bool) {
+	for _, ix := range SkipIndexes {
+		if ix.Name == name {
+			return ix, true
+		}
+	}
+	return SkipIndex{}, false
+}
+
+func (ix SkipIndex) Index() string {
+	return "idx_" + ix.Name
+}
+
+func (c *Client) SchemaManaged() bool {
+	return c.schemaDir != ""
+}
+
+func (c *Client) SetSkipIndexes(names []string) error {
+	for _, name := range names {
+		if _, ok := LookupSkipIndex(name); !ok {
+			return fmt.Errorf("unknown skipping index %q", name)
+		}
+	}
+	c.indexMu.Lock()
+	defer c.indexMu.Unlock()
+	c.skipIndexes = append([]string(nil), names...)
+	return nil
+}
+
+func (c *Client) EnabledSkipIndexes() map[string]bool {
+	c.indexMu.Lock()
+	defer c.indexMu.Unlock()
+	out := make(map[string]bool, len(c.skipIndexes))
+	for _, name := range c.skipIndexes {
+		out[name] = true
+	}
+	return out
+}
+
+func (c *Client) ApplySkipIndexes(ctx context.Context, materialize bool) error {
+	enabled := c.EnabledSkipIndexes()
+	for _, ix := range SkipIndexes {
+		var err error
+		if enabled[ix.Name] {
+			err = c.Exec(ctx, fmt.Sprintf("ALTER TABLE %s ADD INDEX IF NOT EXISTS %s %s TYPE %s GRANULARITY %d",
+				ix.Table, ix.Index(), ix.Expr, ix.Type, ix.Granularity), nil)
+			if err == nil && materialize {
+				err = c.Exec(ctx, fmt.Sprintf("ALTER TABLE %s MATERIALIZE INDEX %s", ix.Table, ix.Index()), nil)
+			}
+		} else {
+			err = c.Exec(ctx, fmt.Sprintf("ALTER TABLE %s DROP INDEX IF EXISTS %s", ix.Table, ix.Index()), nil)
+		}
+		if err != nil {
+			return fmt.Errorf("skipping index %s: %w", ix.Name, err)
+		}
+	}
+	return nil
+}
+
+func (c *Client) SkipIndexStates(ctx context.Context) ([]SkipIndexState, error) {
+	type present struct {
+		Table           string `json:"table"`
+		Name            string `json:"name"`
+		CompressedBytes uint64 `json:"compressed_bytes,string"`
+		Marks           uint64 `json:"marks,string"`
+	}
+	found := map[string]present{}
+	err := c.QueryEachRow(ctx, `SELECT table, name, data_compressed_bytes AS compressed_bytes, marks
+FROM system.data_skipping_indices
+WHERE database = currentDatabase() AND startsWith(name, 'idx_')`, func(line []byte) error {
+		var p present
+		if err := json.Unmarshal(line, &p); err != nil {
+			return err
+		}
+		found[p.Table+"."+p.Name] = p
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	pending := map[string]bool{}
+	err = c.QueryEachRow(ctx, `SELECT table, command
+FROM system.mutations
+WHERE database = currentDatabase() AND NOT is_done AND command LIKE 'MATERIALIZE INDEX %'`, func(line []byte) error {
+		var m struct {
+			Table   string `json:"table"`
+			Command string `json:"command"`
+		}
+		if err := json.Unmarshal(line, &m); err != nil {
+			return err
+		}
+		name := strings.Trim(strings.TrimSpace(strings.TrimPrefix(m.Command, "MATERIALIZE INDEX")), "`")
+		pending[m.Table+"."+name] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	enabled := c.EnabledSkipIndexes()
+	out := make([]SkipIndexState, 0, len(SkipIndexes))
+	for _, ix := range SkipIndexes {
+		key := ix.Table + "." + ix.Index()
+		p, ok := found[key]
+		out = append(out, SkipIndexState{
+			SkipIndex:       ix,
+			Index:           ix.Index(),
+			Enabled:         enabled[ix.Name],
+			Present:         ok,
+			CompressedBytes: p.CompressedBytes,
+			Marks:           p.Marks,
+			Materializing:   pending[key],
+		})
+	}
+	return out, nil
+}
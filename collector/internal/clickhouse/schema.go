@@ -0,0 +1,137 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+func (c *Client) WaitReady(ctx context.Context, maxWait time.Duration) error {
+	deadline := time.Now().Add(maxWait)
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		pingCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		err := c.Ping(pingCtx)
+		cancel()
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("clickhouse reachable after %d attempts", attempt)
+			}
+			return nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return fmt.Errorf("clickhouse not reachable after %s: %w", maxWait, err)
+		}
+		log.Printf("waiting for clickhouse (attempt %d, retry in %s): %v", attempt, backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > 10*time.Second {
+			backoff = 10 * time.Second
+		}
+	}
+}
+
+func (c *Client) SetSchemaDir(dir string) {
+	c.schemaDir = dir
+}
+
+func (c *Client) ApplySchema(ctx context.Context) error {
+	if c.schemaDir == "" {
+		return nil
+	}
+	files, err := filepath.Glob(filepath.Join(c.schemaDir, "*.sql"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	applied := 0
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		for _, stmt := range splitStatements(string(b)) {
+			if err := c.execNoDB(ctx, stmt); err != nil {
+				return fmt.Errorf("%s: %w", filepath.Base(f), err)
+			}
+			applied++
+		}
+	}
+	if err := c.ApplySkipIndexes(ctx, false); err != nil {
+		return err
+	}
+	log.Printf("schema: applied %d statements from %d files in %s", applied, len(files), c.schemaDir)
+	return nil
+}
+
+func (c *Client) healSchema(ctx context.Context) bool {
+	if c.schemaDir == "" {
+		return false
+	}
+	c.healMu.Lock()
+	defer c.healMu.Unlock()
+	if time.Since(c.lastHeal) < time.Minute {
+		return false
+	}
+	c.lastHeal = time.Now()
+	log.Printf("schema: missing table or database detected, re-applying %s", c.schemaDir)
+	if err := c.ApplySchema(ctx); err != nil {
+		log.Printf("schema: re-apply failed: %v", err)
+		return false
+	}
+	return true
+}
+
+func isMissingSchema(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "UNKNOWN_TABLE") || strings.Contains(msg, "UNKNOWN_DATABASE") ||
+		strings.Contains(msg, "Code: 60.") || strings.Contains(msg, "Code: 81.")
+}
+
+func (c *Client) execNoDB(ctx context.Context, stmt string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/", strings.NewReader(stmt))
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 8192))
+		return fmt.Errorf("clickhouse exec failed: %s (%s)", resp.Status, string(b))
+	}
+	return nil
+}
+
+func splitStatements(sql string) []string {
+	sql = strings.TrimPrefix(sql, "\ufeff")
+	var lines []string
+	for _, line := range strings.Split(sql, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	var out []string
+	for _, stmt := range strings.Split(strings.Join(lines, "\n"), ";") {
+		if stmt = strings.TrimSpace(stmt); stmt != "" {
+			out = append(out, stmt)
+		}
+	}
+	return out
+}
@@ -1,64 +1,775 @@
 package config
 
 import (
+	"fmt"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
+
+	"trace-lite/collector/internal/fieldcrypt"
+	"trace-lite/collector/internal/rules"
 )
 
+type VHost struct {
+	Host     string
+	CertFile string
+	KeyFile  string
+	Env      string
+	Tenant   string
+}
+
+type ShedStage struct {
+	Level string
+	Keep  float64
+	At    float64
+}
+
+type WindowOverride struct {
+	Scope  string
+	Name   string
+	Window time.Duration
+	Flush  time.Duration
+}
+
+type TokenPolicy struct {
+	Name     string
+	Token    string
+	Trust    string
+	MaxAge   time.Duration
+	MaxSkew  time.Duration
+	Location *time.Location
+}
+
 type Config struct {
-	Addr              string
-	ClickHouseDSN     string
-	ClickHouseDB      string
-	IngestToken       string
-	TLSAutoSelfSigned bool
-	TLSCertFile       string
-	TLSKeyFile        string
-	TraceWindow       time.Duration
-	FlushInterval     time.Duration
+	Addr               string
+	ClickHouseDSN      string
+	ClickHouseDB       string
+	ClickHouseUser     string
+	ClickHousePass     string
+	ClickHouseCompress bool
+	SecretsRefresh     time.Duration
+	StartupWait        time.Duration
+	SchemaDir          string
+	SkipIndexes        []string
+	IngestToken        string
+	AdminToken         string
+	IngestTokens       []TokenPolicy
+	TLSAutoSelfSigned  bool
+	TLSCertFile        string
+	TLSKeyFile         string
+	VHosts             []VHost
+	HTTP2              bool
+	H2MaxStreams       int
+	H2PingTimeout      time.Duration
+	ReadHeaderTimeout  time.Duration
+	ReadTimeout        time.Duration
+	WriteTimeout       time.Duration
+	IdleTimeout        time.Duration
+	MaxConns           int
+	MaxHeaderBytes     int
+	UDSPath            string
+	UDSMode            os.FileMode
+	TraceWindow        time.Duration
+	FlushInterval      time.Duration
+	TraceMaxAge        time.Duration
+	WindowOverrides    []WindowOverride
+	MaxSpansPerTrace   int
+	FlushWorkers       int
+	ErrorRules         []rules.Rule
+	DropRules          []rules.Rule
+	OTLPEndpoint       string
+	OTLPHeaders        map[string]string
+	OTLPInterval       time.Duration
+	ClientTimeouts     map[string]time.Duration
+	TraceLabels        []rules.Label
+	ReconstructMode    string
+	TraceMerge         string
+	CollectorID        string
+	TraceMergeEvery    time.Duration
+	TraceMergeDelay    time.Duration
+	Federation         string
+	FederationRegion   string
+	FederationPeers    map[string]string
+	FederationToken    string
+	TransactionAttr    string
+	ProxyServices      []string
+	ProxyMode          string
+	InternalEdges      string
+	RetentionTiers     map[string]int
+	RetentionEvery     time.Duration
+	TraceWebhooksFile  string
+	WebhookAllowHosts  []string
+	ModuleAttr         string
+	QueueAttrs         []string
+	IDValidation       string
+	SpanConflicts      string
+	IDAcceptUUID       bool
+	NamePolicy         string
+	NameCase           string
+	NameMaxLen         int
+	NameCharset        string
+	NameAliases        map[string]map[string]string
+	LookupAttrs        []string
+	Encryption         *fieldcrypt.Keyring
+	EncryptAttrs       []string
+	EncryptRawJSON     bool
+	RUMOrigins         []string
+	RUMRate            int
+	RUMBurst           int
+	RUMEnv             string
+	CorrelationFields  []string
+	CorrelationTTL     time.Duration
+	IngestRetryAfter   time.Duration
+	ArchiveRejected    bool
+	IngestBuffer       string
+	RedisAddr          string
+	RedisPassword      string
+	RedisDB            int
+	RedisStream        string
+	RedisGroup         string
+	RedisConsumer      string
+	RedisMaxLen        int64
+	RedisClaimIdle     time.Duration
+	RedisConsume       bool
+	RelayUpstream      string
+	RelayToken         string
+	RelayAcceptToken   string
+	RelaySpoolDir      string
+	RelaySpoolMax      int64
+	RelayBackoffMax    time.Duration
+	OverflowDir        string
+	OverflowMax        int64
+	OverflowMaxAge     time.Duration
+	IngestMaxInflight  int
+	IngestBulkPercent  int
+	IngestLaneWait     time.Duration
+	PriorityServices   []string
+	LoadShed           []ShedStage
+	LoadShedWindow     time.Duration
+	IngestSLO          time.Duration
 }
 
 func Load() Config {
-	return Config{
-		Addr:              getEnv("COLLECTOR_ADDR", ":8443"),
-		ClickHouseDSN:     getEnv("CLICKHOUSE_DSN", "http://localhost:8123"),
-		ClickHouseDB:      getEnv("CLICKHOUSE_DB", "trace_lite"),
-		IngestToken:       getEnv("INGEST_TOKEN", ""),
-		TLSAutoSelfSigned: getEnvBool("TLS_AUTO_SELF_SIGNED", true),
-		TLSCertFile:       os.Getenv("TLS_CERT_FILE"),
-		TLSKeyFile:        os.Getenv("TLS_KEY_FILE"),
-		TraceWindow:       getEnvDuration("TRACE_WINDOW", 2*time.Minute),
-		FlushInterval:     getEnvDuration("FLUSH_INTERVAL", 10*time.Second),
+	problems = nil
+	refCache = map[string]string{}
+	cfg := Config{
+		Addr:               getEnv("COLLECTOR_ADDR", ":8443"),
+		ClickHouseDSN:      getEnv("CLICKHOUSE_DSN", "http://localhost:8123"),
+		ClickHouseDB:       getEnv("CLICKHOUSE_DB", "trace_lite"),
+		ClickHouseUser:     getEnv("CLICKHOUSE_USER", ""),
+		ClickHousePass:     getEnv("CLICKHOUSE_PASSWORD", ""),
+		ClickHouseCompress: getEnvBool("CLICKHOUSE_COMPRESSION", true),
+		SecretsRefresh:     getEnvDuration("SECRETS_REFRESH", 5*time.Minute),
+		StartupWait:        getEnvDuration("CLICKHOUSE_STARTUP_WAIT", 0),
+		SchemaDir:          getEnv("SCHEMA_DIR", ""),
+		SkipIndexes:        getEnvList("SKIP_INDEXES", ""),
+		IngestToken:        getEnv("INGEST_TOKEN", ""),
+		AdminToken:         getEnv("ADMIN_TOKEN", ""),
+		IngestTokens:       loadTokenPolicies(),
+		TLSAutoSelfSigned:  getEnvBool("TLS_AUTO_SELF_SIGNED", true),
+		TLSCertFile:        getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:         getEnv("TLS_KEY_FILE", ""),
+		VHosts:             parseVHosts(getEnv("TLS_VHOSTS", "")),
+		HTTP2:              getEnvBool("COLLECTOR_HTTP2", true),
+		H2MaxStreams:       getEnvInt("COLLECTOR_H2_MAX_CONCURRENT_STREAMS", 250),
+		H2PingTimeout:      getEnvDuration("COLLECTOR_H2_PING_TIMEOUT", 15*time.Second),
+		ReadHeaderTimeout:  getEnvDuration("COLLECTOR_READ_HEADER_TIMEOUT", 10*time.Second),
+		ReadTimeout:        getEnvDuration("COLLECTOR_READ_TIMEOUT", 30*time.Second),
+		WriteTimeout:       getEnvDuration("COLLECTOR_WRITE_TIMEOUT", 30*time.Second),
+		IdleTimeout:        getEnvDuration("COLLECTOR_IDLE_TIMEOUT", 90*time.Second),
+		MaxConns:           getEnvInt("COLLECTOR_MAX_CONNS", 0),
+		MaxHeaderBytes:     getEnvInt("COLLECTOR_MAX_HEADER_BYTES", 64*1024),
+		UDSPath:            getEnv("COLLECTOR_UDS", ""),
+		UDSMode:            getEnvFileMode("COLLECTOR_UDS_MODE", 0o660),
+		TraceWindow:        getEnvDuration("TRACE_WINDOW", 2*time.Minute),
+		FlushInterval:      getEnvDuration("FLUSH_INTERVAL", 10*time.Second),
+		TraceMaxAge:        getEnvDuration("TRACE_MAX_AGE", 30*time.Minute),
+		WindowOverrides:    parseWindowOverrides(getEnv("TRACE_WINDOW_OVERRIDES", "")),
+		MaxSpansPerTrace:   getEnvInt("MAX_SPANS_PER_TRACE", 10000),
+		FlushWorkers:       getEnvInt("FLUSH_WORKERS", 0),
+		ErrorRules:         parseRules("ERROR_RULES", "ok", "error", "cancelled", "timeout"),
+		DropRules:          parseRules("DROP_RULES", "drop"),
+		OTLPEndpoint:       strings.TrimRight(getEnv("OTLP_ENDPOINT", ""), "/"),
+		OTLPHeaders:        parseHeaders(getEnv("OTLP_HEADERS", "")),
+		OTLPInterval:       getEnvDuration("OTLP_INTERVAL", time.Minute),
+		ClientTimeouts:     parseClientTimeouts(getEnv("CLIENT_TIMEOUTS", "")),
+		TraceLabels:        parseLabels(getEnv("TRACE_LABELS", "")),
+		ReconstructMode:    getEnv("RECONSTRUCT_MODE", "go"),
+		TraceMerge:         getEnv("TRACE_MERGE", "off"),
+		CollectorID:        getEnv("COLLECTOR_ID", hostname()),
+		TraceMergeEvery:    getEnvDuration("TRACE_MERGE_INTERVAL", 30*time.Second),
+		TraceMergeDelay:    getEnvDuration("TRACE_MERGE_DELAY", time.Minute),
+		Federation:         getEnv("FEDERATION", "off"),
+		FederationRegion:   strings.TrimSpace(getEnv("FEDERATION_REGION", "")),
+		FederationPeers:    parseFederationPeers(getEnv("FEDERATION_PEERS", "")),
+		FederationToken:    getEnv("FEDERATION_TOKEN", ""),
+		RUMOrigins:         getEnvList("RUM_ALLOWED_ORIGINS", ""),
+		RUMRate:            getEnvInt("RUM_RATE_LIMIT", 20),
+		RUMBurst:           getEnvInt("RUM_BURST", 100),
+		RUMEnv:             getEnv("RUM_ENV", ""),
+		LookupAttrs:        getEnvList("LOOKUP_ATTRS", "user_id,session_id,order_id"),
+		Encryption:         loadKeyring(),
+		EncryptAttrs:       getEnvList("ENCRYPT_ATTRS", ""),
+		EncryptRawJSON:     getEnvBool("ENCRYPT_RAW_JSON", false),
+		TransactionAttr:    getEnv("TRANSACTION_ATTR", "transaction"),
+		ProxyServices:      getEnvList("PROXY_SERVICES", ""),
+		ProxyMode:          getEnv("PROXY_MODE", "collapse"),
+		InternalEdges:      getEnv("INTERNAL_EDGES", "off"),
+		RetentionTiers:     parseRetentionTiers(getEnv("RETENTION_TIERS", "")),
+		RetentionEvery:     getEnvDuration("RETENTION_PROMOTE_INTERVAL", 5*time.Minute),
+		TraceWebhooksFile:  getEnv("TRACE_WEBHOOKS_FILE", ""),
+		WebhookAllowHosts:  getEnvList("WEBHOOK_ALLOW_HOSTS", ""),
+		ModuleAttr:         getEnv("INTERNAL_MODULE_ATTR", "module"),
+		QueueAttrs:         getEnvList("QUEUE_ATTRS", "queue_time_ms,thread_pool_wait_ms"),
+		IDValidation:       getEnv("ID_VALIDATION", "normalize"),
+		SpanConflicts:      getEnv("SPAN_CONFLICTS", "merge"),
+		IDAcceptUUID:       getEnvBool("ID_ACCEPT_UUID", true),
+		NamePolicy:         getEnv("NAME_POLICY", "off"),
+		NameCase:           getEnv("NAME_CASE", "keep"),
+		NameMaxLen:         getEnvInt("NAME_MAX_LEN", 64),
+		NameCharset:        getEnv("NAME_CHARSET", "-_."),
+		NameAliases:        parseNameAliases(getEnv("NAME_ALIASES", ""), getEnv("NAME_CASE", "keep") == "lower"),
+		CorrelationFields:  getEnvList("CORRELATION_FIELDS", "correlationId"),
+		CorrelationTTL:     getEnvDuration("CORRELATION_ALIAS_TTL", 10*time.Minute),
+		IngestRetryAfter:   getEnvDuration("INGEST_RETRY_AFTER", 5*time.Second),
+		ArchiveRejected:    getEnvBool("ARCHIVE_REJECTED", true),
+		IngestBuffer:       getEnv("INGEST_BUFFER", "direct"),
+		RedisAddr:          getEnv("REDIS_ADDR", "localhost:6379"),
+		RedisPassword:      getEnv("REDIS_PASSWORD", ""),
+		RedisDB:            getEnvInt("REDIS_DB", 0),
+		RedisStream:        getEnv("REDIS_STREAM", "trace-lite:ingest"),
+		RedisGroup:         getEnv("REDIS_GROUP", "reconstructor"),
+		RedisConsumer:      getEnv("REDIS_CONSUMER", hostname()),
+		RedisMaxLen:        int64(getEnvInt("REDIS_STREAM_MAXLEN", 1000000)),
+		RedisClaimIdle:     getEnvDuration("REDIS_CLAIM_IDLE", time.Minute),
+		RedisConsume:       getEnvBool("REDIS_CONSUME", true),
+		RelayUpstream:      strings.TrimRight(getEnv("RELAY_UPSTREAM", ""), "/"),
+		RelayToken:         getEnv("RELAY_TOKEN", ""),
+		RelayAcceptToken:   getEnv("RELAY_ACCEPT_TOKEN", ""),
+		RelaySpoolDir:      getEnv("RELAY_SPOOL_DIR", "relay-spool"),
+		RelaySpoolMax:      int64(getEnvInt("RELAY_SPOOL_MAX_MB", 1024)) << 20,
+		RelayBackoffMax:    getEnvDuration("RELAY_BACKOFF_MAX", 5*time.Minute),
+		OverflowDir:        getEnv("OVERFLOW_SPOOL_DIR", ""),
+		OverflowMax:        int64(getEnvInt("OVERFLOW_SPOOL_MAX_MB", 1024)) << 20,
+		OverflowMaxAge:     getEnvDuration("OVERFLOW_MAX_AGE", time.Hour),
+		IngestMaxInflight:  getEnvInt("INGEST_MAX_INFLIGHT", 0),
+		IngestBulkPercent:  getEnvInt("INGEST_BULK_PERCENT", 75),
+		IngestLaneWait:     getEnvDuration("INGEST_LANE_WAIT", 500*time.Millisecond),
+		PriorityServices:   getEnvList("PRIORITY_SERVICES", ""),
+		LoadShed:           parseShedStages(getEnv("LOAD_SHED", "")),
+		LoadShedWindow:     getEnvDuration("LOAD_SHED_WINDOW", 10*time.Second),
+		IngestSLO:          getEnvDuration("INGEST_SLO", 500*time.Millisecond),
 	}
+	cfg.validate()
+	return cfg
+}
+
+func (c Config) validate() {
+	checkOneOf("RECONSTRUCT_MODE", c.ReconstructMode, "go", "off")
+	checkOneOf("TRACE_MERGE", c.TraceMerge, "off", "partials")
+	if c.TraceMerge == "partials" && (c.TraceMergeEvery <= 0 || c.TraceMergeDelay < 0 || strings.TrimSpace(c.CollectorID) == "") {
+		problem("TRACE_MERGE=partials needs a positive TRACE_MERGE_INTERVAL, a non-negative TRACE_MERGE_DELAY and a COLLECTOR_ID")
+	}
+	checkOneOf("FEDERATION", c.Federation, "off", "forward")
+	if c.Federation == "forward" && (c.FederationRegion == "" || len(c.FederationPeers) == 0 || c.FederationToken == "") {
+		problem("FEDERATION=forward needs FEDERATION_REGION, FEDERATION_PEERS and FEDERATION_TOKEN")
+	}
+	if c.Federation == "forward" && c.ReconstructMode == "off" {
+		problem("FEDERATION=forward needs RECONSTRUCT_MODE=go")
+	}
+	checkOneOf("PROXY_MODE", c.ProxyMode, "collapse", "passthrough")
+	checkOneOf("INTERNAL_EDGES", c.InternalEdges, "off", "depth", "modules")
+	checkOneOf("INGEST_BUFFER", c.IngestBuffer, "direct", "redis", "relay")
+	if c.IngestBuffer == "relay" {
+		if u, err := url.Parse(c.RelayUpstream); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problem("INGEST_BUFFER=relay needs RELAY_UPSTREAM set to the central collector's http(s) URL")
+		}
+		if c.RelayToken == "" || c.RelaySpoolDir == "" {
+			problem("INGEST_BUFFER=relay needs RELAY_TOKEN and RELAY_SPOOL_DIR")
+		}
+		if c.RelaySpoolMax <= 0 || c.RelayBackoffMax < time.Second {
+			problem("RELAY_SPOOL_MAX_MB must be positive and RELAY_BACKOFF_MAX at least 1s")
+		}
+		if c.ReconstructMode != "off" {
+			problem("INGEST_BUFFER=relay needs RECONSTRUCT_MODE=off; the central collector reconstructs traces")
+		}
+	}
+	for _, p := range c.IngestTokens {
+		if c.RelayAcceptToken != "" && p.Token == c.RelayAcceptToken {
+			problem("RELAY_ACCEPT_TOKEN must differ from every ingest token")
+			break
+		}
+	}
+	if c.AdminToken != "" {
+		shared := c.AdminToken == c.IngestToken || c.AdminToken == c.RelayToken || c.AdminToken == c.RelayAcceptToken || c.AdminToken == c.FederationToken
+		for _, p := range c.IngestTokens {
+			shared = shared || p.Token == c.AdminToken
+		}
+		if shared {
+			problem("ADMIN_TOKEN must differ from every ingest, relay and federation token")
+		}
+	}
+	if c.IngestMaxInflight < 0 || c.IngestBulkPercent < 1 || c.IngestBulkPercent > 100 || c.IngestLaneWait < 0 {
+		problem("INGEST_MAX_INFLIGHT must not be negative, INGEST_BULK_PERCENT must be between 1 and 100 and INGEST_LANE_WAIT must not be negative")
+	}
+	if c.IngestSLO < 0 {
+		problem("INGEST_SLO must not be negative")
+	}
+	if len(c.LoadShed) > 0 && (c.IngestMaxInflight == 0 || c.LoadShedWindow <= 0) {
+		problem("LOAD_SHED needs INGEST_MAX_INFLIGHT and a positive LOAD_SHED_WINDOW")
+	}
+	if c.OverflowDir != "" && c.IngestBuffer == "relay" {
+		problem("OVERFLOW_SPOOL_DIR does not apply with INGEST_BUFFER=relay; the relay spool already buffers ingest")
+	}
+	if c.OverflowDir != "" && (c.OverflowMax <= 0 || c.OverflowMaxAge < 0) {
+		problem("OVERFLOW_SPOOL_MAX_MB must be positive and OVERFLOW_MAX_AGE must not be negative")
+	}
+	checkOneOf("ID_VALIDATION", c.IDValidation, "off", "normalize", "strict")
+	checkOneOf("SPAN_CONFLICTS", c.SpanConflicts, "merge", "first")
+	checkOneOf("NAME_POLICY", c.NamePolicy, "off", "normalize", "strict")
+	checkOneOf("NAME_CASE", c.NameCase, "keep", "lower")
+	if c.NameMaxLen < 0 {
+		problem("NAME_MAX_LEN must not be negative")
+	}
+	checkOneOf("INGEST_TRUST", c.IngestTokens[0].Trust, "client", "clamp", "server")
+	if c.MaxSpansPerTrace < 0 {
+		problem("MAX_SPANS_PER_TRACE must not be negative")
+	}
+	if c.FlushWorkers < 0 {
+		problem("FLUSH_WORKERS must not be negative")
+	}
+	if c.TraceWindow <= 0 || c.FlushInterval <= 0 {
+		problem("TRACE_WINDOW and FLUSH_INTERVAL must be positive")
+	}
+	if c.OTLPInterval <= 0 {
+		problem("OTLP_INTERVAL must be positive")
+	}
+	if u, err := url.Parse(c.OTLPEndpoint); c.OTLPEndpoint != "" && (err != nil || u.Scheme == "" || u.Host == "") {
+		problem("OTLP_ENDPOINT must be an http(s) URL")
+	}
+	if c.TraceMaxAge < 0 {
+		problem("TRACE_MAX_AGE must not be negative")
+	}
+	if c.RetentionEvery <= 0 {
+		problem("RETENTION_PROMOTE_INTERVAL must be positive")
+	}
+	if c.Encryption == nil && (len(c.EncryptAttrs) > 0 || c.EncryptRawJSON) {
+		problem("ENCRYPT_ATTRS and ENCRYPT_RAW_JSON need ENCRYPTION_KEY")
+	}
+	if len(c.EncryptAttrs) > 0 && !c.EncryptRawJSON {
+		problem("ENCRYPT_ATTRS needs ENCRYPT_RAW_JSON=true, or raw_json and rejected lines keep those attributes in plaintext")
+	}
+	if len(c.SkipIndexes) > 0 && c.SchemaDir == "" {
+		problem("SKIP_INDEXES needs SCHEMA_DIR")
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		problem("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if u, err := url.Parse(c.ClickHouseDSN); err != nil || u.Scheme == "" || u.Host == "" {
+		problem("CLICKHOUSE_DSN must be an http(s) URL")
+	}
+}
+
+func hostname() string {
+	h, err := os.Hostname()
+	if err != nil || h == "" {
+		return "collector"
+	}
+	return h
+}
+
+func parseVHosts(v string) []VHost {
+	var out []VHost
+	for _, entry := range strings.Split(v, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		host, rest, ok := strings.Cut(entry, "=")
+		parts := strings.Split(rest, ",")
+		if !ok || strings.TrimSpace(host) == "" || len(parts) < 2 {
+			problem("ignoring malformed TLS_VHOSTS entry %q", entry)
+			continue
+		}
+		vh := VHost{
+			Host:     strings.ToLower(strings.TrimSpace(host)),
+			CertFile: strings.TrimSpace(parts[0]),
+			KeyFile:  strings.TrimSpace(parts[1]),
+		}
+		for _, opt := range parts[2:] {
+			k, val, _ := strings.Cut(opt, "=")
+			switch strings.TrimSpace(k) {
+			case "env":
+				vh.Env = strings.TrimSpace(val)
+			case "tenant":
+				vh.Tenant = strings.TrimSpace(val)
+			}
+		}
+		out = append(out, vh)
+	}
+	return out
+}
+
+func parseWindowOverrides(v string) []WindowOverride {
+	var out []WindowOverride
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		target, rest, ok := strings.Cut(entry, "=")
+		scope, name, scoped := strings.Cut(strings.TrimSpace(target), ":")
+		windowRaw, flushRaw, hasFlush := strings.Cut(rest, "/")
+		o := WindowOverride{Scope: scope, Name: strings.TrimSpace(name)}
+		var err error
+		if strings.TrimSpace(windowRaw) != "" {
+			o.Window, err = time.ParseDuration(strings.TrimSpace(windowRaw))
+		}
+		if err == nil && hasFlush {
+			o.Flush, err = time.ParseDuration(strings.TrimSpace(flushRaw))
+		}
+		if !ok || !scoped || (scope != "env" && scope != "service") || o.Name == "" || err != nil || o.Window < 0 || o.Flush < 0 {
+			problem("ignoring malformed TRACE_WINDOW_OVERRIDES entry %q", entry)
+			continue
+		}
+		out = append(out, o)
+	}
+	return out
+}
+
+func parseRetentionTiers(v string) map[string]int {
+	out := map[string]int{}
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		tier, raw, _ := strings.Cut(entry, "=")
+		tier = strings.TrimSpace(tier)
+		days, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(raw), "d"))
+		known := tier == "ok" || tier == "error" || (strings.HasPrefix(tier, "label:") && len(tier) > len("label:"))
+		if !known || err != nil || days <= 0 || days > 65535 {
+			problem("ignoring malformed RETENTION_TIERS entry %q", entry)
+			continue
+		}
+		out[tier] = days
+	}
+	return out
+}
+
+func loadKeyring() *fieldcrypt.Keyring {
+	raw, id := getEnv("ENCRYPTION_KEY", ""), getEnv("ENCRYPTION_KEY_ID", "k1")
+	prevRaw, prevID := getEnv("ENCRYPTION_PREVIOUS_KEY", ""), getEnv("ENCRYPTION_PREVIOUS_KEY_ID", "")
+	if raw == "" {
+		if prevRaw != "" {
+			problem("ENCRYPTION_PREVIOUS_KEY needs ENCRYPTION_KEY")
+		}
+		return nil
+	}
+	key, err := fieldcrypt.ParseKey(raw)
+	if err != nil {
+		problem("ENCRYPTION_KEY: %v", err)
+		return nil
+	}
+	ring, err := fieldcrypt.New(id, key)
+	if err != nil {
+		problem("ENCRYPTION_KEY_ID: %v", err)
+		return nil
+	}
+	if prevRaw == "" {
+		return ring
+	}
+	if prevID == "" || prevID == id {
+		problem("ENCRYPTION_PREVIOUS_KEY_ID must be set and differ from ENCRYPTION_KEY_ID")
+		return ring
+	}
+	if key, err = fieldcrypt.ParseKey(prevRaw); err == nil {
+		err = ring.Add(prevID, key)
+	}
+	if err != nil {
+		problem("ENCRYPTION_PREVIOUS_KEY: %v", err)
+	}
+	return ring
+}
+
+func parseNameAliases(v string, lower bool) map[string]map[string]string {
+	out := map[string]map[string]string{}
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		target, canonical, ok := strings.Cut(entry, "=")
+		field, name, scoped := strings.Cut(strings.TrimSpace(target), ":")
+		if !scoped {
+			field, name = "service", field
+		}
+		field, name, canonical = strings.TrimSpace(field), strings.TrimSpace(name), strings.TrimSpace(canonical)
+		if lower {
+			name = strings.ToLower(name)
+		}
+		if !ok || name == "" || canonical == "" || (field != "service" && field != "env" && field != "host") {
+			problem("ignoring malformed NAME_ALIASES entry %q", entry)
+			continue
+		}
+		if out[field] == nil {
+			out[field] = map[string]string{}
+		}
+		out[field][name] = canonical
+	}
+	return out
+}
+
+func parseShedStages(v string) []ShedStage {
+	var out []ShedStage
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		target, atRaw, ok := strings.Cut(entry, "@")
+		level, keepRaw, sampled := strings.Cut(strings.TrimSpace(target), "=")
+		st := ShedStage{Level: strings.ToLower(strings.TrimSpace(level))}
+		var err error
+		st.At, err = strconv.ParseFloat(strings.TrimSpace(atRaw), 64)
+		if err == nil && sampled {
+			st.Keep, err = strconv.ParseFloat(strings.TrimSpace(keepRaw), 64)
+		}
+		if !ok || err != nil || (st.Level != "debug" && st.Level != "info" && st.Level != "warn") || st.At <= 0 || st.At > 1 || st.Keep < 0 || st.Keep >= 1 {
+			problem("ignoring malformed LOAD_SHED entry %q", entry)
+			continue
+		}
+		out = append(out, st)
+	}
+	return out
+}
+
+func parseFederationPeers(v string) map[string]string {
+	out := map[string]string{}
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		region, raw, ok := strings.Cut(entry, "=")
+		region, raw = strings.TrimSpace(region), strings.TrimSpace(raw)
+		u, err := url.Parse(raw)
+		if !ok || region == "" || err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problem("ignoring malformed FEDERATION_PEERS entry %q", entry)
+			continue
+		}
+		out[region] = strings.TrimRight(raw, "/")
+	}
+	return out
+}
+
+func parseClientTimeouts(v string) map[string]time.Duration {
+	out := map[string]time.Duration{}
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		service, raw, ok := strings.Cut(entry, "=")
+		d, err := time.ParseDuration(strings.TrimSpace(raw))
+		if !ok || strings.TrimSpace(service) == "" || err != nil || d <= 0 {
+			problem("ignoring malformed CLIENT_TIMEOUTS entry %q", entry)
+			continue
+		}
+		out[strings.TrimSpace(service)] = d
+	}
+	return out
+}
+
+func parseHeaders(v string) map[string]string {
+	out := map[string]string{}
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			problem("ignoring malformed OTLP_HEADERS entry for %q", strings.TrimSpace(name))
+			continue
+		}
+		out[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return out
+}
+
+func parseLabels(v string) []rules.Label {
+	list, errs := rules.ParseLabels(v)
+	for _, err := range errs {
+		problem("ignoring malformed TRACE_LABELS entry %v", err)
+	}
+	return list
+}
+
+func parseRules(key string, actions ...string) []rules.Rule {
+	list, errs := rules.ParseList(getEnv(key, ""), actions...)
+	for _, err := range errs {
+		problem("ignoring malformed %s entry %v", key, err)
+	}
+	return list
+}
+
+func loadTokenPolicies() []TokenPolicy {
+	base := TokenPolicy{
+		Name:    "default",
+		Token:   getEnv("INGEST_TOKEN", ""),
+		Trust:   getEnv("INGEST_TRUST", "client"),
+		MaxAge:  getEnvDuration("INGEST_MAX_EVENT_AGE", 0),
+		MaxSkew: getEnvDuration("INGEST_MAX_CLOCK_SKEW", 0),
+	}
+	base.Location = loadLocation("INGEST_TIMEZONE", getEnv("INGEST_TIMEZONE", "UTC"))
+	out := []TokenPolicy{base}
+	for _, entry := range strings.Split(getEnv("INGEST_TOKENS", ""), ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, rest, ok := strings.Cut(entry, "=")
+		parts := strings.Split(rest, ",")
+		if !ok || strings.TrimSpace(name) == "" || strings.TrimSpace(parts[0]) == "" {
+			problem("ignoring malformed INGEST_TOKENS entry for %q", strings.TrimSpace(name))
+			continue
+		}
+		p := base
+		p.Name = strings.TrimSpace(name)
+		p.Token = strings.TrimSpace(parts[0])
+		for _, opt := range parts[1:] {
+			k, val, _ := strings.Cut(opt, "=")
+			val = strings.TrimSpace(val)
+			switch strings.TrimSpace(k) {
+			case "trust":
+				p.Trust = val
+			case "max_age":
+				if d, err := time.ParseDuration(val); err == nil {
+					p.MaxAge = d
+				}
+			case "max_skew":
+				if d, err := time.ParseDuration(val); err == nil {
+					p.MaxSkew = d
+				}
+			case "tz":
+				p.Location = loadLocation("token "+p.Name+" tz", val)
+			}
+		}
+		switch p.Trust {
+		case "client", "clamp", "server":
+		default:
+			problem("token %s has unknown trust %q, using client", p.Name, p.Trust)
+			p.Trust = "client"
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+func loadLocation(name, value string) *time.Location {
+	loc, err := time.LoadLocation(value)
+	if err != nil {
+		problem("%s: unknown time zone %q", name, value)
+		return time.UTC
+	}
+	return loc
+}
+
+func MatchVHost(vhosts []VHost, serverName string) (VHost, bool) {
+	name := strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if name == "" {
+		return VHost{}, false
+	}
+	for _, vh := range vhosts {
+		if vh.Host == name {
+			return vh, true
+		}
+	}
+	for _, vh := range vhosts {
+		if suffix, ok := strings.CutPrefix(vh.Host, "*"); ok && strings.HasSuffix(name, suffix) {
+			return vh, true
+		}
+	}
+	return VHost{}, false
 }
 
 func getEnv(key, fallback string) string {
-	if v := os.Getenv(key); v != "" {
-		return v
+	v := envValue(key)
+	if v == "" {
+		v = fallback
 	}
-	return fallback
+	record(key, v)
+	return v
 }
 
 func getEnvBool(key string, fallback bool) bool {
-	v := os.Getenv(key)
+	v := envValue(key)
 	if v == "" {
+		record(key, fallback)
 		return fallback
 	}
 	b, err := strconv.ParseBool(v)
 	if err != nil {
-		return fallback
+		problem("%s=%q is not a valid bool", key, v)
+		b = fallback
 	}
+	record(key, b)
 	return b
 }
 
+func getEnvInt(key string, fallback int) int {
+	v := envValue(key)
+	if v == "" {
+		record(key, fallback)
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		problem("%s=%q is not a valid integer", key, v)
+		n = fallback
+	}
+	record(key, n)
+	return n
+}
+
+func getEnvFileMode(key string, fallback os.FileMode) os.FileMode {
+	mode := fallback
+	if v := envValue(key); v != "" {
+		n, err := strconv.ParseUint(v, 8, 32)
+		if err != nil {
+			problem("%s=%q is not a valid octal file mode", key, v)
+		} else {
+			mode = os.FileMode(n)
+		}
+	}
+	record(key, fmt.Sprintf("%o", uint32(mode)))
+	return mode
+}
+
 func getEnvDuration(key string, fallback time.Duration) time.Duration {
-	v := os.Getenv(key)
+	v := envValue(key)
 	if v == "" {
+		record(key, fallback)
 		return fallback
 	}
 	d, err := time.ParseDuration(v)
 	if err != nil {
-		return fallback
+		problem("%s=%q is not a valid duration", key, v)
+		d = fallback
 	}
+	record(key, d)
 	return d
 }
+
+func getEnvList(key, fallback string) []string {
+	var out []string
+	for _, v := range strings.Split(getEnv(key, fallback), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
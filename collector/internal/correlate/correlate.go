@@ -0,0 +1,147 @@
+package correlate
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"trace-lite/collector/internal/model"
+)
+
+const maxAliases = 200000
+
+type Resolver struct {
+	fields []string
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	aliases map[string]alias
+}
+
+type alias struct {
+	traceID string
+	seen    time.Time
+}
+
+func New(fields []string, ttl time.Duration) *Resolver {
+	if len(fields) == 0 {
+		fields = []string{"correlationId"}
+	}
+	return &Resolver{fields: fields, ttl: ttl, aliases: map[string]alias{}}
+}
+
+func (r *Resolver) Resolve(events []model.IngestEvent, raws []string, learn bool) {
+	if len(r.fields) == 1 && strings.EqualFold(r.fields[0], "correlationId") {
+		return
+	}
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range events {
+		if events[i].IsHeartbeat() {
+			continue
+		}
+		var top map[string]json.RawMessage
+		_ = json.Unmarshal([]byte(raws[i]), &top)
+
+		var candidates []string
+		source := ""
+		for _, f := range r.fields {
+			v := lookup(f, &events[i], top)
+			if v == "" {
+				continue
+			}
+			if source == "" {
+				source = f
+			}
+			candidates = append(candidates, v)
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+
+		traceID := candidates[0]
+		for _, c := range candidates {
+			if a, ok := r.aliases[c]; ok && now.Sub(a.seen) < r.ttl {
+				traceID = a.traceID
+				break
+			}
+		}
+		if learn && len(candidates) > 1 {
+			for _, c := range candidates {
+				if c != traceID {
+					r.aliases[c] = alias{traceID: traceID, seen: now}
+				}
+			}
+		}
+
+		if events[i].CorrelationID != traceID {
+			if events[i].Attrs == nil {
+				events[i].Attrs = map[string]string{}
+			}
+			if events[i].CorrelationID != "" {
+				events[i].Attrs["correlation_original"] = events[i].CorrelationID
+			}
+			events[i].CorrelationID = traceID
+		}
+		if source != "" && !strings.EqualFold(source, "correlationId") {
+			if events[i].Attrs == nil {
+				events[i].Attrs = map[string]string{}
+			}
+			events[i].Attrs["correlation_source"] = source
+		}
+	}
+
+	if len(r.aliases) > maxAliases {
+		r.expireLocked(now)
+	}
+}
+
+func (r *Resolver) expireLocked(now time.Time) {
+	for k, a := range r.aliases {
+		if now.Sub(a.seen) >= r.ttl {
+			delete(r.aliases, k)
+		}
+	}
+	if len(r.aliases) > maxAliases {
+		r.aliases = map[string]alias{}
+	}
+}
+
+func lookup(field string, e *model.IngestEvent, top map[string]json.RawMessage) string {
+	if strings.EqualFold(field, "correlationId") || strings.EqualFold(field, "traceId") {
+		if v := strings.TrimSpace(e.CorrelationID); v != "" {
+			return v
+		}
+	}
+	v := ""
+	if raw, ok := top[field]; ok {
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			v = strings.TrimSpace(s)
+		}
+	}
+	if v == "" {
+		v = strings.TrimSpace(e.Attrs[field])
+	}
+	if v != "" && strings.EqualFold(field, "traceparent") {
+		return traceParentID(v)
+	}
+	return v
+}
+
+func traceParentID(v string) string {
+	parts := strings.Split(v, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || strings.Trim(parts[1], "0") == "" {
+		return ""
+	}
+	for _, c := range parts[1] {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return ""
+		}
+	}
+	return parts[1]
+}
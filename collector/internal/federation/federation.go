@@ -0,0 +1,159 @@
+package federation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"trace-lite/collector/internal/model"
+)
+
+const (
+	SpansPath      = "/v1/federation/spans"
+	ClaimsPath     = "/v1/federation/claims"
+	requestTimeout = 10 * time.Second
+)
+
+type Batch struct {
+	Region string          `json:"region"`
+	Spans  []model.SpanRow `json:"spans"`
+}
+
+type Claims struct {
+	Region   string   `json:"region"`
+	TraceIDs []string `json:"trace_ids"`
+}
+
+type Stats struct {
+	Region    string   `json:"region"`
+	Regions   []string `json:"regions"`
+	Forwarded uint64   `json:"forwarded_spans"`
+	Failed    uint64   `json:"failed_batches"`
+	Received  uint64   `json:"received_spans"`
+	Announced uint64   `json:"announced_traces"`
+	Claimed   uint64   `json:"claimed_traces"`
+}
+
+type Peers struct {
+	region    string
+	urls      map[string]string
+	regions   []string
+	token     string
+	client    *http.Client
+	forwarded atomic.Uint64
+	failed    atomic.Uint64
+	received  atomic.Uint64
+	announced atomic.Uint64
+	claimed   atomic.Uint64
+}
+
+func New(region string, urls map[string]string, token string) *Peers {
+	p := &Peers{region: region, urls: map[string]string{}, regions: []string{region}, token: token, client: &http.Client{Timeout: requestTimeout}}
+	for name, u := range urls {
+		if name == region {
+			continue
+		}
+		p.urls[name] = u
+		p.regions = append(p.regions, name)
+	}
+	sort.Strings(p.regions)
+	return p
+}
+
+func (p *Peers) Owner(traceID string) string {
+	best, bestScore := "", uint64(0)
+	for _, region := range p.regions {
+		h := fnv.New64a()
+		io.WriteString(h, region)
+		h.Write([]byte{0})
+		io.WriteString(h, traceID)
+		if score := h.Sum64(); best == "" || score > bestScore {
+			best, bestScore = region, score
+		}
+	}
+	if best == p.region {
+		return ""
+	}
+	return best
+}
+
+func (p *Peers) Forward(ctx context.Context, owner string, spans []model.SpanRow) error {
+	target, ok := p.urls[owner]
+	if !ok {
+		return fmt.Errorf("unknown region %q", owner)
+	}
+	if err := p.post(ctx, owner, target+SpansPath, Batch{Region: p.region, Spans: spans}); err != nil {
+		return err
+	}
+	p.forwarded.Add(uint64(len(spans)))
+	return nil
+}
+
+func (p *Peers) Announce(ctx context.Context, traceIDs []string) error {
+	var firstErr error
+	for _, region := range p.regions {
+		target, ok := p.urls[region]
+		if !ok {
+			continue
+		}
+		if err := p.post(ctx, region, target+ClaimsPath, Claims{Region: p.region, TraceIDs: traceIDs}); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		p.announced.Add(uint64(len(traceIDs)))
+	}
+	return firstErr
+}
+
+func (p *Peers) post(ctx context.Context, region, target string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		p.failed.Add(1)
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode/100 != 2 {
+		p.failed.Add(1)
+		return fmt.Errorf("%s answered %s", region, resp.Status)
+	}
+	return nil
+}
+
+func (p *Peers) Received(n int) {
+	p.received.Add(uint64(n))
+}
+
+func (p *Peers) Claimed(n int) {
+	p.claimed.Add(uint64(n))
+}
+
+func (p *Peers) Stats() Stats {
+	return Stats{
+		Region:    p.region,
+		Regions:   p.regions,
+		Forwarded: p.forwarded.Load(),
+		Failed:    p.failed.Load(),
+		Received:  p.received.Load(),
+		Announced: p.announced.Load(),
+		Claimed:   p.claimed.Load(),
+	}
+}
@@ -0,0 +1,134 @@
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const prefix = "enc:v1:"
+
+var ErrUnknownKey = errors.New("fieldcrypt: unknown key id")
+
+type key struct {
+	id   string
+	aead cipher.AEAD
+	mac  []byte
+}
+
+type Keyring struct {
+	keys []key
+}
+
+func ParseKey(v string) ([]byte, error) {
+	v = strings.TrimSpace(v)
+	for _, decode := range []func(string) ([]byte, error){
+		hex.DecodeString,
+		base64.StdEncoding.DecodeString,
+		base64.RawStdEncoding.DecodeString,
+		base64.URLEncoding.DecodeString,
+		base64.RawURLEncoding.DecodeString,
+	} {
+		if b, err := decode(v); err == nil && len(b) == 32 {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("key must be 32 bytes, hex or base64 encoded")
+}
+
+func New(id string, master []byte) (*Keyring, error) {
+	k := &Keyring{}
+	if err := k.Add(id, master); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *Keyring) Add(id string, master []byte) error {
+	if id == "" || strings.Contains(id, ":") {
+		return fmt.Errorf("fieldcrypt: invalid key id %q", id)
+	}
+	if len(master) != 32 {
+		return fmt.Errorf("fieldcrypt: key %s must be 32 bytes", id)
+	}
+	block, err := aes.NewCipher(derive(master, "enc"))
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	k.keys = append(k.keys, key{id: id, aead: aead, mac: derive(master, "nonce")})
+	return nil
+}
+
+func (k *Keyring) Seal(field, value string) string {
+	if k == nil || value == "" || IsSealed(value) {
+		return value
+	}
+	return k.keys[0].seal(field, value)
+}
+
+func (k *Keyring) SealAll(field, value string) []string {
+	if k == nil || value == "" {
+		return nil
+	}
+	out := make([]string, len(k.keys))
+	for i, key := range k.keys {
+		out[i] = key.seal(field, value)
+	}
+	return out
+}
+
+func (k *Keyring) Open(field, value string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+	id, payload, _ := strings.Cut(strings.TrimPrefix(value, prefix), ":")
+	if k == nil {
+		return "", ErrUnknownKey
+	}
+	for _, key := range k.keys {
+		if key.id != id {
+			continue
+		}
+		b, err := base64.RawURLEncoding.DecodeString(payload)
+		size := key.aead.NonceSize()
+		if err != nil || len(b) < size {
+			return "", fmt.Errorf("fieldcrypt: malformed %s value", field)
+		}
+		plain, err := key.aead.Open(nil, b[:size], b[size:], []byte(field))
+		if err != nil {
+			return "", fmt.Errorf("fieldcrypt: cannot decrypt %s: %w", field, err)
+		}
+		return string(plain), nil
+	}
+	return "", ErrUnknownKey
+}
+
+func IsSealed(v string) bool {
+	return strings.HasPrefix(v, prefix)
+}
+
+func (k key) seal(field, value string) string {
+	m := hmac.New(sha256.New, k.mac)
+	m.Write([]byte(field))
+	m.Write([]byte{0})
+	m.Write([]byte(value))
+	nonce := m.Sum(nil)[:k.aead.NonceSize()]
+	out := k.aead.Seal(nonce, nonce, []byte(value), []byte(field))
+	return prefix + k.id + ":" + base64.RawURLEncoding.EncodeToString(out)
+}
+
+func derive(master []byte, purpose string) []byte {
+	m := hmac.New(sha256.New, master)
+	m.Write([]byte("trace-lite fieldcrypt " + purpose))
+	return m.Sum(nil)
+}
@@ -0,0 +1,130 @@
+package fieldcrypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testKeyring(t *testing.T, id string, fill byte) *Keyring {
+	t.Helper()
+	k, err := New(id, bytes.Repeat([]byte{fill}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestParseKey(t *testing.T) {
+	raw := bytes.Repeat([]byte{7}, 32)
+	cases := []struct {
+		name  string
+		value string
+		ok    bool
+	}{
+		{"hex", hex.EncodeToString(raw), true},
+		{"base64", base64.StdEncoding.EncodeToString(raw), true},
+		{"raw url base64 with spaces", " " + base64.RawURLEncoding.EncodeToString(raw) + "\n", true},
+		{"short", hex.EncodeToString(raw[:16]), false},
+		{"not encoded", "correct horse battery staple", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseKey(tc.value)
+			if tc.ok != (err == nil) || tc.ok && !bytes.Equal(got, raw) {
+				t.Fatalf("ParseKey = %x, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	k := testKeyring(t, "k1", 1)
+	cases := []struct {
+		field string
+		value string
+	}{
+		{"email", "ada@example.com"},
+		{"raw_json", `{"service":"cart","attrs":{"email":"ada@example.com"}}`},
+		{"card_last4", "4242"},
+		{"note", "café \U0001F600"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.field, func(t *testing.T) {
+			sealed := k.Seal(tc.field, tc.value)
+			if !IsSealed(sealed) || !strings.HasPrefix(sealed, "enc:v1:k1:") || strings.Contains(sealed, tc.value) {
+				t.Fatalf("Seal = %q", sealed)
+			}
+			if again := k.Seal(tc.field, tc.value); again != sealed {
+				t.Fatalf("Seal is not deterministic: %q then %q", sealed, again)
+			}
+			if twice := k.Seal(tc.field, sealed); twice != sealed {
+				t.Fatalf("sealing a sealed value changed it: %q", twice)
+			}
+			if other := k.Seal(tc.field+"_x", tc.value); other == sealed {
+				t.Fatalf("the same value sealed for another field gave the same text")
+			}
+			plain, err := k.Open(tc.field, sealed)
+			if err != nil || plain != tc.value {
+				t.Fatalf("Open = %q, %v", plain, err)
+			}
+			if _, err := k.Open(tc.field+"_x", sealed); err == nil {
+				t.Fatalf("Open under another field succeeded")
+			}
+		})
+	}
+}
+
+func TestSealEdgeCases(t *testing.T) {
+	k := testKeyring(t, "k1", 1)
+	var none *Keyring
+	if got := k.Seal("email", ""); got != "" {
+		t.Fatalf("empty value sealed to %q", got)
+	}
+	if got := none.Seal("email", "ada"); got != "ada" {
+		t.Fatalf("nil keyring sealed to %q", got)
+	}
+	if got, err := k.Open("email", "plain"); got != "plain" || err != nil {
+		t.Fatalf("Open of a plain value = %q, %v", got, err)
+	}
+	if _, err := k.Open("email", "enc:v1:k1:!!!"); err == nil || errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Open of a malformed value = %v", err)
+	}
+	for _, id := range []string{"", "a:b"} {
+		if _, err := New(id, bytes.Repeat([]byte{1}, 32)); err == nil {
+			t.Fatalf("New accepted key id %q", id)
+		}
+	}
+	if _, err := New("k1", []byte("short")); err == nil {
+		t.Fatalf("New accepted a short key")
+	}
+}
+
+func TestRotation(t *testing.T) {
+	old := testKeyring(t, "k1", 1)
+	sealedOld := old.Seal("email", "ada@example.com")
+
+	ring := testKeyring(t, "k2", 2)
+	if err := ring.Add("k1", bytes.Repeat([]byte{1}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	sealedNew := ring.Seal("email", "ada@example.com")
+	if !strings.HasPrefix(sealedNew, "enc:v1:k2:") {
+		t.Fatalf("new values must use the first key: %q", sealedNew)
+	}
+	for _, v := range []string{sealedOld, sealedNew} {
+		if plain, err := ring.Open("email", v); err != nil || plain != "ada@example.com" {
+			t.Fatalf("Open(%q) = %q, %v", v, plain, err)
+		}
+	}
+	all := ring.SealAll("email", "ada@example.com")
+	if len(all) != 2 || all[0] != sealedNew || all[1] != sealedOld {
+		t.Fatalf("SealAll = %v, want [%s %s]", all, sealedNew, sealedOld)
+	}
+	if _, err := old.Open("email", sealedNew); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Open with a ring lacking k2 = %v, want ErrUnknownKey", err)
+	}
+}
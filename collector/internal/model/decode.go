@@ -0,0 +1,445 @@
+package model
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+var v1Keys = []string{
+	"timestamp", "service", "env", "host", "region", "zone", "level", "message", "status", "correlationId", "spanId", "parentSpanId",
+	"event", "route", "method", "statusCode", "durationMs", "version", "linkedTraceId", "linkType", "errorMessage",
+	"errorType", "attrs",
+}
+
+func DecodeV1(data []byte) (IngestEvent, error) {
+	if e, ok := decodeV1Fast(data); ok {
+		return e, nil
+	}
+	return decodeV1JSON(data)
+}
+
+func decodeV1JSON(data []byte) (IngestEvent, error) {
+	var v struct {
+		IngestEvent
+		Timestamp FlexTimestamp `json:"timestamp"`
+	}
+	err := json.Unmarshal(data, &v)
+	v.IngestEvent.Timestamp = string(v.Timestamp)
+	return v.IngestEvent, err
+}
+
+func decodeV1Fast(data []byte) (IngestEvent, bool) {
+	var e IngestEvent
+	s := jsonScanner{data: data}
+	if !s.consume('{') {
+		return e, false
+	}
+	if s.consume('}') {
+		return e, s.end()
+	}
+	attrsSeen := false
+	for {
+		key, ok := s.key()
+		if !ok || !s.consume(':') {
+			return e, false
+		}
+		s.space()
+		switch field := e.stringField(key); {
+		case string(key) == "timestamp" && (s.peek() == '-' || (s.peek() >= '0' && s.peek() <= '9')):
+			start := s.pos
+			if !s.number() {
+				return e, false
+			}
+			e.Timestamp = string(s.data[start:s.pos])
+		case field != nil:
+			if !s.stringInto(field) {
+				return e, false
+			}
+		case string(key) == "statusCode":
+			n, ok := s.uint(math.MaxUint16)
+			if !ok {
+				return e, false
+			}
+			if n >= 0 {
+				e.StatusCode = uint16(n)
+			}
+		case string(key) == "durationMs":
+			n, ok := s.uint(math.MaxUint32)
+			if !ok {
+				return e, false
+			}
+			if n >= 0 {
+				e.DurationMs = uint32(n)
+			}
+		case string(key) == "attrs":
+			if attrsSeen {
+				return e, false
+			}
+			attrsSeen = true
+			if e.Attrs, ok = s.attrs(); !ok {
+				return e, false
+			}
+		default:
+			name := string(key)
+			for _, k := range v1Keys {
+				if strings.EqualFold(name, k) {
+					return e, false
+				}
+			}
+			if !s.skip(0) {
+				return e, false
+			}
+		}
+		if s.consume(',') {
+			continue
+		}
+		if s.consume('}') {
+			return e, s.end()
+		}
+		return e, false
+	}
+}
+
+func (e *IngestEvent) stringField(key []byte) *string {
+	switch string(key) {
+	case "timestamp":
+		return &e.Timestamp
+	case "service":
+		return &e.Service
+	case "env":
+		return &e.Env
+	case "host":
+		return &e.Host
+	case "region":
+		return &e.Region
+	case "zone":
+		return &e.Zone
+	case "level":
+		return &e.Level
+	case "message":
+		return &e.Message
+	case "status":
+		return &e.Status
+	case "correlationId":
+		return &e.CorrelationID
+	case "spanId":
+		return &e.SpanID
+	case "parentSpanId":
+		return &e.ParentSpanID
+	case "event":
+		return &e.Event
+	case "route":
+		return &e.Route
+	case "method":
+		return &e.Method
+	case "version":
+		return &e.Version
+	case "linkedTraceId":
+		return &e.LinkedTraceID
+	case "linkType":
+		return &e.LinkType
+	case "errorMessage":
+		return &e.ErrorMessage
+	case "errorType":
+		return &e.ErrorType
+	}
+	return nil
+}
+
+type jsonScanner struct {
+	data []byte
+	pos  int
+}
+
+func (s *jsonScanner) space() {
+	for s.pos < len(s.data) {
+		switch s.data[s.pos] {
+		case ' ', '\t', '\n', '\r':
+			s.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (s *jsonScanner) consume(c byte) bool {
+	s.space()
+	if s.pos < len(s.data) && s.data[s.pos] == c {
+		s.pos++
+		return true
+	}
+	return false
+}
+
+func (s *jsonScanner) end() bool {
+	s.space()
+	return s.pos == len(s.data)
+}
+
+func (s *jsonScanner) peek() byte {
+	if s.pos < len(s.data) {
+		return s.data[s.pos]
+	}
+	return 0
+}
+
+func (s *jsonScanner) literal(word string) bool {
+	if len(s.data)-s.pos < len(word) || string(s.data[s.pos:s.pos+len(word)]) != word {
+		return false
+	}
+	s.pos += len(word)
+	return true
+}
+
+func (s *jsonScanner) key() ([]byte, bool) {
+	s.space()
+	if s.peek() != '"' {
+		return nil, false
+	}
+	for i := s.pos + 1; i < len(s.data); i++ {
+		switch c := s.data[i]; {
+		case c == '"':
+			key := s.data[s.pos+1 : i]
+			s.pos = i + 1
+			return key, true
+		case c == '\\' || c < 0x20 || c >= utf8.RuneSelf:
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+func (s *jsonScanner) str() (string, bool) {
+	if s.peek() != '"' {
+		return "", false
+	}
+	start, ascii := s.pos+1, true
+	for i := start; i < len(s.data); i++ {
+		switch c := s.data[i]; {
+		case c == '"':
+			raw := s.data[start:i]
+			s.pos = i + 1
+			if ascii || utf8.Valid(raw) {
+				return string(raw), true
+			}
+			return s.unquote(start-1, i+1)
+		case c == '\\':
+			end, ok := s.stringEnd(i)
+			if !ok {
+				return "", false
+			}
+			return s.unquote(start-1, end)
+		case c < 0x20:
+			return "", false
+		case c >= utf8.RuneSelf:
+			ascii = false
+		}
+	}
+	return "", false
+}
+
+func (s *jsonScanner) stringEnd(from int) (int, bool) {
+	for i := from; i < len(s.data); i++ {
+		switch c := s.data[i]; {
+		case c == '"':
+			return i + 1, true
+		case c == '\\':
+			i++
+		case c < 0x20:
+			return 0, false
+		}
+	}
+	return 0, false
+}
+
+func (s *jsonScanner) unquote(start, end int) (string, bool) {
+	var v string
+	if err := json.Unmarshal(s.data[start:end], &v); err != nil {
+		return "", false
+	}
+	s.pos = end
+	return v, true
+}
+
+func (s *jsonScanner) stringInto(field *string) bool {
+	if s.peek() == 'n' {
+		return s.literal("null")
+	}
+	v, ok := s.str()
+	if ok {
+		*field = v
+	}
+	return ok
+}
+
+func (s *jsonScanner) uint(limit uint64) (int64, bool) {
+	if s.peek() == 'n' {
+		return -1, s.literal("null")
+	}
+	start := s.pos
+	var n uint64
+	for s.pos < len(s.data) && s.data[s.pos] >= '0' && s.data[s.pos] <= '9' {
+		n = n*10 + uint64(s.data[s.pos]-'0')
+		if n > limit {
+			return 0, false
+		}
+		s.pos++
+	}
+	digits := s.pos - start
+	if digits == 0 || (digits > 1 && s.data[start] == '0') {
+		return 0, false
+	}
+	switch s.peek() {
+	case '.', 'e', 'E':
+		return 0, false
+	}
+	return int64(n), true
+}
+
+func (s *jsonScanner) attrs() (map[string]string, bool) {
+	if s.peek() == 'n' {
+		return nil, s.literal("null")
+	}
+	if !s.consume('{') {
+		return nil, false
+	}
+	m := make(map[string]string, 8)
+	if s.consume('}') {
+		return m, true
+	}
+	for {
+		s.space()
+		k, ok := s.str()
+		if !ok || !s.consume(':') {
+			return nil, false
+		}
+		s.space()
+		v, ok := s.str()
+		if !ok {
+			return nil, false
+		}
+		m[k] = v
+		if s.consume(',') {
+			continue
+		}
+		return m, s.consume('}')
+	}
+}
+
+func (s *jsonScanner) skip(depth int) bool {
+	if depth > 10000 {
+		return false
+	}
+	switch c := s.peek(); {
+	case c == '"':
+		end, ok := s.stringEnd(s.pos + 1)
+		if !ok || !validEscapes(s.data[s.pos:end]) {
+			return false
+		}
+		s.pos = end
+		return true
+	case c == '{':
+		s.pos++
+		if s.consume('}') {
+			return true
+		}
+		for {
+			s.space()
+			if s.peek() != '"' || !s.skip(depth+1) || !s.consume(':') {
+				return false
+			}
+			s.space()
+			if !s.skip(depth + 1) {
+				return false
+			}
+			if s.consume(',') {
+				continue
+			}
+			return s.consume('}')
+		}
+	case c == '[':
+		s.pos++
+		if s.consume(']') {
+			return true
+		}
+		for {
+			s.space()
+			if !s.skip(depth + 1) {
+				return false
+			}
+			if s.consume(',') {
+				continue
+			}
+			return s.consume(']')
+		}
+	case c == 't':
+		return s.literal("true")
+	case c == 'f':
+		return s.literal("false")
+	case c == 'n':
+		return s.literal("null")
+	case c == '-' || (c >= '0' && c <= '9'):
+		return s.number()
+	}
+	return false
+}
+
+func (s *jsonScanner) number() bool {
+	digits := func() int {
+		start := s.pos
+		for s.pos < len(s.data) && s.data[s.pos] >= '0' && s.data[s.pos] <= '9' {
+			s.pos++
+		}
+		return s.pos - start
+	}
+	if s.peek() == '-' {
+		s.pos++
+	}
+	start := s.pos
+	if n := digits(); n == 0 || (n > 1 && s.data[start] == '0') {
+		return false
+	}
+	if s.peek() == '.' {
+		s.pos++
+		if digits() == 0 {
+			return false
+		}
+	}
+	if c := s.peek(); c == 'e' || c == 'E' {
+		s.pos++
+		if c := s.peek(); c == '+' || c == '-' {
+			s.pos++
+		}
+		if digits() == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func validEscapes(quoted []byte) bool {
+	for i := 1; i < len(quoted)-1; i++ {
+		if quoted[i] != '\\' {
+			continue
+		}
+		i++
+		switch quoted[i] {
+		case '"', '\\', '/', 'b', 'f', 'n', 'r', 't':
+		case 'u':
+			if i+4 >= len(quoted)-1 {
+				return false
+			}
+			for _, h := range quoted[i+1 : i+5] {
+				if !strings.ContainsRune("0123456789abcdefABCDEF", rune(h)) {
+					return false
+				}
+			}
+			i += 4
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+var v1Samples = []string{
+	`{"timestamp":"2026-01-01T10:00:00.250Z","service":"cart","env":"prod","host":"h1","level":"info","message":"GET /cart","correlationId":"4bf92f3577b34da6a3ce929d0e0e4736","spanId":"00f067aa0ba902b7","parentSpanId":"","event":"end","route":"/cart","method":"GET","statusCode":200,"durationMs":42,"version":"v1","attrs":{"user_id":"42","tx":"checkout"}}`,
+	`{"timestamp":1767261600250,"service":"payments","env":"prod","correlationId":"t1","spanId":"s3","parentSpanId":"s1","event":"end","statusCode":502,"durationMs":120,"errorMessage":"upstream \"bank\" timed out\n","errorType":"Timeout"}`,
+	`{"service":"gateway","message":"caf\u00e9 \ud83d\ude00","attrs":{},"extra":{"nested":[1,2.5e3,true,null,"x"]}}`,
+	`{"Service":"mixed-case","statusCode":-1}`,
+	`{"attrs":{"a":"1"},"attrs":{"b":"2"}}`,
+	`{"durationMs":4294967296}`,
+	`{"timestamp":null,"service":null}`,
+	`{}`,
+	`[]`,
+	`{"service":"cart"`,
+}
+
+func FuzzDecodeV1MatchesEncodingJSON(f *testing.F) {
+	for _, s := range v1Samples {
+		f.Add([]byte(s))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fast, ok := decodeV1Fast(data)
+		if !ok {
+			return
+		}
+		slow, err := decodeV1JSON(data)
+		if err != nil {
+			t.Fatalf("fast path accepted %q, encoding/json rejected it: %v", data, err)
+		}
+		if !reflect.DeepEqual(fast, slow) {
+			t.Fatalf("decoders disagree on %q:\nfast %+v\njson %+v", data, fast, slow)
+		}
+	})
+}
+
+func BenchmarkDecodeV1(b *testing.B) {
+	data := []byte(v1Samples[0])
+	b.Run("fast", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		for b.Loop() {
+			if _, ok := decodeV1Fast(data); !ok {
+				b.Fatal("fast path rejected the sample")
+			}
+		}
+	})
+	b.Run("encoding_json", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		for b.Loop() {
+			if _, err := decodeV1JSON(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
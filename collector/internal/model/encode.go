@@ -0,0 +1,303 @@
+package model
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"unicode/utf8"
+)
+
+const hexDigits = "0123456789abcdef"
+
+func (r *RawLogRow) AppendJSON(dst []byte) []byte {
+	dst = append(dst, '{')
+	dst = appendString(dst, "ts", r.TS)
+	dst = appendString(dst, "service", r.Service)
+	dst = appendString(dst, "env", r.Env)
+	dst = appendString(dst, "host", r.Host)
+	dst = appendString(dst, "region", r.Region)
+	dst = appendString(dst, "zone", r.Zone)
+	dst = appendString(dst, "version", r.Version)
+	dst = appendString(dst, "level", r.Level)
+	dst = appendString(dst, "message", r.Message)
+	dst = appendString(dst, "trace_id", r.TraceID)
+	dst = appendString(dst, "span_id", r.SpanID)
+	dst = appendString(dst, "parent_span_id", r.ParentSpanID)
+	dst = appendString(dst, "event", r.Event)
+	dst = appendString(dst, "route", r.Route)
+	dst = appendString(dst, "method", r.Method)
+	dst = appendUint(dst, "status_code", uint64(r.StatusCode))
+	dst = appendUint(dst, "duration_ms", uint64(r.DurationMs))
+	dst = appendMap(dst, "attrs", r.Attrs)
+	dst = appendString(dst, "raw_json", r.RawJSON)
+	dst = appendUint(dst, "retain_days", uint64(r.RetainDays))
+	return append(dst, '}')
+}
+
+func (r *SpanRow) AppendJSON(dst []byte) []byte {
+	dst = append(dst, '{')
+	dst = appendString(dst, "trace_id", r.TraceID)
+	dst = appendString(dst, "span_id", r.SpanID)
+	dst = appendString(dst, "parent_span_id", r.ParentSpanID)
+	dst = appendString(dst, "service", r.Service)
+	dst = appendString(dst, "env", r.Env)
+	dst = appendString(dst, "host", r.Host)
+	dst = appendString(dst, "region", r.Region)
+	dst = appendString(dst, "zone", r.Zone)
+	dst = appendString(dst, "version", r.Version)
+	dst = appendString(dst, "operation", r.Operation)
+	dst = appendString(dst, "method", r.Method)
+	dst = appendString(dst, "route", r.Route)
+	dst = appendString(dst, "start_ts", r.StartTS)
+	dst = appendString(dst, "end_ts", r.EndTS)
+	dst = appendUint(dst, "duration_ms", uint64(r.DurationMs))
+	dst = appendUint(dst, "self_time_ms", uint64(r.SelfTimeMs))
+	dst = appendUint(dst, "queue_ms", uint64(r.QueueMs))
+	dst = appendUint(dst, "status_code", uint64(r.StatusCode))
+	dst = appendUint(dst, "is_error", uint64(r.IsError))
+	dst = appendString(dst, "status", r.Status)
+	dst = appendString(dst, "error_message", r.ErrorMessage)
+	dst = appendString(dst, "error_type", r.ErrorType)
+	dst = appendString(dst, "source", r.Source)
+	dst = appendString(dst, "proxy", r.Proxy)
+	if len(r.Conflicts) > 0 {
+		dst = appendStrings(dst, "conflicts", r.Conflicts)
+	}
+	dst = appendUint(dst, "retain_days", uint64(r.RetainDays))
+	return append(dst, '}')
+}
+
+func (r *TraceRow) AppendJSON(dst []byte) []byte {
+	dst = append(dst, '{')
+	dst = appendString(dst, "trace_id", r.TraceID)
+	dst = appendString(dst, "env", r.Env)
+	dst = appendString(dst, "root_service", r.RootService)
+	dst = appendString(dst, "root_operation", r.RootOperation)
+	dst = appendUint(dst, "root_status_code", uint64(r.RootStatusCode))
+	dst = appendString(dst, "transaction", r.Transaction)
+	dst = appendString(dst, "start_ts", r.StartTS)
+	dst = appendString(dst, "end_ts", r.EndTS)
+	dst = appendUint(dst, "duration_ms", uint64(r.DurationMs))
+	dst = appendUint(dst, "span_count", uint64(r.SpanCount))
+	dst = appendUint(dst, "service_count", uint64(r.ServiceCount))
+	dst = appendUint(dst, "error_count", uint64(r.ErrorCount))
+	dst = appendUint(dst, "critical_path_ms", uint64(r.CriticalPathMs))
+	dst = appendStrings(dst, "versions", r.Versions)
+	dst = appendStrings(dst, "regions", r.Regions)
+	dst = appendStrings(dst, "zones", r.Zones)
+	dst = appendUint(dst, "truncated", uint64(r.Truncated))
+	dst = appendUint(dst, "dropped_spans", uint64(r.DroppedSpans))
+	dst = appendUint(dst, "partial", uint64(r.Partial))
+	dst = appendStrings(dst, "labels", r.Labels)
+	dst = appendFloat32(dst, "integrity", r.Integrity)
+	dst = appendUint(dst, "inferred_spans", uint64(r.InferredSpans))
+	dst = appendUint(dst, "orphan_spans", uint64(r.OrphanSpans))
+	dst = appendUint(dst, "skewed_spans", uint64(r.SkewedSpans))
+	dst = appendUint(dst, "retain_days", uint64(r.RetainDays))
+	return append(dst, '}')
+}
+
+func (r *TracePartialRow) AppendJSON(dst []byte) []byte {
+	dst = r.TraceRow.AppendJSON(dst)
+	dst = dst[:len(dst)-1]
+	dst = appendString(dst, "collector_id", r.CollectorID)
+	dst = appendUint(dst, "has_root", uint64(r.HasRoot))
+	dst = appendStrings(dst, "services", r.Services)
+	return append(dst, '}')
+}
+
+func (r *LookupRow) AppendJSON(dst []byte) []byte {
+	dst = append(dst, '{')
+	dst = appendString(dst, "ts", r.TS)
+	dst = appendString(dst, "key", r.Key)
+	dst = appendString(dst, "value", r.Value)
+	dst = appendString(dst, "trace_id", r.TraceID)
+	dst = appendString(dst, "service", r.Service)
+	dst = appendString(dst, "env", r.Env)
+	return append(dst, '}')
+}
+
+func (r *DependencyEdgeRow) AppendJSON(dst []byte) []byte {
+	dst = append(dst, '{')
+	dst = appendString(dst, "bucket_ts", r.BucketTS)
+	dst = appendString(dst, "env", r.Env)
+	dst = appendString(dst, "caller_service", r.CallerService)
+	dst = appendString(dst, "callee_service", r.CalleeService)
+	dst = appendString(dst, "caller_version", r.CallerVersion)
+	dst = appendString(dst, "callee_version", r.CalleeVersion)
+	dst = appendString(dst, "caller_region", r.CallerRegion)
+	dst = appendString(dst, "callee_region", r.CalleeRegion)
+	dst = appendString(dst, "caller_zone", r.CallerZone)
+	dst = appendString(dst, "callee_zone", r.CalleeZone)
+	dst = appendString(dst, "callee_method", r.CalleeMethod)
+	dst = appendString(dst, "callee_route", r.CalleeRoute)
+	dst = appendUint(dst, "calls", r.Calls)
+	dst = appendUint(dst, "error_calls", r.ErrorCalls)
+	dst = appendUint(dst, "cancelled_calls", r.CancelledCalls)
+	dst = appendUint(dst, "timeout_calls", r.TimeoutCalls)
+	dst = appendFloat32(dst, "p50_ms", r.P50Ms)
+	dst = appendFloat32(dst, "p95_ms", r.P95Ms)
+	dst = appendUint(dst, "max_ms", uint64(r.MaxMs))
+	return append(dst, '}')
+}
+
+func (r *InternalEdgeRow) AppendJSON(dst []byte) []byte {
+	dst = append(dst, '{')
+	dst = appendString(dst, "bucket_ts", r.BucketTS)
+	dst = appendString(dst, "env", r.Env)
+	dst = appendString(dst, "service", r.Service)
+	dst = appendString(dst, "caller_module", r.CallerModule)
+	dst = appendString(dst, "callee_module", r.CalleeModule)
+	dst = appendUint(dst, "calls", r.Calls)
+	dst = appendUint(dst, "error_calls", r.ErrorCalls)
+	dst = appendUint(dst, "max_depth", uint64(r.MaxDepth))
+	dst = appendFloat32(dst, "p50_ms", r.P50Ms)
+	dst = appendFloat32(dst, "p95_ms", r.P95Ms)
+	dst = appendUint(dst, "max_ms", uint64(r.MaxMs))
+	return append(dst, '}')
+}
+
+func (r *ServiceVersionRow) AppendJSON(dst []byte) []byte {
+	dst = append(dst, '{')
+	dst = appendString(dst, "bucket_ts", r.BucketTS)
+	dst = appendString(dst, "env", r.Env)
+	dst = appendString(dst, "service", r.Service)
+	dst = appendString(dst, "version", r.Version)
+	dst = appendUint(dst, "calls", r.Calls)
+	dst = appendUint(dst, "errors", r.Errors)
+	return append(dst, '}')
+}
+
+func (r *UsageRow) AppendJSON(dst []byte) []byte {
+	dst = append(dst, '{')
+	dst = appendString(dst, "day", r.Day)
+	dst = appendString(dst, "env", r.Env)
+	dst = appendString(dst, "service", r.Service)
+	dst = appendUint(dst, "events", r.Events)
+	dst = appendUint(dst, "bytes", r.Bytes)
+	dst = appendUint(dst, "spans", r.Spans)
+	dst = appendUint(dst, "traces", r.Traces)
+	return append(dst, '}')
+}
+
+func appendName(dst []byte, name string) []byte {
+	if dst[len(dst)-1] != '{' {
+		dst = append(dst, ',')
+	}
+	dst = append(dst, '"')
+	dst = append(dst, name...)
+	return append(dst, '"', ':')
+}
+
+func appendString(dst []byte, name, v string) []byte {
+	return appendQuoted(appendName(dst, name), v)
+}
+
+func appendUint(dst []byte, name string, v uint64) []byte {
+	return strconv.AppendUint(appendName(dst, name), v, 10)
+}
+
+func appendFloat32(dst []byte, name string, v float32) []byte {
+	dst = appendName(dst, name)
+	f := float64(v)
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return append(dst, '0')
+	}
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+		format = 'e'
+	}
+	dst = strconv.AppendFloat(dst, f, format, -1, 32)
+	if n := len(dst); format == 'e' && n >= 4 && dst[n-4] == 'e' && dst[n-3] == '-' && dst[n-2] == '0' {
+		dst[n-2] = dst[n-1]
+		dst = dst[:n-1]
+	}
+	return dst
+}
+
+func appendStrings(dst []byte, name string, list []string) []byte {
+	dst = appendName(dst, name)
+	if list == nil {
+		return append(dst, "null"...)
+	}
+	dst = append(dst, '[')
+	for i, v := range list {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = appendQuoted(dst, v)
+	}
+	return append(dst, ']')
+}
+
+func appendMap(dst []byte, name string, m map[string]string) []byte {
+	dst = appendName(dst, name)
+	if m == nil {
+		return append(dst, "null"...)
+	}
+	var stack [32]string
+	keys := stack[:0]
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	dst = append(dst, '{')
+	for i, k := range keys {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = appendQuoted(dst, k)
+		dst = append(dst, ':')
+		dst = appendQuoted(dst, m[k])
+	}
+	return append(dst, '}')
+}
+
+func appendQuoted(dst []byte, s string) []byte {
+	dst = append(dst, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' {
+				i++
+				continue
+			}
+			dst = append(dst, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				dst = append(dst, '\\', b)
+			case '\b':
+				dst = append(dst, '\\', 'b')
+			case '\f':
+				dst = append(dst, '\\', 'f')
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			default:
+				dst = append(dst, '\\', 'u', '0', '0', hexDigits[b>>4], hexDigits[b&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			dst = append(dst, s[start:i]...)
+			dst = utf8.AppendRune(dst, utf8.RuneError)
+		case r == '\u2028' || r == '\u2029':
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, '\\', 'u', '2', '0', '2', hexDigits[r&0xf])
+		default:
+			i += size
+			continue
+		}
+		i += size
+		start = i
+	}
+	dst = append(dst, s[start:]...)
+	return append(dst, '"')
+}
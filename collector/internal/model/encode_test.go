@@ -0,0 +1,74 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+const awkward = "a\"b\\c\n\t\x01<&> café \xff\U0001F600"
+
+func TestAppendJSONMatchesEncodingJSON(t *testing.T) {
+	trace := TraceRow{
+		TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", Env: "prod", RootService: "gateway", RootOperation: awkward,
+		RootStatusCode: 502, Transaction: "checkout", StartTS: "2026-01-01 10:00:00.250", EndTS: "2026-01-01 10:00:01.000",
+		DurationMs: 750, SpanCount: 12, ServiceCount: 4, ErrorCount: 2, CriticalPathMs: 600,
+		Versions: []string{"v1", awkward}, Regions: []string{"eu"}, Zones: []string{}, Truncated: 1, DroppedSpans: 3,
+		Partial: 1, Labels: []string{"slow"}, Integrity: 0.8333333, InferredSpans: 1, OrphanSpans: 2, SkewedSpans: 3,
+		RetainDays: 30,
+	}
+	cases := []struct {
+		name string
+		row  interface{ AppendJSON([]byte) []byte }
+	}{
+		{"raw log", &RawLogRow{
+			TS: "2026-01-01 10:00:00.250", Service: "cart", Env: "prod", Host: "h1", Region: "eu", Zone: "eu-1a",
+			Version: "v1", Level: "ERROR", Message: awkward, TraceID: "t1", SpanID: "s1", ParentSpanID: "s0",
+			Event: "end", Route: "/cart/{id}", Method: "GET", StatusCode: 500, DurationMs: 4294967295,
+			Attrs: map[string]string{"b": awkward, "a": "1", awkward: "k"}, RawJSON: `{"service":"cart"}`, RetainDays: 7,
+		}},
+		{"raw log without attrs", &RawLogRow{TS: "2026-01-01 10:00:00.000", Service: "cart"}},
+		{"span", &SpanRow{
+			TraceID: "t1", SpanID: "s1", ParentSpanID: "s0", Service: "cart", Env: "prod", Host: "h1", Region: "eu",
+			Zone: "eu-1a", Version: "v1", Operation: awkward, Method: "POST", Route: "/pay", StartTS: "2026-01-01 10:00:00.000",
+			EndTS: "2026-01-01 10:00:00.120", DurationMs: 120, SelfTimeMs: 80, QueueMs: 5, StatusCode: 504, IsError: 1,
+			Status: "ERROR", ErrorMessage: awkward, ErrorType: "Timeout", Source: "inferred", Proxy: "mesh",
+			Conflicts: []string{"duration_ms", "status_code"}, RetainDays: 14,
+		}},
+		{"span without conflicts", &SpanRow{TraceID: "t1", SpanID: "s1", Conflicts: []string{}}},
+		{"trace", &trace},
+		{"trace with tiny integrity", &TraceRow{TraceID: "t2", Integrity: 1e-7, Versions: nil}},
+		{"trace with huge integrity", &TraceRow{TraceID: "t3", Integrity: 3e21}},
+		{"trace partial", &TracePartialRow{TraceRow: trace, CollectorID: "collector-2", HasRoot: 1, Services: []string{"cart", "payments"}}},
+		{"lookup", &LookupRow{TS: "2026-01-01 10:00:00.000", Key: "user_id", Value: awkward, TraceID: "t1", Service: "cart", Env: "prod"}},
+		{"dependency edge", &DependencyEdgeRow{
+			BucketTS: "2026-01-01 10:00:00", Env: "prod", CallerService: "gateway", CalleeService: "cart",
+			CallerVersion: "v1", CalleeVersion: "v2", CallerRegion: "eu", CalleeRegion: "us", CallerZone: "eu-1a",
+			CalleeZone: "us-1b", CalleeMethod: "GET", CalleeRoute: awkward, Calls: 18446744073709551615, ErrorCalls: 3,
+			CancelledCalls: 2, TimeoutCalls: 1, P50Ms: 12.5, P95Ms: 123.456, MaxMs: 900,
+		}},
+		{"internal edge", &InternalEdgeRow{
+			BucketTS: "2026-01-01 10:00:00", Env: "prod", Service: "cart", CallerModule: "handler", CalleeModule: awkward,
+			Calls: 10, ErrorCalls: 1, MaxDepth: 4, P50Ms: 0.001, P95Ms: 1e6, MaxMs: 77,
+		}},
+		{"service version", &ServiceVersionRow{BucketTS: "2026-01-01 10:00:00", Env: "prod", Service: "cart", Version: awkward, Calls: 9, Errors: 1}},
+		{"usage", &UsageRow{Day: "2026-01-01", Env: "prod", Service: awkward, Events: 100, Bytes: 2048, Spans: 40, Traces: 8}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var want bytes.Buffer
+			enc := json.NewEncoder(&want)
+			enc.SetEscapeHTML(false)
+			if err := enc.Encode(tc.row); err != nil {
+				t.Fatal(err)
+			}
+			got := tc.row.AppendJSON([]byte("prefix"))
+			if !bytes.HasPrefix(got, []byte("prefix")) {
+				t.Fatalf("AppendJSON dropped the destination prefix: %s", got)
+			}
+			if got, want := string(got[len("prefix"):]), string(bytes.TrimSuffix(want.Bytes(), []byte("\n"))); got != want {
+				t.Fatalf("AppendJSON differs from encoding/json:\ngot  %s\nwant %s", got, want)
+			}
+		})
+	}
+}
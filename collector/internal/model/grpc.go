@@ -0,0 +1,53 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+)
+
+var grpcCodes = []string{
+	"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED", "NOT_FOUND",
+	"ALREADY_EXISTS", "PERMISSION_DENIED", "RESOURCE_EXHAUSTED", "FAILED_PRECONDITION", "ABORTED",
+	"OUT_OF_RANGE", "UNIMPLEMENTED", "INTERNAL", "UNAVAILABLE", "DATA_LOSS", "UNAUTHENTICATED",
+}
+
+func GRPCCodeName(v string) (string, bool) {
+	v = strings.TrimSpace(v)
+	if n, err := strconv.Atoi(v); err == nil {
+		if n < 0 || n >= len(grpcCodes) {
+			return "", false
+		}
+		return grpcCodes[n], true
+	}
+	name := strings.TrimPrefix(strings.ToUpper(v), "STATUS_CODE_")
+	for _, c := range grpcCodes {
+		if c == name {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+func applyRPC(row *RawLogRow) {
+	method := strings.TrimSpace(row.Attrs["rpc.method"])
+	service := strings.TrimSpace(row.Attrs["rpc.service"])
+	code, hasCode := GRPCCodeName(row.Attrs["rpc.grpc.status_code"])
+	isGRPC := hasCode || strings.EqualFold(row.Attrs["rpc.system"], "grpc")
+
+	if row.Route == "" && method != "" {
+		if service != "" {
+			row.Route = service + "/" + method
+		} else {
+			row.Route = method
+		}
+	}
+	if row.Method == "" && isGRPC && method != "" {
+		row.Method = "GRPC"
+	}
+	if hasCode {
+		row.Attrs["rpc.grpc.status"] = code
+		if row.Attrs["status"] == "" {
+			row.Attrs["status"] = code
+		}
+	}
+}
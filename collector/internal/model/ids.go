@@ -0,0 +1,82 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+const maxIDLen = 128
+
+type IDPolicy struct {
+	Mode       string
+	AcceptUUID bool
+}
+
+func (p IDPolicy) Apply(e *IngestEvent) error {
+	for _, f := range []struct {
+		name  string
+		value *string
+		hex   int
+	}{
+		{"correlationId", &e.CorrelationID, 32},
+		{"spanId", &e.SpanID, 16},
+		{"parentSpanId", &e.ParentSpanID, 16},
+		{"linkedTraceId", &e.LinkedTraceID, 32},
+	} {
+		v, err := p.normalize(*f.value, f.hex)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", f.name, err)
+		}
+		*f.value = v
+	}
+	return nil
+}
+
+func (p IDPolicy) normalize(v string, hexLen int) (string, error) {
+	v = strings.TrimSpace(v)
+	if v == "" || p.Mode == "off" {
+		return v, nil
+	}
+	if len(v) > maxIDLen {
+		return "", fmt.Errorf("longer than %d bytes", maxIDLen)
+	}
+	for i := 0; i < len(v); i++ {
+		if v[i] < '!' || v[i] > '~' {
+			return "", fmt.Errorf("contains %q (printable ASCII only)", v[i])
+		}
+	}
+	if p.AcceptUUID && isUUID(v) {
+		v = strings.ReplaceAll(v, "-", "")
+	}
+	hex := isHex(v)
+	if hex {
+		v = strings.ToLower(v)
+	}
+	if p.Mode != "strict" {
+		return v, nil
+	}
+	if !hex || len(v) != hexLen {
+		return "", fmt.Errorf("want %d hex characters", hexLen)
+	}
+	if strings.Trim(v, "0") == "" {
+		return "", fmt.Errorf("all zeros")
+	}
+	return v, nil
+}
+
+func isHex(v string) bool {
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return v != ""
+}
+
+func isUUID(v string) bool {
+	if len(v) != 36 || v[8] != '-' || v[13] != '-' || v[18] != '-' || v[23] != '-' {
+		return false
+	}
+	return isHex(v[:8] + v[9:13] + v[14:18] + v[19:23] + v[24:])
+}
@@ -11,6 +11,8 @@ type IngestEvent struct {
 	Service       string            `json:"service"`
 	Env           string            `json:"env"`
 	Host          string            `json:"host"`
+	Region        string            `json:"region"`
+	Zone          string            `json:"zone"`
 	Level         string            `json:"level"`
 	Message       string            `json:"message"`
 	Status        string            `json:"status"`
@@ -23,6 +25,10 @@ type IngestEvent struct {
 	StatusCode    uint16            `json:"statusCode"`
 	DurationMs    uint32            `json:"durationMs"`
 	Version       string            `json:"version"`
+	LinkedTraceID string            `json:"linkedTraceId"`
+	LinkType      string            `json:"linkType"`
+	ErrorMessage  string            `json:"errorMessage"`
+	ErrorType     string            `json:"errorType"`
 	Attrs         map[string]string `json:"attrs"`
 }
 
@@ -31,6 +37,8 @@ type RawLogRow struct {
 	Service      string            `json:"service"`
 	Env          string            `json:"env"`
 	Host         string            `json:"host"`
+	Region       string            `json:"region"`
+	Zone         string            `json:"zone"`
 	Version      string            `json:"version"`
 	Level        string            `json:"level"`
 	Message      string            `json:"message"`
@@ -44,30 +52,45 @@ type RawLogRow struct {
 	DurationMs   uint32            `json:"duration_ms"`
 	Attrs        map[string]string `json:"attrs"`
 	RawJSON      string            `json:"raw_json"`
+	RetainDays   uint16            `json:"retain_days"`
 }
 
 type SpanRow struct {
-	TraceID      string `json:"trace_id"`
-	SpanID       string `json:"span_id"`
-	ParentSpanID string `json:"parent_span_id"`
-	Service      string `json:"service"`
-	Env          string `json:"env"`
-	Host         string `json:"host"`
-	Version      string `json:"version"`
-	Operation    string `json:"operation"`
-	StartTS      string `json:"start_ts"`
-	EndTS        string `json:"end_ts"`
-	DurationMs   uint32 `json:"duration_ms"`
-	SelfTimeMs   uint32 `json:"self_time_ms"`
-	StatusCode   uint16 `json:"status_code"`
-	IsError      uint8  `json:"is_error"`
-	Source       string `json:"source"`
+	TraceID      string   `json:"trace_id"`
+	SpanID       string   `json:"span_id"`
+	ParentSpanID string   `json:"parent_span_id"`
+	Service      string   `json:"service"`
+	Env          string   `json:"env"`
+	Host         string   `json:"host"`
+	Region       string   `json:"region"`
+	Zone         string   `json:"zone"`
+	Version      string   `json:"version"`
+	Operation    string   `json:"operation"`
+	Method       string   `json:"method"`
+	Route        string   `json:"route"`
+	StartTS      string   `json:"start_ts"`
+	EndTS        string   `json:"end_ts"`
+	DurationMs   uint32   `json:"duration_ms"`
+	SelfTimeMs   uint32   `json:"self_time_ms"`
+	QueueMs      uint32   `json:"queue_ms"`
+	StatusCode   uint16   `json:"status_code"`
+	IsError      uint8    `json:"is_error"`
+	Status       string   `json:"status"`
+	ErrorMessage string   `json:"error_message"`
+	ErrorType    string   `json:"error_type"`
+	Source       string   `json:"source"`
+	Proxy        string   `json:"proxy"`
+	Conflicts    []string `json:"conflicts,omitempty"`
+	RetainDays   uint16   `json:"retain_days"`
 }
 
 type TraceRow struct {
 	TraceID        string   `json:"trace_id"`
 	Env            string   `json:"env"`
 	RootService    string   `json:"root_service"`
+	RootOperation  string   `json:"root_operation"`
+	RootStatusCode uint16   `json:"root_status_code"`
+	Transaction    string   `json:"transaction"`
 	StartTS        string   `json:"start_ts"`
 	EndTS          string   `json:"end_ts"`
 	DurationMs     uint32   `json:"duration_ms"`
@@ -76,35 +99,131 @@ type TraceRow struct {
 	ErrorCount     uint16   `json:"error_count"`
 	CriticalPathMs uint32   `json:"critical_path_ms"`
 	Versions       []string `json:"versions"`
+	Regions        []string `json:"regions"`
+	Zones          []string `json:"zones"`
+	Truncated      uint8    `json:"truncated"`
+	DroppedSpans   uint32   `json:"dropped_spans"`
+	Partial        uint8    `json:"partial"`
+	Labels         []string `json:"labels"`
+	Integrity      float32  `json:"integrity"`
+	InferredSpans  uint16   `json:"inferred_spans"`
+	OrphanSpans    uint16   `json:"orphan_spans"`
+	SkewedSpans    uint16   `json:"skewed_spans"`
+	RetainDays     uint16   `json:"retain_days"`
+}
+
+type TracePartialRow struct {
+	TraceRow
+	CollectorID string   `json:"collector_id"`
+	HasRoot     uint8    `json:"has_root"`
+	Services    []string `json:"services"`
+}
+
+type HeartbeatRow struct {
+	TS      string `json:"ts"`
+	Service string `json:"service"`
+	Env     string `json:"env"`
+	Host    string `json:"host"`
+	Version string `json:"version"`
+}
+
+type LookupRow struct {
+	TS      string `json:"ts"`
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	TraceID string `json:"trace_id"`
+	Service string `json:"service"`
+	Env     string `json:"env"`
+}
+
+type RejectedEventRow struct {
+	TS        string `json:"ts"`
+	BatchID   string `json:"batch_id"`
+	Token     string `json:"token"`
+	Endpoint  string `json:"endpoint"`
+	Version   string `json:"version"`
+	VHost     string `json:"vhost"`
+	Client    string `json:"client"`
+	Line      uint32 `json:"line"`
+	Reason    string `json:"reason"`
+	Payload   string `json:"payload"`
+	Truncated uint8  `json:"truncated"`
+}
+
+type LinkRow struct {
+	TS            string `json:"ts"`
+	TraceID       string `json:"trace_id"`
+	LinkedTraceID string `json:"linked_trace_id"`
+	LinkType      string `json:"link_type"`
+	Service       string `json:"service"`
+	Env           string `json:"env"`
+	SpanID        string `json:"span_id"`
 }
 
 type DependencyEdgeRow struct {
-	BucketTS      string  `json:"bucket_ts"`
-	Env           string  `json:"env"`
-	CallerService string  `json:"caller_service"`
-	CalleeService string  `json:"callee_service"`
-	CallerVersion string  `json:"caller_version"`
-	CalleeVersion string  `json:"callee_version"`
-	Calls         uint64  `json:"calls"`
-	ErrorCalls    uint64  `json:"error_calls"`
-	P50Ms         float32 `json:"p50_ms"`
-	P95Ms         float32 `json:"p95_ms"`
-	MaxMs         uint32  `json:"max_ms"`
-}
-
-func (e IngestEvent) ToRaw(raw string) (RawLogRow, time.Time, error) {
+	BucketTS       string  `json:"bucket_ts"`
+	Env            string  `json:"env"`
+	CallerService  string  `json:"caller_service"`
+	CalleeService  string  `json:"callee_service"`
+	CallerVersion  string  `json:"caller_version"`
+	CalleeVersion  string  `json:"callee_version"`
+	CallerRegion   string  `json:"caller_region"`
+	CalleeRegion   string  `json:"callee_region"`
+	CallerZone     string  `json:"caller_zone"`
+	CalleeZone     string  `json:"callee_zone"`
+	CalleeMethod   string  `json:"callee_method"`
+	CalleeRoute    string  `json:"callee_route"`
+	Calls          uint64  `json:"calls"`
+	ErrorCalls     uint64  `json:"error_calls"`
+	CancelledCalls uint64  `json:"cancelled_calls"`
+	TimeoutCalls   uint64  `json:"timeout_calls"`
+	P50Ms          float32 `json:"p50_ms"`
+	P95Ms          float32 `json:"p95_ms"`
+	MaxMs          uint32  `json:"max_ms"`
+}
+
+type InternalEdgeRow struct {
+	BucketTS     string  `json:"bucket_ts"`
+	Env          string  `json:"env"`
+	Service      string  `json:"service"`
+	CallerModule string  `json:"caller_module"`
+	CalleeModule string  `json:"callee_module"`
+	Calls        uint64  `json:"calls"`
+	ErrorCalls   uint64  `json:"error_calls"`
+	MaxDepth     uint16  `json:"max_depth"`
+	P50Ms        float32 `json:"p50_ms"`
+	P95Ms        float32 `json:"p95_ms"`
+	MaxMs        uint32  `json:"max_ms"`
+}
+
+type ServiceVersionRow struct {
+	BucketTS string `json:"bucket_ts"`
+	Env      string `json:"env"`
+	Service  string `json:"service"`
+	Version  string `json:"version"`
+	Calls    uint64 `json:"calls"`
+	Errors   uint64 `json:"errors"`
+}
+
+type UsageRow struct {
+	Day     string `json:"day"`
+	Env     string `json:"env"`
+	Service string `json:"service"`
+	Events  uint64 `json:"events"`
+	Bytes   uint64 `json:"bytes"`
+	Spans   uint64 `json:"spans"`
+	Traces  uint64 `json:"traces"`
+}
+
+func (e IngestEvent) ToRaw(raw string, clock *TimeSource) (RawLogRow, time.Time, error) {
 	traceID := strings.TrimSpace(e.CorrelationID)
 	if traceID == "" {
 		return RawLogRow{}, time.Time{}, fmt.Errorf("missing correlationId")
 	}
 
-	ts := time.Now().UTC()
-	if strings.TrimSpace(e.Timestamp) != "" {
-		parsed, err := time.Parse(time.RFC3339Nano, e.Timestamp)
-		if err != nil {
-			return RawLogRow{}, time.Time{}, fmt.Errorf("invalid timestamp: %w", err)
-		}
-		ts = parsed.UTC()
+	ts, err := clock.Parse(e.Timestamp)
+	if err != nil {
+		return RawLogRow{}, time.Time{}, fmt.Errorf("invalid timestamp: %w", err)
 	}
 
 	eventType := strings.ToLower(strings.TrimSpace(e.Event))
@@ -119,12 +238,20 @@ func (e IngestEvent) ToRaw(raw string) (RawLogRow, time.Time, error) {
 	if s := strings.TrimSpace(e.Status); s != "" {
 		attrs["status"] = strings.ToUpper(s)
 	}
+	if m := strings.TrimSpace(e.ErrorMessage); m != "" {
+		attrs["error_message"] = m
+	}
+	if t := strings.TrimSpace(e.ErrorType); t != "" {
+		attrs["error_type"] = t
+	}
 
 	row := RawLogRow{
 		TS:           FormatCHTime(ts),
 		Service:      withDefault(e.Service, "unknown-service"),
 		Env:          withDefault(e.Env, "unknown"),
 		Host:         withDefault(e.Host, "unknown-host"),
+		Region:       withDefault(e.Region, attrs["cloud.region"]),
+		Zone:         withDefault(e.Zone, attrs["cloud.availability_zone"]),
 		Version:      withDefault(e.Version, "unknown"),
 		Level:        strings.ToUpper(withDefault(e.Level, "INFO")),
 		Message:      e.Message,
@@ -139,9 +266,93 @@ func (e IngestEvent) ToRaw(raw string) (RawLogRow, time.Time, error) {
 		Attrs:        attrs,
 		RawJSON:      raw,
 	}
+	applyRPC(&row)
 	return row, ts, nil
 }
 
+func (e IngestEvent) IsHeartbeat() bool {
+	return strings.EqualFold(strings.TrimSpace(e.Event), "heartbeat")
+}
+
+func (e IngestEvent) ToHeartbeat(clock *TimeSource) (HeartbeatRow, error) {
+	if strings.TrimSpace(e.Service) == "" {
+		return HeartbeatRow{}, fmt.Errorf("heartbeat missing service")
+	}
+	ts, err := clock.Parse(e.Timestamp)
+	if err != nil {
+		return HeartbeatRow{}, fmt.Errorf("invalid timestamp: %w", err)
+	}
+	return HeartbeatRow{
+		TS:      FormatCHTime(ts),
+		Service: strings.TrimSpace(e.Service),
+		Env:     withDefault(e.Env, "unknown"),
+		Host:    withDefault(e.Host, "unknown-host"),
+		Version: withDefault(e.Version, "unknown"),
+	}, nil
+}
+
+func (e IngestEvent) IsLink() bool {
+	return strings.EqualFold(strings.TrimSpace(e.Event), "link")
+}
+
+func (e IngestEvent) ToLink(clock *TimeSource) (LinkRow, error) {
+	from := strings.TrimSpace(e.CorrelationID)
+	to := strings.TrimSpace(e.LinkedTraceID)
+	if from == "" || to == "" {
+		return LinkRow{}, fmt.Errorf("link requires correlationId and linkedTraceId")
+	}
+	if from == to {
+		return LinkRow{}, fmt.Errorf("link must point at a different trace")
+	}
+	ts, err := clock.Parse(e.Timestamp)
+	if err != nil {
+		return LinkRow{}, fmt.Errorf("invalid timestamp: %w", err)
+	}
+	return LinkRow{
+		TS:            FormatCHTime(ts),
+		TraceID:       from,
+		LinkedTraceID: to,
+		LinkType:      strings.ToLower(withDefault(e.LinkType, "follows_from")),
+		Service:       withDefault(e.Service, "unknown-service"),
+		Env:           withDefault(e.Env, "unknown"),
+		SpanID:        strings.TrimSpace(e.SpanID),
+	}, nil
+}
+
+var httpMethods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "PATCH": true,
+	"DELETE": true, "OPTIONS": true, "CONNECT": true, "TRACE": true, "GRPC": true,
+}
+
+func SplitRoute(method, route string) (string, string) {
+	method = strings.ToUpper(strings.TrimSpace(method))
+	route = strings.TrimSpace(route)
+	if verb, rest, ok := strings.Cut(route, " "); ok && httpMethods[strings.ToUpper(verb)] {
+		if method == "" {
+			method = strings.ToUpper(verb)
+		}
+		route = strings.TrimSpace(rest)
+	}
+	return method, route
+}
+
+func SpanStatus(v string) string {
+	switch strings.ToUpper(strings.TrimSpace(v)) {
+	case "OK", "SUCCESS", "SUCCEEDED":
+		return "ok"
+	case "CANCELLED", "CANCELED":
+		return "cancelled"
+	case "TIMEOUT", "TIMED_OUT", "DEADLINE_EXCEEDED":
+		return "timeout"
+	case "ERROR", "FAIL", "FAILED", "FAILURE",
+		"UNKNOWN", "INVALID_ARGUMENT", "NOT_FOUND", "ALREADY_EXISTS",
+		"PERMISSION_DENIED", "RESOURCE_EXHAUSTED", "FAILED_PRECONDITION", "ABORTED", "OUT_OF_RANGE",
+		"UNIMPLEMENTED", "INTERNAL", "UNAVAILABLE", "DATA_LOSS", "UNAUTHENTICATED":
+		return "error"
+	}
+	return ""
+}
+
 func withDefault(v, fallback string) string {
 	if strings.TrimSpace(v) == "" {
 		return fallback
@@ -152,3 +363,18 @@ func withDefault(v, fallback string) string {
 func FormatCHTime(t time.Time) string {
 	return t.UTC().Format("2006-01-02 15:04:05.000")
 }
+
+func ParseCHTime(v string) (time.Time, error) {
+	t, err := time.Parse("2006-01-02 15:04:05.000", v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.UTC(), nil
+}
+
+func Day(ts string) string {
+	if len(ts) < len("2006-01-02") {
+		return time.Now().UTC().Format("2006-01-02")
+	}
+	return ts[:len("2006-01-02")]
+}
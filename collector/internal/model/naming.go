@@ -0,0 +1,64 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+type NamingPolicy struct {
+	Mode    string
+	Case    string
+	MaxLen  int
+	Charset string
+	Aliases map[string]map[string]string
+}
+
+func (p NamingPolicy) Apply(e *IngestEvent) error {
+	for _, f := range []struct {
+		name  string
+		value *string
+	}{
+		{"service", &e.Service},
+		{"env", &e.Env},
+		{"host", &e.Host},
+	} {
+		v, err := p.normalize(*f.value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", f.name, err)
+		}
+		if alias, ok := p.Aliases[f.name][v]; ok {
+			v = alias
+		}
+		*f.value = v
+	}
+	return nil
+}
+
+func (p NamingPolicy) normalize(v string) (string, error) {
+	v = strings.TrimSpace(v)
+	if p.Case == "lower" {
+		v = strings.ToLower(v)
+	}
+	if v == "" || p.Mode == "" || p.Mode == "off" {
+		return v, nil
+	}
+	var b strings.Builder
+	for _, c := range v {
+		if c < 0x80 && (c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune(p.Charset, c)) {
+			b.WriteRune(c)
+			continue
+		}
+		if p.Mode == "strict" {
+			return "", fmt.Errorf("contains %q (letters, digits and %q only)", c, p.Charset)
+		}
+		b.WriteByte('-')
+	}
+	v = b.String()
+	if p.MaxLen > 0 && len(v) > p.MaxLen {
+		if p.Mode == "strict" {
+			return "", fmt.Errorf("longer than %d bytes", p.MaxLen)
+		}
+		v = v[:p.MaxLen]
+	}
+	return v, nil
+}
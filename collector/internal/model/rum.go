@@ -0,0 +1,121 @@
+package model
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type RUMEvent struct {
+	Type          string  `json:"type"`
+	Timestamp     string  `json:"timestamp"`
+	CorrelationID string  `json:"correlationId"`
+	SpanID        string  `json:"spanId"`
+	Service       string  `json:"service"`
+	Env           string  `json:"env"`
+	Version       string  `json:"version"`
+	URL           string  `json:"url"`
+	Route         string  `json:"route"`
+	Method        string  `json:"method"`
+	Status        uint16  `json:"status"`
+	DurationMs    float64 `json:"durationMs"`
+	SessionID     string  `json:"sessionId"`
+	TTFBMs        float64 `json:"ttfbMs"`
+	DOMReadyMs    float64 `json:"domReadyMs"`
+}
+
+func DecodeRUM(data []byte) (IngestEvent, error) {
+	var e RUMEvent
+	if err := json.Unmarshal(data, &e); err != nil {
+		return IngestEvent{}, err
+	}
+	return e.ToIngest()
+}
+
+func (e RUMEvent) ToIngest() (IngestEvent, error) {
+	kind := strings.ToLower(strings.TrimSpace(e.Type))
+	if kind != "page_load" && kind != "fetch" {
+		return IngestEvent{}, fmt.Errorf("invalid type %q (expected page_load|fetch)", e.Type)
+	}
+	if strings.TrimSpace(e.CorrelationID) == "" {
+		return IngestEvent{}, fmt.Errorf("missing correlationId")
+	}
+	if e.DurationMs <= 0 || e.DurationMs > 10*60*1000 {
+		return IngestEvent{}, fmt.Errorf("durationMs out of range")
+	}
+	start, err := time.Parse(time.RFC3339Nano, e.Timestamp)
+	if err != nil {
+		return IngestEvent{}, fmt.Errorf("invalid timestamp: %w", err)
+	}
+	duration := uint32(e.DurationMs)
+	end := start.Add(time.Duration(duration) * time.Millisecond)
+
+	route := strings.TrimSpace(e.Route)
+	page := ""
+	if u, err := url.Parse(e.URL); err == nil {
+		page = u.Path
+	}
+	if route == "" {
+		route = page
+	}
+	if kind == "fetch" && e.Method != "" {
+		route = strings.ToUpper(e.Method) + " " + route
+	}
+
+	spanID := strings.TrimSpace(e.SpanID)
+	if spanID == "" {
+		var suffix [4]byte
+		rand.Read(suffix[:])
+		spanID = "rum-" + kind + "-" + strconv.FormatInt(start.UnixMilli(), 36) + "-" + hex.EncodeToString(suffix[:])
+	}
+	attrs := map[string]string{
+		"source":   "rum",
+		"rum_type": kind,
+	}
+	if page != "" {
+		attrs["page"] = page
+	}
+	if e.SessionID != "" {
+		attrs["session_id"] = e.SessionID
+	}
+	if e.TTFBMs > 0 {
+		attrs["ttfb_ms"] = strconv.FormatFloat(e.TTFBMs, 'f', 0, 64)
+	}
+	if e.DOMReadyMs > 0 {
+		attrs["dom_ready_ms"] = strconv.FormatFloat(e.DOMReadyMs, 'f', 0, 64)
+	}
+
+	return IngestEvent{
+		Timestamp:     end.UTC().Format(time.RFC3339Nano),
+		Service:       rumService(e.Service),
+		Env:           e.Env,
+		Host:          "browser",
+		Level:         "INFO",
+		Message:       kind,
+		CorrelationID: e.CorrelationID,
+		SpanID:        spanID,
+		Event:         "log",
+		Route:         route,
+		Method:        strings.ToUpper(e.Method),
+		StatusCode:    e.Status,
+		DurationMs:    duration,
+		Version:       e.Version,
+		Attrs:         attrs,
+	}, nil
+}
+
+func rumService(service string) string {
+	service = strings.TrimSpace(service)
+	switch {
+	case service == "":
+		return "browser"
+	case service == "browser" || strings.HasPrefix(service, "browser-"):
+		return service
+	}
+	return "browser-" + service
+}
@@ -0,0 +1,39 @@
+package model
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRUMToIngest(t *testing.T) {
+	cases := []struct {
+		service string
+		want    string
+	}{
+		{"", "browser"},
+		{"browser", "browser"},
+		{"browser-shop", "browser-shop"},
+		{"payments", "browser-payments"},
+		{" checkout ", "browser-checkout"},
+	}
+	for _, tc := range cases {
+		e := RUMEvent{Type: "fetch", Timestamp: "2026-02-18T08:10:11.050Z", DurationMs: 210, CorrelationID: "a1b2", Service: tc.service}
+		got, err := e.ToIngest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Service != tc.want {
+			t.Errorf("service %q mapped to %q, want %q", tc.service, got.Service, tc.want)
+		}
+	}
+
+	e := RUMEvent{Type: "page_load", Timestamp: "2026-02-18T08:10:10.900Z", DurationMs: 1450, CorrelationID: "a1b2"}
+	first, err := e.ToIngest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := e.ToIngest()
+	if !strings.HasPrefix(first.SpanID, "rum-page_load-") || first.SpanID == second.SpanID {
+		t.Fatalf("fallback span ids %q and %q must differ for identical beacons", first.SpanID, second.SpanID)
+	}
+}
@@ -0,0 +1,166 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const timeCacheSize = 1024
+
+var zonedLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+}
+
+var localLayouts = []string{
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+}
+
+var syslogLayouts = []string{
+	"Jan _2 15:04:05.999999999",
+	"Jan _2 2006 15:04:05.999999999",
+}
+
+type FlexTimestamp string
+
+func (t *FlexTimestamp) UnmarshalJSON(data []byte) error {
+	switch {
+	case len(data) == 0 || string(data) == "null":
+		return nil
+	case data[0] == '"':
+		var v string
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		*t = FlexTimestamp(v)
+	case data[0] == '-' || (data[0] >= '0' && data[0] <= '9'):
+		*t = FlexTimestamp(data)
+	default:
+		return fmt.Errorf("json: cannot unmarshal %s into timestamp", data)
+	}
+	return nil
+}
+
+type TimeSource struct {
+	now     time.Time
+	loc     *time.Location
+	lastRaw string
+	last    time.Time
+	cache   map[string]time.Time
+}
+
+func NewTimeSource(now time.Time, loc *time.Location) *TimeSource {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return &TimeSource{now: now.UTC(), loc: loc}
+}
+
+func (c *TimeSource) Now() time.Time {
+	return c.now
+}
+
+func (c *TimeSource) Parse(v string) (time.Time, error) {
+	if strings.TrimSpace(v) == "" {
+		return c.now, nil
+	}
+	if v == c.lastRaw {
+		return c.last, nil
+	}
+	ts, ok := c.cache[v]
+	if !ok {
+		var err error
+		if ts, err = parseTimestamp(v, c.loc, c.now); err != nil {
+			return time.Time{}, err
+		}
+		if c.cache == nil {
+			c.cache = map[string]time.Time{}
+		}
+		if len(c.cache) < timeCacheSize {
+			c.cache[v] = ts
+		}
+	}
+	c.lastRaw, c.last = v, ts
+	return ts, nil
+}
+
+func ParseTimestamp(v string) (time.Time, error) {
+	return parseTimestamp(v, time.UTC, time.Now())
+}
+
+func parseTimestamp(v string, loc *time.Location, now time.Time) (time.Time, error) {
+	if whole, frac, ok := strings.Cut(v, "."); isDigits(whole) && (!ok || isDigits(frac)) {
+		return parseEpoch(whole, frac, ok)
+	}
+	var firstErr error
+	for _, layout := range zonedLayouts {
+		ts, err := time.Parse(layout, v)
+		if err == nil {
+			return ts.UTC(), nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, layout := range localLayouts {
+		if ts, err := time.ParseInLocation(layout, v, loc); err == nil {
+			return ts.UTC(), nil
+		}
+	}
+	if ts, err := time.ParseInLocation(syslogLayouts[0], v, loc); err == nil {
+		local := now.In(loc)
+		ts = time.Date(local.Year(), ts.Month(), ts.Day(), ts.Hour(), ts.Minute(), ts.Second(), ts.Nanosecond(), loc)
+		if ts.After(local.AddDate(0, 0, 1)) {
+			ts = ts.AddDate(-1, 0, 0)
+		}
+		return ts.UTC(), nil
+	}
+	if ts, err := time.ParseInLocation(syslogLayouts[1], v, loc); err == nil {
+		return ts.UTC(), nil
+	}
+	return time.Time{}, firstErr
+}
+
+func parseEpoch(whole, frac string, fractional bool) (time.Time, error) {
+	n := atoi(whole)
+	switch {
+	case fractional:
+		if len(whole) > 10 {
+			return time.Time{}, fmt.Errorf("fractional epoch timestamp %q must be in seconds", whole+"."+frac)
+		}
+		frac = (frac + "000000000")[:9]
+		return time.Unix(int64(n), int64(atoi(frac))).UTC(), nil
+	case len(whole) <= 10:
+		return time.Unix(int64(n), 0).UTC(), nil
+	case len(whole) <= 13:
+		return time.UnixMilli(int64(n)).UTC(), nil
+	case len(whole) <= 16:
+		return time.UnixMicro(int64(n)).UTC(), nil
+	case len(whole) <= 19 && (len(whole) < 19 || whole <= "9223372036854775807"):
+		return time.Unix(0, int64(n)).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("epoch timestamp %q is out of range", whole)
+}
+
+func isDigits(v string) bool {
+	if v == "" {
+		return false
+	}
+	for i := 0; i < len(v); i++ {
+		if v[i] < '0' || v[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func atoi(v string) int {
+	n := 0
+	for i := 0; i < len(v); i++ {
+		n = n*10 + int(v[i]-'0')
+	}
+	return n
+}
@@ -0,0 +1,148 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var v2Kinds = map[string]struct{}{
+	"start":     {},
+	"end":       {},
+	"span":      {},
+	"log":       {},
+	"heartbeat": {},
+	"link":      {},
+}
+
+type IngestEventV2 struct {
+	Timestamp    FlexTimestamp  `json:"timestamp"`
+	Kind         string         `json:"kind"`
+	Service      string         `json:"service"`
+	Env          string         `json:"env"`
+	Host         string         `json:"host"`
+	Region       string         `json:"region"`
+	Zone         string         `json:"zone"`
+	Version      string         `json:"version"`
+	Level        string         `json:"level"`
+	Message      string         `json:"message"`
+	Status       string         `json:"status"`
+	TraceID      string         `json:"traceId"`
+	SpanID       string         `json:"spanId"`
+	ParentSpanID string         `json:"parentSpanId"`
+	Route        string         `json:"route"`
+	Method       string         `json:"method"`
+	StatusCode   uint16         `json:"statusCode"`
+	DurationMs   uint32         `json:"durationMs"`
+	LinkedTrace  string         `json:"linkedTraceId"`
+	LinkType     string         `json:"linkType"`
+	ErrorMessage string         `json:"errorMessage"`
+	ErrorType    string         `json:"errorType"`
+	Attrs        map[string]any `json:"attrs"`
+}
+
+func DecodeV2(data []byte) (IngestEvent, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	dec.UseNumber()
+	var e IngestEventV2
+	if err := dec.Decode(&e); err != nil {
+		return IngestEvent{}, err
+	}
+	if err := e.Validate(); err != nil {
+		return IngestEvent{}, err
+	}
+	return e.ToV1()
+}
+
+func (e IngestEventV2) Validate() error {
+	kind := strings.ToLower(strings.TrimSpace(e.Kind))
+	required := []struct{ name, value string }{
+		{"timestamp", string(e.Timestamp)},
+		{"kind", e.Kind},
+		{"service", e.Service},
+		{"env", e.Env},
+		{"host", e.Host},
+	}
+	if kind != "heartbeat" {
+		required = append(required, struct{ name, value string }{"traceId", e.TraceID})
+	}
+	if kind == "link" {
+		required = append(required, struct{ name, value string }{"linkedTraceId", e.LinkedTrace})
+	}
+	missing := make([]string, 0)
+	for _, f := range required {
+		if strings.TrimSpace(f.value) == "" {
+			missing = append(missing, f.name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required fields: %s", strings.Join(missing, ", "))
+	}
+	if _, err := ParseTimestamp(string(e.Timestamp)); err != nil {
+		return fmt.Errorf("invalid timestamp: %w", err)
+	}
+	if _, ok := v2Kinds[kind]; !ok {
+		return fmt.Errorf("invalid kind %q (expected start|end|span|log|heartbeat|link)", e.Kind)
+	}
+	if kind != "log" && kind != "heartbeat" && kind != "link" && strings.TrimSpace(e.SpanID) == "" {
+		return fmt.Errorf("kind %s requires spanId", kind)
+	}
+	if kind == "span" && e.DurationMs == 0 {
+		return fmt.Errorf("kind span requires durationMs")
+	}
+	return nil
+}
+
+func (e IngestEventV2) ToV1() (IngestEvent, error) {
+	attrs := make(map[string]string, len(e.Attrs))
+	for k, v := range e.Attrs {
+		s, err := attrString(v)
+		if err != nil {
+			return IngestEvent{}, fmt.Errorf("attrs.%s: %w", k, err)
+		}
+		attrs[k] = s
+	}
+	return IngestEvent{
+		Timestamp:     string(e.Timestamp),
+		Service:       e.Service,
+		Env:           e.Env,
+		Host:          e.Host,
+		Region:        e.Region,
+		Zone:          e.Zone,
+		Level:         e.Level,
+		Message:       e.Message,
+		Status:        e.Status,
+		CorrelationID: e.TraceID,
+		SpanID:        e.SpanID,
+		ParentSpanID:  e.ParentSpanID,
+		Event:         strings.ToLower(strings.TrimSpace(e.Kind)),
+		Route:         e.Route,
+		Method:        e.Method,
+		StatusCode:    e.StatusCode,
+		DurationMs:    e.DurationMs,
+		Version:       e.Version,
+		LinkedTraceID: e.LinkedTrace,
+		LinkType:      e.LinkType,
+		ErrorMessage:  e.ErrorMessage,
+		ErrorType:     e.ErrorType,
+		Attrs:         attrs,
+	}, nil
+}
+
+func attrString(v any) (string, error) {
+	switch t := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return t, nil
+	case bool:
+		return strconv.FormatBool(t), nil
+	case json.Number:
+		return t.String(), nil
+	default:
+		return "", fmt.Errorf("unsupported attr type %T (expected string, number or bool)", v)
+	}
+}
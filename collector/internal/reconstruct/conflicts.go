@@ -0,0 +1,34 @@
+package reconstruct
+
+import "slices"
+
+const (
+	ConflictMerge = "merge"
+	ConflictFirst = "first"
+)
+
+func (r *Reconstructor) SetConflictPolicy(policy string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if policy == "" {
+		policy = ConflictMerge
+	}
+	r.conflictPolicy = policy
+}
+
+func (s *spanState) conflict(field string) {
+	if !slices.Contains(s.conflicts, field) {
+		s.conflicts = append(s.conflicts, field)
+	}
+}
+
+func (r *Reconstructor) resolve(s *spanState, field string, differs, set bool) bool {
+	if !differs {
+		return false
+	}
+	if set {
+		s.conflict(field)
+		return r.conflictPolicy != ConflictFirst
+	}
+	return true
+}
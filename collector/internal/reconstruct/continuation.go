@@ -0,0 +1,118 @@
+package reconstruct
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"trace-lite/collector/internal/model"
+)
+
+const continuationTTL = 24 * time.Hour
+
+type continuation struct {
+	start       time.Time
+	transaction string
+	truncated   bool
+	dropped     int
+	flushedAt   time.Time
+}
+
+func (r *Reconstructor) SetMaxAge(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.maxAge = d
+}
+
+func (r *Reconstructor) rememberPartial(traces []*traceState) {
+	now := time.Now().UTC()
+	for _, t := range traces {
+		if !t.partial {
+			delete(r.continued, t.id)
+			continue
+		}
+		c := continuation{
+			transaction: tracedTransaction(t),
+			truncated:   t.truncated,
+			dropped:     t.dropped,
+			flushedAt:   now,
+		}
+		for _, s := range t.spans {
+			if start := stampTime(s.startTs); c.start.IsZero() || start.Before(c.start) {
+				c.start = start
+			}
+		}
+		if t.prior != nil {
+			if t.prior.transaction != "" {
+				c.transaction = t.prior.transaction
+			}
+			if t.prior.start.Before(c.start) {
+				c.start = t.prior.start
+			}
+			c.truncated = c.truncated || t.prior.truncated
+			c.dropped += t.prior.dropped
+		}
+		r.continued[t.id] = c
+		if live := r.traces[t.id]; live != nil && live.prior == nil {
+			live.prior = &c
+		}
+	}
+}
+
+func (r *Reconstructor) pruneContinued(now time.Time) {
+	for id, c := range r.continued {
+		if now.Sub(c.flushedAt) > continuationTTL {
+			delete(r.continued, id)
+		}
+	}
+	for id, at := range r.crossRegion {
+		if now.Sub(at) > federationLookback {
+			delete(r.crossRegion, id)
+		}
+	}
+}
+
+func (r *Reconstructor) restorePrior(ctx context.Context, traces []*traceState) error {
+	byID := map[string]*traceState{}
+	var ids []string
+	var from time.Time
+	for _, t := range traces {
+		if t.prior == nil || t.restored != nil {
+			continue
+		}
+		byID[t.id] = t
+		ids = append(ids, "'"+strings.ReplaceAll(strings.ReplaceAll(t.id, `\`, `\\`), `'`, `\'`)+"'")
+		if from.IsZero() || t.prior.start.Before(from) {
+			from = t.prior.start
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	query := fmt.Sprintf(`
+SELECT trace_id, span_id, parent_span_id, service, env, host, region, zone, version, operation, method, route, start_ts, end_ts, duration_ms, queue_ms, status_code, is_error, status, error_message, error_type, source, proxy, conflicts
+FROM spans FINAL
+WHERE trace_id IN (%s) AND start_ts >= toDateTime64('%s', 3, 'UTC')`, strings.Join(ids, ","), model.FormatCHTime(from))
+	return r.ch.QueryEachRow(ctx, query, func(line []byte) error {
+		var row model.SpanRow
+		if err := json.Unmarshal(line, &row); err != nil {
+			return err
+		}
+		t := byID[row.TraceID]
+		if t == nil {
+			return nil
+		}
+		if t.restored == nil {
+			t.restored = map[string]bool{}
+		}
+		if _, ok := t.spans[row.SpanID]; ok {
+			return nil
+		}
+		t.spans[row.SpanID] = spanFromRow(nil, row)
+		t.restored[row.SpanID] = true
+		return nil
+	})
+}
@@ -0,0 +1,190 @@
+package reconstruct
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"trace-lite/collector/internal/model"
+)
+
+const federationLookback = time.Hour
+
+type Federation interface {
+	Owner(traceID string) string
+	Forward(ctx context.Context, owner string, spans []model.SpanRow) error
+	Announce(ctx context.Context, traceIDs []string) error
+}
+
+func (r *Reconstructor) SetFederation(f Federation) {
+	r.flushMu.Lock()
+	defer r.flushMu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.federation = f
+}
+
+func (r *Reconstructor) AddSpans(rows []model.SpanRow) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().UTC()
+	added := 0
+	for _, row := range rows {
+		if row.TraceID == "" || row.SpanID == "" {
+			continue
+		}
+		t := r.traces[row.TraceID]
+		if t == nil {
+			t = &traceState{
+				id:        row.TraceID,
+				env:       r.strings.intern(row.Env),
+				firstSeen: now,
+				spans:     map[string]*spanState{},
+			}
+			r.traces[row.TraceID] = t
+		}
+		start := parseCHTime(row.StartTS)
+		r.lookBack(t, start)
+		if start.After(t.updatedAt) {
+			t.updatedAt = start
+		}
+		t.federated = true
+		t.lastSeen = now
+		t.widen(r.tuningFor(row.Env, row.Service))
+		if _, ok := t.spans[row.SpanID]; ok {
+			continue
+		}
+		if r.maxSpans > 0 && len(t.spans) >= r.maxSpans {
+			t.truncated = true
+			t.dropped++
+			continue
+		}
+		t.spans[row.SpanID] = spanFromRow(r.strings, row)
+		added++
+	}
+	r.inMemory.Store(int64(len(r.traces)))
+	return added
+}
+
+func (r *Reconstructor) MarkCrossRegion(traceIDs []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now().UTC()
+	for _, id := range traceIDs {
+		if id != "" {
+			r.crossRegion[id] = now
+		}
+	}
+}
+
+func (r *Reconstructor) lookBack(t *traceState, start time.Time) {
+	if t.prior == nil {
+		c := r.continued[t.id]
+		t.prior = &c
+	}
+	if from := start.Add(-federationLookback); t.prior.start.IsZero() || from.Before(t.prior.start) {
+		t.prior.start = from
+	}
+}
+
+func spanFromRow(in *interner, row model.SpanRow) *spanState {
+	return &spanState{
+		spanID:       row.SpanID,
+		parentSpanID: row.ParentSpanID,
+		service:      in.intern(row.Service),
+		env:          in.intern(row.Env),
+		host:         in.intern(row.Host),
+		region:       in.intern(row.Region),
+		zone:         in.intern(row.Zone),
+		version:      in.intern(row.Version),
+		operation:    in.intern(row.Operation),
+		method:       in.intern(row.Method),
+		route:        in.intern(row.Route),
+		startTs:      stamp(parseCHTime(row.StartTS)),
+		endTs:        stamp(parseCHTime(row.EndTS)),
+		durationMs:   row.DurationMs,
+		queueMs:      row.QueueMs,
+		statusCode:   row.StatusCode,
+		isError:      row.IsError == 1,
+		status:       row.Status,
+		errorMessage: row.ErrorMessage,
+		errorType:    in.intern(row.ErrorType),
+		source:       row.Source,
+		proxy:        in.intern(row.Proxy),
+		conflicts:    row.Conflicts,
+	}
+}
+
+func isFragment(t *traceState) bool {
+	for _, s := range t.spans {
+		if s.parentSpanID != "" && t.spans[s.parentSpanID] == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func fragmentRows(t *traceState) []model.SpanRow {
+	c := *t
+	c.spans = make(map[string]*spanState, len(t.spans))
+	for id, s := range t.spans {
+		cp := *s
+		c.spans[id] = &cp
+	}
+	rows := finalizeSpans(&c)
+	out := rows[:0]
+	for _, row := range rows {
+		if !t.restored[row.SpanID] {
+			out = append(out, row)
+		}
+	}
+	return out
+}
+
+func (r *Reconstructor) forwardFragments(ctx context.Context, traces []*traceState) []*traceState {
+	if r.federation == nil {
+		return traces
+	}
+	local := make([]*traceState, 0, len(traces))
+	byOwner := map[string][]*traceState{}
+	var announce []string
+	r.mu.Lock()
+	for _, t := range traces {
+		fragment := !t.federated && isFragment(t)
+		if fragment {
+			announce = append(announce, t.id)
+		}
+		_, claimed := r.crossRegion[t.id]
+		if !fragment && !claimed {
+			local = append(local, t)
+			continue
+		}
+		owner := r.federation.Owner(t.id)
+		if owner == "" {
+			for _, s := range t.spans {
+				r.lookBack(t, stampTime(s.startTs))
+			}
+			local = append(local, t)
+			continue
+		}
+		byOwner[owner] = append(byOwner[owner], t)
+	}
+	r.mu.Unlock()
+	if len(announce) > 0 {
+		if err := r.federation.Announce(ctx, announce); err != nil {
+			log.Printf("announce %d cross-region traces: %v", len(announce), err)
+		}
+	}
+	for owner, list := range byOwner {
+		var rows []model.SpanRow
+		for _, t := range list {
+			rows = append(rows, fragmentRows(t)...)
+		}
+		if err := r.federation.Forward(ctx, owner, rows); err != nil {
+			log.Printf("forward %d cross-region traces to %s: %v; writing them locally", len(list), owner, err)
+			local = append(local, list...)
+		}
+	}
+	return local
+}
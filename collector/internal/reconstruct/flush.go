@@ -0,0 +1,127 @@
+package reconstruct
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"trace-lite/collector/internal/model"
+)
+
+const minTracesPerWorker = 64
+
+type builtTrace struct {
+	spans []model.SpanRow
+	row   model.TraceRow
+}
+
+func (r *Reconstructor) SetFlushWorkers(n int) {
+	r.flushMu.Lock()
+	defer r.flushMu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.workers = n
+}
+
+func (r *Reconstructor) SetOnFinalize(fn func([]model.TraceRow, []model.SpanRow)) {
+	r.flushMu.Lock()
+	defer r.flushMu.Unlock()
+	r.onFinalize = fn
+}
+
+func (r *Reconstructor) flushWorkers(traces int) int {
+	n := r.workers
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	if limit := (traces + minTracesPerWorker - 1) / minTracesPerWorker; n > limit {
+		n = limit
+	}
+	return max(n, 1)
+}
+
+func (r *Reconstructor) buildRows(traces []*traceState) ([]model.SpanRow, []model.TraceRow, []model.DependencyEdgeRow) {
+	built := make([]builtTrace, len(traces))
+	workers := r.flushWorkers(len(traces))
+	aggs := make([]map[edgeKey]*edgeState, workers)
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for w := range aggs {
+		aggs[w] = map[edgeKey]*edgeState{}
+		wg.Go(func() {
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= len(traces) {
+					return
+				}
+				built[i] = r.buildTrace(traces[i], aggs[w])
+			}
+		})
+	}
+	wg.Wait()
+
+	spanCount, traceCount := 0, 0
+	for _, b := range built {
+		if len(b.spans) > 0 {
+			spanCount += len(b.spans)
+			traceCount++
+		}
+	}
+	spanRows := make([]model.SpanRow, 0, spanCount)
+	traceRows := make([]model.TraceRow, 0, traceCount)
+	for i := range built {
+		if len(built[i].spans) == 0 {
+			continue
+		}
+		spanRows = append(spanRows, built[i].spans...)
+		traceRows = append(traceRows, built[i].row)
+		built[i] = builtTrace{}
+	}
+	for _, agg := range aggs[1:] {
+		mergeEdgeAgg(aggs[0], agg)
+	}
+	return spanRows, traceRows, collapseEdgeAgg(aggs[0])
+}
+
+func (r *Reconstructor) buildTrace(t *traceState, edgeAgg map[edgeKey]*edgeState) builtTrace {
+	spans := finalizeSpans(t)
+	if len(spans) == 0 {
+		return builtTrace{}
+	}
+	row := buildTraceRow(t.env, t.id, spans)
+	row.Truncated = boolToUint8(t.truncated)
+	row.DroppedSpans = uint32(t.dropped)
+	row.Partial = boolToUint8(t.partial)
+	if tx := tracedTransaction(t); tx != "" {
+		row.Transaction = tx
+	}
+	if t.prior != nil {
+		if t.prior.transaction != "" {
+			row.Transaction = t.prior.transaction
+		}
+		row.Truncated |= boolToUint8(t.prior.truncated)
+		row.DroppedSpans += uint32(t.prior.dropped)
+	}
+	scoreIntegrity(t, spans, &row)
+	row.Labels = r.traceLabels(t, spans)
+	row.RetainDays = r.retainDays(row)
+	for i := range spans {
+		spans[i].RetainDays = row.RetainDays
+	}
+	r.accumulateEdges(spans, t.restored, edgeAgg)
+	return builtTrace{spans: spans, row: row}
+}
+
+func mergeEdgeAgg(dst, src map[edgeKey]*edgeState) {
+	for k, v := range src {
+		e := dst[k]
+		if e == nil {
+			dst[k] = v
+			continue
+		}
+		e.durations = append(e.durations, v.durations...)
+		e.errorCalls += v.errorCalls
+		e.cancelledCalls += v.cancelledCalls
+		e.timeoutCalls += v.timeoutCalls
+	}
+}
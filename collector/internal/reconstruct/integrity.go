@@ -0,0 +1,55 @@
+package reconstruct
+
+import (
+	"math"
+
+	"trace-lite/collector/internal/model"
+)
+
+const (
+	inferredWeight  = 0.3
+	orphanWeight    = 0.3
+	skewWeight      = 0.2
+	truncatedWeight = 0.2
+)
+
+func scoreIntegrity(t *traceState, spans []model.SpanRow, row *model.TraceRow) {
+	byID := make(map[string]model.SpanRow, len(spans))
+	for _, s := range spans {
+		byID[s.SpanID] = s
+	}
+	var inferred, orphans, skewed int
+	for _, s := range spans {
+		if s.Source == "inferred" || s.Source == "implicit" {
+			inferred++
+		}
+		if state := t.spans[s.SpanID]; state != nil && state.clockAdjusted {
+			skewed++
+			continue
+		}
+		if s.ParentSpanID == "" {
+			continue
+		}
+		parent, ok := byID[s.ParentSpanID]
+		if !ok {
+			orphans++
+			continue
+		}
+		if s.StartTS < parent.StartTS || s.EndTS > parent.EndTS {
+			skewed++
+		}
+	}
+	row.InferredSpans = uint16(min(inferred, math.MaxUint16))
+	row.OrphanSpans = uint16(min(orphans, math.MaxUint16))
+	row.SkewedSpans = uint16(min(skewed, math.MaxUint16))
+	row.Integrity = integrity(len(spans), inferred, orphans, skewed, row.Truncated == 1 || row.DroppedSpans > 0)
+}
+
+func integrity(spans, inferred, orphans, skewed int, truncated bool) float32 {
+	n := float64(max(spans, 1))
+	score := 1 - inferredWeight*float64(inferred)/n - orphanWeight*float64(orphans)/n - skewWeight*float64(skewed)/n
+	if truncated {
+		score -= truncatedWeight
+	}
+	return float32(math.Round(max(score, 0)*100) / 100)
+}
@@ -0,0 +1,49 @@
+package reconstruct
+
+import (
+	"strings"
+	"time"
+)
+
+const maxInterned = 1 << 16
+
+type interner struct {
+	strings map[string]string
+}
+
+func newInterner() *interner {
+	return &interner{strings: map[string]string{}}
+}
+
+func (in *interner) intern(s string) string {
+	if in == nil || s == "" {
+		return s
+	}
+	if v, ok := in.strings[s]; ok {
+		return v
+	}
+	if len(in.strings) >= maxInterned {
+		clear(in.strings)
+	}
+	v := strings.Clone(s)
+	in.strings[v] = v
+	return v
+}
+
+func stamp(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func stampTime(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
+
+func millis(from, to int64) uint32 {
+	return uint32((to - from) / int64(time.Millisecond))
+}
@@ -0,0 +1,122 @@
+package reconstruct
+
+import (
+	"sort"
+
+	"trace-lite/collector/internal/model"
+)
+
+const (
+	InternalOff     = "off"
+	InternalDepth   = "depth"
+	InternalModules = "modules"
+)
+
+type internalKey struct {
+	bucket       string
+	env          string
+	service      string
+	callerModule string
+	calleeModule string
+}
+
+type internalState struct {
+	durations  []uint32
+	errorCalls uint64
+	maxDepth   uint16
+}
+
+func (r *Reconstructor) SetInternalEdges(mode, moduleAttr string) {
+	r.flushMu.Lock()
+	defer r.flushMu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if mode == "" {
+		mode = InternalOff
+	}
+	r.internalMode = mode
+	r.moduleAttr = ""
+	if mode == InternalModules {
+		r.moduleAttr = moduleAttr
+	}
+}
+
+func (r *Reconstructor) internalEdges(traces []*traceState, spans []model.SpanRow) []model.InternalEdgeRow {
+	if r.internalMode == InternalOff || r.internalMode == "" {
+		return nil
+	}
+	byTrace := map[string]*traceState{}
+	for _, t := range traces {
+		byTrace[t.id] = t
+	}
+	byID := map[[2]string]model.SpanRow{}
+	for _, s := range spans {
+		byID[[2]string{s.TraceID, s.SpanID}] = s
+	}
+	depths := map[[2]string]uint16{}
+	var depth func(s model.SpanRow, hops int) uint16
+	depth = func(s model.SpanRow, hops int) uint16 {
+		k := [2]string{s.TraceID, s.SpanID}
+		if d, ok := depths[k]; ok {
+			return d
+		}
+		var d uint16
+		if p, ok := byID[[2]string{s.TraceID, s.ParentSpanID}]; ok && s.ParentSpanID != "" && p.Service == s.Service && hops < len(spans) {
+			d = depth(p, hops+1) + 1
+		}
+		depths[k] = d
+		return d
+	}
+
+	agg := map[internalKey]*internalState{}
+	for _, s := range spans {
+		t := byTrace[s.TraceID]
+		if s.ParentSpanID == "" || (t != nil && t.restored[s.SpanID]) {
+			continue
+		}
+		p, ok := byID[[2]string{s.TraceID, s.ParentSpanID}]
+		if !ok || p.Service != s.Service {
+			continue
+		}
+		k := internalKey{bucket: toMinute(s.StartTS), env: s.Env, service: s.Service}
+		if r.internalMode == InternalModules && t != nil {
+			if ps := t.spans[p.SpanID]; ps != nil {
+				k.callerModule = ps.module
+			}
+			if cs := t.spans[s.SpanID]; cs != nil {
+				k.calleeModule = cs.module
+			}
+		}
+		e := agg[k]
+		if e == nil {
+			e = &internalState{}
+			agg[k] = e
+		}
+		e.durations = append(e.durations, s.DurationMs)
+		if s.IsError == 1 {
+			e.errorCalls++
+		}
+		if d := depth(s, 0); d > e.maxDepth {
+			e.maxDepth = d
+		}
+	}
+
+	out := make([]model.InternalEdgeRow, 0, len(agg))
+	for k, v := range agg {
+		sort.Slice(v.durations, func(i, j int) bool { return v.durations[i] < v.durations[j] })
+		out = append(out, model.InternalEdgeRow{
+			BucketTS:     k.bucket,
+			Env:          k.env,
+			Service:      k.service,
+			CallerModule: k.callerModule,
+			CalleeModule: k.calleeModule,
+			Calls:        uint64(len(v.durations)),
+			ErrorCalls:   v.errorCalls,
+			MaxDepth:     v.maxDepth,
+			P50Ms:        float32(percentile(v.durations, 0.50)),
+			P95Ms:        float32(percentile(v.durations, 0.95)),
+			MaxMs:        v.durations[len(v.durations)-1],
+		})
+	}
+	return out
+}
@@ -0,0 +1,86 @@
+package reconstruct
+
+import (
+	"strconv"
+	"strings"
+
+	"trace-lite/collector/internal/model"
+	"trace-lite/collector/internal/rules"
+)
+
+func (r *Reconstructor) SetLabels(labels []rules.Label) {
+	r.flushMu.Lock()
+	defer r.flushMu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	seen := map[string]bool{}
+	var attrs []string
+	for _, l := range labels {
+		for _, a := range l.Attrs() {
+			if !seen[a] {
+				seen[a] = true
+				attrs = append(attrs, a)
+			}
+		}
+	}
+	r.labels, r.labelAttrs = labels, attrs
+}
+
+func (r *Reconstructor) traceLabels(t *traceState, spans []model.SpanRow) []string {
+	out := []string{}
+	for _, l := range r.labels {
+		matched := 0
+		for _, row := range spans {
+			var attrs map[string]string
+			if s := t.spans[row.SpanID]; s != nil {
+				attrs = s.attrs
+			}
+			if l.Matches(spanField(row, attrs)) {
+				matched++
+			}
+		}
+		if l.Holds(matched, len(spans)) {
+			out = append(out, l.Name)
+		}
+	}
+	return out
+}
+
+func spanField(row model.SpanRow, attrs map[string]string) func(string) string {
+	return func(field string) string {
+		switch field {
+		case "service":
+			return row.Service
+		case "host":
+			return row.Host
+		case "version":
+			return row.Version
+		case "operation":
+			return row.Operation
+		case "method":
+			return row.Method
+		case "route":
+			return row.Route
+		case "status":
+			return row.Status
+		case "error_type":
+			return row.ErrorType
+		case "source":
+			return row.Source
+		case "is_error":
+			return strconv.Itoa(int(row.IsError))
+		case "status_code":
+			return strconv.Itoa(int(row.StatusCode))
+		case "duration_ms":
+			return strconv.FormatUint(uint64(row.DurationMs), 10)
+		case "self_time_ms":
+			return strconv.FormatUint(uint64(row.SelfTimeMs), 10)
+		case "parent_span_id":
+			return row.ParentSpanID
+		}
+		if name, ok := strings.CutPrefix(field, "attr."); ok {
+			return attrs[name]
+		}
+		return ""
+	}
+}
@@ -0,0 +1,115 @@
+package reconstruct
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"trace-lite/collector/internal/model"
+)
+
+const mergeLookback = time.Hour
+
+func (r *Reconstructor) SetPartials(collectorID string) {
+	r.flushMu.Lock()
+	defer r.flushMu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectorID = collectorID
+}
+
+func partialRows(collectorID string, traceRows []model.TraceRow, spanRows []model.SpanRow) []model.TracePartialRow {
+	out := make([]model.TracePartialRow, 0, len(traceRows))
+	next := 0
+	for _, row := range traceRows {
+		p := model.TracePartialRow{TraceRow: row, CollectorID: collectorID, Services: []string{}}
+		seen := map[string]bool{}
+		for ; next < len(spanRows) && spanRows[next].TraceID == row.TraceID; next++ {
+			s := spanRows[next]
+			if s.ParentSpanID == "" {
+				p.HasRoot = 1
+			}
+			if !seen[s.Service] {
+				seen[s.Service] = true
+				p.Services = append(p.Services, s.Service)
+			}
+		}
+		sort.Strings(p.Services)
+		out = append(out, p)
+	}
+	return out
+}
+
+func (r *Reconstructor) RunTraceMerge(ctx context.Context, every, delay time.Duration) {
+	r.mu.Lock()
+	collectorID := r.collectorID
+	r.mu.Unlock()
+	if collectorID == "" {
+		return
+	}
+	since := time.Now().UTC().Add(-delay - mergeLookback)
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			until := time.Now().UTC().Add(-delay)
+			if err := r.mergePartials(ctx, collectorID, since, until); err != nil {
+				log.Printf("trace merge failed: %v", err)
+				continue
+			}
+			since = until
+		}
+	}
+}
+
+func (r *Reconstructor) mergePartials(ctx context.Context, collectorID string, since, until time.Time) error {
+	query := fmt.Sprintf(`
+INSERT INTO traces (trace_id, env, root_service, root_operation, root_status_code, transaction, start_ts, end_ts, duration_ms,
+  span_count, service_count, error_count, critical_path_ms, versions, regions, zones, truncated, dropped_spans, partial, labels,
+  integrity, inferred_spans, orphan_spans, skewed_spans, retain_days)
+SELECT
+  trace_id,
+  argMin(env, start_ts),
+  argMin(root_service, (1 - has_root, start_ts)),
+  argMin(root_operation, (1 - has_root, start_ts)),
+  argMin(root_status_code, (1 - has_root, start_ts)),
+  argMin(transaction, (transaction = '', 1 - has_root, start_ts)),
+  min(start_ts),
+  max(end_ts),
+  toUInt32(dateDiff('millisecond', min(start_ts), max(end_ts))),
+  toUInt16(least(sum(span_count), 65535)),
+  toUInt16(length(groupUniqArrayArray(services))),
+  toUInt16(least(sum(error_count), 65535)),
+  max(critical_path_ms),
+  arraySort(groupUniqArrayArray(versions)),
+  arraySort(groupUniqArrayArray(regions)),
+  arraySort(groupUniqArrayArray(zones)),
+  max(truncated),
+  toUInt32(sum(dropped_spans)),
+  argMax(partial, end_ts),
+  arraySort(groupUniqArrayArray(labels)),
+  toFloat32(round(greatest(1
+    - 0.3 * sum(inferred_spans) / greatest(sum(span_count), 1)
+    - 0.3 * argMin(orphan_spans, (1 - has_root, start_ts)) / greatest(sum(span_count), 1)
+    - 0.2 * sum(skewed_spans) / greatest(sum(span_count), 1)
+    - if(max(truncated) = 1 OR sum(dropped_spans) > 0, 0.2, 0), 0), 2)),
+  toUInt16(least(sum(inferred_spans), 65535)),
+  argMin(orphan_spans, (1 - has_root, start_ts)),
+  toUInt16(least(sum(skewed_spans), 65535)),
+  max(retain_days)
+FROM trace_partials FINAL
+WHERE trace_id IN (
+  SELECT trace_id FROM trace_partials
+  WHERE collector_id = '%s'
+    AND written_at > toDateTime64('%s', 3, 'UTC') AND written_at <= toDateTime64('%s', 3, 'UTC')
+)
+GROUP BY trace_id`,
+		strings.ReplaceAll(strings.ReplaceAll(collectorID, `\`, `\\`), `'`, `\'`), model.FormatCHTime(since), model.FormatCHTime(until))
+	return r.ch.Exec(ctx, query, nil)
+}
@@ -0,0 +1,57 @@
+package reconstruct
+
+import (
+	"context"
+	"slices"
+
+	"trace-lite/collector/internal/model"
+)
+
+func (r *Reconstructor) SetPriorityServices(services []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.priority = services
+}
+
+func (r *Reconstructor) urgent(t *traceState) bool {
+	for _, s := range t.spans {
+		if s.isError || s.timedOut || s.status == "error" || s.status == "timeout" || slices.Contains(r.priority, s.service) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Reconstructor) writeLanes(ctx context.Context, traces []*traceState) error {
+	r.mu.Lock()
+	urgent := map[string]bool{}
+	for _, t := range traces {
+		if r.urgent(t) {
+			urgent[t.id] = true
+		}
+	}
+	r.mu.Unlock()
+	if len(urgent) == len(traces) {
+		urgent = nil
+	}
+	return r.write(ctx, traces, urgent)
+}
+
+func splitUrgent(spanRows []model.SpanRow, traceRows []model.TraceRow, urgent map[string]bool) (first, rest []model.SpanRow, firstTraces, restTraces []model.TraceRow) {
+	next := 0
+	for _, row := range traceRows {
+		end := next
+		for end < len(spanRows) && spanRows[end].TraceID == row.TraceID {
+			end++
+		}
+		if urgent[row.TraceID] {
+			first = append(first, spanRows[next:end]...)
+			firstTraces = append(firstTraces, row)
+		} else {
+			rest = append(rest, spanRows[next:end]...)
+			restTraces = append(restTraces, row)
+		}
+		next = end
+	}
+	return first, rest, firstTraces, restTraces
+}
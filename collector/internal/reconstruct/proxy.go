@@ -0,0 +1,99 @@
+package reconstruct
+
+import (
+	"strings"
+
+	"trace-lite/collector/internal/model"
+)
+
+const (
+	ProxyCollapse    = "collapse"
+	ProxyPassthrough = "passthrough"
+)
+
+func (r *Reconstructor) SetProxies(services []string, mode string) {
+	r.flushMu.Lock()
+	defer r.flushMu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.proxies = services
+	if mode == "" {
+		mode = ProxyCollapse
+	}
+	r.proxyMode = mode
+}
+
+func (r *Reconstructor) isProxy(service string) bool {
+	for _, p := range r.proxies {
+		if p == service || (strings.HasSuffix(p, "*") && strings.HasPrefix(service, strings.TrimSuffix(p, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+func spanKind(row model.RawLogRow) string {
+	kind := strings.ToLower(strings.TrimSpace(row.Attrs["span.kind"]))
+	return strings.TrimPrefix(kind, "span_kind_")
+}
+
+func (r *Reconstructor) claimSpan(s *spanState, in *interner, row model.RawLogRow) {
+	kind := spanKind(row)
+	if kind == "proxy" || r.isProxy(row.Service) {
+		if s.proxy == "" {
+			s.proxy = in.intern(row.Service)
+		}
+		return
+	}
+	if row.Service == "" || row.Service == s.service {
+		if s.kind == "" {
+			s.kind = kind
+		}
+		return
+	}
+	ownedByProxy := s.proxy != "" && s.proxy == s.service
+	takeover := kind == "server" && s.kind == "client"
+	if !ownedByProxy && !takeover {
+		if kind != "client" || s.kind != "server" {
+			s.conflict("service")
+		}
+		return
+	}
+	s.service, s.host, s.kind = in.intern(row.Service), in.intern(row.Host), kind
+	if row.Region != "" || row.Zone != "" {
+		s.region, s.zone = in.intern(row.Region), in.intern(row.Zone)
+	}
+	if row.Version != "" {
+		s.version = in.intern(row.Version)
+	}
+	if row.Route != "" {
+		method, route := model.SplitRoute(row.Method, row.Route)
+		s.operation, s.method, s.route = in.intern(row.Route), in.intern(method), in.intern(route)
+	}
+}
+
+func isProxySpan(s model.SpanRow) bool {
+	return s.Proxy != "" && s.Proxy == s.Service
+}
+
+func (r *Reconstructor) edgeHops(parent, child model.SpanRow, byID map[string]model.SpanRow) [][2]model.SpanRow {
+	if r.proxyMode == ProxyPassthrough {
+		if child.Proxy == "" || isProxySpan(child) {
+			return [][2]model.SpanRow{{parent, child}}
+		}
+		hop := child
+		hop.Service, hop.Version = child.Proxy, ""
+		return [][2]model.SpanRow{{parent, hop}, {hop, child}}
+	}
+	if isProxySpan(child) {
+		return nil
+	}
+	for seen := 0; isProxySpan(parent) && seen < len(byID); seen++ {
+		up, ok := byID[parent.ParentSpanID]
+		if !ok {
+			return nil
+		}
+		parent = up
+	}
+	return [][2]model.SpanRow{{parent, child}}
+}
@@ -0,0 +1,25 @@
+package reconstruct
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+func (r *Reconstructor) SetQueueAttrs(attrs []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.queueAttrs = attrs
+}
+
+func queueTime(attrs map[string]string, keys []string) uint32 {
+	var total float64
+	for _, k := range keys {
+		v, err := strconv.ParseFloat(strings.TrimSpace(attrs[k]), 64)
+		if err != nil || v <= 0 || math.IsInf(v, 0) {
+			continue
+		}
+		total += v
+	}
+	return uint32(min(math.Round(total), math.MaxUint32))
+}
@@ -2,53 +2,128 @@ package reconstruct
 
 import (
 	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"trace-lite/collector/internal/clickhouse"
 	"trace-lite/collector/internal/model"
+	"trace-lite/collector/internal/rules"
 )
 
 type Reconstructor struct {
-	mu            sync.Mutex
-	traces        map[string]*traceState
-	window        time.Duration
-	flushInterval time.Duration
-	ch            *clickhouse.Client
+	mu             sync.Mutex
+	flushMu        sync.Mutex
+	traces         map[string]*traceState
+	window         time.Duration
+	flushInterval  time.Duration
+	maxSpans       int
+	txAttr         string
+	ch             clickhouse.Interface
+	rerollupMu     sync.Mutex
+	inMemory       atomic.Int64
+	statusMu       sync.Mutex
+	lastFlush      time.Time
+	lastErr        string
+	lastErrAt      time.Time
+	overrides      Overrides
+	lastDue        map[string]time.Time
+	maxAge         time.Duration
+	continued      map[string]continuation
+	errorRules     []rules.Rule
+	timeouts       map[string]time.Duration
+	labels         []rules.Label
+	labelAttrs     []string
+	queueAttrs     []string
+	conflictPolicy string
+	proxies        []string
+	proxyMode      string
+	internalMode   string
+	moduleAttr     string
+	tiers          map[string]int
+	promoting      atomic.Bool
+	promotions     []promotion
+	strings        *interner
+	workers        int
+	collectorID    string
+	federation     Federation
+	crossRegion    map[string]time.Time
+	priority       []string
+	onFinalize     func([]model.TraceRow, []model.SpanRow)
 }
 
 type traceState struct {
 	id        string
 	env       string
+	firstSeen time.Time
+	lastSeen  time.Time
 	updatedAt time.Time
 	spans     map[string]*spanState
+	truncated bool
+	dropped   int
+	late      bool
+	window    time.Duration
+	flush     time.Duration
+	partial   bool
+	federated bool
+	prior     *continuation
+	restored  map[string]bool
 }
 
 type spanState struct {
-	traceID      string
-	spanID       string
-	parentSpanID string
-	service      string
-	env          string
-	host         string
-	version      string
-	operation    string
-	startTs      time.Time
-	endTs        time.Time
-	durationMs   uint32
-	statusCode   uint16
-	isError      bool
-	source       string
-}
-
-func New(ch *clickhouse.Client, window, flushInterval time.Duration) *Reconstructor {
+	spanID        string
+	parentSpanID  string
+	service       string
+	env           string
+	host          string
+	region        string
+	zone          string
+	version       string
+	operation     string
+	method        string
+	route         string
+	status        string
+	errorMessage  string
+	errorType     string
+	source        string
+	transaction   string
+	kind          string
+	proxy         string
+	module        string
+	attrs         map[string]string
+	conflicts     []string
+	startTs       int64
+	endTs         int64
+	timeout       time.Duration
+	durationMs    uint32
+	queueMs       uint32
+	statusCode    uint16
+	isError       bool
+	timedOut      bool
+	clockAdjusted bool
+}
+
+func New(ch clickhouse.Interface, window, flushInterval time.Duration, maxSpans int, txAttr string) *Reconstructor {
 	return &Reconstructor{
 		traces:        map[string]*traceState{},
 		window:        window,
 		flushInterval: flushInterval,
+		maxSpans:      maxSpans,
+		txAttr:        txAttr,
 		ch:            ch,
+		overrides:     Overrides{Env: map[string]Tuning{}, Service: map[string]Tuning{}},
+		lastDue:       map[string]time.Time{},
+		continued:     map[string]continuation{},
+		crossRegion:   map[string]time.Time{},
+		proxyMode:     ProxyCollapse,
+		internalMode:  InternalOff,
+		strings:       newInterner(),
 	}
 }
 
@@ -56,88 +131,199 @@ func (r *Reconstructor) Add(rows []model.RawLogRow, eventTimes []time.Time) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	now := time.Now().UTC()
+	implicit := map[uint64]uint64{}
 	for i, row := range rows {
-		ts := eventTimes[i]
+		_, known := r.traces[row.TraceID]
+		r.addRow(r.traces, r.strings, implicit, row, eventTimes[i])
+		tu := r.tuningFor(row.Env, row.Service)
 		t := r.traces[row.TraceID]
-		if t == nil {
-			t = &traceState{
-				id:    row.TraceID,
-				env:   row.Env,
-				spans: map[string]*spanState{},
-			}
-			r.traces[row.TraceID] = t
-		}
-		if ts.After(t.updatedAt) {
-			t.updatedAt = ts
-		}
-
-		spanID := row.SpanID
-		if spanID == "" {
-			spanID = "implicit-" + model.FormatCHTime(ts)
-		}
-		s := t.spans[spanID]
-		if s == nil {
-			s = &spanState{
-				traceID:      row.TraceID,
-				spanID:       spanID,
-				parentSpanID: row.ParentSpanID,
-				service:      row.Service,
-				env:          row.Env,
-				host:         row.Host,
-				version:      row.Version,
-				operation:    chooseOperation(row.Route, row.Message),
-				source:       "explicit",
+		t.lastSeen = now
+		if !known {
+			if c, ok := r.continued[row.TraceID]; ok {
+				t.prior = &c
 			}
-			t.spans[spanID] = s
 		}
-
-		if row.ParentSpanID != "" {
-			s.parentSpanID = row.ParentSpanID
+		t.widen(tu)
+		if eventTimes[i].Before(now.Add(-(tu.Window + tu.Flush))) {
+			t.late = true
 		}
-		if s.service == "" {
-			s.service = row.Service
-		}
-		if s.version == "" {
-			s.version = row.Version
+	}
+	r.inMemory.Store(int64(len(r.traces)))
+}
+
+func (r *Reconstructor) Preview(rows []model.RawLogRow, eventTimes []time.Time) ([]model.SpanRow, []model.TraceRow, []model.DependencyEdgeRow) {
+	traces := map[string]*traceState{}
+	implicit := map[uint64]uint64{}
+	r.mu.Lock()
+	for i, row := range rows {
+		r.addRow(traces, nil, implicit, row, eventTimes[i])
+	}
+	r.mu.Unlock()
+	list := make([]*traceState, 0, len(traces))
+	for _, t := range traces {
+		list = append(list, t)
+	}
+	return r.buildRows(list)
+}
+
+func implicitSpanID(row model.RawLogRow, ts time.Time, seen map[uint64]uint64) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%d\x00%s", row.TraceID, row.Service, row.Host, ts.UnixNano(), row.Message)
+	key := h.Sum64()
+	fmt.Fprintf(h, "\x00%d", seen[key])
+	seen[key]++
+	return fmt.Sprintf("implicit-%016x", h.Sum64())
+}
+
+func (r *Reconstructor) addRow(traces map[string]*traceState, in *interner, implicit map[uint64]uint64, row model.RawLogRow, ts time.Time) {
+	t := traces[row.TraceID]
+	if t == nil {
+		t = &traceState{
+			id:        row.TraceID,
+			env:       in.intern(row.Env),
+			firstSeen: time.Now().UTC(),
+			spans:     map[string]*spanState{},
 		}
-		if s.host == "" {
-			s.host = row.Host
+		traces[row.TraceID] = t
+	}
+	if ts.After(t.updatedAt) {
+		t.updatedAt = ts
+	}
+
+	spanID := row.SpanID
+	if spanID == "" {
+		spanID = implicitSpanID(row, ts, implicit)
+	}
+	s := t.spans[spanID]
+	if s == nil {
+		if r.maxSpans > 0 && len(t.spans) >= r.maxSpans {
+			if !t.truncated {
+				log.Printf("trace %s exceeded %d spans, dropping further spans", t.id, r.maxSpans)
+			}
+			t.truncated = true
+			t.dropped++
+			return
 		}
-		if s.operation == "" {
-			s.operation = chooseOperation(row.Route, row.Message)
+		s = &spanState{
+			spanID:       spanID,
+			parentSpanID: row.ParentSpanID,
+			service:      in.intern(row.Service),
+			env:          in.intern(row.Env),
+			host:         in.intern(row.Host),
+			region:       in.intern(row.Region),
+			zone:         in.intern(row.Zone),
+			version:      in.intern(row.Version),
+			operation:    in.intern(chooseOperation(row.Route, row.Message)),
+			source:       "explicit",
+		}
+		if row.SpanID == "" {
+			s.source = "implicit"
+		}
+		if row.Attrs["source"] == "rum" {
+			s.source = "rum"
+		}
+		t.spans[spanID] = s
+	}
+	r.claimSpan(s, in, row)
+
+	if row.ParentSpanID != "" && r.resolve(s, "parent", row.ParentSpanID != s.parentSpanID, s.parentSpanID != "") {
+		s.parentSpanID = row.ParentSpanID
+	}
+	if s.service == "" {
+		s.service = in.intern(row.Service)
+	}
+	if s.version == "" {
+		s.version = in.intern(row.Version)
+	}
+	if s.host == "" {
+		s.host = in.intern(row.Host)
+	}
+	if s.region == "" {
+		s.region = in.intern(row.Region)
+	}
+	if s.zone == "" {
+		s.zone = in.intern(row.Zone)
+	}
+	if s.operation == "" {
+		s.operation = in.intern(chooseOperation(row.Route, row.Message))
+	}
+	if method, route := model.SplitRoute(row.Method, row.Route); route != "" && s.route == "" {
+		s.method, s.route = in.intern(method), in.intern(route)
+	} else if s.method == "" {
+		s.method = in.intern(method)
+	}
+	for _, k := range r.labelAttrs {
+		if v, ok := row.Attrs[k]; ok {
+			if s.attrs == nil {
+				s.attrs = map[string]string{}
+			}
+			s.attrs[k] = in.intern(v)
 		}
-		if row.StatusCode >= 400 {
-			s.isError = true
-			s.statusCode = row.StatusCode
+	}
+	if row.Attrs["client_ts"] != "" {
+		s.clockAdjusted = true
+	}
+	if q := queueTime(row.Attrs, r.queueAttrs); q > s.queueMs {
+		s.queueMs = q
+	}
+	if r.moduleAttr != "" && s.module == "" {
+		s.module = in.intern(strings.TrimSpace(row.Attrs[r.moduleAttr]))
+	}
+	if r.txAttr != "" && s.transaction == "" {
+		s.transaction = in.intern(strings.TrimSpace(row.Attrs[r.txAttr]))
+	}
+	outcome := rules.First(r.errorRules, row)
+	if row.StatusCode >= 400 && outcome == "" {
+		s.isError = true
+	}
+	if outcome == "" && (row.StatusCode == 408 || row.StatusCode == 504 ||
+		strings.Contains(strings.ToLower(row.Attrs["error_type"]), "timeout") || strings.EqualFold(row.Attrs["timeout"], "true")) {
+		s.timedOut = true
+	}
+	if s.timeout == 0 {
+		s.timeout = r.clientTimeout(row.Service)
+	}
+	st := model.SpanStatus(row.Attrs["status"])
+	if outcome != "" {
+		st = outcome
+	}
+	if statusRank[st] > statusRank[s.status] {
+		s.status = st
+	}
+	if s.errorMessage == "" {
+		s.errorMessage = row.Attrs["error_message"]
+	}
+	if s.errorType == "" {
+		s.errorType = in.intern(row.Attrs["error_type"])
+	}
+	if row.StatusCode > 0 && r.resolve(s, "status_code", row.StatusCode != s.statusCode, s.statusCode > 0) {
+		s.statusCode = row.StatusCode
+	}
+
+	at := stamp(ts)
+	switch row.Event {
+	case "start":
+		if s.startTs == 0 || at < s.startTs {
+			s.startTs = at
 		}
-		if strings.EqualFold(row.Attrs["status"], "ERROR") || strings.EqualFold(row.Attrs["status"], "FAIL") {
-			s.isError = true
+	case "end":
+		if s.endTs == 0 || at > s.endTs {
+			s.endTs = at
 		}
-		if row.StatusCode > 0 {
-			s.statusCode = row.StatusCode
+		if row.DurationMs > 0 && r.resolve(s, "duration", row.DurationMs != s.durationMs, s.durationMs > 0) {
+			s.durationMs = row.DurationMs
 		}
-
-		switch row.Event {
-		case "start":
-			if s.startTs.IsZero() || ts.Before(s.startTs) {
-				s.startTs = ts
+	default:
+		if row.DurationMs > 0 {
+			if s.endTs == 0 || at > s.endTs {
+				s.endTs = at
 			}
-		case "end":
-			if s.endTs.IsZero() || ts.After(s.endTs) {
-				s.endTs = ts
+			candidateStart := at - int64(time.Duration(row.DurationMs)*time.Millisecond)
+			if s.startTs == 0 || candidateStart < s.startTs {
+				s.startTs = candidateStart
 			}
-			if row.DurationMs > 0 {
-				s.durationMs = row.DurationMs
-			}
-		default:
-			if row.DurationMs > 0 {
-				if s.endTs.IsZero() || ts.After(s.endTs) {
-					s.endTs = ts
-				}
-				candidateStart := ts.Add(-time.Duration(row.DurationMs) * time.Millisecond)
-				if s.startTs.IsZero() || candidateStart.Before(s.startTs) {
-					s.startTs = candidateStart
-				}
+			if r.resolve(s, "duration", row.DurationMs != s.durationMs, s.durationMs > 0) {
 				s.durationMs = row.DurationMs
 			}
 		}
@@ -145,54 +331,258 @@ func (r *Reconstructor) Add(rows []model.RawLogRow, eventTimes []time.Time) {
 }
 
 func (r *Reconstructor) Run(ctx context.Context) {
-	ticker := time.NewTicker(r.flushInterval)
-	defer ticker.Stop()
+	timer := time.NewTimer(r.tickInterval())
+	defer timer.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
+		case <-timer.C:
 			r.FlushNow(ctx)
+			timer.Reset(r.tickInterval())
 		}
 	}
 }
 
 func (r *Reconstructor) FlushNow(ctx context.Context) {
+	r.flushMu.Lock()
+	defer r.flushMu.Unlock()
+	_ = r.writeLanes(ctx, r.takeExpired())
+}
+
+func (r *Reconstructor) takeExpired() []*traceState {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	now := time.Now().UTC()
-	var spanRows []model.SpanRow
-	var traceRows []model.TraceRow
-	edgeAgg := map[edgeKey]*edgeState{}
-
+	due := map[string]bool{}
+	var expired []*traceState
 	for traceID, t := range r.traces {
-		if now.Sub(t.updatedAt) < r.window {
-			continue
+		window, flush := t.window, t.flush
+		if window == 0 {
+			window, flush = r.window, r.flushInterval
 		}
-
-		spans := finalizeSpans(t)
-		if len(spans) == 0 {
+		if r.maxAge > 0 && now.Sub(t.firstSeen) >= r.maxAge {
+			t.partial = true
+			expired = append(expired, t)
 			delete(r.traces, traceID)
 			continue
 		}
-		spanRows = append(spanRows, spans...)
-		traceRows = append(traceRows, buildTraceRow(t.env, traceID, spans))
-		accumulateEdges(spans, edgeAgg)
+		if now.Sub(t.lastSeen) < window {
+			continue
+		}
+		key := t.env + "|" + flush.String()
+		isDue, seen := due[key]
+		if !seen {
+			isDue = now.Sub(r.lastDue[key]) >= flush-flush/10
+			due[key] = isDue
+		}
+		if !isDue && !r.urgent(t) {
+			continue
+		}
+		expired = append(expired, t)
 		delete(r.traces, traceID)
 	}
+	for key, isDue := range due {
+		if isDue {
+			r.lastDue[key] = now
+		}
+	}
+	r.pruneContinued(now)
+	r.inMemory.Store(int64(len(r.traces)))
+	return expired
+}
+
+func (r *Reconstructor) FlushTrace(ctx context.Context, traceID string) (bool, error) {
+	r.flushMu.Lock()
+	defer r.flushMu.Unlock()
 
-	if len(spanRows) > 0 {
-		_ = r.ch.InsertJSONEachRow(ctx, "spans", spanRows)
+	r.mu.Lock()
+	t, ok := r.traces[traceID]
+	if ok {
+		delete(r.traces, traceID)
+		r.inMemory.Store(int64(len(r.traces)))
 	}
-	if len(traceRows) > 0 {
-		_ = r.ch.InsertJSONEachRow(ctx, "traces", traceRows)
+	r.mu.Unlock()
+	if !ok {
+		return false, nil
 	}
-	if len(edgeAgg) > 0 {
-		edges := collapseEdgeAgg(edgeAgg)
-		_ = r.ch.InsertJSONEachRow(ctx, "dependency_edges_minute", edges)
+	return true, r.write(ctx, []*traceState{t}, nil)
+}
+
+func (r *Reconstructor) Forget(traceIDs []string) int {
+	r.flushMu.Lock()
+	defer r.flushMu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, id := range traceIDs {
+		if _, ok := r.traces[id]; ok {
+			delete(r.traces, id)
+			n++
+		}
+		delete(r.continued, id)
 	}
+	r.inMemory.Store(int64(len(r.traces)))
+	return n
+}
+
+type TraceInfo struct {
+	TraceID     string    `json:"trace_id"`
+	Env         string    `json:"env"`
+	SpanCount   int       `json:"span_count"`
+	Services    []string  `json:"services"`
+	FirstSeen   time.Time `json:"first_seen"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	AgeSeconds  float64   `json:"age_seconds"`
+	IdleSeconds float64   `json:"idle_seconds"`
+	Truncated   bool      `json:"truncated"`
+	Dropped     int       `json:"dropped_spans"`
+	Continued   bool      `json:"continued"`
+}
+
+func (r *Reconstructor) Snapshot() []TraceInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().UTC()
+	out := make([]TraceInfo, 0, len(r.traces))
+	for id, t := range r.traces {
+		services := map[string]struct{}{}
+		for _, s := range t.spans {
+			services[s.service] = struct{}{}
+		}
+		names := make([]string, 0, len(services))
+		for svc := range services {
+			names = append(names, svc)
+		}
+		sort.Strings(names)
+		out = append(out, TraceInfo{
+			TraceID:     id,
+			Env:         t.env,
+			SpanCount:   len(t.spans),
+			Services:    names,
+			FirstSeen:   t.firstSeen,
+			UpdatedAt:   t.updatedAt,
+			AgeSeconds:  now.Sub(t.firstSeen).Seconds(),
+			IdleSeconds: now.Sub(t.lastSeen).Seconds(),
+			Truncated:   t.truncated,
+			Dropped:     t.dropped,
+			Continued:   t.prior != nil,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].FirstSeen.Before(out[j].FirstSeen) })
+	return out
+}
+
+type FlushStatus struct {
+	InMemory    int64     `json:"in_memory"`
+	LastFlush   time.Time `json:"last_flush,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at,omitempty"`
+}
+
+func (r *Reconstructor) Status() FlushStatus {
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+	return FlushStatus{
+		InMemory:    r.inMemory.Load(),
+		LastFlush:   r.lastFlush,
+		LastError:   r.lastErr,
+		LastErrorAt: r.lastErrAt,
+	}
+}
+
+func (r *Reconstructor) recordFlush(err error) {
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+	now := time.Now().UTC()
+	if err != nil {
+		r.lastErr = err.Error()
+		r.lastErrAt = now
+		return
+	}
+	r.lastFlush = now
+}
+
+func (r *Reconstructor) write(ctx context.Context, traces []*traceState, urgent map[string]bool) error {
+	traces = r.forwardFragments(ctx, traces)
+	if err := r.restorePrior(ctx, traces); err != nil {
+		log.Printf("restore partial traces: %v", err)
+	}
+	spanRows, traceRows, edges := r.buildRows(traces)
+	versions := serviceVersions(traces, spanRows)
+	internal := r.internalEdges(traces, spanRows)
+	usage, usageToken := spanUsage(traces, spanRows)
+
+	var firstErr error
+	keep := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	insertTraces := func(spanRows []model.SpanRow, traceRows []model.TraceRow) {
+		if len(spanRows) > 0 {
+			keep(r.ch.InsertJSONEachRow(ctx, "spans", spanRows))
+		}
+		if len(traceRows) > 0 && r.collectorID != "" {
+			keep(r.ch.InsertJSONEachRow(ctx, "trace_partials", partialRows(r.collectorID, traceRows, spanRows)))
+		} else if len(traceRows) > 0 {
+			keep(r.ch.InsertJSONEachRow(ctx, "traces", traceRows))
+		}
+	}
+	if len(urgent) > 0 {
+		first, rest, firstTraces, restTraces := splitUrgent(spanRows, traceRows, urgent)
+		insertTraces(first, firstTraces)
+		insertTraces(rest, restTraces)
+	} else {
+		insertTraces(spanRows, traceRows)
+	}
+	if len(edges) > 0 {
+		keep(r.ch.InsertJSONEachRow(ctx, "dependency_edges_minute", edges))
+	}
+	if len(versions) > 0 {
+		keep(r.ch.InsertJSONEachRow(ctx, "service_versions_minute", versions))
+	}
+	if len(internal) > 0 {
+		keep(r.ch.InsertJSONEachRow(ctx, "internal_edges_minute", internal))
+	}
+	if len(usage) > 0 {
+		keep(r.ch.InsertJSONEachRowDedup(ctx, "usage_daily", usage, usageToken))
+	}
+	if buckets := lateBuckets(traces, spanRows); len(buckets) > 0 && firstErr == nil {
+		r.scheduleRerollup(buckets)
+	}
+	if firstErr == nil {
+		r.mu.Lock()
+		if r.collectorID == "" {
+			r.rememberPartial(traces)
+		}
+		r.queuePromotions(traceRows)
+		r.mu.Unlock()
+		if r.onFinalize != nil && len(traceRows) > 0 {
+			r.onFinalize(traceRows, spanRows)
+		}
+	}
+	r.recordFlush(firstErr)
+	return firstErr
+}
+
+func tracedTransaction(t *traceState) string {
+	var best *spanState
+	for _, s := range t.spans {
+		if s.transaction == "" {
+			continue
+		}
+		if best == nil || s.startTs < best.startTs {
+			best = s
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return best.transaction
 }
 
 func chooseOperation(route, fallback string) string {
@@ -206,6 +596,7 @@ func chooseOperation(route, fallback string) string {
 }
 
 func finalizeSpans(t *traceState) []model.SpanRow {
+	adoptRUMRoot(t)
 	children := map[string][]*spanState{}
 	for _, s := range t.spans {
 		if s.parentSpanID != "" {
@@ -216,37 +607,36 @@ func finalizeSpans(t *traceState) []model.SpanRow {
 	out := make([]model.SpanRow, 0, len(t.spans))
 	for _, s := range t.spans {
 		source := s.source
-		if s.startTs.IsZero() && !s.endTs.IsZero() && s.durationMs > 0 {
-			s.startTs = s.endTs.Add(-time.Duration(s.durationMs) * time.Millisecond)
+		if s.startTs == 0 && s.endTs != 0 && s.durationMs > 0 {
+			s.startTs = s.endTs - int64(time.Duration(s.durationMs)*time.Millisecond)
 			source = "inferred"
 		}
-		if s.endTs.IsZero() && !s.startTs.IsZero() {
-			if s.durationMs > 0 {
-				s.endTs = s.startTs.Add(time.Duration(s.durationMs) * time.Millisecond)
-			} else {
-				s.endTs = s.startTs
-			}
+		if s.endTs == 0 && s.startTs != 0 {
+			s.endTs = s.startTs + int64(time.Duration(s.durationMs)*time.Millisecond)
 			source = "inferred"
 		}
-		if s.startTs.IsZero() {
-			s.startTs = time.Now().UTC()
+		if s.startTs == 0 {
+			s.startTs = stamp(time.Now().UTC())
 			s.endTs = s.startTs
 			source = "inferred"
 		}
+		if s.source == "implicit" {
+			source = s.source
+		}
 
 		duration := s.durationMs
 		if duration == 0 {
-			if s.endTs.Before(s.startTs) {
+			if s.endTs < s.startTs {
 				s.endTs = s.startTs
 			}
-			duration = uint32(s.endTs.Sub(s.startTs).Milliseconds())
+			duration = millis(s.startTs, s.endTs)
 		}
 
 		childTotal := uint32(0)
 		for _, child := range children[s.spanID] {
 			childDur := child.durationMs
-			if childDur == 0 && !child.startTs.IsZero() && !child.endTs.IsZero() {
-				childDur = uint32(child.endTs.Sub(child.startTs).Milliseconds())
+			if childDur == 0 && child.startTs != 0 && child.endTs != 0 {
+				childDur = millis(child.startTs, child.endTs)
 			}
 			childTotal += childDur
 		}
@@ -255,27 +645,89 @@ func finalizeSpans(t *traceState) []model.SpanRow {
 			selfTime = duration - childTotal
 		}
 
+		status := s.finalStatus(duration)
 		out = append(out, model.SpanRow{
-			TraceID:      s.traceID,
+			TraceID:      t.id,
 			SpanID:       s.spanID,
 			ParentSpanID: s.parentSpanID,
 			Service:      s.service,
 			Env:          s.env,
 			Host:         s.host,
+			Region:       s.region,
+			Zone:         s.zone,
 			Version:      s.version,
 			Operation:    s.operation,
-			StartTS:      model.FormatCHTime(s.startTs),
-			EndTS:        model.FormatCHTime(s.endTs),
+			Method:       s.method,
+			Route:        s.route,
+			StartTS:      model.FormatCHTime(stampTime(s.startTs)),
+			EndTS:        model.FormatCHTime(stampTime(s.endTs)),
 			DurationMs:   duration,
 			SelfTimeMs:   selfTime,
+			QueueMs:      min(s.queueMs, selfTime),
 			StatusCode:   s.statusCode,
-			IsError:      boolToUint8(s.isError),
+			IsError:      boolToUint8(status == "error" || status == "timeout"),
+			Status:       status,
+			ErrorMessage: s.errorMessage,
+			ErrorType:    s.errorType,
 			Source:       source,
+			Proxy:        s.proxy,
+			Conflicts:    slices.Sorted(slices.Values(s.conflicts)),
 		})
 	}
 	return out
 }
 
+var statusRank = map[string]int{"ok": 1, "cancelled": 2, "error": 3, "timeout": 4}
+
+func (s *spanState) finalStatus(durationMs uint32) string {
+	status := s.status
+	if status == "" {
+		status = "ok"
+		if s.isError || s.errorMessage != "" || s.errorType != "" {
+			status = "error"
+		}
+	}
+	if s.status == "ok" || status == "timeout" {
+		return status
+	}
+	elapsed := time.Duration(durationMs) * time.Millisecond
+	if s.timedOut || (s.timeout > 0 && elapsed >= s.timeout-s.timeout/50) {
+		return "timeout"
+	}
+	return status
+}
+
+func adoptRUMRoot(t *traceState) {
+	var root *spanState
+	for _, s := range t.spans {
+		if s.source != "rum" || s.parentSpanID != "" {
+			continue
+		}
+		if root == nil || s.startTs < root.startTs {
+			root = s
+		}
+	}
+	if root == nil {
+		return
+	}
+	var server *spanState
+	servers := 0
+	for _, s := range t.spans {
+		if s == root || s.parentSpanID != "" {
+			continue
+		}
+		if s.source == "rum" {
+			s.parentSpanID = root.spanID
+			continue
+		}
+		server = s
+		servers++
+	}
+	if servers == 1 {
+		server.parentSpanID = root.spanID
+	}
+}
+
 func buildTraceRow(env, traceID string, spans []model.SpanRow) model.TraceRow {
 	if len(spans) == 0 {
 		return model.TraceRow{TraceID: traceID, Env: env}
@@ -285,36 +737,50 @@ func buildTraceRow(env, traceID string, spans []model.SpanRow) model.TraceRow {
 	end := parseCHTime(spans[0].EndTS)
 	services := map[string]struct{}{}
 	versions := map[string]struct{}{}
+	regions := map[string]struct{}{}
+	zones := map[string]struct{}{}
 	errorCount := 0
-	rootService := spans[0].Service
+	byID := make(map[string]bool, len(spans))
+	for _, s := range spans {
+		byID[s.SpanID] = true
+	}
+	root := spans[0]
+	rootStart := start
+	rootIsEntry := false
 	for _, s := range spans {
 		st := parseCHTime(s.StartTS)
 		en := parseCHTime(s.EndTS)
 		if st.Before(start) {
 			start = st
-			rootService = s.Service
+		}
+		isEntry := s.ParentSpanID == "" || !byID[s.ParentSpanID]
+		if (isEntry && !rootIsEntry) || (isEntry == rootIsEntry && st.Before(rootStart)) {
+			root, rootStart, rootIsEntry = s, st, isEntry
 		}
 		if en.After(end) {
 			end = en
 		}
 		services[s.Service] = struct{}{}
 		versions[s.Version] = struct{}{}
+		if s.Region != "" {
+			regions[s.Region] = struct{}{}
+		}
+		if s.Zone != "" {
+			zones[s.Zone] = struct{}{}
+		}
 		if s.IsError == 1 {
 			errorCount++
 		}
 	}
 
 	critical := uint32(end.Sub(start).Milliseconds())
-	versionsOut := make([]string, 0, len(versions))
-	for v := range versions {
-		versionsOut = append(versionsOut, v)
-	}
-	sort.Strings(versionsOut)
-
 	return model.TraceRow{
 		TraceID:        traceID,
 		Env:            env,
-		RootService:    rootService,
+		RootService:    root.Service,
+		RootOperation:  root.Operation,
+		RootStatusCode: root.StatusCode,
+		Transaction:    root.Operation,
 		StartTS:        model.FormatCHTime(start),
 		EndTS:          model.FormatCHTime(end),
 		DurationMs:     uint32(end.Sub(start).Milliseconds()),
@@ -322,8 +788,19 @@ func buildTraceRow(env, traceID string, spans []model.SpanRow) model.TraceRow {
 		ServiceCount:   uint16(len(services)),
 		ErrorCount:     uint16(errorCount),
 		CriticalPathMs: critical,
-		Versions:       versionsOut,
+		Versions:       sortedSet(versions),
+		Regions:        sortedSet(regions),
+		Zones:          sortedSet(zones),
+	}
+}
+
+func sortedSet(set map[string]struct{}) []string {
+	out := make([]string, 0, len(set))
+	for v := range set {
+		out = append(out, v)
 	}
+	sort.Strings(out)
+	return out
 }
 
 func criticalPath(spans map[string]model.SpanRow, children map[string][]string) uint32 {
@@ -384,47 +861,75 @@ type edgeKey struct {
 	calleeService string
 	callerVersion string
 	calleeVersion string
+	callerRegion  string
+	calleeRegion  string
+	callerZone    string
+	calleeZone    string
+	calleeMethod  string
+	calleeRoute   string
 }
 
 type edgeState struct {
-	durations  []uint32
-	errorCalls uint64
+	durations      []uint32
+	errorCalls     uint64
+	cancelledCalls uint64
+	timeoutCalls   uint64
 }
 
-func accumulateEdges(spans []model.SpanRow, agg map[edgeKey]*edgeState) {
+func (r *Reconstructor) accumulateEdges(spans []model.SpanRow, skip map[string]bool, agg map[edgeKey]*edgeState) {
 	byID := map[string]model.SpanRow{}
 	for _, s := range spans {
 		byID[s.SpanID] = s
 	}
 	for _, s := range spans {
-		if s.ParentSpanID == "" {
+		if s.ParentSpanID == "" || skip[s.SpanID] {
 			continue
 		}
 		p, ok := byID[s.ParentSpanID]
-		if !ok || p.Service == s.Service {
+		if !ok {
 			continue
 		}
-		bucket := toMinute(s.StartTS)
-		k := edgeKey{
-			bucket:        bucket,
-			env:           s.Env,
-			callerService: p.Service,
-			calleeService: s.Service,
-			callerVersion: p.Version,
-			calleeVersion: s.Version,
-		}
-		e := agg[k]
-		if e == nil {
-			e = &edgeState{}
-			agg[k] = e
-		}
-		e.durations = append(e.durations, s.DurationMs)
-		if s.IsError == 1 {
-			e.errorCalls++
+		for _, hop := range r.edgeHops(p, s, byID) {
+			if hop[0].Service == hop[1].Service {
+				continue
+			}
+			addEdge(agg, hop[0], hop[1], s)
 		}
 	}
 }
 
+func addEdge(agg map[edgeKey]*edgeState, caller, callee, s model.SpanRow) {
+	k := edgeKey{
+		bucket:        toMinute(s.StartTS),
+		env:           s.Env,
+		callerService: caller.Service,
+		calleeService: callee.Service,
+		callerVersion: caller.Version,
+		calleeVersion: callee.Version,
+		callerRegion:  caller.Region,
+		calleeRegion:  callee.Region,
+		callerZone:    caller.Zone,
+		calleeZone:    callee.Zone,
+		calleeMethod:  s.Method,
+		calleeRoute:   s.Route,
+	}
+	e := agg[k]
+	if e == nil {
+		e = &edgeState{}
+		agg[k] = e
+	}
+	e.durations = append(e.durations, s.DurationMs)
+	if s.IsError == 1 {
+		e.errorCalls++
+	}
+	switch s.Status {
+	case "cancelled":
+		e.cancelledCalls++
+	case "timeout":
+		e.timeoutCalls++
+	}
+}
+
 func collapseEdgeAgg(agg map[edgeKey]*edgeState) []model.DependencyEdgeRow {
 	out := make([]model.DependencyEdgeRow, 0, len(agg))
 	for k, v := range agg {
@@ -437,17 +942,25 @@ func collapseEdgeAgg(agg map[edgeKey]*edgeState) []model.DependencyEdgeRow {
 		p95 := percentile(v.durations, 0.95)
 		maxV := v.durations[calls-1]
 		out = append(out, model.DependencyEdgeRow{
-			BucketTS:      k.bucket,
-			Env:           k.env,
-			CallerService: k.callerService,
-			CalleeService: k.calleeService,
-			CallerVersion: k.callerVersion,
-			CalleeVersion: k.calleeVersion,
-			Calls:         uint64(calls),
-			ErrorCalls:    v.errorCalls,
-			P50Ms:         float32(p50),
-			P95Ms:         float32(p95),
-			MaxMs:         maxV,
+			BucketTS:       k.bucket,
+			Env:            k.env,
+			CallerService:  k.callerService,
+			CalleeService:  k.calleeService,
+			CallerVersion:  k.callerVersion,
+			CalleeVersion:  k.calleeVersion,
+			CallerRegion:   k.callerRegion,
+			CalleeRegion:   k.calleeRegion,
+			CallerZone:     k.callerZone,
+			CalleeZone:     k.calleeZone,
+			CalleeMethod:   k.calleeMethod,
+			CalleeRoute:    k.calleeRoute,
+			Calls:          uint64(calls),
+			ErrorCalls:     v.errorCalls,
+			CancelledCalls: v.cancelledCalls,
+			TimeoutCalls:   v.timeoutCalls,
+			P50Ms:          float32(p50),
+			P95Ms:          float32(p95),
+			MaxMs:          maxV,
 		})
 	}
 	return out
@@ -0,0 +1,376 @@
+package reconstruct
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"trace-lite/collector/internal/clickhouse/clickhousetest"
+	"trace-lite/collector/internal/federation"
+	"trace-lite/collector/internal/model"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func logRow(service, span, parent, route string, status uint16, duration uint32) model.RawLogRow {
+	return model.RawLogRow{
+		Service: service, Env: "prod", Host: "h1", Version: "v1", Level: "info", TraceID: "t1",
+		SpanID: span, ParentSpanID: parent, Event: "end", Route: route, Method: "GET",
+		StatusCode: status, DurationMs: duration, Attrs: map[string]string{"tx": "checkout"},
+	}
+}
+
+func TestFlushGolden(t *testing.T) {
+	f := clickhousetest.New()
+	r := New(f, 24*365*time.Hour, time.Second, 100, "tx")
+	r.SetQueueAttrs([]string{"queue_time_ms", "thread_pool_wait_ms"})
+	base := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	rows := []model.RawLogRow{
+		logRow("gateway", "s1", "", "/checkout", 200, 250),
+		logRow("cart", "s2", "s1", "/cart", 200, 100),
+		logRow("payments", "s3", "s1", "/pay", 502, 120),
+		logRow("bank", "s4", "s3", "/charge", 504, 100),
+	}
+	rows[1].Attrs["queue_time_ms"] = "12.4"
+	rows[2].Region, rows[2].Zone = "eu-west-1", "eu-west-1b"
+	rows[3].Region, rows[3].Zone = "eu-west-1", "eu-west-1a"
+	rows[3].Attrs["thread_pool_wait_ms"] = "500"
+	for _, msg := range []string{"cache miss", "retrying lookup"} {
+		row := logRow("cart", "", "s2", "", 0, 2)
+		row.Message = msg
+		rows = append(rows, row)
+	}
+	rows = append(rows, logRow("billing", "s3", "s1", "/pay", 502, 110))
+	times := []time.Time{base.Add(250 * time.Millisecond), base.Add(110 * time.Millisecond), base.Add(240 * time.Millisecond), base.Add(230 * time.Millisecond), base.Add(50 * time.Millisecond), base.Add(50 * time.Millisecond), base.Add(240 * time.Millisecond)}
+	r.Add(rows, times)
+	if ok, err := r.FlushTrace(context.Background(), "t1"); !ok || err != nil {
+		t.Fatalf("FlushTrace = %v, %v", ok, err)
+	}
+
+	var b strings.Builder
+	for _, ins := range f.Inserts() {
+		fmt.Fprintf(&b, "-- insert into %s\n", ins.Table)
+		lines := make([]string, len(ins.Rows))
+		for i, row := range ins.Rows {
+			line, _ := json.Marshal(row)
+			lines[i] = string(line)
+		}
+		sort.Strings(lines)
+		for _, line := range lines {
+			b.WriteString(line + "\n")
+		}
+	}
+	checkGolden(t, "flush", b.String())
+}
+
+func TestRerollupGolden(t *testing.T) {
+	f := clickhousetest.New()
+	r := New(f, time.Minute, time.Second, 100, "")
+	buckets := map[string][]string{"prod": {"2026-01-01 10:00:00", "2026-01-01 10:01:00"}}
+	if err := r.rerollup(context.Background(), buckets, ProxyCollapse); err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	for _, e := range f.Execs() {
+		fmt.Fprintf(&b, "-- exec %s\n%s\n", e.Settings.Encode(), e.Query)
+	}
+	checkGolden(t, "rerollup", b.String())
+}
+
+type sharedStore struct {
+	*clickhousetest.Fake
+	mu     sync.Mutex
+	spans  []model.SpanRow
+	traces []model.TraceRow
+}
+
+func (s *sharedStore) InsertJSONEachRow(ctx context.Context, table string, rows any) error {
+	s.mu.Lock()
+	switch list := rows.(type) {
+	case []model.SpanRow:
+		s.spans = append(s.spans, list...)
+	case []model.TraceRow:
+		s.traces = append(s.traces, list...)
+	}
+	s.mu.Unlock()
+	return s.Fake.InsertJSONEachRow(ctx, table, rows)
+}
+
+func (s *sharedStore) QueryEachRow(ctx context.Context, query string, fn func([]byte) error) error {
+	if !strings.Contains(query, "FROM spans FINAL") {
+		return s.Fake.QueryEachRow(ctx, query, fn)
+	}
+	s.mu.Lock()
+	spans := append([]model.SpanRow(nil), s.spans...)
+	s.mu.Unlock()
+	for _, row := range spans {
+		line, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type regionLink struct {
+	peers  *federation.Peers
+	remote *Reconstructor
+}
+
+func (l *regionLink) Owner(traceID string) string {
+	return l.peers.Owner(traceID)
+}
+
+func (l *regionLink) Forward(ctx context.Context, owner string, spans []model.SpanRow) error {
+	l.remote.AddSpans(spans)
+	return nil
+}
+
+func (l *regionLink) Announce(ctx context.Context, traceIDs []string) error {
+	l.remote.MarkCrossRegion(traceIDs)
+	return nil
+}
+
+func TestFederationStitchesSplitTraces(t *testing.T) {
+	urls := map[string]string{"eu": "http://eu", "us": "http://us"}
+	ownedBy := func(region string) string {
+		peers := federation.New(region, urls, "")
+		for i := 0; ; i++ {
+			if id := fmt.Sprintf("trace-%d", i); peers.Owner(id) == "" {
+				return id
+			}
+		}
+	}
+	base := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name      string
+		owner     string
+		rootFirst bool
+	}{
+		{"root region owns, fragment flushes first", "eu", false},
+		{"root region owns, root flushes first", "eu", true},
+		{"fragment region owns, fragment flushes first", "us", false},
+		{"fragment region owns, root flushes first", "us", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store := &sharedStore{Fake: clickhousetest.New()}
+			eu := New(store, 24*365*time.Hour, time.Second, 100, "tx")
+			us := New(store, 24*365*time.Hour, time.Second, 100, "tx")
+			eu.SetFederation(&regionLink{peers: federation.New("eu", urls, ""), remote: us})
+			us.SetFederation(&regionLink{peers: federation.New("us", urls, ""), remote: eu})
+
+			id := ownedBy(tc.owner)
+			root := logRow("gateway", "s1", "", "/checkout", 200, 250)
+			child := logRow("cart", "s2", "s1", "/cart", 200, 100)
+			root.TraceID, child.TraceID = id, id
+			eu.Add([]model.RawLogRow{root}, []time.Time{base.Add(250 * time.Millisecond)})
+			us.Add([]model.RawLogRow{child}, []time.Time{base.Add(200 * time.Millisecond)})
+
+			order := []*Reconstructor{us, eu}
+			if tc.rootFirst {
+				order = []*Reconstructor{eu, us}
+			}
+			for round := 0; round < 2; round++ {
+				for _, r := range order {
+					if _, err := r.FlushTrace(context.Background(), id); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			var last *model.TraceRow
+			for i := range store.traces {
+				if store.traces[i].TraceID == id {
+					last = &store.traces[i]
+				}
+			}
+			if last == nil {
+				t.Fatal("no trace row written")
+			}
+			if last.SpanCount != 2 || last.RootService != "gateway" {
+				t.Errorf("last trace row has %d spans rooted at %q, want 2 rooted at gateway", last.SpanCount, last.RootService)
+			}
+		})
+	}
+}
+
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.MkdirAll("testdata", 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs from the golden file (run go test -update and review the diff):\n--- want\n%s\n--- got\n%s", path, want, got)
+	}
+}
+
+func TestFlushWritesUrgentTracesFirstAndEdgesOnce(t *testing.T) {
+	f := clickhousetest.New()
+	r := New(f, time.Nanosecond, time.Nanosecond, 100, "tx")
+	base := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	var rows []model.RawLogRow
+	for _, tc := range []struct {
+		trace  string
+		status uint16
+	}{{"calm", 200}, {"failing", 502}} {
+		for _, row := range []model.RawLogRow{
+			logRow("gateway", "s1", "", "/checkout", tc.status, 250),
+			logRow("cart", "s2", "s1", "/cart", tc.status, 100),
+		} {
+			row.TraceID = tc.trace
+			rows = append(rows, row)
+		}
+	}
+	r.Add(rows, []time.Time{base, base, base, base})
+	time.Sleep(time.Millisecond)
+	r.FlushNow(context.Background())
+
+	var order []string
+	var edges []map[string]any
+	for _, ins := range f.Inserts() {
+		switch ins.Table {
+		case "spans", "traces":
+			order = append(order, fmt.Sprintf("%s:%s", ins.Table, ins.Rows[0]["trace_id"]))
+		case "dependency_edges_minute":
+			edges = append(edges, ins.Rows...)
+		}
+	}
+	if got, want := strings.Join(order, " "), "spans:failing traces:failing spans:calm traces:calm"; got != want {
+		t.Fatalf("insert order = %s, want %s", got, want)
+	}
+	if len(edges) != 1 || fmt.Sprint(edges[0]["calls"]) != "2" || fmt.Sprint(edges[0]["error_calls"]) != "1" {
+		t.Fatalf("edges = %v, want one gateway->cart row over both traces", edges)
+	}
+}
+
+func TestUsageTokenIsStableAcrossFlushes(t *testing.T) {
+	base := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	tokens := map[string]bool{}
+	for _, rows := range [][]model.RawLogRow{
+		{logRow("gateway", "s1", "", "/checkout", 200, 250), logRow("cart", "s2", "s1", "/cart", 200, 100)},
+		{logRow("cart", "s2", "s1", "/cart", 200, 100), logRow("gateway", "s1", "", "/checkout", 200, 250)},
+	} {
+		f := clickhousetest.New()
+		r := New(f, 24*365*time.Hour, time.Second, 100, "tx")
+		r.Add(rows, []time.Time{base, base})
+		if ok, err := r.FlushTrace(context.Background(), "t1"); !ok || err != nil {
+			t.Fatalf("FlushTrace = %v, %v", ok, err)
+		}
+		for _, ins := range f.Inserts() {
+			if ins.Table == "usage_daily" {
+				tokens[ins.Token] = true
+			}
+		}
+	}
+	if len(tokens) != 1 || tokens[""] {
+		t.Fatalf("usage_daily tokens = %v, want one non-empty token for the same spans", tokens)
+	}
+}
+
+func TestRUMRootAdoptsOnlyASingleServerRoot(t *testing.T) {
+	base := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name    string
+		servers []string
+		want    string
+	}{
+		{"one server root", []string{"s1"}, "r1"},
+		{"two server roots", []string{"s1", "s2"}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := clickhousetest.New()
+			r := New(f, 24*365*time.Hour, time.Second, 100, "tx")
+			rum := logRow("browser", "r1", "", "/checkout", 200, 900)
+			rum.Attrs = map[string]string{"source": "rum"}
+			rows := []model.RawLogRow{rum}
+			times := []time.Time{base.Add(900 * time.Millisecond)}
+			for _, id := range tc.servers {
+				rows = append(rows, logRow("gateway", id, "", "/checkout", 200, 300))
+				times = append(times, base.Add(500*time.Millisecond))
+			}
+			r.Add(rows, times)
+			if ok, err := r.FlushTrace(context.Background(), "t1"); !ok || err != nil {
+				t.Fatalf("FlushTrace = %v, %v", ok, err)
+			}
+			for _, ins := range f.Inserts() {
+				if ins.Table != "spans" {
+					continue
+				}
+				for _, s := range ins.Rows {
+					if s["span_id"] != "r1" && s["parent_span_id"] != tc.want {
+						t.Errorf("span %v has parent %q, want %q", s["span_id"], s["parent_span_id"], tc.want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPreviewRunsAlongsideAdminUpdates(t *testing.T) {
+	r := New(clickhousetest.New(), time.Second, time.Second, 100, "tx")
+	base := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	rows := []model.RawLogRow{logRow("gateway", "s1", "", "/checkout", 500, 250), logRow("cart", "s2", "s1", "/cart", 200, 100)}
+	times := []time.Time{base, base}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := range 200 {
+			r.SetErrorRules(nil)
+			r.SetConflictPolicy([]string{ConflictMerge, ConflictFirst}[i%2])
+		}
+	}()
+	for range 200 {
+		if spans, _, _ := r.Preview(rows, times); len(spans) != 2 {
+			t.Fatalf("preview built %d spans, want 2", len(spans))
+		}
+	}
+	wg.Wait()
+}
+
+func TestRedeliveredImplicitRowsMerge(t *testing.T) {
+	f := clickhousetest.New()
+	r := New(f, 24*365*time.Hour, time.Second, 100, "tx")
+	base := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	row := logRow("cart", "", "", "/cart", 200, 40)
+	row.Message = "cart loaded"
+	rows := []model.RawLogRow{row}
+	r.Add(rows, []time.Time{base})
+	r.Add(rows, []time.Time{base})
+	if ok, err := r.FlushTrace(context.Background(), "t1"); !ok || err != nil {
+		t.Fatalf("FlushTrace = %v, %v", ok, err)
+	}
+	var spans []map[string]any
+	for _, ins := range f.Inserts() {
+		if ins.Table == "spans" {
+			spans = append(spans, ins.Rows...)
+		}
+	}
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans for a redelivered implicit row, want 1: %v", len(spans), spans)
+	}
+}
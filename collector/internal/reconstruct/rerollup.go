@@ -0,0 +1,182 @@
+package reconstruct
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"trace-lite/collector/internal/model"
+)
+
+func lateBuckets(traces []*traceState, spans []model.SpanRow) map[string][]string {
+	late := map[string]struct{}{}
+	for _, t := range traces {
+		if t.late {
+			late[t.id] = struct{}{}
+		}
+	}
+	if len(late) == 0 {
+		return nil
+	}
+	seen := map[string]map[string]struct{}{}
+	for _, s := range spans {
+		if _, ok := late[s.TraceID]; !ok {
+			continue
+		}
+		if seen[s.Env] == nil {
+			seen[s.Env] = map[string]struct{}{}
+		}
+		seen[s.Env][toMinute(s.StartTS)] = struct{}{}
+	}
+	out := make(map[string][]string, len(seen))
+	for env, set := range seen {
+		for b := range set {
+			out[env] = append(out[env], b)
+		}
+		sort.Strings(out[env])
+	}
+	return out
+}
+
+func (r *Reconstructor) scheduleRerollup(buckets map[string][]string) {
+	mode := r.proxyMode
+	go func() {
+		r.rerollupMu.Lock()
+		defer r.rerollupMu.Unlock()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		if err := r.rerollup(ctx, buckets, mode); err != nil {
+			log.Printf("rollup rerollup failed: %v", err)
+		}
+	}()
+}
+
+func (r *Reconstructor) rerollup(ctx context.Context, buckets map[string][]string, proxyMode string) error {
+	settings := url.Values{}
+	settings.Set("mutations_sync", "1")
+	for env, list := range buckets {
+		for len(list) > 0 {
+			n := len(list)
+			if n > 200 {
+				n = 200
+			}
+			chunk := list[:n]
+			list = list[n:]
+
+			quoted := make([]string, 0, len(chunk))
+			for _, b := range chunk {
+				quoted = append(quoted, "toDateTime('"+b+"', 'UTC')")
+			}
+			in := strings.Join(quoted, ", ")
+			envLit := "'" + strings.ReplaceAll(strings.ReplaceAll(env, `\`, `\\`), `'`, `\'`) + "'"
+			cond := fmt.Sprintf("env = %s AND bucket_ts IN (%s)", envLit, in)
+			parents := fmt.Sprintf(`
+  SELECT trace_id, span_id, parent_span_id, service, version, region, zone, proxy
+  FROM spans FINAL
+  WHERE trace_id IN (
+    SELECT trace_id FROM spans WHERE env = %s AND toStartOfMinute(start_ts) IN (%s)
+  )`, envLit, in)
+
+			if err := r.ch.Exec(ctx, "ALTER TABLE dependency_edges_minute DELETE WHERE "+cond, settings); err != nil {
+				return fmt.Errorf("rerollup delete: %w", err)
+			}
+			insert := fmt.Sprintf(`
+INSERT INTO dependency_edges_minute
+  (bucket_ts, env, caller_service, callee_service, caller_version, callee_version, caller_region, callee_region, caller_zone, callee_zone,
+   callee_method, callee_route, calls, error_calls, cancelled_calls, timeout_calls, p50_ms, p95_ms, max_ms)
+SELECT
+  toStartOfMinute(start_ts) AS bucket_ts,
+  env,
+  hop.1 AS caller_service,
+  hop.2 AS callee_service,
+  hop.3 AS caller_version,
+  hop.4 AS callee_version,
+  hop.5 AS caller_region,
+  hop.6 AS callee_region,
+  hop.7 AS caller_zone,
+  hop.8 AS callee_zone,
+  method AS callee_method,
+  route AS callee_route,
+  count() AS calls,
+  countIf(is_error = 1) AS error_calls,
+  countIf(status = 'cancelled') AS cancelled_calls,
+  countIf(status = 'timeout') AS timeout_calls,
+  quantileExact(0.50)(duration_ms) AS p50_ms,
+  quantileExact(0.95)(duration_ms) AS p95_ms,
+  max(duration_ms) AS max_ms
+FROM (
+  SELECT c.env AS env, c.method AS method, c.route AS route, c.start_ts AS start_ts, c.duration_ms AS duration_ms,
+    c.is_error AS is_error, c.status AS status, arrayJoin(%s) AS hop
+  FROM (
+    SELECT trace_id, span_id, parent_span_id, service, env, version, region, zone, method, route, start_ts, duration_ms, is_error, status, proxy
+    FROM spans FINAL
+    WHERE env = %s AND toStartOfMinute(start_ts) IN (%s) AND parent_span_id != ''%s
+  ) AS c
+  INNER JOIN (%s) AS p ON p.trace_id = c.trace_id AND p.span_id = c.parent_span_id
+  LEFT JOIN (%s) AS g ON g.trace_id = p.trace_id AND g.span_id = p.parent_span_id
+)
+WHERE hop.1 != '' AND hop.1 != hop.2
+GROUP BY bucket_ts, env, caller_service, callee_service, caller_version, callee_version, caller_region, callee_region, caller_zone, callee_zone,
+  callee_method, callee_route`,
+				edgeHopsSQL(proxyMode), envLit, in, skipProxySQL(proxyMode), parents, parents)
+			if err := r.ch.Exec(ctx, insert, nil); err != nil {
+				return fmt.Errorf("rerollup insert: %w", err)
+			}
+			if err := r.ch.Exec(ctx, "ALTER TABLE service_versions_minute DELETE WHERE "+cond, settings); err != nil {
+				return fmt.Errorf("rerollup versions delete: %w", err)
+			}
+			versions := fmt.Sprintf(`
+INSERT INTO service_versions_minute (bucket_ts, env, service, version, calls, errors)
+SELECT
+  toStartOfMinute(c.start_ts) AS bucket_ts,
+  c.env AS env,
+  c.service AS service,
+  c.version AS version,
+  count() AS calls,
+  countIf(c.is_error = 1) AS errors
+FROM (
+  SELECT trace_id, span_id, parent_span_id, service, env, version, start_ts, is_error
+  FROM spans FINAL
+  WHERE env = %s AND toStartOfMinute(start_ts) IN (%s)
+) AS c
+LEFT JOIN (
+  SELECT trace_id, span_id, service
+  FROM spans FINAL
+  WHERE trace_id IN (
+    SELECT trace_id FROM spans WHERE env = %s AND toStartOfMinute(start_ts) IN (%s)
+  )
+) AS p ON p.trace_id = c.trace_id AND p.span_id = c.parent_span_id
+WHERE c.parent_span_id = '' OR p.service != c.service
+GROUP BY bucket_ts, env, service, version`,
+				envLit, in, envLit, in)
+			if err := r.ch.Exec(ctx, versions, nil); err != nil {
+				return fmt.Errorf("rerollup versions insert: %w", err)
+			}
+			log.Printf("rerolled %d dependency and version minute buckets for env %s", len(chunk), env)
+		}
+	}
+	return nil
+}
+
+func edgeHopsSQL(proxyMode string) string {
+	if proxyMode == ProxyPassthrough {
+		return `if(c.proxy != '' AND c.proxy != c.service,
+    [(p.service, toString(c.proxy), p.version, '', p.region, c.region, p.zone, c.zone),
+      (toString(c.proxy), c.service, '', c.version, c.region, c.region, c.zone, c.zone)],
+    [(p.service, c.service, p.version, c.version, p.region, c.region, p.zone, c.zone)])`
+	}
+	return `[if(p.proxy != '' AND p.proxy = p.service,
+    (g.service, c.service, g.version, c.version, g.region, c.region, g.zone, c.zone),
+    (p.service, c.service, p.version, c.version, p.region, c.region, p.zone, c.zone))]`
+}
+
+func skipProxySQL(proxyMode string) string {
+	if proxyMode == ProxyPassthrough {
+		return ""
+	}
+	return " AND NOT (proxy != '' AND proxy = service)"
+}
@@ -0,0 +1,125 @@
+package reconstruct
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"trace-lite/collector/internal/model"
+)
+
+type promotion struct {
+	traceID string
+	env     string
+	from    string
+	days    uint16
+}
+
+func (r *Reconstructor) SetRetention(tiers map[string]int) {
+	r.flushMu.Lock()
+	defer r.flushMu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tiers = tiers
+}
+
+func (r *Reconstructor) RawRetainDays() uint16 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return uint16(r.tiers["ok"])
+}
+
+func (r *Reconstructor) retainDays(row model.TraceRow) uint16 {
+	if len(r.tiers) == 0 {
+		return 0
+	}
+	days := r.tiers["ok"]
+	if row.ErrorCount > 0 && r.tiers["error"] > days {
+		days = r.tiers["error"]
+	}
+	for _, l := range row.Labels {
+		if d := r.tiers["label:"+l]; d > days {
+			days = d
+		}
+	}
+	return uint16(days)
+}
+
+func (r *Reconstructor) queuePromotions(rows []model.TraceRow) {
+	if !r.promoting.Load() || len(r.promotions) > 100000 {
+		return
+	}
+	base := uint16(r.tiers["ok"])
+	for _, row := range rows {
+		if row.RetainDays > base {
+			r.promotions = append(r.promotions, promotion{traceID: row.TraceID, env: row.Env, from: row.StartTS, days: row.RetainDays})
+		}
+	}
+}
+
+func (r *Reconstructor) RunRetention(ctx context.Context, every time.Duration) {
+	r.mu.Lock()
+	enabled := len(r.tiers) > 0
+	r.mu.Unlock()
+	if !enabled {
+		return
+	}
+	r.promoting.Store(true)
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.mu.Lock()
+			pending := r.promotions
+			r.promotions = nil
+			r.mu.Unlock()
+			if err := r.promoteRaw(ctx, pending); err != nil {
+				log.Printf("retention: promoting raw logs failed: %v", err)
+				r.mu.Lock()
+				r.promotions = append(pending, r.promotions...)
+				r.mu.Unlock()
+			}
+		}
+	}
+}
+
+func (r *Reconstructor) promoteRaw(ctx context.Context, pending []promotion) error {
+	byDays := map[uint16][]promotion{}
+	for _, p := range pending {
+		byDays[p.days] = append(byDays[p.days], p)
+	}
+	for days, list := range byDays {
+		for len(list) > 0 {
+			n := len(list)
+			if n > 500 {
+				n = 500
+			}
+			chunk := list[:n]
+			list = list[n:]
+
+			ids := make([]string, 0, len(chunk))
+			from := chunk[0].from
+			for _, p := range chunk {
+				ids = append(ids, "'"+strings.ReplaceAll(strings.ReplaceAll(p.traceID, `\`, `\\`), `'`, `\'`)+"'")
+				if p.from < from {
+					from = p.from
+				}
+			}
+			query := fmt.Sprintf(`ALTER TABLE raw_logs UPDATE retain_days = %d
+WHERE ts >= toDateTime64('%s', 3, 'UTC') - INTERVAL 1 HOUR AND trace_id IN (%s) AND retain_days < %d`,
+				days, from, strings.Join(ids, ","), days)
+			if err := r.ch.Exec(ctx, query, nil); err != nil {
+				return err
+			}
+		}
+	}
+	if len(pending) > 0 {
+		log.Printf("retention: extended raw log retention for %d traces", len(pending))
+	}
+	return nil
+}
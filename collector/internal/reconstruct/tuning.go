@@ -0,0 +1,114 @@
+package reconstruct
+
+import (
+	"time"
+
+	"trace-lite/collector/internal/rules"
+)
+
+type Tuning struct {
+	Window time.Duration
+	Flush  time.Duration
+}
+
+type Overrides struct {
+	Env     map[string]Tuning
+	Service map[string]Tuning
+}
+
+func (r *Reconstructor) SetOverrides(o Overrides) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if o.Env == nil {
+		o.Env = map[string]Tuning{}
+	}
+	if o.Service == nil {
+		o.Service = map[string]Tuning{}
+	}
+	r.overrides = o
+}
+
+func (r *Reconstructor) Overrides() (Tuning, Overrides) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := Overrides{Env: map[string]Tuning{}, Service: map[string]Tuning{}}
+	for k, v := range r.overrides.Env {
+		out.Env[k] = v
+	}
+	for k, v := range r.overrides.Service {
+		out.Service[k] = v
+	}
+	return Tuning{Window: r.window, Flush: r.flushInterval}, out
+}
+
+func (r *Reconstructor) tuningFor(env, service string) Tuning {
+	t := Tuning{Window: r.window, Flush: r.flushInterval}
+	if o, ok := r.overrides.Env[env]; ok {
+		t = merge(t, o)
+	}
+	if o, ok := r.overrides.Service[service]; ok {
+		t = merge(t, o)
+	}
+	return t
+}
+
+func merge(base, o Tuning) Tuning {
+	if o.Window > 0 {
+		base.Window = o.Window
+	}
+	if o.Flush > 0 {
+		base.Flush = o.Flush
+	}
+	return base
+}
+
+func (r *Reconstructor) tickInterval() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := r.flushInterval
+	for _, o := range r.overrides.Env {
+		if o.Flush > 0 && o.Flush < d {
+			d = o.Flush
+		}
+	}
+	for _, o := range r.overrides.Service {
+		if o.Flush > 0 && o.Flush < d {
+			d = o.Flush
+		}
+	}
+	return d
+}
+
+func (t *traceState) widen(tu Tuning) {
+	if tu.Window > t.window {
+		t.window = tu.Window
+	}
+	if t.flush == 0 || tu.Flush > t.flush {
+		t.flush = tu.Flush
+	}
+}
+
+func (r *Reconstructor) SetErrorRules(list []rules.Rule) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errorRules = list
+}
+
+func (r *Reconstructor) ErrorRules() []rules.Rule {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]rules.Rule(nil), r.errorRules...)
+}
+
+func (r *Reconstructor) SetClientTimeouts(timeouts map[string]time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.timeouts = timeouts
+}
+
+func (r *Reconstructor) clientTimeout(service string) time.Duration {
+	if d, ok := r.timeouts[service]; ok {
+		return d
+	}
+	return r.timeouts["*"]
+}
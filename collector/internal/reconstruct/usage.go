@@ -0,0 +1,55 @@
+package reconstruct
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+
+	"trace-lite/collector/internal/model"
+)
+
+type usageKey struct {
+	day     string
+	env     string
+	service string
+}
+
+func spanUsage(traces []*traceState, spans []model.SpanRow) ([]model.UsageRow, string) {
+	restored := map[string]map[string]bool{}
+	for _, t := range traces {
+		if t.restored != nil {
+			restored[t.id] = t.restored
+		}
+	}
+	agg := map[usageKey]*model.UsageRow{}
+	var order []usageKey
+	var counted []string
+	for _, s := range spans {
+		if restored[s.TraceID][s.SpanID] {
+			continue
+		}
+		counted = append(counted, s.TraceID+"\x00"+s.SpanID+"\n")
+		k := usageKey{day: model.Day(s.StartTS), env: s.Env, service: s.Service}
+		row := agg[k]
+		if row == nil {
+			row = &model.UsageRow{Day: k.day, Env: k.env, Service: k.service}
+			agg[k] = row
+			order = append(order, k)
+		}
+		row.Spans++
+		if s.ParentSpanID == "" {
+			row.Traces++
+		}
+	}
+
+	out := make([]model.UsageRow, 0, len(order))
+	for _, k := range order {
+		out = append(out, *agg[k])
+	}
+	slices.Sort(counted)
+	h := sha256.New()
+	for _, c := range counted {
+		h.Write([]byte(c))
+	}
+	return out, "usage-" + hex.EncodeToString(h.Sum(nil)[:16])
+}
@@ -0,0 +1,51 @@
+package reconstruct
+
+import "trace-lite/collector/internal/model"
+
+type versionKey struct {
+	bucket  string
+	env     string
+	service string
+	version string
+}
+
+func serviceVersions(traces []*traceState, spans []model.SpanRow) []model.ServiceVersionRow {
+	restored := map[string]map[string]bool{}
+	for _, t := range traces {
+		if t.restored != nil {
+			restored[t.id] = t.restored
+		}
+	}
+	byID := map[[2]string]model.SpanRow{}
+	for _, s := range spans {
+		byID[[2]string{s.TraceID, s.SpanID}] = s
+	}
+
+	agg := map[versionKey]*model.ServiceVersionRow{}
+	var order []versionKey
+	for _, s := range spans {
+		if restored[s.TraceID][s.SpanID] {
+			continue
+		}
+		if p, ok := byID[[2]string{s.TraceID, s.ParentSpanID}]; ok && s.ParentSpanID != "" && p.Service == s.Service {
+			continue
+		}
+		k := versionKey{bucket: toMinute(s.StartTS), env: s.Env, service: s.Service, version: s.Version}
+		row := agg[k]
+		if row == nil {
+			row = &model.ServiceVersionRow{BucketTS: k.bucket, Env: k.env, Service: k.service, Version: k.version}
+			agg[k] = row
+			order = append(order, k)
+		}
+		row.Calls++
+		if s.IsError == 1 {
+			row.Errors++
+		}
+	}
+
+	out := make([]model.ServiceVersionRow, 0, len(order))
+	for _, k := range order {
+		out = append(out, *agg[k])
+	}
+	return out
+}
@@ -0,0 +1,421 @@
+package redisstream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"trace-lite/collector/internal/model"
+)
+
+type Message struct {
+	ID     string
+	Values map[string]string
+}
+
+func (c *Client) XAdd(ctx context.Context, stream string, fields ...string) (string, error) {
+	args := append([]string{"XADD", stream, "*"}, fields...)
+	reply, err := c.Do(ctx, args...)
+	if err != nil {
+		return "", err
+	}
+	id, _ := reply.(string)
+	return id, nil
+}
+
+func (c *Client) XTrimMinID(ctx context.Context, stream, minID string) error {
+	_, err := c.Do(ctx, "XTRIM", stream, "MINID", minID)
+	return err
+}
+
+func (c *Client) XGroupCreate(ctx context.Context, stream, group string) error {
+	_, err := c.Do(ctx, "XGROUP", "CREATE", stream, group, "0", "MKSTREAM")
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+func (c *Client) XReadGroup(ctx context.Context, group, consumer, stream, id string, count int, block time.Duration) ([]Message, error) {
+	args := []string{"XREADGROUP", "GROUP", group, consumer, "COUNT", strconv.Itoa(count)}
+	if block > 0 {
+		args = append(args, "BLOCK", strconv.FormatInt(block.Milliseconds(), 10))
+	}
+	args = append(args, "STREAMS", stream, id)
+	reply, err := c.do(ctx, block, args...)
+	if err != nil {
+		return nil, err
+	}
+	streams, _ := reply.([]any)
+	var out []Message
+	for _, s := range streams {
+		pair, _ := s.([]any)
+		if len(pair) != 2 {
+			continue
+		}
+		entries, _ := pair[1].([]any)
+		out = appendEntries(out, entries)
+	}
+	return out, nil
+}
+
+func appendEntries(out []Message, entries []any) []Message {
+	for _, e := range entries {
+		entry, _ := e.([]any)
+		if len(entry) != 2 {
+			continue
+		}
+		msg := Message{Values: map[string]string{}}
+		msg.ID, _ = entry[0].(string)
+		kv, _ := entry[1].([]any)
+		for i := 0; i+1 < len(kv); i += 2 {
+			k, _ := kv[i].(string)
+			v, _ := kv[i+1].(string)
+			msg.Values[k] = v
+		}
+		out = append(out, msg)
+	}
+	return out
+}
+
+func (c *Client) XAutoClaim(ctx context.Context, stream, group, consumer string, minIdle time.Duration, start string, count int) (claimed int, next string, err error) {
+	reply, err := c.Do(ctx, "XAUTOCLAIM", stream, group, consumer, strconv.FormatInt(minIdle.Milliseconds(), 10), start, "COUNT", strconv.Itoa(count), "JUSTID")
+	if err != nil {
+		return 0, "", err
+	}
+	parts, _ := reply.([]any)
+	if len(parts) < 2 {
+		return 0, "0-0", nil
+	}
+	next, _ = parts[0].(string)
+	ids, _ := parts[1].([]any)
+	return len(ids), next, nil
+}
+
+func (c *Client) XAck(ctx context.Context, stream, group string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := append([]string{"XACK", stream, group}, ids...)
+	_, err := c.Do(ctx, args...)
+	return err
+}
+
+func (c *Client) XLen(ctx context.Context, stream string) (int64, error) {
+	reply, err := c.Do(ctx, "XLEN", stream)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := reply.(int64)
+	return n, nil
+}
+
+func (c *Client) XPendingCount(ctx context.Context, stream, group string) (int64, error) {
+	n, _, err := c.XPendingSummary(ctx, stream, group)
+	return n, err
+}
+
+func (c *Client) XPendingSummary(ctx context.Context, stream, group string) (count int64, oldest string, err error) {
+	reply, err := c.Do(ctx, "XPENDING", stream, group)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "NOGROUP") {
+			return 0, "", nil
+		}
+		return 0, "", err
+	}
+	summary, _ := reply.([]any)
+	if len(summary) < 2 {
+		return 0, "", nil
+	}
+	count, _ = summary[0].(int64)
+	oldest, _ = summary[1].(string)
+	return count, oldest, nil
+}
+
+func (c *Client) XLastDelivered(ctx context.Context, stream, group string) (string, error) {
+	reply, err := c.Do(ctx, "XINFO", "GROUPS", stream)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "ERR no such key") {
+			return "", nil
+		}
+		return "", err
+	}
+	groups, _ := reply.([]any)
+	for _, g := range groups {
+		kv, _ := g.([]any)
+		fields := map[string]string{}
+		for i := 0; i+1 < len(kv); i += 2 {
+			k, _ := kv[i].(string)
+			v, _ := kv[i+1].(string)
+			fields[k] = v
+		}
+		if fields["name"] == group {
+			return fields["last-delivered-id"], nil
+		}
+	}
+	return "", nil
+}
+
+var ErrBacklogFull = errors.New("redis stream backlog is full")
+
+type Producer struct {
+	client *Client
+	stream string
+	group  string
+	maxLen int64
+}
+
+func NewProducer(client *Client, stream, group string, maxLen int64) *Producer {
+	return &Producer{client: client, stream: stream, group: group, maxLen: maxLen}
+}
+
+func (p *Producer) Append(ctx context.Context, batchID string, rows []model.RawLogRow) (string, error) {
+	payload, err := json.Marshal(rows)
+	if err != nil {
+		return "", err
+	}
+	if err := p.makeRoom(ctx); err != nil {
+		return "", err
+	}
+	return p.client.XAdd(ctx, p.stream, "batch_id", batchID, "rows", string(payload))
+}
+
+func (p *Producer) makeRoom(ctx context.Context) error {
+	if p.maxLen <= 0 {
+		return nil
+	}
+	length, err := p.client.XLen(ctx, p.stream)
+	if err != nil || length < p.maxLen {
+		return err
+	}
+	floor, err := p.consumedFloor(ctx)
+	if err != nil {
+		return err
+	}
+	if floor != "" {
+		if err := p.client.XTrimMinID(ctx, p.stream, floor); err != nil {
+			return err
+		}
+		if length, err = p.client.XLen(ctx, p.stream); err != nil {
+			return err
+		}
+	}
+	if length >= p.maxLen {
+		return ErrBacklogFull
+	}
+	return nil
+}
+
+func (p *Producer) consumedFloor(ctx context.Context) (string, error) {
+	pending, oldest, err := p.client.XPendingSummary(ctx, p.stream, p.group)
+	if err != nil {
+		return "", err
+	}
+	if pending > 0 {
+		return oldest, nil
+	}
+	last, err := p.client.XLastDelivered(ctx, p.stream, p.group)
+	if err != nil || last == "0-0" {
+		return "", err
+	}
+	return last, nil
+}
+
+func (p *Producer) Backlog(ctx context.Context) (length, pending int64, err error) {
+	if length, err = p.client.XLen(ctx, p.stream); err != nil {
+		return 0, 0, err
+	}
+	pending, err = p.client.XPendingCount(ctx, p.stream, p.group)
+	return length, pending, err
+}
+
+const leaseTTL = 30 * time.Second
+
+const leaseScript = `local cur = redis.call('GET', KEYS[1])
+if cur == false or cur == ARGV[1] then
+  redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+  return 1
+end
+return 0`
+
+type Consumer struct {
+	client    *Client
+	stream    string
+	group     string
+	name      string
+	batch     int
+	claimIdle time.Duration
+	exclusive bool
+	renewed   time.Time
+	handle    func(context.Context, string, []model.RawLogRow) error
+}
+
+func NewConsumer(client *Client, stream, group, name string, handle func(context.Context, string, []model.RawLogRow) error) *Consumer {
+	return &Consumer{client: client, stream: stream, group: group, name: name, batch: 100, claimIdle: time.Minute, handle: handle}
+}
+
+func (c *Consumer) SetClaimIdle(d time.Duration) {
+	c.claimIdle = d
+}
+
+func (c *Consumer) SetExclusive(on bool) {
+	c.exclusive = on
+}
+
+func (c *Consumer) Run(ctx context.Context) {
+	backoff := time.Second
+	pending := true
+	for ctx.Err() == nil {
+		if err := c.client.XGroupCreate(ctx, c.stream, c.group); err != nil {
+			log.Printf("redis stream group create: %v", err)
+			sleepCtx(ctx, backoff)
+			continue
+		}
+		break
+	}
+
+	var lastClaim time.Time
+	standby := false
+	for ctx.Err() == nil {
+		if !c.holdLease(ctx) {
+			if !standby {
+				log.Printf("redis stream consumer %s on standby: another consumer of group %s holds %s", c.name, c.group, c.leaseKey())
+				standby = true
+			}
+			sleepCtx(ctx, leaseTTL/3)
+			continue
+		}
+		if standby {
+			log.Printf("redis stream consumer %s took over group %s", c.name, c.group)
+			standby = false
+		}
+		if c.claimIdle > 0 && time.Since(lastClaim) >= c.claimIdle {
+			lastClaim = time.Now()
+			if n := c.claimIdleEntries(ctx); n > 0 {
+				log.Printf("redis stream consumer %s reclaimed %d idle pending entries", c.name, n)
+				pending = true
+			}
+		}
+
+		id := ">"
+		block := 5 * time.Second
+		if pending {
+			id = "0"
+			block = 0
+		}
+		msgs, err := c.client.XReadGroup(ctx, c.group, c.name, c.stream, id, c.batch, block)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("redis stream read: %v", err)
+				sleepCtx(ctx, backoff)
+			}
+			continue
+		}
+		if pending && len(msgs) == 0 {
+			pending = false
+			continue
+		}
+
+		acked := make([]string, 0, len(msgs))
+		for _, m := range msgs {
+			if !c.holdLease(ctx) {
+				pending = true
+				break
+			}
+			if err := c.process(ctx, m); err != nil {
+				log.Printf("redis stream process %s: %v", m.ID, err)
+				pending = true
+				break
+			}
+			acked = append(acked, m.ID)
+		}
+		if err := c.client.XAck(ctx, c.stream, c.group, acked...); err != nil {
+			log.Printf("redis stream ack: %v", err)
+		}
+		if pending && len(acked) < len(msgs) {
+			sleepCtx(ctx, backoff)
+		}
+	}
+}
+
+func (c *Consumer) claimIdleEntries(ctx context.Context) int {
+	total := 0
+	start := "0-0"
+	for ctx.Err() == nil {
+		n, next, err := c.client.XAutoClaim(ctx, c.stream, c.group, c.name, c.claimIdle, start, c.batch)
+		if err != nil {
+			log.Printf("redis stream claim: %v", err)
+			break
+		}
+		total += n
+		if next == "" || next == "0-0" {
+			break
+		}
+		start = next
+	}
+	return total
+}
+
+func (c *Consumer) leaseKey() string {
+	return c.stream + ":" + c.group + ":owner"
+}
+
+func (c *Consumer) holdLease(ctx context.Context) bool {
+	if !c.exclusive {
+		return true
+	}
+	if time.Since(c.renewed) < leaseTTL/3 {
+		return true
+	}
+	reply, err := c.client.Do(ctx, "EVAL", leaseScript, "1", c.leaseKey(), c.name, strconv.FormatInt(leaseTTL.Milliseconds(), 10))
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("redis stream lease: %v", err)
+		}
+		c.renewed = time.Time{}
+		return false
+	}
+	if n, _ := reply.(int64); n != 1 {
+		c.renewed = time.Time{}
+		return false
+	}
+	c.renewed = time.Now()
+	return true
+}
+
+func (c *Consumer) process(ctx context.Context, m Message) error {
+	raw, ok := m.Values["rows"]
+	if !ok {
+		return nil
+	}
+	var rows []model.RawLogRow
+	if err := json.Unmarshal([]byte(raw), &rows); err != nil {
+		log.Printf("redis stream drop malformed entry %s: %v", m.ID, err)
+		return nil
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	batchID := m.Values["batch_id"]
+	if batchID == "" {
+		batchID = m.ID
+	}
+	if err := c.handle(ctx, batchID, rows); err != nil {
+		return fmt.Errorf("handle: %w", err)
+	}
+	return nil
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
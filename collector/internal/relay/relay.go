@@ -0,0 +1,100 @@
+package relay
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"trace-lite/collector/internal/spool"
+)
+
+const (
+	BatchesPath    = "/v1/relay/batches"
+	MaxBatchBytes  = 16 << 20
+	requestTimeout = 30 * time.Second
+)
+
+type Upstream struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func New(url, token string) *Upstream {
+	return &Upstream{url: url, token: token, client: &http.Client{Timeout: requestTimeout}}
+}
+
+func (u *Upstream) Send(ctx context.Context, b spool.Batch) error {
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	if err := json.NewEncoder(gz).Encode(b); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.url+BatchesPath, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Authorization", "Bearer "+u.token)
+	req.Header.Set("X-Batch-Id", b.BatchID)
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	switch {
+	case resp.StatusCode == http.StatusBadRequest:
+		return spool.Permanent(fmt.Errorf("upstream answered %s: %s", resp.Status, bytes.TrimSpace(msg)))
+	case resp.StatusCode/100 != 2:
+		return fmt.Errorf("upstream answered %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+func Split(b spool.Batch, maxBytes int) []spool.Batch {
+	var parts []spool.Batch
+	var cur spool.Batch
+	size := 0
+	fits := func(v any) {
+		n := 1
+		if line, err := json.Marshal(v); err == nil {
+			n += len(line)
+		}
+		if size > 0 && size+n > maxBytes {
+			parts = append(parts, cur)
+			cur, size = spool.Batch{}, 0
+		}
+		size += n
+	}
+	for _, row := range b.Rows {
+		fits(row)
+		cur.Rows = append(cur.Rows, row)
+	}
+	for _, hb := range b.Heartbeats {
+		fits(hb)
+		cur.Heartbeats = append(cur.Heartbeats, hb)
+	}
+	for _, l := range b.Links {
+		fits(l)
+		cur.Links = append(cur.Links, l)
+	}
+	parts = append(parts, cur)
+	if len(parts) == 1 {
+		parts[0].BatchID = b.BatchID
+		return parts
+	}
+	for i := range parts {
+		parts[i].BatchID = fmt.Sprintf("%s.%d", b.BatchID, i)
+	}
+	return parts
+}
@@ -0,0 +1,162 @@
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var labelName = regexp.MustCompile(`^[a-z0-9_.-]{1,64}$`)
+
+var labelFields = map[string]bool{
+	"service": true, "host": true, "version": true, "operation": true, "method": true, "route": true,
+	"status": true, "error_type": true, "source": true, "is_error": true, "status_code": true,
+	"duration_ms": true, "self_time_ms": true, "parent_span_id": true,
+}
+
+type Cond struct {
+	Field string
+	Op    string
+	Value string
+	num   float64
+}
+
+type Label struct {
+	Name   string
+	Conds  []Cond
+	Min    int
+	MinPct float64
+}
+
+func ParseLabel(entry string) (Label, error) {
+	name, expr, ok := strings.Cut(entry, "=")
+	l := Label{Name: strings.TrimSpace(name), Min: 1}
+	if !ok || !labelName.MatchString(l.Name) {
+		return Label{}, fmt.Errorf("expected name=condition[&condition...][@count|@pct%%]")
+	}
+	if body, threshold, has := strings.Cut(expr, "@"); has {
+		expr = body
+		threshold = strings.TrimSpace(threshold)
+		if pct, isPct := strings.CutSuffix(threshold, "%"); isPct {
+			v, err := strconv.ParseFloat(pct, 64)
+			if err != nil || v <= 0 || v > 100 {
+				return Label{}, fmt.Errorf("threshold %q must be a percentage in (0, 100]", threshold)
+			}
+			l.Min, l.MinPct = 0, v
+		} else {
+			v, err := strconv.Atoi(threshold)
+			if err != nil || v < 1 {
+				return Label{}, fmt.Errorf("threshold %q must be a count >= 1", threshold)
+			}
+			l.Min = v
+		}
+	}
+	for _, part := range strings.Split(expr, "&") {
+		c, err := parseCond(strings.TrimSpace(part))
+		if err != nil {
+			return Label{}, err
+		}
+		l.Conds = append(l.Conds, c)
+	}
+	return l, nil
+}
+
+func ParseLabels(v string) ([]Label, []error) {
+	var out []Label
+	var errs []error
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		l, err := ParseLabel(entry)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%q: %w", entry, err))
+			continue
+		}
+		out = append(out, l)
+	}
+	return out, errs
+}
+
+func parseCond(s string) (Cond, error) {
+	i := strings.IndexAny(s, "<>!=")
+	if i <= 0 {
+		return Cond{}, fmt.Errorf("condition %q needs field, operator and value", s)
+	}
+	op := s[i : i+1]
+	if i+1 < len(s) && s[i+1] == '=' {
+		op = s[i : i+2]
+	}
+	c := Cond{Field: strings.ToLower(strings.TrimSpace(s[:i])), Op: op, Value: strings.TrimSpace(s[i+len(op):])}
+	if op == "!" {
+		return Cond{}, fmt.Errorf("condition %q: unknown operator", s)
+	}
+	if strings.HasPrefix(c.Field, "attr.") && len(c.Field) > len("attr.") {
+		c.Field = "attr." + strings.TrimSpace(s[:i])[len("attr."):]
+	} else if !labelFields[c.Field] {
+		return Cond{}, fmt.Errorf("condition %q: unknown field %q", s, c.Field)
+	}
+	if op != "=" && op != "!=" {
+		v, err := strconv.ParseFloat(c.Value, 64)
+		if err != nil {
+			return Cond{}, fmt.Errorf("condition %q: %s needs a number", s, op)
+		}
+		c.num = v
+	}
+	return c, nil
+}
+
+func (l Label) Attrs() []string {
+	var out []string
+	for _, c := range l.Conds {
+		if name, ok := strings.CutPrefix(c.Field, "attr."); ok {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+func (l Label) Matches(get func(field string) string) bool {
+	for _, c := range l.Conds {
+		if !c.matches(get(c.Field)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (l Label) Holds(matched, total int) bool {
+	if matched == 0 {
+		return false
+	}
+	if l.MinPct > 0 {
+		return total > 0 && float64(matched)*100 >= l.MinPct*float64(total)
+	}
+	return matched >= l.Min
+}
+
+func (c Cond) matches(v string) bool {
+	switch c.Op {
+	case "=":
+		return strings.EqualFold(v, c.Value)
+	case "!=":
+		return !strings.EqualFold(v, c.Value)
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return false
+	}
+	switch c.Op {
+	case ">":
+		return n > c.num
+	case ">=":
+		return n >= c.num
+	case "<":
+		return n < c.num
+	case "<=":
+		return n <= c.num
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package rules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"trace-lite/collector/internal/model"
+)
+
+type Rule struct {
+	Service string
+	Field   string
+	Value   string
+	Action  string
+	low     int
+	high    int
+}
+
+func Parse(entry string, actions ...string) (Rule, error) {
+	match, action, ok := strings.Cut(entry, "->")
+	if !ok {
+		return Rule{}, fmt.Errorf("expected service:field=value->action")
+	}
+	target, value, ok := strings.Cut(match, "=")
+	service, field, scoped := strings.Cut(strings.TrimSpace(target), ":")
+	if !ok || !scoped {
+		return Rule{}, fmt.Errorf("expected service:field=value->action")
+	}
+	r := Rule{
+		Service: strings.TrimSpace(service),
+		Field:   strings.ToLower(strings.TrimSpace(field)),
+		Value:   strings.TrimSpace(value),
+		Action:  strings.ToLower(strings.TrimSpace(action)),
+	}
+	if r.Service == "" || r.Value == "" {
+		return Rule{}, fmt.Errorf("service and value are required")
+	}
+	if !validAction(r.Action, actions) {
+		return Rule{}, fmt.Errorf("action %q must be one of %s", r.Action, strings.Join(actions, ", "))
+	}
+	switch {
+	case r.Field == "status_code":
+		lo, hi, isRange := strings.Cut(r.Value, "-")
+		var err error
+		if r.low, err = strconv.Atoi(strings.TrimSpace(lo)); err != nil {
+			return Rule{}, fmt.Errorf("status_code %q: %w", r.Value, err)
+		}
+		r.high = r.low
+		if isRange {
+			if r.high, err = strconv.Atoi(strings.TrimSpace(hi)); err != nil || r.high < r.low {
+				return Rule{}, fmt.Errorf("status_code range %q is invalid", r.Value)
+			}
+		}
+	case r.Field == "status", r.Field == "route", r.Field == "method", r.Field == "level", r.Field == "event":
+	case strings.HasPrefix(r.Field, "attr.") && len(r.Field) > len("attr."):
+		r.Field = "attr." + strings.TrimSpace(field)[len("attr."):]
+	default:
+		return Rule{}, fmt.Errorf("unknown field %q", r.Field)
+	}
+	return r, nil
+}
+
+func ParseList(v string, actions ...string) ([]Rule, []error) {
+	var out []Rule
+	var errs []error
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		r, err := Parse(entry, actions...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%q: %w", entry, err))
+			continue
+		}
+		out = append(out, r)
+	}
+	return out, errs
+}
+
+func validAction(action string, actions []string) bool {
+	for _, a := range actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) Matches(row model.RawLogRow) bool {
+	if r.Service != "*" && r.Service != row.Service {
+		return false
+	}
+	switch r.Field {
+	case "status_code":
+		return row.StatusCode > 0 && int(row.StatusCode) >= r.low && int(row.StatusCode) <= r.high
+	case "status":
+		return r.match(row.Attrs["status"], true)
+	case "route":
+		_, route := model.SplitRoute(row.Method, row.Route)
+		return r.match(route, false) || r.match(row.Route, false)
+	case "method":
+		return r.match(row.Method, true)
+	case "level":
+		return r.match(row.Level, true)
+	case "event":
+		return r.match(row.Event, true)
+	}
+	return r.match(row.Attrs[strings.TrimPrefix(r.Field, "attr.")], false)
+}
+
+func (r Rule) match(got string, fold bool) bool {
+	want := r.Value
+	if prefix, ok := strings.CutSuffix(want, "*"); ok && prefix != "" {
+		if len(got) < len(prefix) {
+			return false
+		}
+		got, want = got[:len(prefix)], prefix
+	}
+	if fold {
+		return strings.EqualFold(got, want)
+	}
+	return got == want
+}
+
+func First(list []Rule, row model.RawLogRow) string {
+	for _, r := range list {
+		if r.Matches(row) {
+			return r.Action
+		}
+	}
+	return ""
+}
+
+func (r Rule) String() string {
+	return r.Service + ":" + r.Field + "=" + r.Value + "->" + r.Action
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"trace-lite/collector/internal/reconstruct"
+	"trace-lite/collector/internal/rules"
+)
+
+func (h *Handler) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	_, adminToken := h.currentTokens()
+	if adminToken == "" {
+		writeError(w, http.StatusForbidden, "admin_token_required", "set ADMIN_TOKEN to use this endpoint", nil)
+		return false
+	}
+	if !validBearer(r.Header.Get("Authorization"), adminToken) {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "missing or invalid bearer token", nil)
+		return false
+	}
+	return true
+}
+
+func (h *Handler) AdminTraces(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
+		return
+	}
+	limit := 100
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 {
+		limit = v
+	}
+	minSpans, _ := strconv.Atoi(r.URL.Query().Get("min_spans"))
+
+	all := h.recon.Snapshot()
+	traces := all[:0]
+	for _, t := range all {
+		if t.SpanCount >= minSpans {
+			traces = append(traces, t)
+		}
+	}
+	matched := len(traces)
+	if len(traces) > limit {
+		traces = traces[:limit]
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"in_memory": len(all),
+		"matched":   matched,
+		"traces":    traces,
+	})
+}
+
+func (h *Handler) AdminFlush(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
+		return
+	}
+	traceID := strings.TrimSpace(r.URL.Query().Get("trace_id"))
+	if traceID == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "trace_id is required", nil)
+		return
+	}
+	found, err := h.recon.FlushTrace(r.Context(), traceID)
+	if err != nil {
+		log.Printf("admin flush %s failed: %v", traceID, err)
+		writeError(w, http.StatusBadGateway, "storage_failed", "flushing the trace to storage failed", nil)
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "not_found", "trace not in memory", nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"flushed": traceID})
+}
+
+type tuningJSON struct {
+	Window string `json:"window,omitempty"`
+	Flush  string `json:"flush,omitempty"`
+}
+
+type windowsJSON struct {
+	Default *tuningJSON           `json:"default,omitempty"`
+	Env     map[string]tuningJSON `json:"env"`
+	Service map[string]tuningJSON `json:"service"`
+}
+
+func (h *Handler) AdminWindows(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var body windowsJSON
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", "body must be JSON with env and service maps", nil)
+			return
+		}
+		o := reconstruct.Overrides{Env: map[string]reconstruct.Tuning{}, Service: map[string]reconstruct.Tuning{}}
+		for scope, src := range map[string]map[string]tuningJSON{"env": body.Env, "service": body.Service} {
+			dst := o.Env
+			if scope == "service" {
+				dst = o.Service
+			}
+			for name, tj := range src {
+				t, err := parseTuning(tj)
+				if err != nil {
+					writeError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("%s %q: %v", scope, name, err), nil)
+					return
+				}
+				dst[name] = t
+			}
+		}
+		h.recon.SetOverrides(o)
+		log.Printf("admin: trace window overrides replaced (%d env, %d service)", len(o.Env), len(o.Service))
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
+		return
+	}
+
+	def, o := h.recon.Overrides()
+	out := windowsJSON{
+		Default: &tuningJSON{Window: def.Window.String(), Flush: def.Flush.String()},
+		Env:     map[string]tuningJSON{},
+		Service: map[string]tuningJSON{},
+	}
+	for name, t := range o.Env {
+		out.Env[name] = formatTuning(t)
+	}
+	for name, t := range o.Service {
+		out.Service[name] = formatTuning(t)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func parseTuning(tj tuningJSON) (reconstruct.Tuning, error) {
+	var t reconstruct.Tuning
+	var err error
+	if tj.Window != "" {
+		if t.Window, err = time.ParseDuration(tj.Window); err != nil || t.Window < 0 {
+			return t, fmt.Errorf("invalid window %q", tj.Window)
+		}
+	}
+	if tj.Flush != "" {
+		if t.Flush, err = time.ParseDuration(tj.Flush); err != nil || t.Flush < 0 {
+			return t, fmt.Errorf("invalid flush %q", tj.Flush)
+		}
+	}
+	if t.Window == 0 && t.Flush == 0 {
+		return t, fmt.Errorf("window or flush is required")
+	}
+	return t, nil
+}
+
+func formatTuning(t reconstruct.Tuning) tuningJSON {
+	var tj tuningJSON
+	if t.Window > 0 {
+		tj.Window = t.Window.String()
+	}
+	if t.Flush > 0 {
+		tj.Flush = t.Flush.String()
+	}
+	return tj
+}
+
+func (h *Handler) AdminErrorRules(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var body struct {
+			Rules []string `json:"rules"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", "body must be JSON with a rules list", nil)
+			return
+		}
+		list := make([]rules.Rule, 0, len(body.Rules))
+		for _, entry := range body.Rules {
+			rule, err := rules.Parse(entry, "ok", "error", "cancelled", "timeout")
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("rule %q: %v", entry, err), nil)
+				return
+			}
+			list = append(list, rule)
+		}
+		h.recon.SetErrorRules(list)
+		log.Printf("admin: error rules replaced (%d rules)", len(list))
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
+		return
+	}
+
+	list := h.recon.ErrorRules()
+	out := make([]string, 0, len(list))
+	for _, rule := range list {
+		out = append(out, rule.String())
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"rules": out})
+}
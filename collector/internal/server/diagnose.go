@@ -0,0 +1,267 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"trace-lite/collector/internal/model"
+)
+
+type diagnostic struct {
+	Code     string  `json:"code"`
+	Severity string  `json:"severity"`
+	Message  string  `json:"message"`
+	Affected int     `json:"affected"`
+	Ratio    float64 `json:"ratio"`
+	Hint     string  `json:"hint"`
+}
+
+type diagnoseResponse struct {
+	Events        int           `json:"events"`
+	Parsed        int           `json:"parsed"`
+	Mapped        int           `json:"mapped"`
+	Traces        int           `json:"traces"`
+	Spans         int           `json:"spans"`
+	Edges         int           `json:"edges"`
+	InferredSpans int           `json:"inferred_spans"`
+	Rejected      int           `json:"rejected"`
+	Dropped       int           `json:"dropped"`
+	Issues        []diagnostic  `json:"issues"`
+	ParseErrors   []ingestError `json:"parse_errors,omitempty"`
+	MapErrors     []ingestError `json:"map_errors,omitempty"`
+}
+
+func (h *Handler) Diagnose(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
+		return
+	}
+	policy, ok := h.ingestPolicy(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "missing or invalid bearer token", nil)
+		return
+	}
+	version, err := negotiateVersion(r)
+	if err != nil {
+		writeError(w, http.StatusNotAcceptable, "unsupported_version", err.Error(), map[string]any{"supported": []string{"1", "2"}})
+		return
+	}
+	reader, err := maybeGzipReader(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_gzip", "request body is not valid gzip", nil)
+		return
+	}
+	defer reader.Close()
+
+	events, raws, parseErrs := parseEvents(reader, decoders[version])
+	h.correlator.Resolve(events, raws, false)
+	h.applyVHost(serverName(r), events)
+	resp := diagnoseResponse{
+		Events:      len(events) + len(parseErrs),
+		Parsed:      len(events),
+		ParseErrors: parseErrs,
+	}
+	if len(parseErrs) > 0 && resp.Events > 0 {
+		resp.Issues = append(resp.Issues, issue("unparseable_lines", "error", len(parseErrs), resp.Events,
+			"%s of lines are not valid JSON events",
+			"check parse_errors; every line must be one JSON object"))
+	}
+
+	traceEvents := make([]model.IngestEvent, 0, len(events))
+	for i := range events {
+		if !events[i].IsHeartbeat() && !events[i].IsLink() {
+			traceEvents = append(traceEvents, events[i])
+		}
+	}
+	batch := mappedBatch{rows: make([]model.RawLogRow, 0, len(events))}
+	h.mapEvents(&batch, events, raws, policy, time.Now().UTC())
+	rows, times := batch.rows, batch.times
+	resp.Mapped = len(rows)
+	resp.Rejected, resp.Dropped = batch.rejected, len(batch.dropped)
+	resp.MapErrors = reportedErrors(batch.errors)
+	if batch.rejected > 0 {
+		resp.Issues = append(resp.Issues, issue("rejected_events", "error", batch.rejected, resp.Parsed,
+			"%s of events would be rejected by the collector's timestamp, id or naming policy",
+			"check map_errors for the reason of each line"))
+	}
+	if resp.Dropped > 0 {
+		resp.Issues = append(resp.Issues, issue("dropped_events", "info", resp.Dropped, resp.Parsed,
+			"%s of events match a drop rule and would not be stored",
+			"see GET /v1/admin/ingest/drop-rules"))
+	}
+	resp.Issues = append(resp.Issues, diagnoseEvents(traceEvents, rows, times)...)
+
+	spans, traces, edges := h.recon.Preview(rows, times)
+	resp.Spans = len(spans)
+	resp.Traces = len(traces)
+	resp.Edges = len(edges)
+	for _, s := range spans {
+		if s.Source == "inferred" {
+			resp.InferredSpans++
+		}
+	}
+	if len(spans) > 0 && resp.InferredSpans > 0 {
+		resp.Issues = append(resp.Issues, issue("inferred_spans", "info", resp.InferredSpans, len(spans),
+			"%s of spans have inferred start or end times",
+			"log both start and end events, or a durationMs on the end event"))
+	}
+	if len(traces) > 0 && len(edges) == 0 && len(spans) > len(traces) {
+		resp.Issues = append(resp.Issues, diagnostic{
+			Code:     "no_dependency_edges",
+			Severity: "warning",
+			Message:  "spans were reconstructed but no cross-service dependency edges",
+			Hint:     "edges need child spans whose parentSpanId points at a span of another service",
+		})
+	}
+
+	sort.SliceStable(resp.Issues, func(i, j int) bool {
+		return severityRank(resp.Issues[i].Severity) < severityRank(resp.Issues[j].Severity)
+	})
+	if resp.Issues == nil {
+		resp.Issues = []diagnostic{}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func diagnoseEvents(events []model.IngestEvent, rows []model.RawLogRow, times []time.Time) []diagnostic {
+	total := len(events)
+	if total == 0 {
+		return nil
+	}
+
+	var missingCorrelation, missingSpan, missingTS, missingService, missingEnv, missingHost, missingVersion, missingRoute int
+	var unknownEvent, withDuration, endEvents, startEvents int
+	for _, e := range events {
+		if strings.TrimSpace(e.CorrelationID) == "" {
+			missingCorrelation++
+		}
+		if strings.TrimSpace(e.SpanID) == "" {
+			missingSpan++
+		}
+		if strings.TrimSpace(e.Timestamp) == "" {
+			missingTS++
+		}
+		if strings.TrimSpace(e.Service) == "" {
+			missingService++
+		}
+		if strings.TrimSpace(e.Env) == "" {
+			missingEnv++
+		}
+		if strings.TrimSpace(e.Host) == "" {
+			missingHost++
+		}
+		if strings.TrimSpace(e.Version) == "" {
+			missingVersion++
+		}
+		if strings.TrimSpace(e.Route) == "" {
+			missingRoute++
+		}
+		if e.DurationMs > 0 {
+			withDuration++
+		}
+		switch strings.ToLower(strings.TrimSpace(e.Event)) {
+		case "", "log", "span":
+		case "start":
+			startEvents++
+		case "end":
+			endEvents++
+		default:
+			unknownEvent++
+		}
+	}
+
+	var out []diagnostic
+	add := func(count int, code, severity, format, hint string) {
+		if count > 0 {
+			out = append(out, issue(code, severity, count, total, format, hint))
+		}
+	}
+	add(missingCorrelation, "missing_correlation_id", "error", "%s of events are missing correlationId and are rejected", "set correlationId (trace id) on every event")
+	add(missingSpan, "missing_span_id", "warning", "%s of events are missing spanId", "without spanId each event becomes its own implicit span and cannot be linked to children")
+	add(missingTS, "missing_timestamp", "warning", "%s of events have no timestamp; receive time is used", "send RFC3339 timestamps so ordering survives shipping delays")
+	add(missingService, "missing_service", "warning", "%s of events have no service and are attributed to unknown-service", "set service on every event")
+	add(missingEnv, "missing_env", "info", "%s of events have no env", "set env so traces can be filtered per environment")
+	add(missingHost, "missing_host", "info", "%s of events have no host", "set host for the hosts view")
+	add(missingVersion, "missing_version", "info", "%s of events have no version", "set version to enable compare and rollout views")
+	add(missingRoute, "missing_route", "info", "%s of events have no route; operation falls back to the message", "set route to get stable operation names")
+	add(unknownEvent, "unknown_event_type", "warning", "%s of events have an event type other than start|end|log", "unknown types are treated like log events")
+	if withDuration > 0 && endEvents == 0 {
+		out = append(out, issue("durations_without_end_events", "info", withDuration, total,
+			"%s of events carry durationMs but no end events were sent",
+			"durations on log lines are used to infer span start times"))
+	}
+	if startEvents > 0 && endEvents == 0 && withDuration == 0 {
+		out = append(out, issue("start_without_end", "warning", startEvents, total,
+			"%s of events are start events and no end events or durations were sent",
+			"spans will have zero duration; log an end event or durationMs"))
+	}
+
+	spanIDs := map[string]struct{}{}
+	for _, row := range rows {
+		if row.SpanID != "" {
+			spanIDs[row.TraceID+"/"+row.SpanID] = struct{}{}
+		}
+	}
+	parents, unmatched := 0, 0
+	for _, row := range rows {
+		if row.ParentSpanID == "" {
+			continue
+		}
+		parents++
+		if _, ok := spanIDs[row.TraceID+"/"+row.ParentSpanID]; !ok {
+			unmatched++
+		}
+	}
+	if parents > 0 && unmatched == parents {
+		out = append(out, issue("parent_never_matches", "warning", unmatched, parents,
+			"parentSpanId never matches any spanId in the batch (%s of references)",
+			"parentSpanId must carry the caller's spanId within the same correlationId"))
+	} else if unmatched > 0 {
+		out = append(out, issue("parent_unmatched", "info", unmatched, parents,
+			"%s of parentSpanId references have no matching span in the batch",
+			"expected if the parent span is logged by a service outside this sample"))
+	}
+
+	now := time.Now().UTC()
+	var future, stale int
+	for _, ts := range times {
+		if ts.After(now.Add(5 * time.Minute)) {
+			future++
+		}
+		if ts.Before(now.Add(-24 * time.Hour)) {
+			stale++
+		}
+	}
+	add(future, "timestamps_in_future", "warning", "%s of events are more than 5m in the future", "check producer clocks and timezone handling")
+	add(stale, "timestamps_stale", "info", "%s of events are more than 24h old", "old events are stored but reconstructed immediately")
+	return out
+}
+
+func issue(code, severity string, affected, total int, format, hint string) diagnostic {
+	ratio := 0.0
+	if total > 0 {
+		ratio = float64(affected) / float64(total)
+	}
+	return diagnostic{
+		Code:     code,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, fmt.Sprintf("%.0f%%", ratio*100)),
+		Affected: affected,
+		Ratio:    float64(int(ratio*10000)) / 10000,
+		Hint:     hint,
+	}
+}
+
+func severityRank(s string) int {
+	switch s {
+	case "error":
+		return 0
+	case "warning":
+		return 1
+	default:
+		return 2
+	}
+}
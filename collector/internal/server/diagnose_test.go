@@ -0,0 +1,54 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"trace-lite/collector/internal/config"
+)
+
+func TestDiagnoseAppliesIngestPolicies(t *testing.T) {
+	h := testHandler(config.Config{IDValidation: "strict", NamePolicy: "strict", NameCharset: "-_."}, nil)
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	body := strings.Join([]string{
+		`{"timestamp":"` + now + `","service":"cart","correlationId":"4bf92f3577b34da6a3ce929d0e0e4736","spanId":"00f067aa0ba902b7","event":"end","durationMs":5}`,
+		`{"timestamp":"` + now + `","service":"cart service!","correlationId":"4bf92f3577b34da6a3ce929d0e0e4736","spanId":"00f067aa0ba902b8","event":"end","durationMs":5}`,
+		`{"timestamp":"` + now + `","service":"cart","correlationId":"4bf92f3577b34da6a3ce929d0e0e4736","spanId":"span-3","event":"end","durationMs":5}`,
+	}, "\n")
+
+	rec := httptest.NewRecorder()
+	h.Diagnose(rec, httptest.NewRequest(http.MethodPost, "/v1/ingest/diagnose", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp diagnoseResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Parsed != 3 || resp.Mapped != 1 || resp.Rejected != 2 || resp.Spans != 1 {
+		t.Fatalf("parsed %d, mapped %d, rejected %d, spans %d; want 3, 1, 2, 1", resp.Parsed, resp.Mapped, resp.Rejected, resp.Spans)
+	}
+	wantReasons := map[int]string{2: "invalid service", 3: "invalid spanId"}
+	for _, e := range resp.MapErrors {
+		if !strings.Contains(e.Reason, wantReasons[e.Line]) {
+			t.Errorf("line %d rejected for %q, want %q", e.Line, e.Reason, wantReasons[e.Line])
+		}
+		delete(wantReasons, e.Line)
+	}
+	if len(wantReasons) > 0 {
+		t.Errorf("lines %v were not rejected", wantReasons)
+	}
+	found := false
+	for _, is := range resp.Issues {
+		if is.Code == "rejected_events" {
+			found = is.Affected == 2 && is.Severity == "error"
+		}
+	}
+	if !found {
+		t.Errorf("issues %+v lack an error rejected_events issue for 2 events", resp.Issues)
+	}
+}
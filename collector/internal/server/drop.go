@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"trace-lite/collector/internal/model"
+	"trace-lite/collector/internal/rules"
+)
+
+type dropCounters struct {
+	mu        sync.Mutex
+	rules     []rules.Rule
+	total     int64
+	byRule    map[string]int64
+	byService map[string]int64
+	since     time.Time
+}
+
+type droppedEvent struct {
+	rule    string
+	service string
+}
+
+func (h *Handler) SetDropRules(list []rules.Rule) {
+	h.drops.mu.Lock()
+	defer h.drops.mu.Unlock()
+	h.drops.rules = list
+}
+
+func (h *Handler) dropRule(row model.RawLogRow) (string, bool) {
+	h.drops.mu.Lock()
+	list := h.drops.rules
+	h.drops.mu.Unlock()
+	for _, rule := range list {
+		if rule.Matches(row) {
+			return rule.String(), true
+		}
+	}
+	return "", false
+}
+
+func (h *Handler) countDrops(events []droppedEvent) {
+	if len(events) == 0 {
+		return
+	}
+	h.drops.mu.Lock()
+	defer h.drops.mu.Unlock()
+	for _, e := range events {
+		h.drops.total++
+		h.drops.byRule[e.rule]++
+		h.drops.byService[e.service]++
+	}
+}
+
+func (h *Handler) AdminDropRules(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var body struct {
+			Rules []string `json:"rules"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", "body must be JSON with a rules list", nil)
+			return
+		}
+		list := make([]rules.Rule, 0, len(body.Rules))
+		for _, entry := range body.Rules {
+			rule, err := rules.Parse(entry, "drop")
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("rule %q: %v", entry, err), nil)
+				return
+			}
+			list = append(list, rule)
+		}
+		h.SetDropRules(list)
+		log.Printf("admin: drop rules replaced (%d rules)", len(list))
+	case http.MethodDelete:
+		h.drops.mu.Lock()
+		h.drops.total = 0
+		h.drops.byRule = map[string]int64{}
+		h.drops.byService = map[string]int64{}
+		h.drops.since = time.Now().UTC()
+		h.drops.mu.Unlock()
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
+		return
+	}
+
+	h.drops.mu.Lock()
+	out := make([]string, 0, len(h.drops.rules))
+	for _, rule := range h.drops.rules {
+		out = append(out, rule.String())
+	}
+	byRule := make(map[string]int64, len(h.drops.byRule))
+	for k, v := range h.drops.byRule {
+		byRule[k] = v
+	}
+	byService := make(map[string]int64, len(h.drops.byService))
+	for k, v := range h.drops.byService {
+		byService[k] = v
+	}
+	dropped := map[string]any{
+		"total":      h.drops.total,
+		"by_rule":    byRule,
+		"by_service": byService,
+		"since":      h.drops.since,
+	}
+	h.drops.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{"rules": out, "dropped": dropped})
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"strings"
+
+	"trace-lite/collector/internal/fieldcrypt"
+	"trace-lite/collector/internal/model"
+)
+
+func (h *Handler) encrypting() bool {
+	return h.crypt != nil && (len(h.encryptAttrs) > 0 || h.encryptRaw)
+}
+
+func (h *Handler) sealRows(rows []model.RawLogRow) []model.RawLogRow {
+	if !h.encrypting() {
+		return rows
+	}
+	out := make([]model.RawLogRow, len(rows))
+	for i, row := range rows {
+		if h.encryptRaw {
+			row.RawJSON = h.crypt.Seal("raw_json", row.RawJSON)
+		}
+		var attrs map[string]string
+		for _, k := range h.encryptAttrs {
+			v, ok := row.Attrs[k]
+			if !ok || v == "" {
+				continue
+			}
+			if attrs == nil {
+				attrs = make(map[string]string, len(row.Attrs))
+				for ak, av := range row.Attrs {
+					attrs[ak] = av
+				}
+			}
+			attrs[k] = h.crypt.Seal(k, v)
+		}
+		if attrs != nil {
+			row.Attrs = attrs
+		}
+		out[i] = row
+	}
+	return out
+}
+
+func (h *Handler) openRows(rows []model.RawLogRow) []model.RawLogRow {
+	if !h.encrypting() {
+		return rows
+	}
+	out := make([]model.RawLogRow, len(rows))
+	for i, row := range rows {
+		if raw, err := h.crypt.Open("raw_json", row.RawJSON); err == nil {
+			row.RawJSON = raw
+		}
+		var attrs map[string]string
+		for k, v := range row.Attrs {
+			if !fieldcrypt.IsSealed(v) {
+				continue
+			}
+			plain, err := h.crypt.Open(k, v)
+			if err != nil {
+				continue
+			}
+			if attrs == nil {
+				attrs = make(map[string]string, len(row.Attrs))
+				for ak, av := range row.Attrs {
+					attrs[ak] = av
+				}
+			}
+			attrs[k] = plain
+		}
+		if attrs != nil {
+			row.Attrs = attrs
+		}
+		out[i] = row
+	}
+	return out
+}
+
+func (h *Handler) sealLookups(rows []model.LookupRow) {
+	if !h.encrypting() {
+		return
+	}
+	for i := range rows {
+		if h.encrypted(rows[i].Key) {
+			rows[i].Value = h.crypt.Seal(rows[i].Key, rows[i].Value)
+		}
+	}
+}
+
+func (h *Handler) encrypted(attr string) bool {
+	for _, k := range h.encryptAttrs {
+		if k == attr {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) valueSet(attr, value string) string {
+	list := []string{chString(value)}
+	if h.crypt != nil && h.encrypted(attr) {
+		for _, sealed := range h.crypt.SealAll(attr, value) {
+			list = append(list, chString(sealed))
+		}
+	}
+	return "(" + strings.Join(list, ", ") + ")"
+}
@@ -0,0 +1,21 @@
+package server
+
+import (
+	"net/http"
+)
+
+type errorBody struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Retryable bool   `json:"retryable"`
+	Details   any    `json:"details,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string, details any) {
+	writeJSON(w, status, map[string]errorBody{"error": {
+		Code:      code,
+		Message:   message,
+		Retryable: status == http.StatusTooManyRequests || status >= 500,
+		Details:   details,
+	}})
+}
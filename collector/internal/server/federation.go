@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"trace-lite/collector/internal/federation"
+)
+
+const maxFederationBody = 64 << 20
+
+func (h *Handler) SetFederation(p *federation.Peers, token string) {
+	h.federation = p
+	h.federationToken = token
+}
+
+func (h *Handler) FederationSpans(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
+		return
+	}
+	if h.federation == nil {
+		writeError(w, http.StatusNotFound, "not_found", "federation is disabled on this collector", nil)
+		return
+	}
+	if !validBearer(r.Header.Get("Authorization"), h.federationToken) {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "missing or invalid bearer token", nil)
+		return
+	}
+	var batch federation.Batch
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxFederationBody)).Decode(&batch); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "body must be JSON with a region and a spans list", nil)
+		return
+	}
+	added := h.recon.AddSpans(batch.Spans)
+	h.federation.Received(added)
+	writeJSON(w, http.StatusAccepted, map[string]any{"region": batch.Region, "received": len(batch.Spans), "accepted": added})
+}
+
+func (h *Handler) FederationClaims(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
+		return
+	}
+	if h.federation == nil {
+		writeError(w, http.StatusNotFound, "not_found", "federation is disabled on this collector", nil)
+		return
+	}
+	if !validBearer(r.Header.Get("Authorization"), h.federationToken) {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "missing or invalid bearer token", nil)
+		return
+	}
+	var claims federation.Claims
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxFederationBody)).Decode(&claims); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "body must be JSON with a region and a trace_ids list", nil)
+		return
+	}
+	h.recon.MarkCrossRegion(claims.TraceIDs)
+	h.federation.Claimed(len(claims.TraceIDs))
+	writeJSON(w, http.StatusAccepted, map[string]any{"region": claims.Region, "claimed": len(claims.TraceIDs)})
+}
+
+func (h *Handler) AdminFederation(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
+		return
+	}
+	if h.federation == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"mode": "off"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"mode": "forward", "federation": h.federation.Stats()})
+}
@@ -2,101 +2,404 @@ package server
 
 import (
 	"bufio"
+	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io"
+	"log"
+	"math"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"trace-lite/collector/internal/clickhouse"
+	"trace-lite/collector/internal/config"
+	"trace-lite/collector/internal/correlate"
+	"trace-lite/collector/internal/federation"
+	"trace-lite/collector/internal/fieldcrypt"
 	"trace-lite/collector/internal/model"
 	"trace-lite/collector/internal/reconstruct"
+	"trace-lite/collector/internal/redisstream"
+	"trace-lite/collector/internal/spool"
+	"trace-lite/collector/internal/webhook"
 )
 
 type Handler struct {
-	token string
-	ch    *clickhouse.Client
-	recon *reconstruct.Reconstructor
+	tokens           []config.TokenPolicy
+	ch               clickhouse.Interface
+	recon            *reconstruct.Reconstructor
+	stream           *redisstream.Producer
+	adminToken       string
+	retryAfter       time.Duration
+	vhosts           []config.VHost
+	correlator       *correlate.Resolver
+	ids              model.IDPolicy
+	names            model.NamingPolicy
+	lookupKeys       []string
+	crypt            *fieldcrypt.Keyring
+	encryptAttrs     []string
+	encryptRaw       bool
+	archive          bool
+	rumOrigins       []string
+	rumLimiter       *rateLimiter
+	rumEnv           string
+	reconstruct      bool
+	lastPersist      atomic.Int64
+	authMu           sync.RWMutex
+	drops            dropCounters
+	stats            ingestStats
+	webhooks         *webhook.Dispatcher
+	federation       *federation.Peers
+	relay            *spool.Spool
+	overflow         *spool.Spool
+	lanes            *ingestLanes
+	priorityServices []string
+	shedder          *loadShedder
+	latency          *ingestLatency
+	federationToken  string
+	relayToken       string
+}
+
+const maxReportedErrors = 100
+
+var batchIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]+$`)
+
+type eventDecoder func([]byte) (model.IngestEvent, error)
+
+var decoders = map[string]eventDecoder{
+	"1": model.DecodeV1,
+	"2": model.DecodeV2,
 }
 
 type ingestError struct {
-	Line   int    `json:"line"`
-	Reason string `json:"reason"`
+	Line    int    `json:"line"`
+	Reason  string `json:"reason"`
+	payload string
 }
 
 type ingestResponse struct {
-	Accepted int           `json:"accepted"`
-	Rejected int           `json:"rejected"`
-	Errors   []ingestError `json:"errors,omitempty"`
+	BatchID    string        `json:"batch_id"`
+	Accepted   int           `json:"accepted"`
+	Rejected   int           `json:"rejected"`
+	Heartbeats int           `json:"heartbeats,omitempty"`
+	Links      int           `json:"links,omitempty"`
+	Dropped    int           `json:"dropped,omitempty"`
+	Shed       int           `json:"shed,omitempty"`
+	Errors     []ingestError `json:"errors,omitempty"`
 }
 
-func NewHandler(token string, ch *clickhouse.Client, recon *reconstruct.Reconstructor) *Handler {
-	return &Handler{token: token, ch: ch, recon: recon}
+type dryRunResponse struct {
+	ingestResponse
+	DryRun bool                      `json:"dry_run"`
+	Rows   []model.RawLogRow         `json:"rows"`
+	Spans  []model.SpanRow           `json:"spans"`
+	Traces []model.TraceRow          `json:"traces"`
+	Edges  []model.DependencyEdgeRow `json:"edges"`
 }
 
-func (h *Handler) Healthz(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
-	defer cancel()
-	if err := h.ch.Ping(ctx); err != nil {
-		http.Error(w, err.Error(), http.StatusServiceUnavailable)
-		return
+func NewHandler(cfg config.Config, ch clickhouse.Interface, recon *reconstruct.Reconstructor, stream *redisstream.Producer) *Handler {
+	return &Handler{
+		tokens:           cfg.IngestTokens,
+		adminToken:       cfg.AdminToken,
+		ch:               ch,
+		recon:            recon,
+		stream:           stream,
+		retryAfter:       cfg.IngestRetryAfter,
+		vhosts:           cfg.VHosts,
+		correlator:       correlate.New(cfg.CorrelationFields, cfg.CorrelationTTL),
+		ids:              model.IDPolicy{Mode: cfg.IDValidation, AcceptUUID: cfg.IDAcceptUUID},
+		names:            model.NamingPolicy{Mode: cfg.NamePolicy, Case: cfg.NameCase, MaxLen: cfg.NameMaxLen, Charset: cfg.NameCharset, Aliases: cfg.NameAliases},
+		lookupKeys:       cfg.LookupAttrs,
+		crypt:            cfg.Encryption,
+		encryptAttrs:     cfg.EncryptAttrs,
+		encryptRaw:       cfg.EncryptRawJSON,
+		archive:          cfg.ArchiveRejected && cfg.IngestBuffer != "relay",
+		rumOrigins:       cfg.RUMOrigins,
+		rumLimiter:       newRateLimiter(float64(cfg.RUMRate), cfg.RUMBurst),
+		rumEnv:           cfg.RUMEnv,
+		reconstruct:      cfg.ReconstructMode != "off",
+		lanes:            newIngestLanes(cfg.IngestMaxInflight, cfg.IngestBulkPercent, cfg.IngestLaneWait),
+		priorityServices: cfg.PriorityServices,
+		shedder:          newLoadShedder(cfg.LoadShed, cfg.LoadShedWindow),
+		latency:          newIngestLatency(cfg.IngestSLO),
+		drops:            dropCounters{rules: cfg.DropRules, byRule: map[string]int64{}, byService: map[string]int64{}, since: time.Now().UTC()},
 	}
-	w.Header().Set("Content-Type", "application/json")
-	_, _ = w.Write([]byte(`{"status":"ok"}`))
 }
 
 func (h *Handler) IngestLogs(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
 		return
 	}
-	if h.token != "" && !validBearer(r.Header.Get("Authorization"), h.token) {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	policy, ok := h.ingestPolicy(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "missing or invalid bearer token", nil)
 		return
 	}
+	phases := startPhases()
+	defer h.latency.observe(r, policy.Name, phases)
 
+	version, err := negotiateVersion(r)
+	if err != nil {
+		w.Header().Set("Supported-Versions", "1, 2")
+		writeError(w, http.StatusNotAcceptable, "unsupported_version", err.Error(), map[string]any{"supported": []string{"1", "2"}})
+		return
+	}
+	w.Header().Set("Content-Version", version)
+
+	batchID, err := requestBatchID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_batch_id", err.Error(), nil)
+		return
+	}
+	w.Header().Set("X-Batch-Id", batchID)
+
+	body := &timedReader{r: r.Body}
+	r.Body = &compositeReadCloser{Reader: body, closers: []io.Closer{r.Body}}
 	reader, err := maybeGzipReader(r)
 	if err != nil {
-		http.Error(w, "invalid gzip", http.StatusBadRequest)
+		phases.reading(body, body, 0)
+		writeError(w, http.StatusBadRequest, "invalid_gzip", "request body is not valid gzip", nil)
 		return
 	}
 	defer reader.Close()
 
-	events, raws, parseErrs := parseEvents(reader)
-	resp := ingestResponse{Errors: parseErrs}
+	inflated := &timedReader{r: reader}
+	parseStart := time.Now()
+	events, raws, parseErrs := parseEvents(inflated, decoders[version])
+	phases.reading(body, inflated, time.Since(parseStart))
+	mark := time.Now()
+	resp := ingestResponse{BatchID: batchID, Errors: reportedErrors(parseErrs)}
 	if len(events) == 0 {
 		resp.Rejected = len(parseErrs)
+		h.stats.rejected.Add(int64(resp.Rejected))
+		h.archiveRejected(r, batchID, policy.Name, version, parseErrs)
 		writeJSON(w, http.StatusBadRequest, resp)
 		return
 	}
+	h.correlator.Resolve(events, raws, !dryRun(r))
+	h.applyVHost(serverName(r), events)
 
-	rawRows := make([]model.RawLogRow, 0, len(events))
-	times := make([]time.Time, 0, len(events))
-	for i := range events {
-		row, ts, err := events[i].ToRaw(raws[i])
+	batch := mappedBatch{rows: make([]model.RawLogRow, 0, len(events)), rejected: len(parseErrs), errors: parseErrs}
+	h.mapEvents(&batch, events, raws, policy, time.Now().UTC())
+	rawRows, times, heartbeats, links, dropped := batch.rows, batch.times, batch.heartbeats, batch.links, batch.dropped
+	resp.Rejected, resp.Errors = batch.rejected, reportedErrors(batch.errors)
+	resp.Dropped = len(dropped)
+
+	if dryRun(r) {
+		phases.since("validate", mark)
+		spans, traces, edges := h.recon.Preview(rawRows, times)
+		resp.Accepted = len(rawRows) + len(heartbeats) + len(links)
+		resp.Heartbeats = len(heartbeats)
+		resp.Links = len(links)
+		sample := rawRows
+		if len(sample) > 20 {
+			sample = sample[:20]
+		}
+		writeJSON(w, http.StatusOK, dryRunResponse{
+			ingestResponse: resp,
+			DryRun:         true,
+			Rows:           sample,
+			Spans:          spans,
+			Traces:         traces,
+			Edges:          edges,
+		})
+		return
+	}
+
+	rawRows, times, resp.Shed = h.shedRows(w, rawRows, times)
+	mark = phases.since("validate", mark)
+	release, ok := h.enterLane(w, r, rawRows)
+	mark = phases.since("queue", mark)
+	if !ok {
+		return
+	}
+	defer release()
+	out := spool.Batch{BatchID: batchID, Rows: rawRows, Heartbeats: heartbeats, Links: links}
+	if out.Len() > 0 {
+		err := h.persistBatch(r.Context(), out, times)
+		phases.since("insert", mark)
 		if err != nil {
-			resp.Rejected++
-			if len(resp.Errors) < 100 {
-				resp.Errors = append(resp.Errors, ingestError{Line: i + 1, Reason: err.Error()})
+			h.unavailable(w, err)
+			return
+		}
+	}
+	resp.Accepted = out.Len()
+	resp.Heartbeats, resp.Links = len(heartbeats), len(links)
+	h.countDrops(dropped)
+	h.stats.accepted.Add(int64(resp.Accepted))
+	h.stats.rejected.Add(int64(resp.Rejected))
+	h.archiveRejected(r, batchID, policy.Name, version, batch.errors)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type mappedBatch struct {
+	rows       []model.RawLogRow
+	times      []time.Time
+	heartbeats []model.HeartbeatRow
+	links      []model.LinkRow
+	dropped    []droppedEvent
+	rejected   int
+	errors     []ingestError
+}
+
+func (b *mappedBatch) reject(line int, reason, payload string) {
+	b.rejected++
+	b.errors = append(b.errors, ingestError{Line: line, Reason: reason, payload: payload})
+}
+
+func reportedErrors(errs []ingestError) []ingestError {
+	if len(errs) > maxReportedErrors {
+		return errs[:maxReportedErrors]
+	}
+	return errs
+}
+
+func (h *Handler) mapEvents(b *mappedBatch, events []model.IngestEvent, raws []string, policy config.TokenPolicy, now time.Time) {
+	clock := model.NewTimeSource(now, policy.Location)
+	policyErrs := applyTrustPolicy(events, policy, clock)
+	for i := range events {
+		if policyErrs[i] != "" {
+			b.reject(i+1, policyErrs[i], raws[i])
+			continue
+		}
+		if err := h.ids.Apply(&events[i]); err != nil {
+			b.reject(i+1, err.Error(), raws[i])
+			continue
+		}
+		if err := h.names.Apply(&events[i]); err != nil {
+			b.reject(i+1, err.Error(), raws[i])
+			continue
+		}
+		if events[i].IsHeartbeat() {
+			hb, err := events[i].ToHeartbeat(clock)
+			if err != nil {
+				b.reject(i+1, err.Error(), raws[i])
+				continue
 			}
+			b.heartbeats = append(b.heartbeats, hb)
+			continue
+		}
+		if events[i].IsLink() {
+			link, err := events[i].ToLink(clock)
+			if err != nil {
+				b.reject(i+1, err.Error(), raws[i])
+				continue
+			}
+			b.links = append(b.links, link)
+			continue
+		}
+		row, ts, err := events[i].ToRaw(raws[i], clock)
+		if err != nil {
+			b.reject(i+1, err.Error(), raws[i])
+			continue
+		}
+		if rule, ok := h.dropRule(row); ok {
+			b.dropped = append(b.dropped, droppedEvent{rule: rule, service: row.Service})
 			continue
 		}
-		rawRows = append(rawRows, row)
-		times = append(times, ts)
+		b.rows = append(b.rows, row)
+		b.times = append(b.times, ts)
 	}
+}
 
-	if len(rawRows) > 0 {
-		if err := h.ch.InsertJSONEachRow(r.Context(), "raw_logs", rawRows); err != nil {
-			http.Error(w, err.Error(), http.StatusBadGateway)
-			return
+func (h *Handler) Store(ctx context.Context, batchID string, rows []model.RawLogRow, times []time.Time) error {
+	if days := h.recon.RawRetainDays(); days > 0 {
+		for i := range rows {
+			if rows[i].RetainDays == 0 {
+				rows[i].RetainDays = days
+			}
 		}
-		h.recon.Add(rawRows, times)
-		resp.Accepted = len(rawRows)
 	}
-	resp.Rejected += len(events) - len(rawRows)
-	writeJSON(w, http.StatusOK, resp)
+	plain := h.openRows(rows)
+	usage := usageRows(plain)
+	if err := h.ch.InsertJSONEachRowDedup(ctx, "raw_logs", h.sealRows(rows), batchID); err != nil {
+		return err
+	}
+	if err := h.ch.InsertJSONEachRowDedup(ctx, "usage_daily", usage, batchID); err != nil {
+		return err
+	}
+	if lookups := lookupRows(plain, h.lookupKeys); len(lookups) > 0 {
+		h.sealLookups(lookups)
+		if err := h.ch.InsertJSONEachRowDedup(ctx, "attr_lookup", lookups, batchID); err != nil {
+			return err
+		}
+	}
+	h.lastPersist.Store(time.Now().UnixNano())
+	if h.reconstruct {
+		h.recon.Add(plain, times)
+	}
+	return nil
+}
+
+func (h *Handler) StoreBuffered(ctx context.Context, batchID string, rows []model.RawLogRow) error {
+	return h.Store(ctx, batchID, rows, rowTimes(rows))
+}
+
+func (h *Handler) unavailable(w http.ResponseWriter, err error) {
+	log.Printf("ingest persist failed: %v", err)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(h.retryAfter.Seconds()))))
+	writeError(w, http.StatusServiceUnavailable, "ingest_unavailable", "ingest temporarily unavailable, retry with the same X-Batch-Id", nil)
+}
+
+func serverName(r *http.Request) string {
+	if r.TLS == nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSuffix(r.TLS.ServerName, "."))
+}
+
+func (h *Handler) applyVHost(serverName string, events []model.IngestEvent) {
+	if vh, ok := config.MatchVHost(h.vhosts, serverName); ok {
+		applyVHostDefaults(events, vh)
+	}
+}
+
+func applyVHostDefaults(events []model.IngestEvent, vh config.VHost) {
+	for i := range events {
+		if vh.Env != "" && strings.TrimSpace(events[i].Env) == "" {
+			events[i].Env = vh.Env
+		}
+		if vh.Tenant != "" {
+			if events[i].Attrs == nil {
+				events[i].Attrs = map[string]string{}
+			}
+			if events[i].Attrs["tenant"] == "" {
+				events[i].Attrs["tenant"] = vh.Tenant
+			}
+		}
+	}
+}
+
+func requestBatchID(r *http.Request) (string, error) {
+	id := strings.TrimSpace(r.Header.Get("X-Batch-Id"))
+	if id == "" {
+		id = strings.TrimSpace(r.Header.Get("Idempotency-Key"))
+	}
+	if id == "" {
+		return newBatchID(), nil
+	}
+	if len(id) > 128 || !batchIDPattern.MatchString(id) {
+		return "", fmt.Errorf("invalid X-Batch-Id")
+	}
+	return id, nil
+}
+
+func newBatchID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
 }
 
 func maybeGzipReader(r *http.Request) (io.ReadCloser, error) {
@@ -122,29 +425,61 @@ func (c *compositeReadCloser) Close() error {
 	return nil
 }
 
-func parseEvents(r io.Reader) ([]model.IngestEvent, []string, []ingestError) {
-	body, err := io.ReadAll(io.LimitReader(r, 20*1024*1024))
-	if err != nil {
+func dryRun(r *http.Request) bool {
+	if strings.HasSuffix(r.URL.Path, "/ingest/validate") {
+		return true
+	}
+	v, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	return v
+}
+
+func negotiateVersion(r *http.Request) (string, error) {
+	version := "1"
+	if strings.HasPrefix(r.URL.Path, "/v2/") {
+		version = "2"
+	}
+	if v := strings.TrimSpace(r.Header.Get("Accept-Version")); v != "" {
+		version = strings.TrimPrefix(strings.ToLower(v), "v")
+	}
+	if _, ok := decoders[version]; !ok {
+		return "", fmt.Errorf("unsupported ingest version %q", version)
+	}
+	return version, nil
+}
+
+var bodyPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+const maxLineBytes = 2 * 1024 * 1024
+
+func parseEvents(r io.Reader, decode eventDecoder) ([]model.IngestEvent, []string, []ingestError) {
+	buf := bodyPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= 4*1024*1024 {
+			bodyPool.Put(buf)
+		}
+	}()
+	if _, err := buf.ReadFrom(io.LimitReader(r, 20*1024*1024)); err != nil {
 		return nil, nil, []ingestError{{Line: 0, Reason: err.Error()}}
 	}
 
-	trimmed := strings.TrimSpace(string(body))
-	if trimmed == "" {
+	trimmed := bytes.TrimSpace(buf.Bytes())
+	if len(trimmed) == 0 {
 		return nil, nil, []ingestError{{Line: 0, Reason: "empty body"}}
 	}
 
-	if strings.HasPrefix(trimmed, "[") {
+	if trimmed[0] == '[' {
 		var rawMsgs []json.RawMessage
-		if err := json.Unmarshal([]byte(trimmed), &rawMsgs); err != nil {
-			return nil, nil, []ingestError{{Line: 0, Reason: err.Error()}}
+		if err := json.Unmarshal(trimmed, &rawMsgs); err != nil {
+			return nil, nil, []ingestError{{Line: 0, Reason: err.Error(), payload: string(trimmed)}}
 		}
 		events := make([]model.IngestEvent, 0, len(rawMsgs))
 		raws := make([]string, 0, len(rawMsgs))
 		errs := make([]ingestError, 0)
 		for i, m := range rawMsgs {
-			var e model.IngestEvent
-			if err := json.Unmarshal(m, &e); err != nil {
-				errs = append(errs, ingestError{Line: i + 1, Reason: err.Error()})
+			e, err := decode(m)
+			if err != nil {
+				errs = append(errs, ingestError{Line: i + 1, Reason: err.Error(), payload: string(m)})
 				continue
 			}
 			events = append(events, e)
@@ -153,38 +488,44 @@ func parseEvents(r io.Reader) ([]model.IngestEvent, []string, []ingestError) {
 		return events, raws, errs
 	}
 
-	if strings.Contains(trimmed, "\n") {
-		scanner := bufio.NewScanner(strings.NewReader(trimmed))
-		scanner.Buffer(make([]byte, 0, 64*1024), 2*1024*1024)
-		events := make([]model.IngestEvent, 0)
-		raws := make([]string, 0)
+	if bytes.IndexByte(trimmed, '\n') >= 0 {
+		n := bytes.Count(trimmed, []byte{'\n'}) + 1
+		events := make([]model.IngestEvent, 0, n)
+		raws := make([]string, 0, n)
 		errs := make([]ingestError, 0)
 		line := 0
-		for scanner.Scan() {
+		for rest := trimmed; len(rest) > 0; {
+			entry := rest
+			if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+				entry, rest = rest[:i], rest[i+1:]
+			} else {
+				rest = nil
+			}
+			if len(entry) > maxLineBytes {
+				errs = append(errs, ingestError{Line: line, Reason: bufio.ErrTooLong.Error()})
+				break
+			}
 			line++
-			entry := strings.TrimSpace(scanner.Text())
-			if entry == "" {
+			entry = bytes.TrimSpace(entry)
+			if len(entry) == 0 {
 				continue
 			}
-			var e model.IngestEvent
-			if err := json.Unmarshal([]byte(entry), &e); err != nil {
-				errs = append(errs, ingestError{Line: line, Reason: err.Error()})
+			e, err := decode(entry)
+			if err != nil {
+				errs = append(errs, ingestError{Line: line, Reason: err.Error(), payload: string(entry)})
 				continue
 			}
 			events = append(events, e)
-			raws = append(raws, entry)
-		}
-		if err := scanner.Err(); err != nil {
-			errs = append(errs, ingestError{Line: line, Reason: err.Error()})
+			raws = append(raws, string(entry))
 		}
 		return events, raws, errs
 	}
 
-	var single model.IngestEvent
-	if err := json.Unmarshal([]byte(trimmed), &single); err != nil {
-		return nil, nil, []ingestError{{Line: 1, Reason: err.Error()}}
+	single, err := decode(trimmed)
+	if err != nil {
+		return nil, nil, []ingestError{{Line: 1, Reason: err.Error(), payload: string(trimmed)}}
 	}
-	return []model.IngestEvent{single}, []string{trimmed}, nil
+	return []model.IngestEvent{single}, []string{string(trimmed)}, nil
 }
 
 func validBearer(header, token string) bool {
@@ -195,7 +536,7 @@ func validBearer(header, token string) bool {
 	if !strings.EqualFold(parts[0], "Bearer") {
 		return false
 	}
-	return strings.TrimSpace(parts[1]) == token
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(parts[1])), []byte(token)) == 1
 }
 
 func writeJSON(w http.ResponseWriter, status int, v any) {
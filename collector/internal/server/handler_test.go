@@ -0,0 +1,47 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"trace-lite/collector/internal/clickhouse"
+	"trace-lite/collector/internal/clickhouse/clickhousetest"
+	"trace-lite/collector/internal/config"
+	"trace-lite/collector/internal/reconstruct"
+)
+
+func testHandler(cfg config.Config, ch clickhouse.Interface) *Handler {
+	return NewHandler(cfg, ch, reconstruct.New(clickhousetest.New(), time.Second, time.Second, 100, "tx"), nil)
+}
+
+func inserted(t *testing.T, f *clickhousetest.Fake, table string) ([]string, string) {
+	var lines []string
+	var token string
+	for _, ins := range f.Inserts() {
+		if ins.Table != table {
+			continue
+		}
+		for _, row := range ins.Rows {
+			b, err := json.Marshal(row)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines = append(lines, string(b))
+		}
+		token = ins.Token
+	}
+	return lines, token
+}
+
+func executed(f *clickhousetest.Fake) []string {
+	var out []string
+	for _, e := range f.Execs() {
+		out = append(out, e.Query)
+	}
+	return out
+}
+
+func touched(f *clickhousetest.Fake) bool {
+	return len(f.Queries()) > 0 || len(f.Execs()) > 0 || len(f.Inserts()) > 0
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"time"
+)
+
+type checkResult struct {
+	OK        bool    `json:"ok"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+func (h *Handler) Livez(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"status": "ok"})
+}
+
+func (h *Handler) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	checks := map[string]any{}
+	var ready bool
+	if h.relay != nil {
+		stats := h.relay.Stats()
+		ready = !h.relay.Full()
+		checks["relay"] = map[string]any{"ok": ready, "spool": stats}
+	} else {
+		chCheck := timedCheck(func() error { return h.ch.Ping(ctx) })
+		checks["clickhouse"] = chCheck
+		ready = chCheck.OK
+	}
+	if h.overflow != nil {
+		spill := !h.overflow.Full()
+		checks["overflow"] = map[string]any{"ok": spill, "spool": h.overflow.Stats()}
+		ready = ready || spill
+	}
+	if h.stream != nil {
+		var length, pending int64
+		queue := timedCheck(func() error {
+			var err error
+			length, pending, err = h.stream.Backlog(ctx)
+			return err
+		})
+		checks["queue"] = map[string]any{
+			"ok":         queue.OK,
+			"latency_ms": queue.LatencyMs,
+			"error":      queue.Error,
+			"length":     length,
+			"pending":    pending,
+		}
+		ready = queue.OK
+	}
+	if h.lanes != nil {
+		checks["lanes"] = map[string]any{
+			"inflight":      len(h.lanes.slots),
+			"capacity":      cap(h.lanes.slots),
+			"bulk_capacity": cap(h.lanes.bulk),
+			"shed_bulk":     h.lanes.shedBulk.Load(),
+			"shed_priority": h.lanes.shedPriority.Load(),
+		}
+	}
+	if h.shedder != nil {
+		pressure, shed := h.shedder.snapshot()
+		checks["load_shed"] = map[string]any{"pressure": math.Round(pressure*100) / 100, "shed": shed}
+	}
+	if h.reconstruct {
+		checks["reconstructor"] = h.recon.Status()
+	}
+	if ns := h.lastPersist.Load(); ns > 0 {
+		checks["last_persist"] = time.Unix(0, ns).UTC()
+	}
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not_ready", http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]any{"status": status, "checks": checks})
+}
+
+func timedCheck(fn func() error) checkResult {
+	start := time.Now()
+	err := fn()
+	res := checkResult{OK: err == nil, LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	return res
+}
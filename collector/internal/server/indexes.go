@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func (h *Handler) AdminSkipIndexes(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		if !h.ch.SchemaManaged() {
+			writeError(w, http.StatusConflict, "schema_unmanaged", "skipping indexes are managed with the schema; set SCHEMA_DIR on the collector", nil)
+			return
+		}
+		var body struct {
+			Indexes     []string `json:"indexes"`
+			Materialize bool     `json:"materialize"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", "body must be JSON with an indexes list", nil)
+			return
+		}
+		if err := h.ch.SetSkipIndexes(body.Indexes); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", err.Error(), nil)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		defer cancel()
+		if err := h.ch.ApplySkipIndexes(ctx, body.Materialize); err != nil {
+			writeError(w, http.StatusBadGateway, "storage_failed", err.Error(), nil)
+			return
+		}
+		log.Printf("admin: skipping indexes set to [%s] (materialize=%t)", strings.Join(body.Indexes, ","), body.Materialize)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	states, err := h.ch.SkipIndexStates(ctx)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "storage_failed", err.Error(), nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"managed": h.ch.SchemaManaged(), "indexes": states})
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"trace-lite/collector/internal/model"
+)
+
+type ingestLanes struct {
+	slots        chan struct{}
+	bulk         chan struct{}
+	wait         time.Duration
+	shedBulk     atomic.Int64
+	shedPriority atomic.Int64
+}
+
+func newIngestLanes(inflight, bulkPercent int, wait time.Duration) *ingestLanes {
+	if inflight <= 0 {
+		return nil
+	}
+	bulk := max(1, inflight*bulkPercent/100)
+	return &ingestLanes{slots: make(chan struct{}, inflight), bulk: make(chan struct{}, bulk), wait: wait}
+}
+
+func (l *ingestLanes) acquire(ctx context.Context, priority bool) (func(), bool) {
+	if l == nil {
+		return func() {}, true
+	}
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+	shed := &l.shedBulk
+	if priority {
+		shed = &l.shedPriority
+	} else {
+		select {
+		case l.bulk <- struct{}{}:
+		case <-timer.C:
+			shed.Add(1)
+			return nil, false
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+	select {
+	case l.slots <- struct{}{}:
+	case <-timer.C:
+		shed.Add(1)
+		if !priority {
+			<-l.bulk
+		}
+		return nil, false
+	case <-ctx.Done():
+		if !priority {
+			<-l.bulk
+		}
+		return nil, false
+	}
+	return func() {
+		<-l.slots
+		if !priority {
+			<-l.bulk
+		}
+	}, true
+}
+
+func (h *Handler) priorityRows(rows []model.RawLogRow) bool {
+	for _, row := range rows {
+		if row.StatusCode >= 500 || slices.Contains(h.priorityServices, row.Service) {
+			return true
+		}
+		switch strings.ToUpper(row.Level) {
+		case "ERROR", "FATAL", "CRITICAL":
+			return true
+		}
+		if row.Attrs["error_type"] != "" || row.Attrs["error_message"] != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) enterLane(w http.ResponseWriter, r *http.Request, rows []model.RawLogRow) (func(), bool) {
+	priority := h.priorityRows(rows)
+	lane := "bulk"
+	if priority {
+		lane = "priority"
+	}
+	w.Header().Set("X-Ingest-Lane", lane)
+	release, ok := h.lanes.acquire(r.Context(), priority)
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(h.retryAfter.Seconds()))))
+		writeError(w, http.StatusServiceUnavailable, "ingest_saturated", "collector is saturated and is shedding "+lane+" batches, retry with the same X-Batch-Id", nil)
+	}
+	return release, ok
+}
@@ -0,0 +1,253 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	latencySamples = 1024
+	maxProducers   = 1000
+)
+
+var ingestPhases = []string{"read", "decompress", "parse", "validate", "queue", "insert"}
+
+type timedReader struct {
+	r       io.Reader
+	spent   time.Duration
+	timeout bool
+}
+
+func (t *timedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.r.Read(p)
+	t.spent += time.Since(start)
+	var netErr net.Error
+	if err != nil && errors.As(err, &netErr) && netErr.Timeout() {
+		t.timeout = true
+	}
+	return n, err
+}
+
+type latencyWindow struct {
+	samples [latencySamples]float64
+	next    int
+	count   int64
+	sum     float64
+	max     float64
+}
+
+type latencySummary struct {
+	Count int64   `json:"count"`
+	AvgMs float64 `json:"avg_ms"`
+	P50Ms float64 `json:"p50_ms"`
+	P95Ms float64 `json:"p95_ms"`
+	P99Ms float64 `json:"p99_ms"`
+	MaxMs float64 `json:"max_ms"`
+}
+
+func (w *latencyWindow) add(d time.Duration) {
+	ms := float64(d.Microseconds()) / 1000
+	w.samples[w.next] = ms
+	w.next = (w.next + 1) % latencySamples
+	w.count++
+	w.sum += ms
+	w.max = max(w.max, ms)
+}
+
+func (w *latencyWindow) summary() latencySummary {
+	if w.count == 0 {
+		return latencySummary{}
+	}
+	n := min(int(w.count), latencySamples)
+	recent := slices.Clone(w.samples[:n])
+	sort.Float64s(recent)
+	at := func(q float64) float64 {
+		return recent[min(n-1, int(math.Ceil(q*float64(n)))-1)]
+	}
+	return latencySummary{
+		Count: w.count,
+		AvgMs: math.Round(w.sum/float64(w.count)*100) / 100,
+		P50Ms: at(0.5),
+		P95Ms: at(0.95),
+		P99Ms: at(0.99),
+		MaxMs: w.max,
+	}
+}
+
+type producerKey struct {
+	token string
+	agent string
+}
+
+type producerStats struct {
+	Token       string    `json:"token"`
+	UserAgent   string    `json:"user_agent"`
+	LastClient  string    `json:"last_client"`
+	Requests    int64     `json:"requests"`
+	Timeouts    int64     `json:"body_read_timeouts"`
+	Slow        int64     `json:"over_slo"`
+	ReadMs      float64   `json:"avg_read_ms"`
+	LastTimeout time.Time `json:"last_timeout,omitzero"`
+	Flagged     bool      `json:"flagged"`
+	readTotal   float64
+}
+
+type ingestLatency struct {
+	mu        sync.Mutex
+	slo       time.Duration
+	phases    map[string]*latencyWindow
+	total     latencyWindow
+	withinSLO int64
+	timeouts  int64
+	producers map[producerKey]*producerStats
+}
+
+type requestPhases struct {
+	started time.Time
+	spent   map[string]time.Duration
+	timeout bool
+}
+
+func newIngestLatency(slo time.Duration) *ingestLatency {
+	l := &ingestLatency{slo: slo, phases: map[string]*latencyWindow{}, producers: map[producerKey]*producerStats{}}
+	for _, p := range ingestPhases {
+		l.phases[p] = &latencyWindow{}
+	}
+	return l
+}
+
+func startPhases() *requestPhases {
+	return &requestPhases{started: time.Now(), spent: map[string]time.Duration{}}
+}
+
+func (p *requestPhases) since(phase string, start time.Time) time.Time {
+	now := time.Now()
+	p.spent[phase] += now.Sub(start)
+	return now
+}
+
+func (p *requestPhases) reading(body, inflated *timedReader, parse time.Duration) {
+	p.spent["read"] = body.spent
+	p.spent["decompress"] = max(0, inflated.spent-body.spent)
+	p.spent["parse"] = max(0, parse-inflated.spent)
+	p.timeout = body.timeout
+}
+
+func (l *ingestLatency) observe(r *http.Request, token string, p *requestPhases) {
+	total := time.Since(p.started)
+	key := producerKey{token: token, agent: r.UserAgent()}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for phase, d := range p.spent {
+		if w := l.phases[phase]; w != nil {
+			w.add(d)
+		}
+	}
+	l.total.add(total)
+	if l.slo <= 0 || total <= l.slo {
+		l.withinSLO++
+	}
+	if p.timeout {
+		l.timeouts++
+	}
+
+	ps := l.producers[key]
+	if ps == nil {
+		if len(l.producers) >= maxProducers {
+			return
+		}
+		ps = &producerStats{Token: token, UserAgent: key.agent}
+		l.producers[key] = ps
+	}
+	ps.Requests++
+	ps.LastClient = clientIP(r)
+	ps.readTotal += float64(p.spent["read"].Microseconds()) / 1000
+	ps.ReadMs = math.Round(ps.readTotal/float64(ps.Requests)*100) / 100
+	if l.slo > 0 && total > l.slo {
+		ps.Slow++
+	}
+	if p.timeout {
+		ps.Timeouts++
+		ps.LastTimeout = time.Now().UTC()
+	}
+	if !ps.Flagged && ps.Timeouts >= 3 && ps.Timeouts*5 >= ps.Requests {
+		ps.Flagged = true
+		log.Printf("slow producer: token %q agent %q from %s hit %d body-read timeouts in %d requests", ps.Token, ps.UserAgent, ps.LastClient, ps.Timeouts, ps.Requests)
+	}
+}
+
+func (l *ingestLatency) report() map[string]any {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	phases := map[string]latencySummary{}
+	for name, w := range l.phases {
+		phases[name] = w.summary()
+	}
+	producers := make([]producerStats, 0, len(l.producers))
+	for _, ps := range l.producers {
+		producers = append(producers, *ps)
+	}
+	sort.Slice(producers, func(i, j int) bool {
+		if producers[i].Timeouts != producers[j].Timeouts {
+			return producers[i].Timeouts > producers[j].Timeouts
+		}
+		if producers[i].Slow != producers[j].Slow {
+			return producers[i].Slow > producers[j].Slow
+		}
+		return producers[i].Requests > producers[j].Requests
+	})
+	if len(producers) > 50 {
+		producers = producers[:50]
+	}
+	within := 100.0
+	if l.total.count > 0 {
+		within = math.Round(float64(l.withinSLO)/float64(l.total.count)*10000) / 100
+	}
+	return map[string]any{
+		"slo_ms":             l.slo.Milliseconds(),
+		"requests":           l.total.count,
+		"within_slo_pct":     within,
+		"body_read_timeouts": l.timeouts,
+		"total":              l.total.summary(),
+		"phases":             phases,
+		"producers":          producers,
+	}
+}
+
+func (h *Handler) AdminIngestLatency(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, h.latency.report())
+}
+
+func (l *ingestLatency) points() []otlpPoint {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	points := make([]otlpPoint, 0, len(ingestPhases)+3)
+	for _, name := range ingestPhases {
+		points = append(points, otlpPoint{"tracelite.collector.ingest.latency." + name + ".p95", "95th percentile of recent ingest time spent in the " + name + " phase.", "ms", false, l.phases[name].summary().P95Ms})
+	}
+	within := 1.0
+	if l.total.count > 0 {
+		within = float64(l.withinSLO) / float64(l.total.count)
+	}
+	return append(points,
+		otlpPoint{"tracelite.collector.ingest.latency.p95", "95th percentile of recent ingest request latency.", "ms", false, l.total.summary().P95Ms},
+		otlpPoint{"tracelite.collector.ingest.slo.within", "Share of ingest requests that finished within INGEST_SLO.", "1", false, within},
+		otlpPoint{"tracelite.collector.ingest.body_read_timeouts", "Ingest requests whose body read timed out.", "{request}", true, float64(l.timeouts)},
+	)
+}
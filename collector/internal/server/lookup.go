@@ -0,0 +1,38 @@
+package server
+
+import (
+	"strings"
+
+	"trace-lite/collector/internal/model"
+)
+
+func lookupRows(rows []model.RawLogRow, keys []string) []model.LookupRow {
+	if len(keys) == 0 {
+		return nil
+	}
+	type seenKey struct{ key, value, traceID string }
+	seen := map[seenKey]struct{}{}
+	var out []model.LookupRow
+	for _, row := range rows {
+		for _, k := range keys {
+			v := strings.TrimSpace(row.Attrs[k])
+			if v == "" || len(v) > 256 {
+				continue
+			}
+			sk := seenKey{k, v, row.TraceID}
+			if _, ok := seen[sk]; ok {
+				continue
+			}
+			seen[sk] = struct{}{}
+			out = append(out, model.LookupRow{
+				TS:      row.TS,
+				Key:     k,
+				Value:   v,
+				TraceID: row.TraceID,
+				Service: row.Service,
+				Env:     row.Env,
+			})
+		}
+	}
+	return out
+}
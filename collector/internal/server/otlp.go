@@ -0,0 +1,173 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"trace-lite/collector/internal/spool"
+)
+
+type ingestStats struct {
+	accepted atomic.Int64
+	rejected atomic.Int64
+}
+
+type otlpPoint struct {
+	name    string
+	desc    string
+	unit    string
+	counter bool
+	value   float64
+}
+
+func (h *Handler) RunOTLPExport(ctx context.Context, endpoint string, headers map[string]string, every time.Duration) {
+	if endpoint == "" {
+		return
+	}
+	started := time.Now()
+	client := &http.Client{Timeout: 10 * time.Second}
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	log.Printf("exporting self-monitoring metrics to %s every %s", endpoint, every)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		payload, err := otlpPayload(h.selfMetrics(), started, time.Now())
+		if err == nil {
+			err = postOTLP(ctx, client, endpoint, headers, payload)
+		}
+		if err != nil {
+			log.Printf("otlp export: %v", err)
+		}
+	}
+}
+
+func (h *Handler) selfMetrics() []otlpPoint {
+	h.drops.mu.Lock()
+	dropped := h.drops.total
+	h.drops.mu.Unlock()
+	points := []otlpPoint{
+		{"tracelite.collector.events.accepted", "Events persisted by the ingest endpoints.", "{event}", true, float64(h.stats.accepted.Load())},
+		{"tracelite.collector.events.rejected", "Events rejected by the ingest endpoints.", "{event}", true, float64(h.stats.rejected.Load())},
+		{"tracelite.collector.events.dropped", "Events discarded by drop rules.", "{event}", true, float64(dropped)},
+	}
+	if ns := h.lastPersist.Load(); ns > 0 {
+		points = append(points, otlpPoint{"tracelite.collector.persist.age", "Time since the last successful persist.", "s", false, time.Since(time.Unix(0, ns)).Seconds()})
+	}
+	points = append(points, h.latency.points()...)
+	if h.lanes != nil {
+		points = append(points,
+			otlpPoint{"tracelite.collector.lanes.shed.bulk", "Bulk batches refused because the collector was saturated.", "{batch}", true, float64(h.lanes.shedBulk.Load())},
+			otlpPoint{"tracelite.collector.lanes.shed.priority", "Priority batches refused because the collector was saturated.", "{batch}", true, float64(h.lanes.shedPriority.Load())},
+			otlpPoint{"tracelite.collector.lanes.inflight", "Ingest requests holding a lane slot.", "{request}", false, float64(len(h.lanes.slots))},
+		)
+	}
+	if h.shedder != nil {
+		pressure, shed := h.shedder.snapshot()
+		points = append(points, otlpPoint{"tracelite.collector.shed.pressure", "Smoothed ingest lane utilisation that drives load shedding.", "1", false, pressure})
+		for _, level := range []string{"debug", "info", "warn"} {
+			points = append(points, otlpPoint{"tracelite.collector.shed." + level, "Events shed at level " + level + " under load.", "{event}", true, float64(shed[level])})
+		}
+	}
+	if h.relay != nil {
+		points = append(points, spoolPoints("tracelite.collector.relay", h.relay.Stats())...)
+	}
+	if h.overflow != nil {
+		points = append(points, spoolPoints("tracelite.collector.overflow", h.overflow.Stats())...)
+	}
+	if h.reconstruct {
+		st := h.recon.Status()
+		points = append(points, otlpPoint{"tracelite.reconstructor.traces", "Traces held in memory by the reconstructor.", "{trace}", false, float64(st.InMemory)})
+		if !st.LastFlush.IsZero() {
+			points = append(points, otlpPoint{"tracelite.reconstructor.flush.age", "Time since the last successful flush.", "s", false, time.Since(st.LastFlush).Seconds()})
+		}
+	}
+	return points
+}
+
+func spoolPoints(prefix string, st spool.Stats) []otlpPoint {
+	return []otlpPoint{
+		{prefix + ".spool.bytes", "Bytes waiting in the spool.", "By", false, float64(st.Bytes)},
+		{prefix + ".spool.batches", "Batches waiting in the spool.", "{batch}", false, float64(st.Files)},
+		{prefix + ".spool.age", "Age of the oldest spooled batch.", "s", false, st.OldestAge},
+		{prefix + ".spool.sent", "Spooled batches stored or sent.", "{batch}", true, float64(st.Sent)},
+		{prefix + ".spool.failures", "Failed attempts to store or send a spooled batch.", "{attempt}", true, float64(st.Failed)},
+		{prefix + ".spool.full", "Batches refused because the spool was full.", "{batch}", true, float64(st.Rejected)},
+		{prefix + ".spool.expired", "Spooled batches discarded for age.", "{batch}", true, float64(st.Expired)},
+	}
+}
+
+func otlpPayload(points []otlpPoint, start, now time.Time) ([]byte, error) {
+	startNano := strconv.FormatInt(start.UnixNano(), 10)
+	nowNano := strconv.FormatInt(now.UnixNano(), 10)
+	metrics := make([]map[string]any, 0, len(points))
+	for _, p := range points {
+		m := map[string]any{"name": p.name, "description": p.desc, "unit": p.unit}
+		if p.counter {
+			m["sum"] = map[string]any{
+				"aggregationTemporality": 2,
+				"isMonotonic":            true,
+				"dataPoints": []map[string]any{{
+					"startTimeUnixNano": startNano,
+					"timeUnixNano":      nowNano,
+					"asInt":             strconv.FormatInt(int64(p.value), 10),
+				}},
+			}
+		} else {
+			m["gauge"] = map[string]any{
+				"dataPoints": []map[string]any{{"timeUnixNano": nowNano, "asDouble": p.value}},
+			}
+		}
+		metrics = append(metrics, m)
+	}
+	return json.Marshal(map[string]any{
+		"resourceMetrics": []map[string]any{{
+			"resource": map[string]any{"attributes": []map[string]any{
+				{"key": "service.name", "value": map[string]any{"stringValue": "trace-lite-collector"}},
+				{"key": "host.name", "value": map[string]any{"stringValue": hostName()}},
+			}},
+			"scopeMetrics": []map[string]any{{
+				"scope":   map[string]any{"name": "trace-lite"},
+				"metrics": metrics,
+			}},
+		}},
+	})
+}
+
+func postOTLP(ctx context.Context, client *http.Client, endpoint string, headers map[string]string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/v1/metrics", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 2048))
+		return fmt.Errorf("%s (%s)", resp.Status, string(body))
+	}
+	return nil
+}
+
+func hostName() string {
+	name, _ := os.Hostname()
+	return name
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"trace-lite/collector/internal/relay"
+	"trace-lite/collector/internal/spool"
+)
+
+func (h *Handler) SetOverflow(s *spool.Spool) {
+	h.overflow = s
+}
+
+func (h *Handler) persistBatch(ctx context.Context, b spool.Batch, times []time.Time) error {
+	b.Rows = h.sealRows(b.Rows)
+	if h.relay != nil {
+		for _, part := range relay.Split(b, relay.MaxBatchBytes) {
+			if err := h.relay.Append(part); err != nil {
+				return err
+			}
+		}
+		h.lastPersist.Store(time.Now().UnixNano())
+		return nil
+	}
+	err := h.storeBatch(ctx, b, times)
+	if err == nil || h.overflow == nil {
+		return err
+	}
+	if serr := h.overflow.Append(b); serr != nil {
+		log.Printf("overflow spool: %v", serr)
+		return err
+	}
+	log.Printf("ingest persist failed, batch %s spooled for retry: %v", b.BatchID, err)
+	return nil
+}
+
+func (h *Handler) StoreBatch(ctx context.Context, b spool.Batch) error {
+	return h.storeBatch(ctx, b, rowTimes(b.Rows))
+}
+
+func (h *Handler) storeBatch(ctx context.Context, b spool.Batch, times []time.Time) error {
+	if len(b.Rows) > 0 && h.stream != nil {
+		if _, err := h.stream.Append(ctx, b.BatchID, b.Rows); err != nil {
+			return err
+		}
+		h.lastPersist.Store(time.Now().UnixNano())
+	} else if len(b.Rows) > 0 {
+		if err := h.Store(ctx, b.BatchID, b.Rows, times); err != nil {
+			return err
+		}
+	}
+	if len(b.Heartbeats) > 0 {
+		if err := h.ch.InsertJSONEachRowDedup(ctx, "service_heartbeats", b.Heartbeats, b.BatchID); err != nil {
+			return err
+		}
+	}
+	if len(b.Links) > 0 {
+		if err := h.ch.InsertJSONEachRowDedup(ctx, "trace_links", b.Links, b.BatchID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
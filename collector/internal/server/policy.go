@@ -0,0 +1,97 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"trace-lite/collector/internal/config"
+	"trace-lite/collector/internal/model"
+)
+
+func (h *Handler) SetTokens(tokens []config.TokenPolicy, adminToken string) {
+	h.authMu.Lock()
+	defer h.authMu.Unlock()
+	h.tokens, h.adminToken = tokens, adminToken
+}
+
+func (h *Handler) currentTokens() ([]config.TokenPolicy, string) {
+	h.authMu.RLock()
+	defer h.authMu.RUnlock()
+	return h.tokens, h.adminToken
+}
+
+func (h *Handler) ingestPolicy(r *http.Request) (config.TokenPolicy, bool) {
+	tokens, _ := h.currentTokens()
+	required := false
+	for _, p := range tokens {
+		if p.Token != "" {
+			required = true
+			break
+		}
+	}
+	if !required {
+		if len(tokens) > 0 {
+			return tokens[0], true
+		}
+		return config.TokenPolicy{Name: "default", Trust: "client"}, true
+	}
+
+	parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return config.TokenPolicy{}, false
+	}
+	got := []byte(strings.TrimSpace(parts[1]))
+	for _, p := range tokens {
+		if p.Token != "" && subtle.ConstantTimeCompare(got, []byte(p.Token)) == 1 {
+			return p, true
+		}
+	}
+	return config.TokenPolicy{}, false
+}
+
+func applyTrustPolicy(events []model.IngestEvent, p config.TokenPolicy, clock *model.TimeSource) []string {
+	now := clock.Now()
+	reasons := make([]string, len(events))
+	for i := range events {
+		e := &events[i]
+		if e.IsHeartbeat() || e.IsLink() {
+			continue
+		}
+		raw := strings.TrimSpace(e.Timestamp)
+		if raw == "" {
+			continue
+		}
+		if p.Trust == "server" {
+			keepClientTS(e, raw)
+			e.Timestamp = ""
+			continue
+		}
+		ts, err := clock.Parse(raw)
+		if err != nil {
+			continue
+		}
+		if p.MaxSkew > 0 && ts.After(now.Add(p.MaxSkew)) {
+			keepClientTS(e, raw)
+			e.Timestamp = ""
+			continue
+		}
+		if p.MaxAge > 0 && ts.Before(now.Add(-p.MaxAge)) {
+			if p.Trust == "clamp" {
+				keepClientTS(e, raw)
+				e.Timestamp = ""
+				continue
+			}
+			reasons[i] = fmt.Sprintf("timestamp older than %s accepted for token %s", p.MaxAge, p.Name)
+		}
+	}
+	return reasons
+}
+
+func keepClientTS(e *model.IngestEvent, raw string) {
+	if e.Attrs == nil {
+		e.Attrs = map[string]string{}
+	}
+	e.Attrs["client_ts"] = raw
+}
@@ -0,0 +1,295 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"trace-lite/collector/internal/fieldcrypt"
+	"trace-lite/collector/internal/model"
+)
+
+var purgeAttr = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,64}$`)
+
+type purgeRecord struct {
+	ID            string   `json:"id"`
+	RequestedAt   string   `json:"requested_at"`
+	RequestedFrom string   `json:"requested_from"`
+	Attr          string   `json:"attr"`
+	ValueSHA256   string   `json:"value_sha256"`
+	Reason        string   `json:"reason"`
+	Traces        uint32   `json:"traces"`
+	Tables        []string `json:"tables"`
+	Status        string   `json:"status"`
+	Error         string   `json:"error"`
+}
+
+func (h *Handler) AdminPurge(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if h.authorizeAdmin(w, r) {
+			h.purgeHistory(w, r)
+		}
+		return
+	case http.MethodPost:
+		if !h.authorizeAdmin(w, r) {
+			return
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
+		return
+	}
+
+	q := r.URL.Query()
+	attr, value := strings.TrimSpace(q.Get("attr")), q.Get("value")
+	if !purgeAttr.MatchString(attr) {
+		writeError(w, http.StatusBadRequest, "invalid_request", "attr must be 1-64 characters from [A-Za-z0-9_.:-]", nil)
+		return
+	}
+	if value == "" || len(value) > 256 {
+		writeError(w, http.StatusBadRequest, "invalid_request", "value must be 1-256 bytes", nil)
+		return
+	}
+	sum := sha256.Sum256([]byte(value))
+	rec := purgeRecord{
+		ID:            newBatchID(),
+		RequestedAt:   model.FormatCHTime(time.Now().UTC()),
+		RequestedFrom: r.RemoteAddr,
+		Attr:          attr,
+		ValueSHA256:   hex.EncodeToString(sum[:]),
+		Reason:        q.Get("reason"),
+		Tables:        []string{},
+		Status:        "submitted",
+	}
+
+	ids, err := h.purgeTraceIDs(r.Context(), attr, value)
+	if err == nil {
+		rec.Traces = uint32(len(ids))
+		h.recon.Forget(ids)
+		rec.Tables, err = h.purgeRows(r.Context(), attr, value, ids)
+	}
+	if err != nil {
+		rec.Status, rec.Error = "failed", err.Error()
+	}
+	if aerr := h.ch.InsertJSONEachRow(r.Context(), "purge_audit", []purgeRecord{rec}); aerr != nil {
+		log.Printf("purge %s: audit record failed: %v", rec.ID, aerr)
+		if err == nil {
+			err = aerr
+		}
+	}
+	if err != nil {
+		log.Printf("purge %s: %v", rec.ID, err)
+		writeError(w, http.StatusBadGateway, "storage_failed", "purge failed, see purge id "+rec.ID, map[string]any{"id": rec.ID})
+		return
+	}
+	log.Printf("purge %s: %s subject in %d traces, deletes submitted for %s", rec.ID, attr, rec.Traces, strings.Join(rec.Tables, ", "))
+	writeJSON(w, http.StatusAccepted, rec)
+}
+
+func (h *Handler) purgeTraceIDs(ctx context.Context, attr, value string) ([]string, error) {
+	a, v := chString(attr), h.valueSet(attr, value)
+	query := fmt.Sprintf(`
+SELECT DISTINCT trace_id FROM (
+  SELECT trace_id FROM raw_logs WHERE attrs[%s] IN %s
+  UNION ALL
+  SELECT trace_id FROM attr_lookup WHERE key = %s AND value IN %s
+)
+WHERE trace_id != ''`, a, v, a, v)
+	var ids []string
+	err := h.ch.QueryEachRow(ctx, query, func(line []byte) error {
+		var row struct {
+			TraceID string `json:"trace_id"`
+		}
+		if err := json.Unmarshal(line, &row); err != nil {
+			return err
+		}
+		ids = append(ids, row.TraceID)
+		return nil
+	})
+	return ids, err
+}
+
+func (h *Handler) purgeRows(ctx context.Context, attr, value string, ids []string) ([]string, error) {
+	a, v := chString(attr), h.valueSet(attr, value)
+	traceIDs := ids
+	tables := []string{}
+	exec := func(table, cond string) error {
+		if err := h.ch.Exec(ctx, "ALTER TABLE "+table+" DELETE WHERE "+cond, nil); err != nil {
+			return fmt.Errorf("%s: %w", table, err)
+		}
+		for _, t := range tables {
+			if t == table {
+				return nil
+			}
+		}
+		tables = append(tables, table)
+		return nil
+	}
+	if err := exec("raw_logs", fmt.Sprintf("attrs[%s] IN %s", a, v)); err != nil {
+		return tables, err
+	}
+	if err := exec("attr_lookup", fmt.Sprintf("key = %s AND value IN %s", a, v)); err != nil {
+		return tables, err
+	}
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > 1000 {
+			n = 1000
+		}
+		quoted := make([]string, 0, n)
+		for _, id := range ids[:n] {
+			quoted = append(quoted, chString(id))
+		}
+		ids = ids[n:]
+		in := "(" + strings.Join(quoted, ",") + ")"
+		for _, step := range [][2]string{
+			{"raw_logs", "trace_id IN " + in},
+			{"spans", "trace_id IN " + in},
+			{"traces", "trace_id IN " + in},
+			{"trace_partials", "trace_id IN " + in},
+			{"trace_snapshots", "trace_id IN " + in},
+			{"attr_lookup", "trace_id IN " + in},
+			{"trace_links", "trace_id IN " + in + " OR linked_trace_id IN " + in},
+		} {
+			if err := exec(step[0], step[1]); err != nil {
+				return tables, err
+			}
+		}
+	}
+	conds, err := h.rejectedMatches(ctx, attr, value, traceIDs)
+	if err != nil {
+		return tables, fmt.Errorf("rejected_events: %w", err)
+	}
+	for _, cond := range conds {
+		if err := exec("rejected_events", cond); err != nil {
+			return tables, err
+		}
+	}
+	return tables, nil
+}
+
+func (h *Handler) rejectedMatches(ctx context.Context, attr, value string, ids []string) ([]string, error) {
+	quoted, _ := json.Marshal(value)
+	filters := []string{fmt.Sprintf("startsWith(payload, 'enc:v1:') OR position(payload, %s) > 0 OR position(payload, %s) > 0",
+		chString(value), chString(string(quoted[1:len(quoted)-1])))}
+	traces := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		traces[id] = true
+	}
+	for len(ids) > 0 {
+		n := min(len(ids), 1000)
+		list := make([]string, 0, n)
+		for _, id := range ids[:n] {
+			list = append(list, chString(id))
+		}
+		ids = ids[n:]
+		in := "(" + strings.Join(list, ",") + ")"
+		filters = append(filters, fmt.Sprintf("JSONExtractString(payload, 'correlationId') IN %s OR JSONExtractString(payload, 'traceId') IN %s OR JSONExtractString(payload, 'trace_id') IN %s", in, in, in))
+	}
+
+	seen := map[string]bool{}
+	var keys []string
+	for _, filter := range filters {
+		err := h.ch.QueryEachRow(ctx, "SELECT batch_id, line, payload FROM rejected_events WHERE "+filter, func(line []byte) error {
+			var row model.RejectedEventRow
+			if err := json.Unmarshal(line, &row); err != nil {
+				return err
+			}
+			key := fmt.Sprintf("(%s, %d)", chString(row.BatchID), row.Line)
+			if seen[key] {
+				return nil
+			}
+			plain := row.Payload
+			if fieldcrypt.IsSealed(plain) {
+				opened, err := h.crypt.Open("raw_json", plain)
+				if err != nil {
+					seen[key] = true
+					keys = append(keys, key)
+					return nil
+				}
+				plain = opened
+			}
+			if rejectedMatch(plain, attr, value, traces) {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	var conds []string
+	for len(keys) > 0 {
+		n := min(len(keys), 1000)
+		conds = append(conds, "(batch_id, line) IN ("+strings.Join(keys[:n], ",")+")")
+		keys = keys[n:]
+	}
+	return conds, nil
+}
+
+func rejectedMatch(payload, attr, value string, traces map[string]bool) bool {
+	var fields map[string]any
+	dec := json.NewDecoder(strings.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		k, _ := json.Marshal(attr)
+		v, _ := json.Marshal(value)
+		return strings.Contains(payload, string(k)+":"+string(v)) || strings.Contains(payload, string(k)+": "+string(v))
+	}
+	for _, k := range []string{"correlationId", "traceId", "trace_id"} {
+		if id, ok := fields[k].(string); ok && traces[id] {
+			return true
+		}
+	}
+	if attrString(fields[attr]) == value {
+		return true
+	}
+	attrs, _ := fields["attrs"].(map[string]any)
+	return attrString(attrs[attr]) == value
+}
+
+func attrString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
+
+func (h *Handler) purgeHistory(w http.ResponseWriter, r *http.Request) {
+	var out []json.RawMessage
+	err := h.ch.QueryEachRow(r.Context(), `
+SELECT id, requested_at, requested_from, attr, value_sha256, reason, traces, tables, status, error
+FROM purge_audit
+ORDER BY requested_at DESC
+LIMIT 100`, func(line []byte) error {
+		out = append(out, append(json.RawMessage(nil), line...))
+		return nil
+	})
+	if err != nil {
+		log.Printf("purge history: %v", err)
+		writeError(w, http.StatusServiceUnavailable, "storage_unavailable", "could not read purge history", nil)
+		return
+	}
+	if out == nil {
+		out = []json.RawMessage{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"purges": out})
+}
+
+func chString(v string) string {
+	return "'" + strings.ReplaceAll(strings.ReplaceAll(v, `\`, `\\`), `'`, `\'`) + "'"
+}
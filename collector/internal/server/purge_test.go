@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"trace-lite/collector/internal/clickhouse/clickhousetest"
+	"trace-lite/collector/internal/config"
+	"trace-lite/collector/internal/model"
+)
+
+func TestRejectedMatch(t *testing.T) {
+	traces := map[string]bool{"4bf92f3577b34da6a3ce929d0e0e4736": true}
+	cases := []struct {
+		name    string
+		payload string
+		want    bool
+	}{
+		{"attr equal", `{"service":"cart","attrs":{"user_id":"1"}}`, true},
+		{"attr longer", `{"service":"cart","attrs":{"user_id":"12"}}`, false},
+		{"other attr with the value", `{"service":"cart","attrs":{"order_id":"1"}}`, false},
+		{"value in message only", `{"service":"cart","message":"retry 1 of 3"}`, false},
+		{"numeric v2 attr", `{"kind":"log","attrs":{"user_id":1}}`, true},
+		{"top level field", `{"service":"cart","user_id":"1"}`, true},
+		{"v1 trace", `{"correlationId":"4bf92f3577b34da6a3ce929d0e0e4736","service":"cart"}`, true},
+		{"v2 trace", `{"traceId":"4bf92f3577b34da6a3ce929d0e0e4736","kind":"log"}`, true},
+		{"other trace", `{"correlationId":"00000000000000000000000000000001"}`, false},
+		{"unparseable with pair", `{"attrs":{"user_id":"1"`, true},
+		{"unparseable with longer value", `{"attrs":{"user_id":"12"`, false},
+		{"unparseable without attr", `not json 1`, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := rejectedMatch(tc.payload, "user_id", "1", traces); got != tc.want {
+				t.Fatalf("rejectedMatch(%s) = %v, want %v", tc.payload, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAdminPurgeRefusesBadRequests(t *testing.T) {
+	cases := []struct {
+		name   string
+		admin  string
+		auth   string
+		target string
+		want   int
+	}{
+		{"admin token unset", "", "Bearer admin", "/v1/admin/purge?attr=user_id&value=1", http.StatusForbidden},
+		{"no bearer", "admin", "", "/v1/admin/purge?attr=user_id&value=1", http.StatusUnauthorized},
+		{"wrong bearer", "admin", "Bearer nope", "/v1/admin/purge?attr=user_id&value=1", http.StatusUnauthorized},
+		{"attr with a quote", "admin", "Bearer admin", "/v1/admin/purge?attr=user'id&value=1", http.StatusBadRequest},
+		{"attr with a space", "admin", "Bearer admin", "/v1/admin/purge?attr=user+id&value=1", http.StatusBadRequest},
+		{"empty value", "admin", "Bearer admin", "/v1/admin/purge?attr=user_id&value=", http.StatusBadRequest},
+		{"value too long", "admin", "Bearer admin", "/v1/admin/purge?attr=user_id&value=" + strings.Repeat("x", 257), http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := clickhousetest.New()
+			h := testHandler(config.Config{AdminToken: tc.admin}, fake)
+			req := httptest.NewRequest(http.MethodPost, tc.target, nil)
+			if tc.auth != "" {
+				req.Header.Set("Authorization", tc.auth)
+			}
+			rec := httptest.NewRecorder()
+			h.AdminPurge(rec, req)
+			if rec.Code != tc.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tc.want, rec.Body)
+			}
+			if touched(fake) {
+				t.Fatal("a refused purge reached ClickHouse")
+			}
+		})
+	}
+}
+
+func TestAdminPurgeDeletesTracesAndRejectedLines(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	fake := clickhousetest.New().
+		On("FROM rejected_events WHERE",
+			model.RejectedEventRow{BatchID: "b1", Line: 1, Payload: `{"service":"cart","attrs":{"user_id":"1"}}`},
+			model.RejectedEventRow{BatchID: "b1", Line: 2, Payload: `{"service":"cart","message":"retry 1 of 3"}`},
+			model.RejectedEventRow{BatchID: "b1", Line: 3, Payload: `{"correlationId":"` + traceID + `","service":"cart"}`},
+		).
+		On("SELECT DISTINCT trace_id", map[string]string{"trace_id": traceID})
+	h := testHandler(config.Config{AdminToken: "admin"}, fake)
+	req := httptest.NewRequest(http.MethodPost, "/v1/admin/purge?attr=user_id&value=1&reason=gdpr", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	rec := httptest.NewRecorder()
+	h.AdminPurge(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	want := []string{
+		"ALTER TABLE raw_logs DELETE WHERE attrs['user_id'] IN ('1')",
+		"ALTER TABLE attr_lookup DELETE WHERE key = 'user_id' AND value IN ('1')",
+		"ALTER TABLE spans DELETE WHERE trace_id IN ('" + traceID + "')",
+		"ALTER TABLE trace_links DELETE WHERE trace_id IN ('" + traceID + "') OR linked_trace_id IN ('" + traceID + "')",
+		"ALTER TABLE rejected_events DELETE WHERE (batch_id, line) IN (('b1', 1),('b1', 3))",
+	}
+	execs := strings.Join(executed(fake), "\n")
+	for _, stmt := range want {
+		if !strings.Contains(execs, stmt) {
+			t.Errorf("missing statement %q in:\n%s", stmt, execs)
+		}
+	}
+
+	audit, _ := inserted(t, fake, "purge_audit")
+	if len(audit) != 1 {
+		t.Fatalf("purge_audit rows = %d, want 1", len(audit))
+	}
+	var rec0 purgeRecord
+	if err := json.Unmarshal([]byte(audit[0]), &rec0); err != nil {
+		t.Fatal(err)
+	}
+	if rec0.Status != "submitted" || rec0.Traces != 1 || rec0.Reason != "gdpr" || rec0.Error != "" {
+		t.Fatalf("audit record %+v", rec0)
+	}
+	if strings.Contains(audit[0], `"1"`) || rec0.ValueSHA256 == "" {
+		t.Fatalf("audit record stores the purged value: %s", audit[0])
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"trace-lite/collector/internal/model"
+)
+
+const maxRejectedPayload = 16 * 1024
+
+func (h *Handler) archiveRejected(r *http.Request, batchID, token, version string, errs []ingestError) {
+	if !h.archive || len(errs) == 0 || dryRun(r) {
+		return
+	}
+	now := model.FormatCHTime(time.Now().UTC())
+	rows := make([]model.RejectedEventRow, 0, len(errs))
+	for _, e := range errs {
+		row := model.RejectedEventRow{
+			TS:       now,
+			BatchID:  batchID,
+			Token:    token,
+			Endpoint: r.URL.Path,
+			Version:  version,
+			VHost:    serverName(r),
+			Client:   clientIP(r),
+			Line:     uint32(e.Line),
+			Reason:   e.Reason,
+			Payload:  e.payload,
+		}
+		if len(row.Payload) > maxRejectedPayload {
+			row.Payload = strings.ToValidUTF8(row.Payload[:maxRejectedPayload], "")
+			row.Truncated = 1
+		}
+		if h.encryptRaw && h.crypt != nil {
+			row.Payload = h.crypt.Seal("raw_json", row.Payload)
+		}
+		rows = append(rows, row)
+	}
+	if err := h.ch.InsertJSONEachRowDedup(r.Context(), "rejected_events", rows, batchID); err != nil {
+		log.Printf("archive %d rejected events of batch %s: %v", len(rows), batchID, err)
+	}
+}
+
+func (h *Handler) AdminRejected(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
+		return
+	}
+	q := r.URL.Query()
+	where, from, to, ok := rejectedFilter(w, q)
+	if !ok {
+		return
+	}
+	limit := 100
+	if v, err := strconv.Atoi(q.Get("limit")); err == nil && v > 0 {
+		limit = min(v, 1000)
+	}
+	query := fmt.Sprintf(`
+SELECT ts, batch_id, token, endpoint, version, vhost, client, line, reason, payload, truncated
+FROM rejected_events
+WHERE %s
+ORDER BY ts DESC, line ASC
+LIMIT %d`, strings.Join(where, " AND "), limit)
+
+	out := []json.RawMessage{}
+	err := h.ch.QueryEachRow(r.Context(), query, func(line []byte) error {
+		out = append(out, append(json.RawMessage(nil), line...))
+		return nil
+	})
+	if err != nil {
+		log.Printf("rejected events: %v", err)
+		writeError(w, http.StatusServiceUnavailable, "storage_unavailable", "could not read rejected events", nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"from":   from.Format(time.RFC3339),
+		"to":     to.Format(time.RFC3339),
+		"events": out,
+	})
+}
+
+func rejectedFilter(w http.ResponseWriter, q url.Values) ([]string, time.Time, time.Time, bool) {
+	to := time.Now().UTC()
+	from := to.Add(-24 * time.Hour)
+	for _, p := range []struct {
+		name string
+		into *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		if v := q.Get(p.name); v != "" {
+			ts, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid_request", p.name+" must be RFC3339", nil)
+				return nil, from, to, false
+			}
+			*p.into = ts.UTC()
+		}
+	}
+	where := []string{
+		fmt.Sprintf("ts >= toDateTime64(%s, 3, 'UTC')", chString(model.FormatCHTime(from))),
+		fmt.Sprintf("ts < toDateTime64(%s, 3, 'UTC')", chString(model.FormatCHTime(to))),
+	}
+	if v := q.Get("token"); v != "" {
+		where = append(where, "token = "+chString(v))
+	}
+	if v := q.Get("batch_id"); v != "" {
+		where = append(where, "batch_id = "+chString(v))
+	}
+	if v := q.Get("reason"); v != "" {
+		where = append(where, "positionCaseInsensitive(reason, "+chString(v)+") > 0")
+	}
+	return where, from, to, true
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"trace-lite/collector/internal/model"
+	"trace-lite/collector/internal/spool"
+)
+
+const maxRelayBody = 64 << 20
+
+func (h *Handler) SetRelay(s *spool.Spool) {
+	h.relay = s
+}
+
+func (h *Handler) SetRelayAcceptToken(token string) {
+	h.relayToken = token
+}
+
+func (h *Handler) RelayBatches(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
+		return
+	}
+	if h.relayToken == "" {
+		writeError(w, http.StatusNotFound, "not_found", "relay intake is disabled on this collector", nil)
+		return
+	}
+	if !validBearer(r.Header.Get("Authorization"), h.relayToken) {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "missing or invalid bearer token", nil)
+		return
+	}
+	reader, err := maybeGzipReader(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_gzip", "request body is not valid gzip", nil)
+		return
+	}
+	defer reader.Close()
+	var b spool.Batch
+	if err := json.NewDecoder(http.MaxBytesReader(w, reader, maxRelayBody)).Decode(&b); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "payload_too_large", "relay batch exceeds the body limit", nil)
+			return
+		}
+		writeError(w, http.StatusBadRequest, "invalid_request", "body must be a relay batch", nil)
+		return
+	}
+	if b.BatchID == "" || !batchIDPattern.MatchString(b.BatchID) {
+		writeError(w, http.StatusBadRequest, "invalid_batch_id", "relay batch needs a valid batch_id", nil)
+		return
+	}
+	if b.Len() > 0 {
+		release, ok := h.enterLane(w, r, b.Rows)
+		if !ok {
+			return
+		}
+		defer release()
+		if err := h.persistBatch(r.Context(), b, rowTimes(b.Rows)); err != nil {
+			h.unavailable(w, err)
+			return
+		}
+	}
+	h.stats.accepted.Add(int64(b.Len()))
+	writeJSON(w, http.StatusOK, map[string]any{"batch_id": b.BatchID, "accepted": b.Len()})
+}
+
+func rowTimes(rows []model.RawLogRow) []time.Time {
+	times := make([]time.Time, len(rows))
+	for i := range rows {
+		ts, err := model.ParseCHTime(rows[i].TS)
+		if err != nil {
+			ts = time.Now().UTC()
+		}
+		times[i] = ts
+	}
+	return times
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"trace-lite/collector/internal/clickhouse/clickhousetest"
+	"trace-lite/collector/internal/config"
+	"trace-lite/collector/internal/model"
+	"trace-lite/collector/internal/relay"
+	"trace-lite/collector/internal/spool"
+)
+
+func TestRelayBatches(t *testing.T) {
+	row := model.RawLogRow{TS: model.FormatCHTime(time.Now().UTC()), Service: "cart", Env: "prod", TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Event: "end"}
+	cases := []struct {
+		name   string
+		accept string
+		auth   string
+		batch  spool.Batch
+		want   int
+	}{
+		{"intake disabled", "", "Bearer relay", spool.Batch{BatchID: "relay-1", Rows: []model.RawLogRow{row}}, http.StatusNotFound},
+		{"no bearer", "relay", "", spool.Batch{BatchID: "relay-1", Rows: []model.RawLogRow{row}}, http.StatusUnauthorized},
+		{"ingest token", "relay", "Bearer ingest", spool.Batch{BatchID: "relay-1", Rows: []model.RawLogRow{row}}, http.StatusUnauthorized},
+		{"missing batch id", "relay", "Bearer relay", spool.Batch{Rows: []model.RawLogRow{row}}, http.StatusBadRequest},
+		{"invalid batch id", "relay", "Bearer relay", spool.Batch{BatchID: "relay 1'", Rows: []model.RawLogRow{row}}, http.StatusBadRequest},
+		{"empty batch", "relay", "Bearer relay", spool.Batch{BatchID: "relay-1"}, http.StatusOK},
+		{"stored", "relay", "Bearer relay", spool.Batch{BatchID: "relay-1", Rows: []model.RawLogRow{row}}, http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := clickhousetest.New()
+			h := testHandler(config.Config{IngestTokens: []config.TokenPolicy{{Name: "default", Token: "ingest"}}}, fake)
+			h.SetRelayAcceptToken(tc.accept)
+			var body bytes.Buffer
+			gz := gzip.NewWriter(&body)
+			if err := json.NewEncoder(gz).Encode(tc.batch); err != nil {
+				t.Fatal(err)
+			}
+			gz.Close()
+			req := httptest.NewRequest(http.MethodPost, "/v1/relay/batches", &body)
+			req.Header.Set("Content-Encoding", "gzip")
+			if tc.auth != "" {
+				req.Header.Set("Authorization", tc.auth)
+			}
+			rec := httptest.NewRecorder()
+			h.RelayBatches(rec, req)
+			if rec.Code != tc.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tc.want, rec.Body)
+			}
+			rows, token := inserted(t, fake, "raw_logs")
+			wantRows := 0
+			if tc.want == http.StatusOK {
+				wantRows = len(tc.batch.Rows)
+			}
+			if len(rows) != wantRows {
+				t.Fatalf("raw_logs got %d rows, want %d", len(rows), wantRows)
+			}
+			if len(rows) > 0 && token != tc.batch.BatchID {
+				t.Fatalf("raw_logs dedup token %q, want the relay batch id %q", token, tc.batch.BatchID)
+			}
+		})
+	}
+}
+
+func TestRelayBatchesRejectsOversizedBody(t *testing.T) {
+	fake := clickhousetest.New()
+	h := testHandler(config.Config{}, fake)
+	h.SetRelayAcceptToken("relay")
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	gz.Write([]byte(`{"batch_id":"relay-1","rows":[`))
+	gz.Write([]byte(strings.Repeat(" ", maxRelayBody)))
+	gz.Write([]byte(`]}`))
+	gz.Close()
+	req := httptest.NewRequest(http.MethodPost, "/v1/relay/batches", &body)
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Authorization", "Bearer relay")
+	rec := httptest.NewRecorder()
+	h.RelayBatches(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusRequestEntityTooLarge, rec.Body)
+	}
+	if touched(fake) {
+		t.Fatal("oversized relay batch reached clickhouse")
+	}
+}
+
+func TestRelayFullIngestArrivesInParts(t *testing.T) {
+	sp, err := spool.Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edge := testHandler(config.Config{IngestTokens: []config.TokenPolicy{{Name: "default", Token: "ingest", Trust: "client"}}}, clickhousetest.New())
+	edge.SetRelay(sp)
+
+	fake := clickhousetest.New()
+	central := testHandler(config.Config{}, fake)
+	central.SetRelayAcceptToken("relay")
+	srv := httptest.NewServer(http.HandlerFunc(central.RelayBatches))
+	defer srv.Close()
+
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	var body bytes.Buffer
+	events := 0
+	for {
+		line := fmt.Sprintf(`{"timestamp":"%s","service":"cart","correlationId":"%032x","event":"log","message":"m"}`+"\n", now, events)
+		if body.Len()+len(line) > 20*1024*1024 {
+			break
+		}
+		body.WriteString(line)
+		events++
+	}
+	req := httptest.NewRequest(http.MethodPost, "/v1/ingest/logs", &body)
+	req.Header.Set("Authorization", "Bearer ingest")
+	req.Header.Set("X-Batch-Id", "full-1")
+	rec := httptest.NewRecorder()
+	edge.IngestLogs(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ingest status = %d: %s", rec.Code, rec.Body)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sp.Drain(ctx, time.Second, relay.New(srv.URL, "relay").Send)
+	for deadline := time.Now().Add(time.Minute); sp.Pending() > 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d relay batches still spooled: %+v", sp.Pending(), sp.Stats())
+		}
+	}
+	if st := sp.Stats(); st.Failed != 0 || st.Sent < 2 {
+		t.Fatalf("spool stats %+v, want the batch sent in several parts without failures", st)
+	}
+	stored := 0
+	for _, ins := range fake.Inserts() {
+		if ins.Table != "raw_logs" {
+			continue
+		}
+		if !strings.HasPrefix(ins.Token, "full-1.") {
+			t.Fatalf("raw_logs dedup token %q, want a part of full-1", ins.Token)
+		}
+		stored += len(ins.Rows)
+	}
+	if stored != events {
+		t.Fatalf("central stored %d of %d relayed rows", stored, events)
+	}
+}
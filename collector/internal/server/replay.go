@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"trace-lite/collector/internal/config"
+	"trace-lite/collector/internal/fieldcrypt"
+	"trace-lite/collector/internal/model"
+	"trace-lite/collector/internal/spool"
+)
+
+type replayError struct {
+	BatchID string `json:"batch_id"`
+	Line    uint32 `json:"line"`
+	Reason  string `json:"reason"`
+}
+
+type replayResult struct {
+	DryRun        bool          `json:"dry_run"`
+	Matched       int           `json:"matched"`
+	Replayed      int           `json:"replayed"`
+	Accepted      int           `json:"accepted"`
+	Dropped       int           `json:"dropped"`
+	StillRejected int           `json:"still_rejected"`
+	Skipped       int           `json:"skipped"`
+	Errors        []replayError `json:"errors"`
+}
+
+func (res *replayResult) fail(row model.RejectedEventRow, reason string) {
+	res.StillRejected++
+	if len(res.Errors) < 100 {
+		res.Errors = append(res.Errors, replayError{BatchID: row.BatchID, Line: row.Line, Reason: reason})
+	}
+}
+
+func (h *Handler) AdminReplayRejected(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
+		return
+	}
+	q := r.URL.Query()
+	where, _, _, ok := rejectedFilter(w, q)
+	if !ok {
+		return
+	}
+	limit := 1000
+	if v, err := strconv.Atoi(q.Get("limit")); err == nil && v > 0 {
+		limit = min(v, 10000)
+	}
+	trust := q.Get("trust")
+	switch trust {
+	case "", "client", "clamp", "server":
+	default:
+		writeError(w, http.StatusBadRequest, "invalid_request", "trust must be client, clamp or server", nil)
+		return
+	}
+
+	src, err := h.rejectedRows(r.Context(), where, limit)
+	if err != nil {
+		log.Printf("replay rejected events: %v", err)
+		writeError(w, http.StatusServiceUnavailable, "storage_unavailable", "could not read rejected events", nil)
+		return
+	}
+	res := replayResult{DryRun: dryRun(r), Matched: len(src), Errors: []replayError{}}
+	type groupKey struct{ token, version, vhost string }
+	groups := map[groupKey][]model.RejectedEventRow{}
+	var order []groupKey
+	for _, row := range src {
+		if row.Truncated == 1 {
+			res.Skipped++
+			continue
+		}
+		if fieldcrypt.IsSealed(row.Payload) {
+			plain, err := h.crypt.Open("raw_json", row.Payload)
+			if err != nil {
+				res.Skipped++
+				continue
+			}
+			row.Payload = plain
+		}
+		k := groupKey{row.Token, row.Version, row.VHost}
+		if _, ok := groups[k]; !ok {
+			order = append(order, k)
+		}
+		groups[k] = append(groups[k], row)
+	}
+
+	var total mappedBatch
+	var done []model.RejectedEventRow
+	now := time.Now().UTC()
+	for _, k := range order {
+		decode, ok := decoders[k.version]
+		if !ok {
+			decode = decoders["1"]
+		}
+		policy, ok := h.policyNamed(k.token)
+		if !ok {
+			for _, row := range groups[k] {
+				res.fail(row, fmt.Sprintf("token %q no longer exists", k.token))
+			}
+			continue
+		}
+		if trust != "" {
+			policy.Trust = trust
+		}
+		var events []model.IngestEvent
+		var raws []string
+		var rows []model.RejectedEventRow
+		for _, row := range groups[k] {
+			e, err := decode([]byte(row.Payload))
+			if err != nil {
+				res.fail(row, err.Error())
+				continue
+			}
+			events, raws, rows = append(events, e), append(raws, row.Payload), append(rows, row)
+		}
+		h.correlator.Resolve(events, raws, !res.DryRun)
+		h.applyVHost(k.vhost, events)
+		for i := range events {
+			var one mappedBatch
+			h.mapEvents(&one, events[i:i+1], raws[i:i+1], policy, now)
+			if one.rejected > 0 {
+				res.fail(rows[i], one.errors[0].Reason)
+				continue
+			}
+			total.rows = append(total.rows, one.rows...)
+			total.times = append(total.times, one.times...)
+			total.heartbeats = append(total.heartbeats, one.heartbeats...)
+			total.links = append(total.links, one.links...)
+			total.dropped = append(total.dropped, one.dropped...)
+			done = append(done, rows[i])
+		}
+	}
+	res.Replayed = len(done)
+	res.Accepted = len(total.rows) + len(total.heartbeats) + len(total.links)
+	res.Dropped = len(total.dropped)
+	if res.DryRun || len(done) == 0 {
+		writeJSON(w, http.StatusOK, res)
+		return
+	}
+
+	batchID := replayBatchID(done)
+	out := spool.Batch{BatchID: batchID, Rows: total.rows, Heartbeats: total.heartbeats, Links: total.links}
+	if err := h.persistBatch(r.Context(), out, total.times); err != nil {
+		h.unavailable(w, err)
+		return
+	}
+	h.countDrops(total.dropped)
+	h.stats.accepted.Add(int64(res.Accepted))
+	if err := h.forgetRejected(r.Context(), done); err != nil {
+		log.Printf("replay %s: events stored but not removed from rejected_events: %v", batchID, err)
+	}
+	log.Printf("replay %s: %d of %d rejected events replayed, %d still rejected, %d skipped", batchID, res.Replayed, res.Matched, res.StillRejected, res.Skipped)
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) rejectedRows(ctx context.Context, where []string, limit int) ([]model.RejectedEventRow, error) {
+	query := fmt.Sprintf(`
+SELECT ts, batch_id, token, endpoint, version, vhost, client, line, reason, payload, truncated
+FROM rejected_events
+WHERE %s
+ORDER BY ts ASC, batch_id ASC, line ASC
+LIMIT %d`, strings.Join(where, " AND "), limit)
+	var out []model.RejectedEventRow
+	err := h.ch.QueryEachRow(ctx, query, func(line []byte) error {
+		var row model.RejectedEventRow
+		if err := json.Unmarshal(line, &row); err != nil {
+			return err
+		}
+		out = append(out, row)
+		return nil
+	})
+	return out, err
+}
+
+func (h *Handler) policyNamed(name string) (config.TokenPolicy, bool) {
+	tokens, _ := h.currentTokens()
+	for _, p := range tokens {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return config.TokenPolicy{}, false
+}
+
+func (h *Handler) forgetRejected(ctx context.Context, rows []model.RejectedEventRow) error {
+	for len(rows) > 0 {
+		n := min(len(rows), 500)
+		keys := make([]string, n)
+		for i, row := range rows[:n] {
+			keys[i] = fmt.Sprintf("(%s, %d)", chString(row.BatchID), row.Line)
+		}
+		if err := h.ch.Exec(ctx, "ALTER TABLE rejected_events DELETE WHERE (batch_id, line) IN ("+strings.Join(keys, ", ")+")", nil); err != nil {
+			return err
+		}
+		rows = rows[n:]
+	}
+	return nil
+}
+
+func replayBatchID(rows []model.RejectedEventRow) string {
+	sum := sha256.New()
+	for _, row := range rows {
+		fmt.Fprintf(sum, "%s:%d\n", row.BatchID, row.Line)
+	}
+	return "replay-" + hex.EncodeToString(sum.Sum(nil))[:32]
+}
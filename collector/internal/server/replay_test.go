@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"trace-lite/collector/internal/clickhouse/clickhousetest"
+	"trace-lite/collector/internal/config"
+	"trace-lite/collector/internal/model"
+)
+
+func TestAdminReplayRejected(t *testing.T) {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	valid := `{"timestamp":"` + now + `","service":"cart","correlationId":"4bf92f3577b34da6a3ce929d0e0e4736","spanId":"00f067aa0ba902b7","event":"end","durationMs":5}`
+	src := []model.RejectedEventRow{
+		{BatchID: "b1", Line: 1, Token: "default", Version: "1", VHost: "ingest.prod.example.com", Payload: valid},
+		{BatchID: "b1", Line: 2, Token: "gone", Version: "1", Payload: valid},
+		{BatchID: "b1", Line: 3, Token: "default", Version: "1", Payload: `{"service":`},
+		{BatchID: "b1", Line: 4, Token: "default", Version: "1", Payload: `{"service":"ca`, Truncated: 1},
+	}
+	cfg := config.Config{
+		AdminToken:   "admin",
+		IngestTokens: []config.TokenPolicy{{Name: "default", Trust: "client"}},
+		VHosts:       []config.VHost{{Host: "ingest.prod.example.com", Env: "prod", Tenant: "acme"}},
+	}
+	cases := []struct {
+		name   string
+		target string
+		stored bool
+	}{
+		{"dry run", "/v1/admin/ingest/rejected/replay?dry_run=1", false},
+		{"replay", "/v1/admin/ingest/rejected/replay", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			archived := make([]any, len(src))
+			for i, row := range src {
+				archived[i] = row
+			}
+			fake := clickhousetest.New().On("FROM rejected_events", archived...)
+			h := testHandler(cfg, fake)
+			req := httptest.NewRequest(http.MethodPost, tc.target, nil)
+			req.Header.Set("Authorization", "Bearer admin")
+			rec := httptest.NewRecorder()
+			h.AdminReplayRejected(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			var res replayResult
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Matched != 4 || res.Replayed != 1 || res.Accepted != 1 || res.StillRejected != 2 || res.Skipped != 1 {
+				t.Fatalf("result %+v", res)
+			}
+
+			rows, token := inserted(t, fake, "raw_logs")
+			execs := executed(fake)
+			if !tc.stored {
+				if len(rows) != 0 || len(execs) != 0 {
+					t.Fatalf("dry run wrote %d rows and ran %v", len(rows), execs)
+				}
+				return
+			}
+			if len(rows) != 1 || !strings.HasPrefix(token, "replay-") {
+				t.Fatalf("raw_logs got %d rows with token %q", len(rows), token)
+			}
+			var row model.RawLogRow
+			if err := json.Unmarshal([]byte(rows[0]), &row); err != nil {
+				t.Fatal(err)
+			}
+			if row.Env != "prod" || row.Attrs["tenant"] != "acme" {
+				t.Fatalf("replayed row env %q tenant %q, want the vhost defaults", row.Env, row.Attrs["tenant"])
+			}
+			if want := "ALTER TABLE rejected_events DELETE WHERE (batch_id, line) IN (('b1', 1))"; len(execs) != 1 || execs[0] != want {
+				t.Fatalf("execs %v, want %q", execs, want)
+			}
+		})
+	}
+}
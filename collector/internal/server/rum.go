@@ -0,0 +1,105 @@
+package server
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"trace-lite/collector/internal/model"
+	"trace-lite/collector/internal/spool"
+)
+
+const maxRUMEvents = 50
+
+func (h *Handler) IngestRUM(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if !h.rumOriginAllowed(origin) {
+		writeError(w, http.StatusForbidden, "origin_not_allowed", "origin not allowed", nil)
+		return
+	}
+	if origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Vary", "Origin")
+	}
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Max-Age", "86400")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
+		return
+	}
+
+	events, raws, parseErrs := parseEvents(io.LimitReader(r.Body, 64*1024), model.DecodeRUM)
+	if len(events) > maxRUMEvents {
+		events, raws = events[:maxRUMEvents], raws[:maxRUMEvents]
+	}
+	if !h.rumLimiter.Allow(clientIP(r), len(events)+len(parseErrs)) {
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusTooManyRequests, "rate_limited", "rate limited", nil)
+		return
+	}
+	if len(events) == 0 {
+		writeError(w, http.StatusBadRequest, "no_valid_events", "no valid events", nil)
+		return
+	}
+
+	rows := make([]model.RawLogRow, 0, len(events))
+	times := make([]time.Time, 0, len(events))
+	clock := model.NewTimeSource(time.Now(), nil)
+	for i := range events {
+		if h.rumEnv != "" && events[i].Env == "" {
+			events[i].Env = h.rumEnv
+		}
+		if err := h.ids.Apply(&events[i]); err != nil {
+			continue
+		}
+		if err := h.names.Apply(&events[i]); err != nil {
+			continue
+		}
+		row, ts, err := events[i].ToRaw(raws[i], clock)
+		if err != nil {
+			continue
+		}
+		rows = append(rows, row)
+		times = append(times, ts)
+	}
+	rows, times, _ = h.shedRows(w, rows, times)
+	if len(rows) > 0 {
+		release, ok := h.enterLane(w, r, rows)
+		if !ok {
+			return
+		}
+		defer release()
+		if err := h.persistBatch(r.Context(), spool.Batch{BatchID: newBatchID(), Rows: rows}, times); err != nil {
+			h.unavailable(w, err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) rumOriginAllowed(origin string) bool {
+	if len(h.rumOrigins) == 0 {
+		return false
+	}
+	for _, o := range h.rumOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"trace-lite/collector/internal/config"
+	"trace-lite/collector/internal/model"
+)
+
+type loadShedder struct {
+	stages   []config.ShedStage
+	window   time.Duration
+	mu       sync.Mutex
+	pressure float64
+	at       time.Time
+	shed     map[string]int64
+}
+
+func newLoadShedder(stages []config.ShedStage, window time.Duration) *loadShedder {
+	if len(stages) == 0 {
+		return nil
+	}
+	return &loadShedder{stages: stages, window: window, shed: map[string]int64{}}
+}
+
+func (s *loadShedder) observe(utilisation float64, now time.Time) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.at.IsZero() {
+		s.at = now
+	}
+	decay := math.Exp(-float64(now.Sub(s.at)) / float64(s.window))
+	s.pressure = s.pressure*decay + utilisation*(1-decay)
+	s.at = now
+	return s.pressure
+}
+
+func (s *loadShedder) keep(level string, pressure float64) (float64, bool) {
+	keep, active := 1.0, false
+	for _, st := range s.stages {
+		if st.Level == level && pressure >= st.At && st.Keep < keep {
+			keep, active = st.Keep, true
+		}
+	}
+	return keep, active
+}
+
+func (s *loadShedder) snapshot() (float64, map[string]int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]int64, len(s.shed))
+	for k, v := range s.shed {
+		out[k] = v
+	}
+	return s.pressure, out
+}
+
+func shedLevel(row model.RawLogRow) string {
+	switch strings.ToUpper(row.Level) {
+	case "DEBUG", "TRACE":
+		return "debug"
+	case "WARN", "WARNING":
+		return "warn"
+	case "", "INFO":
+		return "info"
+	}
+	return ""
+}
+
+func spanLifecycle(row model.RawLogRow) bool {
+	if row.DurationMs > 0 {
+		return true
+	}
+	switch row.Event {
+	case "start", "end", "span":
+		return true
+	}
+	return false
+}
+
+func sampled(traceID string, keep float64) bool {
+	h := fnv.New32a()
+	h.Write([]byte(traceID))
+	return float64(h.Sum32()%10000) < keep*10000
+}
+
+func (h *Handler) shedRows(w http.ResponseWriter, rows []model.RawLogRow, times []time.Time) ([]model.RawLogRow, []time.Time, int) {
+	if h.shedder == nil || len(rows) == 0 {
+		return rows, times, 0
+	}
+	utilisation := float64(len(h.lanes.slots)) / float64(cap(h.lanes.slots))
+	if h.overflow != nil && h.overflow.Pending() > 0 {
+		utilisation = 1
+	}
+	pressure := h.shedder.observe(utilisation, time.Now())
+	w.Header().Set("X-Ingest-Pressure", fmt.Sprintf("%.2f", pressure))
+
+	counts := map[string]int{}
+	keptRows, keptTimes := rows[:0], times[:0]
+	for i, row := range rows {
+		level := shedLevel(row)
+		if spanLifecycle(row) {
+			level = ""
+		}
+		if keep, active := h.shedder.keep(level, pressure); active && !h.priorityRows(rows[i:i+1]) && !sampled(row.TraceID, keep) {
+			counts[level]++
+			continue
+		}
+		keptRows = append(keptRows, row)
+		keptTimes = append(keptTimes, times[i])
+	}
+	if len(counts) == 0 {
+		return keptRows, keptTimes, 0
+	}
+	levels := make([]string, 0, len(counts))
+	total := 0
+	h.shedder.mu.Lock()
+	for level, n := range counts {
+		levels = append(levels, fmt.Sprintf("%s=%d", level, n))
+		h.shedder.shed[level] += int64(n)
+		total += n
+	}
+	h.shedder.mu.Unlock()
+	sort.Strings(levels)
+	w.Header().Set("X-Ingest-Shed", strings.Join(levels, ","))
+	return keptRows, keptTimes, total
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"trace-lite/collector/internal/config"
+	"trace-lite/collector/internal/model"
+)
+
+func TestSampled(t *testing.T) {
+	cases := []struct {
+		name    string
+		traceID string
+		keep    float64
+		want    bool
+	}{
+		{"keep none", "4bf92f3577b34da6a3ce929d0e0e4736", 0, false},
+		{"keep all", "4bf92f3577b34da6a3ce929d0e0e4736", 1, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := sampled(tc.traceID, tc.keep); got != tc.want {
+				t.Fatalf("sampled = %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	kept := 0
+	for i := range 1000 {
+		traceID := fmt.Sprintf("%032x", i)
+		if first := sampled(traceID, 0.5); first != sampled(traceID, 0.5) {
+			t.Fatalf("trace %d sampled apart", i)
+		} else if first {
+			kept++
+		}
+	}
+	if kept < 400 || kept > 600 {
+		t.Fatalf("kept %d of 1000 traces at 0.5, want 400-600", kept)
+	}
+}
+
+func TestShedRowsKeepsSpans(t *testing.T) {
+	h := testHandler(config.Config{IngestMaxInflight: 4, LoadShed: []config.ShedStage{{Level: "info", Keep: 0, At: 0}}, LoadShedWindow: time.Second}, nil)
+	rows := []model.RawLogRow{
+		{TraceID: "t1", Service: "cart", Level: "INFO", Event: "log", Message: "plain"},
+		{TraceID: "t1", Service: "browser", Level: "INFO", Event: "log", Message: "page_load", DurationMs: 420},
+		{TraceID: "t1", Service: "cart", Level: "INFO", Event: "log", Message: "timed", DurationMs: 12},
+		{TraceID: "t1", Service: "cart", Level: "INFO", Event: "end", DurationMs: 0},
+	}
+	times := make([]time.Time, len(rows))
+	rec := httptest.NewRecorder()
+	kept, _, shed := h.shedRows(rec, rows, times)
+	if shed != 1 || len(kept) != 3 {
+		t.Fatalf("shed %d, kept %d rows, want 1 shed and 3 kept", shed, len(kept))
+	}
+	for _, row := range kept {
+		if row.Message == "plain" {
+			t.Fatalf("plain info log was kept: %+v", kept)
+		}
+	}
+	if got := rec.Header().Get("X-Ingest-Shed"); got != "info=1" {
+		t.Fatalf("X-Ingest-Shed = %q, want info=1", got)
+	}
+}
@@ -0,0 +1,25 @@
+package server
+
+import "trace-lite/collector/internal/model"
+
+func usageRows(rows []model.RawLogRow) []model.UsageRow {
+	type usageKey struct{ day, env, service string }
+	agg := map[usageKey]*model.UsageRow{}
+	var order []usageKey
+	for _, row := range rows {
+		k := usageKey{day: model.Day(row.TS), env: row.Env, service: row.Service}
+		u := agg[k]
+		if u == nil {
+			u = &model.UsageRow{Day: k.day, Env: k.env, Service: k.service}
+			agg[k] = u
+			order = append(order, k)
+		}
+		u.Events++
+		u.Bytes += uint64(len(row.RawJSON))
+	}
+	out := make([]model.UsageRow, 0, len(order))
+	for _, k := range order {
+		out = append(out, *agg[k])
+	}
+	return out
+}
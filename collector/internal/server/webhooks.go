@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"trace-lite/collector/internal/secrets"
+	"trace-lite/collector/internal/webhook"
+)
+
+func (h *Handler) SetWebhooks(d *webhook.Dispatcher) {
+	h.webhooks = d
+}
+
+func (h *Handler) AdminWebhooks(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var body webhook.File
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", "body must be JSON with a webhooks list", nil)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		if err := h.webhooks.Prepare(ctx, body.Webhooks); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", err.Error(), nil)
+			return
+		}
+		h.webhooks.Set(body.Webhooks)
+		log.Printf("admin: trace webhooks replaced (%d webhooks)", len(body.Webhooks))
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
+		return
+	}
+
+	hooks := h.webhooks.Hooks()
+	for i := range hooks {
+		hooks[i].Headers = redactHeaders(hooks[i].Headers)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"webhooks": hooks, "deliveries": h.webhooks.Stats()})
+}
+
+func redactHeaders(headers map[string]string) map[string]string {
+	if len(headers) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(headers))
+	for k, v := range headers {
+		if !secrets.IsRef(v) {
+			v = "redacted"
+		}
+		out[k] = v
+	}
+	return out
+}
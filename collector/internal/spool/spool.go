@@ -0,0 +1,324 @@
+package spool
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"trace-lite/collector/internal/model"
+)
+
+const suffix = ".json.gz"
+
+var ErrFull = errors.New("spool is full")
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+type Batch struct {
+	BatchID    string               `json:"batch_id"`
+	Rows       []model.RawLogRow    `json:"rows,omitempty"`
+	Heartbeats []model.HeartbeatRow `json:"heartbeats,omitempty"`
+	Links      []model.LinkRow      `json:"links,omitempty"`
+}
+
+func (b Batch) Len() int {
+	return len(b.Rows) + len(b.Heartbeats) + len(b.Links)
+}
+
+type Stats struct {
+	Files     int     `json:"files"`
+	Bytes     int64   `json:"bytes"`
+	MaxBytes  int64   `json:"max_bytes"`
+	Sent      uint64  `json:"sent_batches"`
+	Failed    uint64  `json:"failed_attempts"`
+	Rejected  uint64  `json:"full_rejections"`
+	Expired   uint64  `json:"expired_batches"`
+	OldestAge float64 `json:"oldest_age_s"`
+	LastError string  `json:"last_error,omitempty"`
+}
+
+type Spool struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+	mu       sync.Mutex
+	files    int
+	bytes    int64
+	seq      uint64
+	lastErr  string
+	wake     chan struct{}
+	sent     atomic.Uint64
+	failed   atomic.Uint64
+	rejected atomic.Uint64
+	expired  atomic.Uint64
+}
+
+func Open(dir string, maxBytes int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	s := &Spool{dir: dir, maxBytes: maxBytes, wake: make(chan struct{}, 1)}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if strings.HasSuffix(e.Name(), ".tmp") {
+			_ = os.Remove(filepath.Join(dir, e.Name()))
+			continue
+		}
+		if !strings.HasSuffix(e.Name(), suffix) {
+			continue
+		}
+		if fi, err := e.Info(); err == nil {
+			s.files++
+			s.bytes += fi.Size()
+		}
+	}
+	return s, nil
+}
+
+func (s *Spool) SetMaxAge(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxAge = d
+}
+
+func (s *Spool) Append(b Batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxBytes > 0 && s.bytes >= s.maxBytes {
+		s.rejected.Add(1)
+		return ErrFull
+	}
+	s.seq++
+	name := filepath.Join(s.dir, fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), s.seq%1000000, suffix))
+	f, err := os.OpenFile(name+".tmp", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(f)
+	err = json.NewEncoder(gz).Encode(b)
+	if cerr := gz.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	var size int64
+	if fi, serr := f.Stat(); err == nil && serr == nil {
+		size = fi.Size()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(name+".tmp", name)
+	}
+	if err != nil {
+		_ = os.Remove(name + ".tmp")
+		return err
+	}
+	s.files++
+	s.bytes += size
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (s *Spool) Drain(ctx context.Context, backoffMax time.Duration, send func(context.Context, Batch) error) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		names, err := s.pending()
+		if err != nil {
+			log.Printf("spool %s: %v", s.dir, err)
+		}
+		failed := false
+		for _, name := range names {
+			if ctx.Err() != nil {
+				return
+			}
+			if err := s.sendFile(ctx, name, send); err != nil {
+				s.failed.Add(1)
+				s.setError(err)
+				log.Printf("spool %s: %s: %v (retrying in %s)", s.dir, name, err, backoff)
+				failed = true
+				break
+			}
+			s.sent.Add(1)
+			backoff = time.Second
+		}
+		wait := time.Minute
+		if failed {
+			wait = backoff
+			backoff = min(backoff*2, backoffMax)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+			if failed {
+				sleepCtx(ctx, wait)
+			}
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (s *Spool) pending() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), suffix) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *Spool) sendFile(ctx context.Context, name string, send func(context.Context, Batch) error) error {
+	path := filepath.Join(s.dir, name)
+	s.mu.Lock()
+	maxAge := s.maxAge
+	s.mu.Unlock()
+	if age := fileAge(name, time.Now()); maxAge > 0 && age > maxAge {
+		size := int64(0)
+		if fi, err := os.Stat(path); err == nil {
+			size = fi.Size()
+		}
+		log.Printf("spool %s: discarding %s, spooled %s ago", s.dir, name, age.Round(time.Second))
+		s.expired.Add(1)
+		s.remove(path, size)
+		return nil
+	}
+	b, size, err := readBatch(path)
+	if err != nil {
+		log.Printf("spool %s: discarding unreadable %s: %v", s.dir, name, err)
+		s.remove(path, size)
+		return nil
+	}
+	if err := send(ctx, b); err != nil {
+		var perm permanentError
+		if !errors.As(err, &perm) {
+			return err
+		}
+		log.Printf("spool %s: discarding %s: %v", s.dir, name, err)
+	}
+	s.remove(path, size)
+	return nil
+}
+
+func fileAge(name string, now time.Time) time.Duration {
+	stamp, _, _ := strings.Cut(name, "-")
+	ns, err := strconv.ParseInt(stamp, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return now.Sub(time.Unix(0, ns))
+}
+
+func readBatch(path string) (Batch, int64, error) {
+	var b Batch
+	f, err := os.Open(path)
+	if err != nil {
+		return b, 0, err
+	}
+	defer f.Close()
+	var size int64
+	if fi, err := f.Stat(); err == nil {
+		size = fi.Size()
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return b, size, err
+	}
+	defer gz.Close()
+	err = json.NewDecoder(gz).Decode(&b)
+	return b, size, err
+}
+
+func (s *Spool) remove(path string, size int64) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("spool %s: %v", s.dir, err)
+		return
+	}
+	s.mu.Lock()
+	s.files--
+	s.bytes -= size
+	s.mu.Unlock()
+}
+
+func (s *Spool) setError(err error) {
+	s.mu.Lock()
+	s.lastErr = err.Error()
+	s.mu.Unlock()
+}
+
+func (s *Spool) Full() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maxBytes > 0 && s.bytes >= s.maxBytes
+}
+
+func (s *Spool) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.files
+}
+
+func (s *Spool) Stats() Stats {
+	var oldest time.Duration
+	if names, err := s.pending(); err == nil && len(names) > 0 {
+		oldest = fileAge(names[0], time.Now())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Stats{
+		Files:     s.files,
+		Bytes:     s.bytes,
+		MaxBytes:  s.maxBytes,
+		Sent:      s.sent.Load(),
+		Failed:    s.failed.Load(),
+		Rejected:  s.rejected.Load(),
+		Expired:   s.expired.Load(),
+		OldestAge: oldest.Seconds(),
+		LastError: s.lastErr,
+	}
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
@@ -0,0 +1,126 @@
+package spool
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"trace-lite/collector/internal/model"
+)
+
+func testBatch(id string) Batch {
+	return Batch{BatchID: id, Rows: []model.RawLogRow{{TS: "2026-01-01 10:00:00.000", Service: "cart", TraceID: "t1", RawJSON: `{"service":"cart"}`}}}
+}
+
+func TestAppendBounds(t *testing.T) {
+	cases := []struct {
+		name     string
+		maxBytes int64
+		appends  int
+		stored   int
+		rejected uint64
+	}{
+		{"unbounded", 0, 3, 3, 0},
+		{"room for all", 1 << 20, 3, 3, 0},
+		{"full after the first file", 1, 3, 1, 2},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := Open(t.TempDir(), tc.maxBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stored := 0
+			for i := 0; i < tc.appends; i++ {
+				switch err := s.Append(testBatch("b")); {
+				case err == nil:
+					stored++
+				case !errors.Is(err, ErrFull):
+					t.Fatal(err)
+				}
+			}
+			st := s.Stats()
+			if stored != tc.stored || st.Files != tc.stored || st.Rejected != tc.rejected {
+				t.Fatalf("stored %d, stats %+v; want %d stored and %d rejected", stored, st, tc.stored, tc.rejected)
+			}
+			if s.Full() != (tc.rejected > 0) {
+				t.Fatalf("Full() = %v", s.Full())
+			}
+		})
+	}
+}
+
+func TestReopenCountsPendingFiles(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b"} {
+		if err := s.Append(testBatch(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	again, err := Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := again.Stats(); st.Files != 2 || st.Bytes != s.Stats().Bytes {
+		t.Fatalf("reopened stats %+v, want the 2 files of %+v", st, s.Stats())
+	}
+}
+
+func TestSendFile(t *testing.T) {
+	cases := []struct {
+		name    string
+		maxAge  time.Duration
+		sendErr error
+		sent    bool
+		kept    bool
+		expired uint64
+	}{
+		{"delivered", 0, nil, true, false, 0},
+		{"young enough", time.Hour, nil, true, false, 0},
+		{"too old", time.Nanosecond, nil, false, false, 1},
+		{"transient failure", 0, errors.New("clickhouse down"), true, true, 0},
+		{"permanent failure", 0, Permanent(errors.New("bad batch")), true, false, 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := Open(t.TempDir(), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.SetMaxAge(tc.maxAge)
+			if err := s.Append(testBatch("b1")); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(time.Millisecond)
+			names, err := s.pending()
+			if err != nil || len(names) != 1 {
+				t.Fatalf("pending = %v, %v", names, err)
+			}
+			var got []Batch
+			err = s.sendFile(context.Background(), names[0], func(_ context.Context, b Batch) error {
+				got = append(got, b)
+				return tc.sendErr
+			})
+			if tc.kept != (err != nil) {
+				t.Fatalf("sendFile error = %v", err)
+			}
+			if tc.sent != (len(got) == 1) || tc.sent && (got[0].BatchID != "b1" || len(got[0].Rows) != 1) {
+				t.Fatalf("sent %+v", got)
+			}
+			_, statErr := os.Stat(filepath.Join(s.dir, names[0]))
+			if tc.kept != (statErr == nil) {
+				t.Fatalf("file kept = %v, want %v", statErr == nil, tc.kept)
+			}
+			if st := s.Stats(); st.Expired != tc.expired || (st.Files == 1) != tc.kept {
+				t.Fatalf("stats %+v", st)
+			}
+		})
+	}
+}
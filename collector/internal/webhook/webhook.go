@@ -0,0 +1,404 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"trace-lite/collector/internal/model"
+	"trace-lite/collector/internal/secrets"
+)
+
+const (
+	queueSize   = 1000
+	maxAttempts = 3
+)
+
+var hookName = regexp.MustCompile(`^[a-z0-9_.-]{1,64}$`)
+
+type Hook struct {
+	Name          string            `json:"name"`
+	URL           string            `json:"url"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	Service       string            `json:"service,omitempty"`
+	Env           string            `json:"env,omitempty"`
+	HasError      *bool             `json:"has_error,omitempty"`
+	MinDurationMs uint32            `json:"min_duration_ms,omitempty"`
+
+	target  string
+	headers http.Header
+	trusted bool
+}
+
+type File struct {
+	Webhooks []Hook `json:"webhooks"`
+}
+
+type Summary struct {
+	model.TraceRow
+	Services []string `json:"services"`
+}
+
+type Payload struct {
+	Event   string  `json:"event"`
+	Webhook string  `json:"webhook"`
+	SentAt  string  `json:"sent_at"`
+	Trace   Summary `json:"trace"`
+}
+
+type Stats struct {
+	Delivered uint64 `json:"delivered"`
+	Failed    uint64 `json:"failed"`
+	Dropped   uint64 `json:"dropped"`
+}
+
+type delivery struct {
+	hook    *Hook
+	payload Payload
+}
+
+type allowlist struct {
+	hosts map[string]bool
+	nets  []netip.Prefix
+}
+
+type Dispatcher struct {
+	mu        sync.RWMutex
+	hooks     []*Hook
+	queue     chan delivery
+	done      chan struct{}
+	allow     allowlist
+	client    *http.Client
+	trusted   *http.Client
+	backoff   time.Duration
+	delivered atomic.Uint64
+	failed    atomic.Uint64
+	dropped   atomic.Uint64
+	closed    bool
+}
+
+func parseAllowlist(entries []string) (allowlist, error) {
+	a := allowlist{hosts: map[string]bool{}}
+	for _, e := range entries {
+		e = strings.ToLower(strings.TrimSpace(e))
+		switch {
+		case e == "":
+		case strings.Contains(e, "/"):
+			p, err := netip.ParsePrefix(e)
+			if err != nil {
+				return allowlist{}, fmt.Errorf("WEBHOOK_ALLOW_HOSTS entry %q: %w", e, err)
+			}
+			a.nets = append(a.nets, p.Masked())
+		default:
+			a.hosts[e] = true
+		}
+	}
+	return a, nil
+}
+
+func (a allowlist) permits(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !internalAddr(ip) {
+		return true
+	}
+	for _, p := range a.nets {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return a.hosts[ip.String()]
+}
+
+func internalAddr(ip netip.Addr) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified()
+}
+
+func (a allowlist) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !a.permits(ip) {
+		return fmt.Errorf("%s is a loopback, private or link-local address; add it to WEBHOOK_ALLOW_HOSTS", ip)
+	}
+	return nil
+}
+
+func (d *Dispatcher) Load(ctx context.Context, path string) ([]Hook, error) {
+	if path == "" {
+		return nil, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f File
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := d.prepare(ctx, f.Webhooks, true); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f.Webhooks, nil
+}
+
+func (d *Dispatcher) Prepare(ctx context.Context, hooks []Hook) error {
+	return d.prepare(ctx, hooks, false)
+}
+
+func (d *Dispatcher) prepare(ctx context.Context, hooks []Hook, resolveRefs bool) error {
+	resolve := func(field, v string) (string, error) {
+		if !secrets.IsRef(v) {
+			return v, nil
+		}
+		if !resolveRefs {
+			return "", fmt.Errorf("%s: secret references are only allowed in TRACE_WEBHOOKS_FILE", field)
+		}
+		out, err := secrets.Resolve(ctx, v)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", field, err)
+		}
+		return out, nil
+	}
+	seen := map[string]bool{}
+	for i := range hooks {
+		h := &hooks[i]
+		if !hookName.MatchString(h.Name) {
+			return fmt.Errorf("webhook %q: name must match %s", h.Name, hookName)
+		}
+		if seen[h.Name] {
+			return fmt.Errorf("webhook %q is defined twice", h.Name)
+		}
+		seen[h.Name] = true
+		target, err := resolve("url", h.URL)
+		if err != nil {
+			return fmt.Errorf("webhook %q: %w", h.Name, err)
+		}
+		u, err := url.Parse(target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook %q: url must be an http(s) URL", h.Name)
+		}
+		host := strings.ToLower(u.Hostname())
+		h.trusted = d.allow.hosts[host]
+		if ip, err := netip.ParseAddr(host); err == nil && !h.trusted && !d.allow.permits(ip) {
+			return fmt.Errorf("webhook %q: url points at a loopback, private or link-local address; add it to WEBHOOK_ALLOW_HOSTS", h.Name)
+		}
+		h.target = target
+		h.headers = http.Header{}
+		for k, v := range h.Headers {
+			value, err := resolve("header "+k, v)
+			if err != nil {
+				return fmt.Errorf("webhook %q: %w", h.Name, err)
+			}
+			h.headers.Set(k, value)
+		}
+	}
+	return nil
+}
+
+func New(allowHosts []string) (*Dispatcher, error) {
+	allow, err := parseAllowlist(allowHosts)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: allow.control}
+	d := &Dispatcher{
+		queue: make(chan delivery, queueSize),
+		done:  make(chan struct{}),
+		allow: allow,
+		client: &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConnsPerHost: 4,
+		}},
+		trusted: &http.Client{Timeout: 10 * time.Second},
+		backoff: time.Second,
+	}
+	go d.run()
+	return d, nil
+}
+
+func (d *Dispatcher) Set(hooks []Hook) {
+	list := make([]*Hook, len(hooks))
+	for i := range hooks {
+		h := hooks[i]
+		list[i] = &h
+	}
+	d.mu.Lock()
+	d.hooks = list
+	d.mu.Unlock()
+}
+
+func (d *Dispatcher) Hooks() []Hook {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	out := make([]Hook, len(d.hooks))
+	for i, h := range d.hooks {
+		out[i] = *h
+	}
+	return out
+}
+
+func (d *Dispatcher) Stats() Stats {
+	return Stats{Delivered: d.delivered.Load(), Failed: d.failed.Load(), Dropped: d.dropped.Load()}
+}
+
+func (d *Dispatcher) Finalized(traces []model.TraceRow, spans []model.SpanRow) {
+	d.mu.RLock()
+	hooks := d.hooks
+	d.mu.RUnlock()
+	if len(hooks) == 0 || len(traces) == 0 {
+		return
+	}
+	services := map[string]map[string]bool{}
+	for _, s := range spans {
+		if services[s.TraceID] == nil {
+			services[s.TraceID] = map[string]bool{}
+		}
+		services[s.TraceID][s.Service] = true
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return
+	}
+	for _, t := range traces {
+		summary := Summary{TraceRow: t, Services: sortedKeys(services[t.TraceID])}
+		for _, h := range hooks {
+			if !h.Matches(summary) {
+				continue
+			}
+			select {
+			case d.queue <- delivery{hook: h, payload: Payload{Event: "trace.finalized", Webhook: h.Name, SentAt: now, Trace: summary}}:
+			default:
+				if d.dropped.Add(1)%100 == 1 {
+					log.Printf("webhook %s: queue full, dropping trace %s (%d dropped so far)", h.Name, t.TraceID, d.dropped.Load())
+				}
+			}
+		}
+	}
+}
+
+func (h *Hook) Matches(s Summary) bool {
+	if h.Env != "" && !strings.EqualFold(h.Env, s.Env) {
+		return false
+	}
+	if h.Service != "" {
+		found := false
+		for _, name := range s.Services {
+			if strings.EqualFold(name, h.Service) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if h.HasError != nil && *h.HasError != (s.ErrorCount > 0) {
+		return false
+	}
+	return s.DurationMs >= h.MinDurationMs
+}
+
+func (d *Dispatcher) Close(ctx context.Context) {
+	d.mu.Lock()
+	d.closed = true
+	close(d.queue)
+	d.mu.Unlock()
+	select {
+	case <-d.done:
+	case <-ctx.Done():
+		log.Printf("webhook: %d deliveries still queued at shutdown", len(d.queue))
+	}
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.done)
+	for job := range d.queue {
+		if err := d.deliver(job); err != nil {
+			d.failed.Add(1)
+			log.Printf("webhook %s: trace %s not delivered: %v", job.hook.Name, job.payload.Trace.TraceID, err)
+			continue
+		}
+		d.delivered.Add(1)
+	}
+}
+
+func (d *Dispatcher) deliver(job delivery) error {
+	body, err := json.Marshal(job.payload)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(d.backoff << (attempt - 1))
+		}
+		retry, err := d.post(job.hook, body)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retry {
+			break
+		}
+	}
+	return lastErr
+}
+
+func (d *Dispatcher) post(h *Hook, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, h.target, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for k, v := range h.headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := d.client
+	if h.trusted {
+		client = d.trusted
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("status %d", resp.StatusCode)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	out := make([]string, 0, len(set))
+	for k := range set {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
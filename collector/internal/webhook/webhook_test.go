@@ -0,0 +1,104 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"trace-lite/collector/internal/model"
+)
+
+func TestPrepare(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(secret, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ref := "file://" + secret
+	cases := []struct {
+		name    string
+		allow   []string
+		static  bool
+		hook    Hook
+		wantErr string
+	}{
+		{name: "public url", hook: Hook{Name: "a", URL: "https://hooks.example.com/x"}},
+		{name: "runtime url ref", hook: Hook{Name: "a", URL: ref}, wantErr: "only allowed in TRACE_WEBHOOKS_FILE"},
+		{name: "runtime header ref", hook: Hook{Name: "a", URL: "https://hooks.example.com/x", Headers: map[string]string{"Authorization": ref}}, wantErr: "only allowed in TRACE_WEBHOOKS_FILE"},
+		{name: "static header ref", static: true, hook: Hook{Name: "a", URL: "https://hooks.example.com/x", Headers: map[string]string{"Authorization": ref}}},
+		{name: "loopback", hook: Hook{Name: "a", URL: "http://127.0.0.1:8080/x"}, wantErr: "WEBHOOK_ALLOW_HOSTS"},
+		{name: "metadata", hook: Hook{Name: "a", URL: "http://169.254.169.254/latest/meta-data/"}, wantErr: "WEBHOOK_ALLOW_HOSTS"},
+		{name: "private", static: true, hook: Hook{Name: "a", URL: "http://10.1.2.3/x"}, wantErr: "WEBHOOK_ALLOW_HOSTS"},
+		{name: "mapped loopback", hook: Hook{Name: "a", URL: "http://[::ffff:127.0.0.1]/x"}, wantErr: "WEBHOOK_ALLOW_HOSTS"},
+		{name: "private allowed by cidr", allow: []string{"10.0.0.0/8"}, hook: Hook{Name: "a", URL: "http://10.1.2.3/x"}},
+		{name: "private allowed by host", allow: []string{"hooks.internal"}, hook: Hook{Name: "a", URL: "http://hooks.internal/x"}},
+		{name: "not http", hook: Hook{Name: "a", URL: "file:///etc/passwd"}, wantErr: "only allowed in TRACE_WEBHOOKS_FILE"},
+		{name: "bad name", hook: Hook{Name: "A B", URL: "https://hooks.example.com/x"}, wantErr: "name must match"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := New(tc.allow)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close(context.Background())
+			hooks := []Hook{tc.hook}
+			err = d.prepare(context.Background(), hooks, tc.static)
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Fatalf("prepare: %v", err)
+			case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+				t.Fatalf("prepare error = %v, want %q", err, tc.wantErr)
+			case tc.static && err == nil && len(tc.hook.Headers) > 0 && hooks[0].headers.Get("Authorization") != "s3cret":
+				t.Fatalf("static header ref resolved to %q", hooks[0].headers.Get("Authorization"))
+			}
+		})
+	}
+}
+
+func TestDeliveryRefusesInternalTargetsResolvedByName(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { calls.Add(1) }))
+	defer srv.Close()
+	target := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+
+	for _, tc := range []struct {
+		allow []string
+		want  int32
+	}{{nil, 0}, {[]string{"127.0.0.0/8", "::1/128"}, 1}} {
+		calls.Store(0)
+		d, err := New(tc.allow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.backoff = time.Millisecond
+		hooks := []Hook{{Name: "local", URL: target}}
+		if err := d.Prepare(context.Background(), hooks); err != nil {
+			t.Fatal(err)
+		}
+		d.Set(hooks)
+		d.Finalized([]model.TraceRow{{TraceID: "t1"}}, nil)
+		d.Close(context.Background())
+		if got := calls.Load(); got != tc.want {
+			t.Fatalf("allow %v: %d deliveries, want %d", tc.allow, got, tc.want)
+		}
+	}
+}
+
+func TestFinalizedAfterCloseIsDropped(t *testing.T) {
+	d, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Set([]Hook{{Name: "late", URL: "https://hooks.example.com/trace"}})
+	d.Close(context.Background())
+	d.Finalized([]model.TraceRow{{TraceID: "t1"}}, nil)
+	if got := d.Stats(); got.Delivered != 0 || got.Failed != 0 {
+		t.Fatalf("stats %+v after close, want nothing delivered or attempted", got)
+	}
+}
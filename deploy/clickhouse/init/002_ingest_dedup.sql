@@ -0,0 +1 @@
+ALTER TABLE trace_lite.raw_logs MODIFY SETTING non_replicated_deduplication_window = 10000;
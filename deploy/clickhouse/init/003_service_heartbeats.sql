@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS trace_lite.service_heartbeats (
+  ts        DateTime64(3, 'UTC'),
+  service   LowCardinality(String),
+  env       LowCardinality(String),
+  host      LowCardinality(String),
+  version   LowCardinality(String)
+)
+ENGINE = MergeTree
+PARTITION BY toDate(ts)
+ORDER BY (env, service, ts, host)
+TTL toDateTime(ts) + INTERVAL 30 DAY
+SETTINGS non_replicated_deduplication_window = 10000;
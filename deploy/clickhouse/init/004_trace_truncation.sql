@@ -0,0 +1,2 @@
+ALTER TABLE trace_lite.traces ADD COLUMN IF NOT EXISTS truncated UInt8 DEFAULT 0 AFTER versions;
+ALTER TABLE trace_lite.traces ADD COLUMN IF NOT EXISTS dropped_spans UInt32 DEFAULT 0 AFTER truncated;
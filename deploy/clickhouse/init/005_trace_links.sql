@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS trace_lite.trace_links (
+  ts                DateTime64(3, 'UTC'),
+  trace_id          String,
+  linked_trace_id   String,
+  link_type         LowCardinality(String),
+  service           LowCardinality(String),
+  env               LowCardinality(String),
+  span_id           String,
+  INDEX idx_linked linked_trace_id TYPE bloom_filter GRANULARITY 2
+)
+ENGINE = ReplacingMergeTree(ts)
+ORDER BY (trace_id, linked_trace_id, link_type)
+TTL toDateTime(ts) + INTERVAL 180 DAY
+SETTINGS non_replicated_deduplication_window = 10000;
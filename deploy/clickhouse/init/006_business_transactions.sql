@@ -0,0 +1 @@
+ALTER TABLE trace_lite.traces ADD COLUMN IF NOT EXISTS transaction LowCardinality(String) DEFAULT '' AFTER root_service;
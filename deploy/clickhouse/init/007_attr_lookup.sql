@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS trace_lite.attr_lookup (
+  ts        DateTime64(3, 'UTC'),
+  key       LowCardinality(String),
+  value     String,
+  trace_id  String,
+  service   LowCardinality(String),
+  env       LowCardinality(String)
+)
+ENGINE = ReplacingMergeTree(ts)
+PARTITION BY toYYYYMM(ts)
+ORDER BY (key, value, trace_id)
+TTL toDateTime(ts) + INTERVAL 90 DAY
+SETTINGS non_replicated_deduplication_window = 10000;
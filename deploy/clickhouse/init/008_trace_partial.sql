@@ -0,0 +1 @@
+ALTER TABLE trace_lite.traces ADD COLUMN IF NOT EXISTS partial UInt8 DEFAULT 0 AFTER dropped_spans;
@@ -0,0 +1,3 @@
+ALTER TABLE trace_lite.spans ADD COLUMN IF NOT EXISTS status LowCardinality(String) DEFAULT if(is_error = 1, 'error', 'ok') AFTER is_error;
+ALTER TABLE trace_lite.spans ADD COLUMN IF NOT EXISTS error_message String DEFAULT '' AFTER status;
+ALTER TABLE trace_lite.spans ADD COLUMN IF NOT EXISTS error_type LowCardinality(String) DEFAULT '' AFTER error_message;
@@ -0,0 +1 @@
+ALTER TABLE trace_lite.dependency_edges_minute ADD COLUMN IF NOT EXISTS cancelled_calls UInt64 DEFAULT 0 AFTER error_calls;
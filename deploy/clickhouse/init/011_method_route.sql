@@ -0,0 +1,4 @@
+ALTER TABLE trace_lite.spans ADD COLUMN IF NOT EXISTS method LowCardinality(String) DEFAULT '' AFTER operation;
+ALTER TABLE trace_lite.spans ADD COLUMN IF NOT EXISTS route String DEFAULT '' AFTER method;
+ALTER TABLE trace_lite.dependency_edges_minute ADD COLUMN IF NOT EXISTS callee_method LowCardinality(String) DEFAULT '' AFTER callee_version;
+ALTER TABLE trace_lite.dependency_edges_minute ADD COLUMN IF NOT EXISTS callee_route String DEFAULT '' AFTER callee_method;
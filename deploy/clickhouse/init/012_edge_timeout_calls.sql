@@ -0,0 +1 @@
+ALTER TABLE trace_lite.dependency_edges_minute ADD COLUMN IF NOT EXISTS timeout_calls UInt64 DEFAULT 0 AFTER cancelled_calls;
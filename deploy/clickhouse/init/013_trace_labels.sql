@@ -0,0 +1 @@
+ALTER TABLE trace_lite.traces ADD COLUMN IF NOT EXISTS labels Array(LowCardinality(String)) DEFAULT [] AFTER partial;
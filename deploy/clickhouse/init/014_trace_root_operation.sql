@@ -0,0 +1,2 @@
+ALTER TABLE trace_lite.traces ADD COLUMN IF NOT EXISTS root_operation String DEFAULT '' AFTER root_service;
+ALTER TABLE trace_lite.traces ADD COLUMN IF NOT EXISTS root_status_code UInt16 DEFAULT 0 AFTER root_operation;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS trace_lite.service_versions_minute (
+  bucket_ts  DateTime('UTC'),
+  env        LowCardinality(String),
+  service    LowCardinality(String),
+  version    LowCardinality(String),
+  calls      UInt64,
+  errors     UInt64
+)
+ENGINE = MergeTree
+PARTITION BY toDate(bucket_ts)
+ORDER BY (env, service, bucket_ts, version)
+TTL bucket_ts + INTERVAL 365 DAY;
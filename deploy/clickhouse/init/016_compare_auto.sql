@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS trace_lite.compare_auto (
+  env              LowCardinality(String),
+  service          LowCardinality(String),
+  base_version     LowCardinality(String),
+  cand_version     LowCardinality(String),
+  deployed_at      DateTime('UTC'),
+  evaluated_at     DateTime64(3, 'UTC'),
+  soak_seconds     UInt32,
+  verdict          LowCardinality(String),
+  base_calls       UInt64,
+  cand_calls       UInt64,
+  base_p95         Float64,
+  cand_p95         Float64,
+  base_error_rate  Float64,
+  cand_error_rate  Float64,
+  result           String
+)
+ENGINE = ReplacingMergeTree(evaluated_at)
+ORDER BY (env, service, cand_version)
+TTL deployed_at + INTERVAL 365 DAY;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS trace_lite.maintenance_windows (
+  id          String,
+  env         LowCardinality(String),
+  service     LowCardinality(String),
+  starts_at   DateTime('UTC'),
+  ends_at     DateTime('UTC'),
+  reason      String,
+  created_at  DateTime64(3, 'UTC'),
+  updated_at  DateTime64(3, 'UTC')
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY id
+TTL ends_at + INTERVAL 90 DAY;
+
+ALTER TABLE trace_lite.compare_auto ADD COLUMN IF NOT EXISTS silenced UInt8 DEFAULT 0 AFTER verdict;
+ALTER TABLE trace_lite.compare_auto ADD COLUMN IF NOT EXISTS maintenance_id String DEFAULT '' AFTER silenced;
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS trace_lite.alert_events (
+  fingerprint  String,
+  rule         LowCardinality(String),
+  service      LowCardinality(String),
+  env          LowCardinality(String),
+  metric       LowCardinality(String),
+  op           LowCardinality(String),
+  threshold    Float64,
+  status       LowCardinality(String),
+  value        Float64,
+  silenced     UInt8,
+  starts_at    DateTime64(3, 'UTC'),
+  at           DateTime64(3, 'UTC')
+)
+ENGINE = MergeTree
+PARTITION BY toYYYYMM(at)
+ORDER BY (fingerprint, at)
+TTL toDateTime(at) + INTERVAL 365 DAY;
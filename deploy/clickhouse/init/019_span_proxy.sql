@@ -0,0 +1 @@
+ALTER TABLE trace_lite.spans ADD COLUMN IF NOT EXISTS proxy LowCardinality(String) DEFAULT '' AFTER source;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS trace_lite.internal_edges_minute (
+  bucket_ts      DateTime('UTC'),
+  env            LowCardinality(String),
+  service        LowCardinality(String),
+  caller_module  LowCardinality(String),
+  callee_module  LowCardinality(String),
+  calls          UInt64,
+  error_calls    UInt64,
+  max_depth      UInt16,
+  p50_ms         Float32,
+  p95_ms         Float32,
+  max_ms         UInt32
+)
+ENGINE = MergeTree
+PARTITION BY toDate(bucket_ts)
+ORDER BY (env, service, bucket_ts, caller_module, callee_module)
+TTL bucket_ts + INTERVAL 365 DAY;
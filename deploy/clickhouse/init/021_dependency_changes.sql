@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS trace_lite.dependency_changes (
+  at              DateTime('UTC'),
+  detected_at     DateTime64(3, 'UTC'),
+  env             LowCardinality(String),
+  caller_service  LowCardinality(String),
+  callee_service  LowCardinality(String),
+  kind            LowCardinality(String),
+  calls           UInt64,
+  before          Float64,
+  after           Float64
+)
+ENGINE = ReplacingMergeTree(detected_at)
+PARTITION BY toYYYYMM(at)
+ORDER BY (env, caller_service, callee_service, kind, at)
+TTL at + INTERVAL 365 DAY;
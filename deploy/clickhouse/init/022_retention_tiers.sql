@@ -0,0 +1,3 @@
+ALTER TABLE trace_lite.raw_logs ADD COLUMN IF NOT EXISTS retain_days UInt16 DEFAULT 0;
+ALTER TABLE trace_lite.spans ADD COLUMN IF NOT EXISTS retain_days UInt16 DEFAULT 0;
+ALTER TABLE trace_lite.traces ADD COLUMN IF NOT EXISTS retain_days UInt16 DEFAULT 0;
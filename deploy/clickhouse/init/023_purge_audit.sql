@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS trace_lite.purge_audit (
+  id              String,
+  requested_at    DateTime64(3, 'UTC'),
+  requested_from  String,
+  attr            LowCardinality(String),
+  value_sha256    String,
+  reason          String,
+  traces          UInt32,
+  tables          Array(String),
+  status          LowCardinality(String),
+  error           String
+)
+ENGINE = MergeTree
+ORDER BY (requested_at, id);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS trace_lite.rejected_events (
+  ts         DateTime64(3, 'UTC'),
+  batch_id   String,
+  token      LowCardinality(String),
+  endpoint   LowCardinality(String),
+  client     String,
+  line       UInt32,
+  reason     String,
+  payload    String,
+  truncated  UInt8
+)
+ENGINE = MergeTree
+PARTITION BY toDate(ts)
+ORDER BY (token, ts)
+TTL toDateTime(ts) + INTERVAL 14 DAY
+SETTINGS non_replicated_deduplication_window = 10000;
@@ -0,0 +1 @@
+ALTER TABLE trace_lite.rejected_events ADD COLUMN IF NOT EXISTS version LowCardinality(String) DEFAULT '1' AFTER endpoint;
@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS trace_lite.trace_partials (
+  trace_id          String,
+  collector_id      LowCardinality(String),
+  env               LowCardinality(String),
+  root_service      LowCardinality(String),
+  root_operation    String,
+  root_status_code  UInt16,
+  transaction       String,
+  start_ts          DateTime64(3, 'UTC'),
+  end_ts            DateTime64(3, 'UTC'),
+  duration_ms       UInt32,
+  span_count        UInt16,
+  service_count     UInt16,
+  error_count       UInt16,
+  critical_path_ms  UInt32,
+  versions          Array(LowCardinality(String)),
+  truncated         UInt8,
+  dropped_spans     UInt32,
+  partial           UInt8,
+  labels            Array(LowCardinality(String)),
+  retain_days       UInt16,
+  has_root          UInt8,
+  services          Array(LowCardinality(String)),
+  written_at        DateTime64(3, 'UTC') DEFAULT now64(3)
+)
+ENGINE = ReplacingMergeTree(written_at)
+PARTITION BY toDate(written_at)
+ORDER BY (trace_id, collector_id, start_ts)
+TTL toDateTime(written_at) + INTERVAL 7 DAY;
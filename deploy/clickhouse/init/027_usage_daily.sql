@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS trace_lite.usage_daily (
+  day      Date,
+  env      LowCardinality(String),
+  service  LowCardinality(String),
+  events   UInt64,
+  bytes    UInt64,
+  spans    UInt64,
+  traces   UInt64
+)
+ENGINE = SummingMergeTree
+PARTITION BY toYYYYMM(day)
+ORDER BY (env, service, day)
+TTL day + INTERVAL 400 DAY
+SETTINGS non_replicated_deduplication_window = 10000;
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS trace_lite.operation_latency_hourly (
+  bucket_ts    DateTime('UTC'),
+  env          LowCardinality(String),
+  service      LowCardinality(String),
+  operation    String,
+  calls        SimpleAggregateFunction(sum, UInt64),
+  duration_q   AggregateFunction(quantilesTDigest(0.5, 0.9, 0.99), UInt32)
+)
+ENGINE = AggregatingMergeTree
+PARTITION BY toYYYYMM(bucket_ts)
+ORDER BY (env, service, operation, bucket_ts)
+TTL bucket_ts + INTERVAL 35 DAY;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS trace_lite.mv_operation_latency_hourly
+TO trace_lite.operation_latency_hourly
+AS
+SELECT
+  toStartOfHour(start_ts) AS bucket_ts,
+  env,
+  service,
+  operation,
+  count() AS calls,
+  quantilesTDigestState(0.5, 0.9, 0.99)(duration_ms) AS duration_q
+FROM trace_lite.spans
+GROUP BY bucket_ts, env, service, operation;
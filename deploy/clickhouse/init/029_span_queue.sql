@@ -0,0 +1 @@
+ALTER TABLE trace_lite.spans ADD COLUMN IF NOT EXISTS queue_ms UInt32 DEFAULT 0 AFTER self_time_ms;
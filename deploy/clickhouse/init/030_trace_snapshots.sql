@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS trace_lite.trace_snapshots (
+  id          String,
+  trace_id    String,
+  created_at  DateTime64(3, 'UTC'),
+  bytes       UInt32,
+  body        String CODEC(ZSTD(3))
+)
+ENGINE = ReplacingMergeTree
+ORDER BY id;
@@ -0,0 +1 @@
+ALTER TABLE trace_lite.spans ADD COLUMN IF NOT EXISTS conflicts Array(LowCardinality(String)) DEFAULT [] AFTER proxy;
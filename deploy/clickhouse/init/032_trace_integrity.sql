@@ -0,0 +1,8 @@
+ALTER TABLE trace_lite.traces ADD COLUMN IF NOT EXISTS integrity Float32 DEFAULT 1 AFTER labels;
+ALTER TABLE trace_lite.traces ADD COLUMN IF NOT EXISTS inferred_spans UInt16 DEFAULT 0 AFTER integrity;
+ALTER TABLE trace_lite.traces ADD COLUMN IF NOT EXISTS orphan_spans UInt16 DEFAULT 0 AFTER inferred_spans;
+ALTER TABLE trace_lite.traces ADD COLUMN IF NOT EXISTS skewed_spans UInt16 DEFAULT 0 AFTER orphan_spans;
+ALTER TABLE trace_lite.trace_partials ADD COLUMN IF NOT EXISTS integrity Float32 DEFAULT 1 AFTER labels;
+ALTER TABLE trace_lite.trace_partials ADD COLUMN IF NOT EXISTS inferred_spans UInt16 DEFAULT 0 AFTER integrity;
+ALTER TABLE trace_lite.trace_partials ADD COLUMN IF NOT EXISTS orphan_spans UInt16 DEFAULT 0 AFTER inferred_spans;
+ALTER TABLE trace_lite.trace_partials ADD COLUMN IF NOT EXISTS skewed_spans UInt16 DEFAULT 0 AFTER orphan_spans;
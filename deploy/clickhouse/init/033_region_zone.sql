@@ -0,0 +1,12 @@
+ALTER TABLE trace_lite.raw_logs ADD COLUMN IF NOT EXISTS region LowCardinality(String) DEFAULT '' AFTER host;
+ALTER TABLE trace_lite.raw_logs ADD COLUMN IF NOT EXISTS zone LowCardinality(String) DEFAULT '' AFTER region;
+ALTER TABLE trace_lite.spans ADD COLUMN IF NOT EXISTS region LowCardinality(String) DEFAULT '' AFTER host;
+ALTER TABLE trace_lite.spans ADD COLUMN IF NOT EXISTS zone LowCardinality(String) DEFAULT '' AFTER region;
+ALTER TABLE trace_lite.traces ADD COLUMN IF NOT EXISTS regions Array(LowCardinality(String)) DEFAULT [] AFTER versions;
+ALTER TABLE trace_lite.traces ADD COLUMN IF NOT EXISTS zones Array(LowCardinality(String)) DEFAULT [] AFTER regions;
+ALTER TABLE trace_lite.trace_partials ADD COLUMN IF NOT EXISTS regions Array(LowCardinality(String)) DEFAULT [] AFTER versions;
+ALTER TABLE trace_lite.trace_partials ADD COLUMN IF NOT EXISTS zones Array(LowCardinality(String)) DEFAULT [] AFTER regions;
+ALTER TABLE trace_lite.dependency_edges_minute ADD COLUMN IF NOT EXISTS caller_region LowCardinality(String) DEFAULT '' AFTER callee_version;
+ALTER TABLE trace_lite.dependency_edges_minute ADD COLUMN IF NOT EXISTS callee_region LowCardinality(String) DEFAULT '' AFTER caller_region;
+ALTER TABLE trace_lite.dependency_edges_minute ADD COLUMN IF NOT EXISTS caller_zone LowCardinality(String) DEFAULT '' AFTER callee_region;
+ALTER TABLE trace_lite.dependency_edges_minute ADD COLUMN IF NOT EXISTS callee_zone LowCardinality(String) DEFAULT '' AFTER caller_zone;
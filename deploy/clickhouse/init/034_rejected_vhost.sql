@@ -0,0 +1 @@
+ALTER TABLE trace_lite.rejected_events ADD COLUMN IF NOT EXISTS vhost LowCardinality(String) DEFAULT '' AFTER version;
@@ -0,0 +1,229 @@
+CREATE TABLE IF NOT EXISTS trace_lite.spans_mv_state (
+  day             Date,
+  env             LowCardinality(String),
+  trace_id        String,
+  span_id         String,
+  parent_max      SimpleAggregateFunction(max, String),
+  service_any     SimpleAggregateFunction(any, String),
+  host_any        SimpleAggregateFunction(any, String),
+  region_max      SimpleAggregateFunction(max, String),
+  zone_max        SimpleAggregateFunction(max, String),
+  version_any     SimpleAggregateFunction(any, String),
+  operation_any   SimpleAggregateFunction(any, String),
+  method_max      SimpleAggregateFunction(max, String),
+  route_max       SimpleAggregateFunction(max, String),
+  start_min       SimpleAggregateFunction(min, DateTime64(3, 'UTC')),
+  end_max         SimpleAggregateFunction(max, DateTime64(3, 'UTC')),
+  duration_max    SimpleAggregateFunction(max, UInt32),
+  status_max      SimpleAggregateFunction(max, UInt16),
+  error_max       SimpleAggregateFunction(max, UInt8),
+  cancel_max      SimpleAggregateFunction(max, UInt8),
+  timeout_max     SimpleAggregateFunction(max, UInt8),
+  error_msg_max   SimpleAggregateFunction(max, String),
+  error_type_max  SimpleAggregateFunction(max, String),
+  updated_max     SimpleAggregateFunction(max, DateTime64(3, 'UTC'))
+)
+ENGINE = AggregatingMergeTree
+PARTITION BY day
+ORDER BY (env, trace_id, span_id, day)
+TTL day + INTERVAL 90 DAY;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS trace_lite.mv_spans_state
+TO trace_lite.spans_mv_state
+AS
+SELECT
+  toDate(ts) AS day,
+  env,
+  trace_id,
+  if(span_id = '', concat('implicit-', lower(hex(cityHash64(toString(service), toString(host), ts, message)))), span_id) AS span_id,
+  max(parent_span_id) AS parent_max,
+  any(toString(service)) AS service_any,
+  any(toString(host)) AS host_any,
+  max(toString(region)) AS region_max,
+  max(toString(zone)) AS zone_max,
+  any(toString(version)) AS version_any,
+  any(if(route != '', route, if(message != '', message, 'unknown-op'))) AS operation_any,
+  max(if(method != '', upper(toString(method)), upper(extract(route, '^(?i)(GET|HEAD|POST|PUT|PATCH|DELETE|OPTIONS|CONNECT|TRACE|GRPC) ')))) AS method_max,
+  max(trim(replaceRegexpOne(route, '^(?i)(GET|HEAD|POST|PUT|PATCH|DELETE|OPTIONS|CONNECT|TRACE|GRPC) ', ''))) AS route_max,
+  min(ts - toIntervalMillisecond(duration_ms)) AS start_min,
+  max(ts) AS end_max,
+  max(duration_ms) AS duration_max,
+  max(status_code) AS status_max,
+  max(toUInt8(if(upper(attrs['status']) IN ('OK', 'SUCCESS', 'SUCCEEDED', 'CANCELLED', 'CANCELED'), 0,
+    status_code >= 400 OR attrs['error_message'] != '' OR attrs['error_type'] != ''
+    OR upper(attrs['status']) IN ('ERROR', 'FAIL', 'FAILED', 'FAILURE', 'TIMEOUT', 'TIMED_OUT', 'UNKNOWN', 'INVALID_ARGUMENT',
+      'DEADLINE_EXCEEDED', 'NOT_FOUND', 'ALREADY_EXISTS', 'PERMISSION_DENIED', 'RESOURCE_EXHAUSTED', 'FAILED_PRECONDITION',
+      'ABORTED', 'OUT_OF_RANGE', 'UNIMPLEMENTED', 'INTERNAL', 'UNAVAILABLE', 'DATA_LOSS', 'UNAUTHENTICATED')))) AS error_max,
+  max(toUInt8(upper(attrs['status']) IN ('CANCELLED', 'CANCELED'))) AS cancel_max,
+  max(toUInt8(upper(attrs['status']) NOT IN ('OK', 'SUCCESS', 'SUCCEEDED') AND (
+    upper(attrs['status']) IN ('TIMEOUT', 'TIMED_OUT', 'DEADLINE_EXCEEDED') OR status_code IN (408, 504)
+    OR positionCaseInsensitive(attrs['error_type'], 'timeout') > 0 OR lower(attrs['timeout']) = 'true'))) AS timeout_max,
+  max(attrs['error_message']) AS error_msg_max,
+  max(attrs['error_type']) AS error_type_max,
+  max(ingest_ts) AS updated_max
+FROM trace_lite.raw_logs
+GROUP BY day, env, trace_id, span_id;
+
+CREATE VIEW IF NOT EXISTS trace_lite.spans_mv
+AS
+SELECT
+  trace_id,
+  span_id,
+  max(parent_max) AS parent_span_id,
+  any(service_any) AS service,
+  env,
+  any(host_any) AS host,
+  max(region_max) AS region,
+  max(zone_max) AS zone,
+  any(version_any) AS version,
+  any(operation_any) AS operation,
+  max(method_max) AS method,
+  max(route_max) AS route,
+  min(start_min) AS start_ts,
+  max(end_max) AS end_ts,
+  toUInt32(if(max(duration_max) > 0, max(duration_max), dateDiff('millisecond', min(start_min), max(end_max)))) AS duration_ms,
+  toUInt32(if(max(duration_max) > 0, max(duration_max), dateDiff('millisecond', min(start_min), max(end_max)))) AS self_time_ms,
+  toUInt32(0) AS queue_ms,
+  max(status_max) AS status_code,
+  greatest(max(error_max), max(timeout_max)) AS is_error,
+  multiIf(max(timeout_max) = 1, 'timeout', max(error_max) = 1, 'error', max(cancel_max) = 1, 'cancelled', 'ok') AS status,
+  max(error_msg_max) AS error_message,
+  max(error_type_max) AS error_type,
+  if(startsWith(span_id, 'implicit-'), 'implicit', 'mv') AS source,
+  '' AS proxy,
+  CAST([], 'Array(LowCardinality(String))') AS conflicts,
+  max(updated_max) AS updated_at
+FROM trace_lite.spans_mv_state
+GROUP BY env, trace_id, span_id;
+
+CREATE VIEW IF NOT EXISTS trace_lite.traces_mv
+AS
+SELECT
+  trace_id,
+  env,
+  argMin(service, s_ts) AS root_service,
+  argMin(operation, s_ts) AS root_operation,
+  argMin(sc, s_ts) AS root_status_code,
+  argMin(operation, s_ts) AS transaction,
+  min(s_ts) AS start_ts,
+  max(e_ts) AS end_ts,
+  toUInt32(dateDiff('millisecond', min(s_ts), max(e_ts))) AS duration_ms,
+  toUInt16(count()) AS span_count,
+  toUInt16(uniqExact(service)) AS service_count,
+  toUInt16(countIf(err = 1)) AS error_count,
+  toUInt32(dateDiff('millisecond', min(s_ts), max(e_ts))) AS critical_path_ms,
+  arraySort(groupUniqArray(version)) AS versions,
+  arraySort(groupUniqArrayIf(region, region != '')) AS regions,
+  arraySort(groupUniqArrayIf(zone, zone != '')) AS zones,
+  toUInt8(0) AS truncated,
+  toUInt32(0) AS dropped_spans,
+  toUInt8(0) AS partial,
+  emptyArrayString() AS labels,
+  toFloat32(round(greatest(1 - 0.3 * countIf(src = 'implicit') / count(), 0), 2)) AS integrity,
+  toUInt16(countIf(src = 'implicit')) AS inferred_spans,
+  toUInt16(0) AS orphan_spans,
+  toUInt16(0) AS skewed_spans,
+  max(u_ts) AS updated_at
+FROM
+(
+  SELECT trace_id, env, service, operation, version, region, zone, status_code AS sc, start_ts AS s_ts, end_ts AS e_ts, is_error AS err, source AS src, updated_at AS u_ts
+  FROM trace_lite.spans_mv
+)
+GROUP BY env, trace_id;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS trace_lite.mv_edges_refresh
+REFRESH EVERY 1 MINUTE APPEND
+TO trace_lite.dependency_edges_minute
+AS
+WITH
+  toStartOfMinute(now() - INTERVAL 3 MINUTE) AS lo,
+  lo + INTERVAL 1 MINUTE AS hi
+SELECT
+  toStartOfMinute(c.s_ts) AS bucket_ts,
+  c.env AS env,
+  p.service AS caller_service,
+  c.service AS callee_service,
+  p.version AS caller_version,
+  c.version AS callee_version,
+  p.region AS caller_region,
+  c.region AS callee_region,
+  p.zone AS caller_zone,
+  c.zone AS callee_zone,
+  c.method AS callee_method,
+  c.route AS callee_route,
+  count() AS calls,
+  countIf(c.err = 1) AS error_calls,
+  countIf(c.cancelled = 1) AS cancelled_calls,
+  countIf(c.timed_out = 1) AS timeout_calls,
+  quantileExact(0.50)(c.dur) AS p50_ms,
+  quantileExact(0.95)(c.dur) AS p95_ms,
+  max(c.dur) AS max_ms
+FROM
+(
+  SELECT
+    env, trace_id, span_id,
+    max(parent_max) AS parent,
+    any(service_any) AS service,
+    any(version_any) AS version,
+    max(region_max) AS region,
+    max(zone_max) AS zone,
+    max(method_max) AS method,
+    max(route_max) AS route,
+    min(start_min) AS s_ts,
+    toUInt32(if(max(duration_max) > 0, max(duration_max), dateDiff('millisecond', min(start_min), max(end_max)))) AS dur,
+    greatest(max(error_max), max(timeout_max)) AS err,
+    toUInt8(max(error_max) = 0 AND max(timeout_max) = 0 AND max(cancel_max) = 1) AS cancelled,
+    max(timeout_max) AS timed_out
+  FROM trace_lite.spans_mv_state
+  WHERE day >= toDate(lo) - 1
+  GROUP BY env, trace_id, span_id
+  HAVING s_ts >= lo AND s_ts < hi AND parent != ''
+) AS c
+INNER JOIN
+(
+  SELECT trace_id, span_id, any(service_any) AS service, any(version_any) AS version, max(region_max) AS region, max(zone_max) AS zone
+  FROM trace_lite.spans_mv_state
+  WHERE day >= toDate(lo) - 1
+  GROUP BY env, trace_id, span_id
+) AS p ON p.trace_id = c.trace_id AND p.span_id = c.parent
+WHERE p.service != c.service
+GROUP BY bucket_ts, env, caller_service, callee_service, caller_version, callee_version, caller_region, callee_region, caller_zone, callee_zone,
+  callee_method, callee_route;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS trace_lite.mv_service_versions_refresh
+REFRESH EVERY 1 MINUTE APPEND
+TO trace_lite.service_versions_minute
+AS
+WITH
+  toStartOfMinute(now() - INTERVAL 3 MINUTE) AS lo,
+  lo + INTERVAL 1 MINUTE AS hi
+SELECT
+  toStartOfMinute(c.s_ts) AS bucket_ts,
+  c.env AS env,
+  c.service AS service,
+  c.version AS version,
+  count() AS calls,
+  countIf(c.err = 1) AS errors
+FROM
+(
+  SELECT
+    env, trace_id, span_id,
+    max(parent_max) AS parent,
+    any(service_any) AS service,
+    any(version_any) AS version,
+    min(start_min) AS s_ts,
+    greatest(max(error_max), max(timeout_max)) AS err
+  FROM trace_lite.spans_mv_state
+  WHERE day >= toDate(lo) - 1
+  GROUP BY env, trace_id, span_id
+  HAVING s_ts >= lo AND s_ts < hi
+) AS c
+LEFT JOIN
+(
+  SELECT trace_id, span_id, any(service_any) AS service
+  FROM trace_lite.spans_mv_state
+  WHERE day >= toDate(lo) - 1
+  GROUP BY env, trace_id, span_id
+) AS p ON p.trace_id = c.trace_id AND p.span_id = c.parent
+WHERE c.parent = '' OR p.service != c.service
+GROUP BY bucket_ts, env, service, version;
@@ -0,0 +1,3 @@
+ALTER TABLE trace_lite.raw_logs MODIFY TTL toDateTime(ts) + toIntervalDay(if(retain_days = 0, 30, retain_days));
+ALTER TABLE trace_lite.spans MODIFY TTL toDateTime(start_ts) + toIntervalDay(if(retain_days = 0, 90, retain_days));
+ALTER TABLE trace_lite.traces MODIFY TTL toDateTime(start_ts) + toIntervalDay(if(retain_days = 0, 180, retain_days));
@@ -1,5 +1,5 @@
 import { useEffect, useMemo, useState } from "react";
-import DependencyGraph, { type GraphEdge } from "./components/DependencyGraph";
+import DependencyGraph, { type GraphEdge, type GraphNode } from "./components/DependencyGraph";
 
 type TraceItem = {
   trace_id: string;
@@ -35,6 +35,8 @@ type OperationDiff = {
   delta_p95_ms: number;
   base_calls: number | string;
   cand_calls: number | string;
+  significance?: "significant" | "noise" | "insufficient_data";
+  p_value?: number | null;
 };
 
 type RootCause = {
@@ -44,16 +46,36 @@ type RootCause = {
   error_delta_pct: number;
   call_delta_pct: number;
   blocking_ratio: number;
+  self_time_delta_pct: number;
+  critical_path_share: number;
+  exonerated_by?: string;
   reason: string;
 };
 
+type BadgeEvidence = {
+  metric: string;
+  base: number;
+  cand: number;
+  delta_pct: number;
+  threshold_pct: number;
+  spans?: { trace_id: string; span_id: string; operation: string; duration_ms: number }[];
+};
+
 type AnomalyBadge = {
   level: string;
   title: string;
   message: string;
   deviation_score: number;
+  evidence?: BadgeEvidence;
 };
 
+function evidenceText(e?: BadgeEvidence): string {
+  if (!e) return "";
+  const lines = [`${e.metric}: ${e.base} -> ${e.cand} (${num(e.delta_pct).toFixed(1)}%, threshold ${e.threshold_pct}%)`];
+  for (const s of e.spans ?? []) lines.push(`${s.operation} ${s.duration_ms}ms trace ${s.trace_id}`);
+  return lines.join("\n");
+}
+
 type WaterfallSpan = {
   span_id: string;
   parent_span_id: string;
@@ -68,6 +90,9 @@ type WaterfallSpan = {
   depth: number;
   is_critical: boolean;
   is_error: boolean;
+  status: string;
+  error_message: string;
+  error_type: string;
   left_pct: number;
   width_pct: number;
   explanation: string;
@@ -109,6 +134,7 @@ type TraceSpanDetail = {
   duration_ms: number;
   status_code: number;
   is_error: number | boolean;
+  status: string;
 };
 
 type TraceDetailPayload = {
@@ -173,6 +199,7 @@ function App() {
 
   const [hosts, setHosts] = useState<HostItem[]>([]);
   const [edges, setEdges] = useState<GraphEdge[]>([]);
+  const [nodes, setNodes] = useState<GraphNode[]>([]);
   const [metrics, setMetrics] = useState<CompareMetric[]>([]);
   const [operationDiff, setOperationDiff] = useState<OperationDiff[]>([]);
   const [rootCauses, setRootCauses] = useState<RootCause[]>([]);
@@ -291,7 +318,7 @@ function App() {
           { data: [] }
         ),
         fetchJson<{ hosts: HostItem[] }>(`${apiBase}/v1/hosts?${q}`, { hosts: [] }),
-        fetchJson<{ edges: GraphEdge[] }>(`${apiBase}/v1/dependency?${q}`, { edges: [] }),
+        fetchJson<{ edges: GraphEdge[]; nodes: GraphNode[] }>(`${apiBase}/v1/dependency?${q}`, { edges: [], nodes: [] }),
         fetchJson<{ metrics: CompareMetric[]; operation_diff: OperationDiff[]; root_causes: RootCause[]; anomalies: AnomalyBadge[] }>(
           `${apiBase}/v1/compare?${q}&service=${encodeURIComponent(service)}&base=${encodeURIComponent(baseVersion)}&cand=${encodeURIComponent(candVersion)}`,
           { metrics: [], operation_diff: [], root_causes: [], anomalies: [] }
@@ -310,6 +337,7 @@ function App() {
       setTraces(traceList);
       setHosts((hostData.hosts ?? []) as HostItem[]);
       setEdges((depData.edges ?? []) as GraphEdge[]);
+      setNodes((depData.nodes ?? []) as GraphNode[]);
       setMetrics((compareData.metrics ?? []) as CompareMetric[]);
       setOperationDiff((compareData.operation_diff ?? []) as OperationDiff[]);
       setRootCauses((compareData.root_causes ?? []) as RootCause[]);
@@ -354,7 +382,7 @@ function App() {
         byHost.get(hostName) ??
         { host: hostName, logs: 0, errors: 0, activeServices: new Set<string>(), lastSeen: s.end_ts || s.start_ts || "" };
       curr.logs += 1;
-      if (Boolean(s.is_error) || num(s.status_code) >= 400) {
+      if (Boolean(s.is_error)) {
         curr.errors += 1;
       }
       if (s.service) {
@@ -388,7 +416,7 @@ function App() {
     const byOperation = new Map<string, { service: string; operation: string; errors: number; calls: number }>();
 
     spans.forEach((s) => {
-      const isErr = Boolean(s.is_error) || num(s.status_code) >= 400;
+      const isErr = Boolean(s.is_error);
       const svc = s.service || "unknown-service";
       const op = s.operation || "unknown-op";
 
@@ -515,6 +543,9 @@ function App() {
                       <div className="wf-label" style={{ paddingLeft: `${num(s.depth) * 14}px` }}>
                         <strong>{s.service}</strong> <span>{s.span_id}</span>
                         <small>{num(s.duration_ms)}ms (self {num(s.self_time_ms)} / wait {num(s.wait_ms)})</small>
+                        {s.is_error && (s.error_type || s.error_message) ? (
+                          <small className="wf-error-detail">{[s.error_type, s.error_message].filter(Boolean).join(": ")}</small>
+                        ) : null}
                       </div>
                       <div className="wf-timeline">
                         <div className="wf-bar" style={{ left: `${num(s.left_pct)}%`, width: `${num(s.width_pct)}%` }} />
@@ -553,7 +584,7 @@ function App() {
               Dependency Graph{" "}
               {selectedTraceEdges.length > 0 && drilldown?.trace?.trace_id ? `(Trace: ${drilldown.trace.trace_id})` : "(Window Aggregate)"}
             </h2>
-            <DependencyGraph edges={graphEdges} />
+            <DependencyGraph edges={graphEdges} nodes={selectedTraceEdges.length > 0 ? [] : nodes} />
           </article>
 
           <article className="panel">
@@ -596,8 +627,8 @@ function App() {
               </thead>
               <tbody>
                 {(traceErrorPanel?.service_breakdown ?? []).map((r) => (
-                  <tr key={r.service}>
-                    <td>{r.service}</td>
+                  <tr key={r.service} title={r.reason}>
+                    <td>{r.exonerated_by ? <span className="noise">{r.service} → {r.exonerated_by}</span> : r.service}</td>
                     <td>{num(r.errors)}</td>
                     <td>{num(r.calls)}</td>
                     <td>{(num(r.error_rate) * 100).toFixed(2)}</td>
@@ -632,7 +663,7 @@ function App() {
             <h2>Root Cause Ranking</h2>
             <div className="badge-row">
               {anomalies.map((a, idx) => (
-                <span key={`${a.title}-${idx}`} className={`badge badge-${a.level}`}>
+                <span key={`${a.title}-${idx}`} className={`badge badge-${a.level}`} title={evidenceText(a.evidence)}>
                   {a.title}: {a.message}
                 </span>
               ))}
@@ -645,6 +676,7 @@ function App() {
                   <th>Latency%</th>
                   <th>Error%</th>
                   <th>Calls%</th>
+                  <th>Path share</th>
                 </tr>
               </thead>
               <tbody>
@@ -655,6 +687,7 @@ function App() {
                     <td>{num(r.latency_delta_pct).toFixed(1)}</td>
                     <td>{num(r.error_delta_pct).toFixed(1)}</td>
                     <td>{num(r.call_delta_pct).toFixed(1)}</td>
+                    <td>{(num(r.critical_path_share) * 100).toFixed(0)}%</td>
                   </tr>
                 ))}
               </tbody>
@@ -689,7 +722,12 @@ function App() {
                     <td>{d.operation}</td>
                     <td>{num(d.base_p95_ms)}</td>
                     <td>{num(d.cand_p95_ms)}</td>
-                    <td className={num(d.delta_p95_ms) > 0 ? "bad" : "good"}>{num(d.delta_p95_ms)}</td>
+                    <td
+                      className={d.significance && d.significance !== "significant" ? "noise" : num(d.delta_p95_ms) > 0 ? "bad" : "good"}
+                      title={d.significance === "insufficient_data" ? "too few samples" : d.p_value != null ? `p = ${d.p_value}` : undefined}
+                    >
+                      {num(d.delta_p95_ms)}
+                    </td>
                   </tr>
                 ))}
               </tbody>
@@ -2,6 +2,16 @@ import { useMemo } from "react";
 import ReactFlow, { Background, Controls, type Edge, type Node } from "reactflow";
 import "reactflow/dist/style.css";
 
+export type Health = {
+  score: number | null;
+  status: "green" | "yellow" | "red" | "unknown";
+};
+
+export type GraphNode = {
+  service: string;
+  health?: Health;
+};
+
 export type GraphEdge = {
   caller_service: string;
   callee_service: string;
@@ -13,13 +23,18 @@ export type GraphEdge = {
   status?: string;
   call_diff_pct?: number;
   is_new_edge?: boolean;
+  health?: Health;
 };
 
 type Props = {
   edges: GraphEdge[];
+  nodes?: GraphNode[];
 };
 
-function DependencyGraph({ edges }: Props) {
+const healthStroke: Record<string, string> = { green: "#2f8f46", yellow: "#d4a106", red: "#d64545" };
+const healthFill: Record<string, string> = { green: "#eaf7ee", yellow: "#fff8e1", red: "#fdecec" };
+
+function DependencyGraph({ edges, nodes: serviceNodes = [] }: Props) {
   const { nodes, flowEdges } = useMemo(() => {
     const services = new Set<string>();
     edges.forEach((e) => {
@@ -27,16 +42,17 @@ function DependencyGraph({ edges }: Props) {
       services.add(e.callee_service);
     });
 
+    const healthBySvc = new Map(serviceNodes.map((n) => [n.service, n.health]));
     const arr = Array.from(services);
     const nodes: Node[] = arr.map((name, idx) => ({
       id: name,
       position: { x: (idx % 4) * 240, y: Math.floor(idx / 4) * 120 },
-      data: { label: name },
+      data: { label: healthBySvc.get(name)?.score != null ? `${name} (${Math.round(healthBySvc.get(name)?.score ?? 0)})` : name },
       style: {
-        border: "1px solid #22435f",
+        border: `1px solid ${healthStroke[healthBySvc.get(name)?.status ?? ""] ?? "#22435f"}`,
         borderRadius: 12,
         padding: 8,
-        background: "#f1f7ff",
+        background: healthFill[healthBySvc.get(name)?.status ?? ""] ?? "#f1f7ff",
         color: "#0c1f33",
         fontWeight: 700
       }
@@ -49,14 +65,17 @@ function DependencyGraph({ edges }: Props) {
       label: `${Math.round(e.calls ?? 0)} calls | p95 ${Math.round(e.p95_ms ?? 0)}ms | err ${Math.round((e.error_rate ?? 0) * 100)}%`,
       animated: (e.p95_ms ?? 0) > 500 || (e.call_diff_pct ?? 0) > 100,
       style: {
-        stroke: e.is_new_edge || e.status === "new" ? "#cf1322" : (e.error_rate ?? 0) > 0.1 ? "#d64545" : "#205493",
+        stroke:
+          e.is_new_edge || e.status === "new"
+            ? "#cf1322"
+            : healthStroke[e.health?.status ?? ""] ?? ((e.error_rate ?? 0) > 0.1 ? "#d64545" : "#205493"),
         strokeDasharray: e.status === "removed" ? "4 3" : undefined,
         strokeWidth: Math.min(8, Math.max(1, (e.calls ?? 0) / 100))
       }
     }));
 
     return { nodes, flowEdges };
-  }, [edges]);
+  }, [edges, serviceNodes]);
 
   return (
     <div style={{ width: "100%", height: 380 }}>